@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const (
+	// benchmarkDotNetVersion is the version of BenchmarkDotNet the benchmark project refers to with -emit-bench.
+	benchmarkDotNetVersion = "0.13.12"
+
+	// wasmtimeVersion is the version of Wasmtime (Wasmtime.NET) the benchmark project refers to for the baseline.
+	wasmtimeVersion = "22.0.0"
+)
+
+// WasmtimeGetter returns the method name and the type arguments of Wasmtime's Instance to get the function.
+func (c *exportCall) WasmtimeGetter() string {
+	sig := c.Export.Funcs[c.Export.Index].Wasm.Sig
+	var types []string
+	for _, t := range sig.ParamTypes {
+		types = append(types, wasmTypeToReturnType(t).CSharp())
+	}
+	name := "GetAction"
+	for _, t := range sig.ReturnTypes {
+		types = append(types, wasmTypeToReturnType(t).CSharp())
+		name = "GetFunction"
+	}
+	if len(types) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s<%s>", name, strings.Join(types, ", "))
+}
+
+// NameLiteral returns the C# literal of the export name.
+func (c *exportCall) NameLiteral() string {
+	return csString(c.Export.Name)
+}
+
+var benchTmpl = template.Must(template.New("bench").Parse(`    using BenchmarkDotNet.Attributes;
+    using BenchmarkDotNet.Configs;
+    using BenchmarkDotNet.Running;
+
+    public static class Program
+    {
+        public static void Main(string[] args)
+        {
+            BenchmarkSwitcher.FromAssembly(typeof(Program).Assembly).Run(args);
+        }
+    }
+
+    // GoBenchmarks calls the exported functions of the translated program{{if .Wasm}}, and of the original wasm file
+    // under Wasmtime as the baseline{{end}}.
+    //
+    // The program must keep running after the main function returns, e.g. by select {}, so that the exported functions
+    // can be called. Replace the arguments with the ones of the workload.
+    [MemoryDiagnoser]
+    [CategoriesColumn]
+    [GroupBenchmarksBy(BenchmarkLogicalGroupRule.ByCategory)]
+    public class GoBenchmarks
+    {
+        private {{.Go}} go;
+        private Task<int> task;
+{{- if .Wasm}}
+        private global::Wasmtime.Engine engine;
+        private global::Wasmtime.Store store;
+{{- range .Exports}}
+        private {{.DelegateType}} wasmtime_{{.Export.BaseIdent}};
+{{- end}}
+{{- end}}
+
+        [GlobalSetup]
+        public void Setup()
+        {
+            this.go = new {{.Go}}
+            {
+                ThreadingModel = {{.Prefix}}GoThreadingModel.DedicatedThread,
+                SerializeHostCalls = true,
+            };
+            this.task = this.go.RunAsync(new string[0]);
+            System.Threading.SpinWait.SpinUntil(() => this.go.Instance != null || this.task.IsCompleted);
+            if (this.task.IsCompleted)
+            {
+                throw new InvalidOperationException("Go program exited before the benchmarks");
+            }
+{{- if .Wasm}}
+
+            this.engine = new global::Wasmtime.Engine();
+            var module = global::Wasmtime.Module.FromFile(this.engine, Path.Combine(AppContext.BaseDirectory, "{{.WasmName}}"));
+            var linker = new global::Wasmtime.Linker(this.engine);
+            linker.DefineWasi();
+            this.store = new global::Wasmtime.Store(this.engine);
+            this.store.SetWasiConfiguration(new global::Wasmtime.WasiConfiguration().WithInheritedStandardOutput().WithInheritedStandardError());
+            var instance = linker.Instantiate(this.store, module);
+            // A reactor module is initialized by _initialize instead of _start.
+            instance.GetAction("_initialize")?.Invoke();
+{{- range .Exports}}
+            this.wasmtime_{{.Export.BaseIdent}} = instance.{{.WasmtimeGetter}}({{.NameLiteral}});
+{{- end}}
+{{- end}}
+        }
+{{- if .Wasm}}
+
+        [GlobalCleanup]
+        public void Cleanup()
+        {
+            this.store.Dispose();
+            this.engine.Dispose();
+        }
+{{- end}}
+{{- range .Exports}}
+
+        [Benchmark]
+        [BenchmarkCategory({{.NameLiteral}})]
+{{- if .Values}}
+        [Arguments({{range $i, $v := .Values}}{{if $i}}, {{end}}{{$v}}{{end}})]
+{{- end}}
+        public {{.ReturnType}} {{.Export.BaseIdent}}_Go({{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p}}{{end}})
+        {
+            {{if ne .ReturnType "void"}}return {{end}}(({{$.Prefix}}IGoApp)this.go).{{.Export.Ident}}({{range $i, $a := .Args}}{{if $i}}, {{end}}{{$a}}{{end}});
+        }
+{{- if $.Wasm}}
+
+        [Benchmark(Baseline = true)]
+        [BenchmarkCategory({{.NameLiteral}})]
+{{- if .Values}}
+        [Arguments({{range $i, $v := .Values}}{{if $i}}, {{end}}{{$v}}{{end}})]
+{{- end}}
+        public {{.ReturnType}} {{.Export.BaseIdent}}_Wasmtime({{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p}}{{end}})
+        {
+            {{if ne .ReturnType "void"}}return {{end}}this.wasmtime_{{.Export.BaseIdent}}({{range $i, $a := .Args}}{{if $i}}, {{end}}{{$a}}{{end}});
+        }
+{{- end}}
+{{- end}}
+    }`))
+
+// benchCode returns the C# code of the benchmarks, which is put in the namespace. prefix is the prefix of the
+// generated types, e.g. the outer class by -outer. wasmName is the file name of the wasm file to run under Wasmtime,
+// or an empty string if none.
+func benchCode(prefix string, exports []*exportCall, wasmName string) (string, error) {
+	var buf bytes.Buffer
+	if err := benchTmpl.Execute(&buf, struct {
+		Go       string
+		Prefix   string
+		Exports  []*exportCall
+		Wasm     bool
+		WasmName string
+	}{
+		Go:       prefix + "Go",
+		Prefix:   prefix,
+		Exports:  exports,
+		Wasm:     wasmName != "",
+		WasmName: wasmName,
+	}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,294 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// benchNamespace is the namespace of the code generated by the bench subcommand.
+const benchNamespace = "Go2DotNet.Bench"
+
+// benchReservedFlags is the translation flags that the bench subcommand sets by itself.
+var benchReservedFlags = []string{"wasm", "namespace", "out", "outer", "emit"}
+
+var benchRunnerTmpl = template.Must(template.New("runner").Parse(`// Code generated by go2dotnet bench. DO NOT EDIT.
+
+using System;
+using System.Diagnostics;
+using System.Threading;
+
+namespace {{.Namespace}}.Runner
+{
+    public static class Program
+    {
+        public static int Main(string[] args)
+        {
+            var go = new {{.Namespace}}.Go
+            {
+                ThreadingModel = {{.Namespace}}.GoThreadingModel.DedicatedThread,
+                SerializeHostCalls = true,
+            };
+            var task = go.RunAsync(new string[0]);
+            SpinWait.SpinUntil(() => go.Instance != null || task.IsCompleted);
+            if (task.IsCompleted)
+            {
+                Console.Error.WriteLine("the Go program exited before the benchmark; keep it running, e.g. by select {}");
+                return 1;
+            }
+            var app = ({{.Namespace}}.IGoApp)go;
+
+            // Warm up, so that the tiered compilation optimizes the hot methods.
+            for (int i = 0; i < {{.Warmup}}; i++)
+            {
+                app.{{.Export}}({{.Args}});
+            }
+
+            long allocated = GC.GetTotalAllocatedBytes(true);
+            var sw = Stopwatch.StartNew();
+            for (int i = 0; i < {{.N}}; i++)
+            {
+                app.{{.Export}}({{.Args}});
+            }
+            sw.Stop();
+            allocated = GC.GetTotalAllocatedBytes(true) - allocated;
+
+            Console.WriteLine($"calls:     {{.N}}");
+            Console.WriteLine($"wall time: {sw.Elapsed.TotalMilliseconds:F3} ms ({sw.Elapsed.TotalMilliseconds * 1e6 / {{.N}}:F1} ns/call)");
+            Console.WriteLine($"allocated: {allocated} bytes ({(double)allocated / {{.N}}:F1} bytes/call)");
+            return 0;
+        }
+    }
+}
+`))
+
+// runBench runs the bench subcommand with the arguments after "bench".
+//
+// The subcommand builds the Go package to wasm, translates it with the translation flags after "--", compiles the C#
+// code with dotnet, calls the exported function N times, and reports the wall time, the allocations and the size of
+// the generated code. This evaluates a change of the code generation on a real module without a hand-written
+// harness.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: go2dotnet bench -export name [flags] [package] [-- translation flags]\n")
+		fs.PrintDefaults()
+	}
+	export := fs.String("export", "", "Exported function to call, e.g. by //go:wasmexport (required)")
+	n := fs.Int("n", 10000, "Number of calls to measure")
+	callArgs := fs.String("args", "", "Comma-separated arguments of the exported function (zeros by default)")
+	keep := fs.String("keep", "", "Directory to keep the wasm file and the projects in, instead of a temporary directory")
+
+	var transFlags []string
+	for i, a := range args {
+		if a == "--" {
+			args, transFlags = args[:i], args[i+1:]
+			break
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *export == "" {
+		return fmt.Errorf("bench: -export is required")
+	}
+	if *n <= 0 {
+		return fmt.Errorf("bench: -n must be positive but %d", *n)
+	}
+	for _, f := range transFlags {
+		name := strings.SplitN(strings.TrimLeft(f, "-"), "=", 2)[0]
+		for _, r := range benchReservedFlags {
+			if strings.HasPrefix(f, "-") && name == r {
+				return fmt.Errorf("bench: -%s cannot be given as a translation flag", r)
+			}
+		}
+	}
+	pkg := "."
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		pkg = fs.Arg(0)
+	default:
+		return fmt.Errorf("bench: too many arguments: %q", fs.Args())
+	}
+
+	dir := *keep
+	if dir == "" {
+		d, err := ioutil.TempDir("", "go2dotnet-bench")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(d)
+		dir = d
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	wasmPath := filepath.Join(dir, "bench.wasm")
+	build := exec.Command("go", "build", "-o", wasmPath, pkg)
+	build.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	build.Stdout = os.Stderr
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		return fmt.Errorf("bench: go build failed: %v", err)
+	}
+	wasmBytes, err := ioutil.ReadFile(wasmPath)
+	if err != nil {
+		return err
+	}
+	csArgs, err := benchArgs(wasmBytes, *export, *callArgs)
+	if err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	genDir := filepath.Join(dir, "gen")
+	// The wasm file has just been built by go build, so it doesn't have to be validated.
+	trans := exec.Command(self, append(transFlags, "-trusted", "-wasm", wasmPath, "-namespace", benchNamespace, "-out", genDir)...)
+	trans.Stdout = os.Stderr
+	trans.Stderr = os.Stderr
+	if err := trans.Run(); err != nil {
+		return fmt.Errorf("bench: translation failed: %v", err)
+	}
+
+	var code bytes.Buffer
+	warmup := *n / 10
+	if warmup < 100 {
+		warmup = 100
+	}
+	if err := benchRunnerTmpl.Execute(&code, struct {
+		Namespace string
+		Export    string
+		Args      string
+		N         int
+		Warmup    int
+	}{
+		Namespace: benchNamespace,
+		Export:    *export,
+		Args:      strings.Join(csArgs, ", "),
+		N:         *n,
+		Warmup:    warmup,
+	}); err != nil {
+		return err
+	}
+	runner := &subproject{
+		Name: "Runner",
+		// The runner is next to the generated project, not in it.
+		Project:         filepath.Join("gen", projectName(wasmPath)),
+		TargetFramework: "net8.0",
+		Exe:             true,
+		Files: map[string][]byte{
+			"Program.cs": code.Bytes(),
+		},
+	}
+	if err := runner.write(dir); err != nil {
+		return err
+	}
+
+	size, lines, err := csSize(genDir)
+	if err != nil {
+		return err
+	}
+
+	run := exec.Command("dotnet", "run", "-c", "Release", "--project", filepath.Join(dir, runner.Name, runner.Name+".csproj"))
+	var out bytes.Buffer
+	run.Stdout = &out
+	run.Stderr = os.Stderr
+	if err := run.Run(); err != nil {
+		os.Stderr.Write(out.Bytes())
+		return fmt.Errorf("bench: dotnet run failed: %v", err)
+	}
+
+	fmt.Printf("export:    %s\n", *export)
+	fmt.Printf("wasm:      %d bytes\n", len(wasmBytes))
+	fmt.Printf("C#:        %d bytes, %d lines\n", size, lines)
+	os.Stdout.Write(out.Bytes())
+	return nil
+}
+
+// benchArgs returns the C# arguments of the exported function from the comma-separated values, or zeros if values is
+// empty. The literals have the suffixes of the parameter types, as the types must match exactly.
+func benchArgs(wasmBytes []byte, export string, values string) ([]string, error) {
+	mod, err := decodeModule(wasmBytes)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := mod.Export.Entries[export]
+	if !ok || e.Kind != wasm.ExternalFunction || goABIExports[export] {
+		return nil, fmt.Errorf("bench: %q is not an exported function", export)
+	}
+	idx := int(e.Index)
+	for _, i := range mod.Import.Entries {
+		if _, ok := i.Type.(wasm.FuncImport); ok {
+			if idx == 0 {
+				return nil, fmt.Errorf("bench: %q is an imported function", export)
+			}
+			idx--
+		}
+	}
+	sig := mod.Types.Entries[mod.Function.Types[idx]]
+
+	var vs []string
+	if values != "" {
+		vs = strings.Split(values, ",")
+	}
+	if len(vs) != 0 && len(vs) != len(sig.ParamTypes) {
+		return nil, fmt.Errorf("bench: %q takes %d arguments but %d are given", export, len(sig.ParamTypes), len(vs))
+	}
+	var args []string
+	for i, t := range sig.ParamTypes {
+		v := "0"
+		if len(vs) > 0 {
+			v = strings.TrimSpace(vs[i])
+		}
+		switch wasmTypeToReturnType(t) {
+		case ReturnTypeI64:
+			v += "L"
+		case ReturnTypeF32:
+			v += "f"
+		case ReturnTypeF64:
+			v += "d"
+		}
+		args = append(args, v)
+	}
+	return args, nil
+}
+
+// csSize returns the total size and the total number of lines of the C# files in the directory.
+func csSize(dir string) (int, int, error) {
+	var size, lines int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".cs" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		size += len(b)
+		lines += bytes.Count(b, []byte("\n"))
+		return nil
+	})
+	return size, lines, err
+}
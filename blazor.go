@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+// blazorDependencyInjectionVersion is the version of Microsoft.Extensions.DependencyInjection.Abstractions
+// the Blazor project refers to.
+const blazorDependencyInjectionVersion = "6.0.0"
+
+const blazor = `    // GoBlazorExtensions hosts the Go program in a Blazor WebAssembly app.
+    // The program runs as managed code on the browser's event loop, and doesn't need IJSRuntime.
+    public static class GoBlazorExtensions
+    {
+        // AddGo registers Go as a transient service. configure is called for each instance before it is returned.
+        public static Microsoft.Extensions.DependencyInjection.IServiceCollection AddGo(this Microsoft.Extensions.DependencyInjection.IServiceCollection services, Action<Go> configure = null)
+        {
+            return Microsoft.Extensions.DependencyInjection.ServiceCollectionServiceExtensions.AddTransient<Go>(services, _ => {
+                var go = new Go();
+                configure?.Invoke(go);
+                return go;
+            });
+        }
+
+        // RunInBrowserAsync runs the Go program without blocking the browser's only thread.
+        // The event loop is driven by the thread pool, which is the browser's event loop in Blazor WebAssembly.
+        public static Task<int> RunInBrowserAsync(this Go go, params string[] args)
+        {
+            var prev = System.Threading.SynchronizationContext.Current;
+            if (prev == null)
+            {
+                // The base SynchronizationContext posts callbacks to the thread pool.
+                System.Threading.SynchronizationContext.SetSynchronizationContext(new System.Threading.SynchronizationContext());
+            }
+            try
+            {
+                go.ThreadingModel = GoThreadingModel.SynchronizationContext;
+                return go.RunAsync(args);
+            }
+            finally
+            {
+                System.Threading.SynchronizationContext.SetSynchronizationContext(prev);
+            }
+        }
+    }
+`
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// cacheTrimAge is the age after which an unused entry is removed from the cache.
+const cacheTrimAge = 5 * 24 * time.Hour
+
+// buildCache is the cache of the translated functions in the user cache directory, by -cache.
+//
+// An entry is keyed by everything that the translation depends on: the go2dotnet executable itself, the wasm file,
+// the options, the license header, the templates and the profile of -pgo. Then an entry is never stale, and the
+// cache can be removed at any time.
+type buildCache struct {
+	path string
+}
+
+// newBuildCache returns the cache entry for the current run. newBuildCache returns nil if there is no user cache
+// directory.
+func newBuildCache(header *fileHeader) (*buildCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, nil
+	}
+	dir = filepath.Join(dir, "go2dotnet")
+
+	h := sha256.New()
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if err := hashFile(h, exe); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(h, "wasm %s\n", header.InputSHA256)
+	fmt.Fprintf(h, "license %q\n", header.License)
+	flag.VisitAll(func(f *flag.Flag) {
+		// The parallelism and the verbosity don't change the code.
+		if f.Name == "p" || f.Name == "v" {
+			return
+		}
+		fmt.Fprintf(h, "flag %s=%q\n", f.Name, f.Value.String())
+	})
+	if *flagTemplates != "" {
+		files, err := filepath.Glob(filepath.Join(*flagTemplates, "*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			fmt.Fprintf(h, "template %s\n", filepath.Base(f))
+			if err := hashFile(h, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The profile might be regenerated at the same path.
+	if *flagPGO != "" {
+		fmt.Fprintf(h, "pgo\n")
+		if err := hashFile(h, *flagPGO); err != nil {
+			return nil, err
+		}
+	}
+
+	return &buildCache{
+		path: filepath.Join(dir, hex.EncodeToString(h.Sum(nil))),
+	}, nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// get returns the cached code of the functions, or nil if there is no entry.
+func (c *buildCache) get() []generatedFunc {
+	b, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil
+	}
+	var gs []generatedFunc
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&gs); err != nil {
+		return nil
+	}
+	// Mark the entry as used so that it is not trimmed.
+	now := time.Now()
+	_ = os.Chtimes(c.path, now, now)
+	return gs
+}
+
+// put stores the code of the functions, and removes the entries that have not been used for a while.
+// The cache is only to save time, so an error is not fatal and is ignored.
+func (c *buildCache) put(gs []generatedFunc) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gs); err != nil {
+		return
+	}
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	// Write to a temporary file and rename it, so that a concurrent run never reads a partial entry.
+	f, err := ioutil.TempFile(dir, "tmp-")
+	if err != nil {
+		return
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if time.Since(e.ModTime()) < cacheTrimAge {
+			continue
+		}
+		os.Remove(filepath.Join(dir, e.Name()))
+	}
+}
@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	labelRe = regexp.MustCompile(`^label(\d+):;$`)
+	gotoRe  = regexp.MustCompile(`goto (label\d+);`)
+	identRe = regexp.MustCompile(`\b(?:stack|local|tmp)\w*`)
+
+	// varDeclRe matches a declaration of a stack variable or a temporary variable, e.g. "int stack0 = 1;".
+	varDeclRe = regexp.MustCompile(`^(?:var|int|long|float|double|uint|ulong) ((?:stack|tmp)\w*)(?: = ([^;]*))?;(?: //.*)?$`)
+
+	// pureInitRe matches an initializer without side effects: a constant or a variable.
+	pureInitRe = regexp.MustCompile(`^(?:-?\d+|(?:stack|local|tmp)\w*)$`)
+
+	// selfAssignRe matches an assignment of a variable to a variable, e.g. "local2 = local2;".
+	selfAssignRe = regexp.MustCompile(`^((?:stack|local|tmp)\w*) = ((?:stack|local|tmp)\w*);$`)
+
+	// compareRe matches a comparison of two variables, e.g. "(local0 != local0)".
+	compareRe = regexp.MustCompile(`\(((?:stack|local|tmp)\w*) (==|!=) ((?:stack|local|tmp)\w*)\)`)
+
+	// floatDeclRe matches a declaration of a float or a double variable, e.g. "double local1 = 0;" or "float local0".
+	floatDeclRe = regexp.MustCompile(`^(float|double) ((?:stack|local|tmp)\w*)\b`)
+)
+
+// removeWarnings removes the code that the C# compiler warns about from the body:
+// unreferenced labels (CS0164), unreachable statements (CS0162) and unused variables (CS0168 and CS0219).
+//
+// Removing code can make other code unused, e.g. a label referred only from unreachable code, so this repeats until nothing is removed.
+func removeWarnings(body []string) []string {
+	for {
+		n := len(body)
+		body = removeUnreferencedLabels(body)
+		body = removeUnreachable(body)
+		body = removeUnusedVars(body)
+		if len(body) == n {
+			return body
+		}
+	}
+}
+
+func removeUnreferencedLabels(body []string) []string {
+	refs := map[string]bool{}
+	for _, l := range body {
+		for _, m := range gotoRe.FindAllStringSubmatch(l, -1) {
+			refs[m[1]] = true
+		}
+	}
+	var r []string
+	for _, l := range body {
+		if m := labelRe.FindStringSubmatch(strings.TrimSpace(l)); m != nil && !refs["label"+m[1]] {
+			continue
+		}
+		r = append(r, l)
+	}
+	return r
+}
+
+// isJump reports whether the statement never completes normally.
+func isJump(stmt string) bool {
+	return strings.HasPrefix(stmt, "goto ") || stmt == "return;" || strings.HasPrefix(stmt, "return ") || strings.HasPrefix(stmt, "throw ")
+}
+
+// removeUnreachable removes the statements after goto, return or throw until the next label or the end of the block.
+//
+// The reachability is determined as the C# compiler does for if-else and switch, but a label is always regarded as reachable.
+// Then, this never removes code that the C# compiler regards as reachable.
+func removeUnreachable(body []string) []string {
+	r, _ := reachableStmts(body)
+	return r
+}
+
+// isEndReachable reports whether the end of the body is reachable, e.g. it is false if the body ends with an if-else
+// whose blocks both jump. Like removeUnreachable, this never reports false if the C# compiler regards the end as
+// reachable.
+func isEndReachable(body []string) bool {
+	_, reachable := reachableStmts(body)
+	return reachable
+}
+
+// reachableStmts returns the reachable statements of the body, and whether the end of the body is reachable.
+func reachableStmts(body []string) ([]string, bool) {
+	type frame struct {
+		kind string
+
+		// ifEndReachable reports whether the end of the if block is reachable. This is used for the else block.
+		ifEndReachable bool
+
+		// defaultJumps reports whether the default section of the switch jumps.
+		defaultJumps bool
+	}
+
+	var r []string
+	var frames []*frame
+	reachable := true
+	var pendingKind string
+	var lastIfEndReachable bool
+	var skipDepth int
+	for _, l := range body {
+		stmt := strings.TrimSpace(l)
+
+		if !reachable {
+			switch {
+			case skipDepth == 0 && labelRe.MatchString(stmt):
+				reachable = true
+			case stmt == "{":
+				skipDepth++
+				continue
+			case stmt == "}" && skipDepth > 0:
+				skipDepth--
+				continue
+			case stmt == "}":
+				// The end of the current block. Process this below.
+			default:
+				continue
+			}
+		}
+
+		r = append(r, l)
+		switch {
+		case strings.HasPrefix(stmt, "if ("):
+			pendingKind = "if"
+		case stmt == "else":
+			pendingKind = "else"
+		case strings.HasPrefix(stmt, "switch ("):
+			pendingKind = "switch"
+		case stmt == "{":
+			f := &frame{kind: pendingKind}
+			if pendingKind == "else" {
+				f.ifEndReachable = lastIfEndReachable
+			}
+			frames = append(frames, f)
+			pendingKind = ""
+		case stmt == "}":
+			if len(frames) == 0 {
+				break
+			}
+			f := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			switch f.kind {
+			case "if":
+				lastIfEndReachable = reachable
+				// The condition might be false.
+				reachable = true
+			case "else":
+				reachable = f.ifEndReachable || reachable
+			case "switch":
+				reachable = !f.defaultJumps
+			default:
+				reachable = true
+			}
+		case strings.HasPrefix(stmt, "default: "):
+			if len(frames) > 0 && isJump(strings.TrimPrefix(stmt, "default: ")) {
+				frames[len(frames)-1].defaultJumps = true
+			}
+		case isJump(stmt):
+			reachable = false
+		}
+	}
+	return r, reachable
+}
+
+// removeUnusedVars removes the declarations of variables that are never used, if the initializers have no side effects.
+func removeUnusedVars(body []string) []string {
+	counts := identCounts(body)
+	var r []string
+	for _, l := range body {
+		if m := varDeclRe.FindStringSubmatch(strings.TrimSpace(l)); m != nil && counts[m[1]] == 1 && (m[2] == "" || pureInitRe.MatchString(m[2])) {
+			continue
+		}
+		r = append(r, l)
+	}
+	return r
+}
+
+// removeSelfAssignments removes the assignments of variables to themselves (CS1717), which forwardSubstitute leaves
+// for a value that is read from a local and written back, e.g. "var stack0 = local2; local2 = stack0;".
+func removeSelfAssignments(body []string) []string {
+	var r []string
+	for _, l := range body {
+		if m := selfAssignRe.FindStringSubmatch(strings.TrimSpace(l)); m != nil && m[1] == m[2] {
+			continue
+		}
+		r = append(r, l)
+	}
+	return r
+}
+
+// replaceNaNChecks replaces the comparisons of float and double variables with themselves (CS1718), which
+// forwardSubstitute leaves for a NaN check like "local.get 0; local.get 0; f64.ne", with IsNaN. decls is the
+// declarations of the variables, e.g. the parameters and the locals, and varTypes is the types of the variables
+// declared with var.
+func replaceNaNChecks(body []string, decls []string, varTypes map[string]string) []string {
+	types := map[string]string{}
+	for n, t := range varTypes {
+		types[n] = t
+	}
+	for _, l := range append(append([]string{}, decls...), body...) {
+		if m := floatDeclRe.FindStringSubmatch(strings.TrimSpace(l)); m != nil {
+			types[m[2]] = m[1]
+		}
+	}
+	r := make([]string, len(body))
+	for i, l := range body {
+		r[i] = compareRe.ReplaceAllStringFunc(l, func(c string) string {
+			m := compareRe.FindStringSubmatch(c)
+			t := types[m[1]]
+			if m[1] != m[3] || (t != "float" && t != "double") {
+				return c
+			}
+			if m[2] == "!=" {
+				return fmt.Sprintf("%s.IsNaN(%s)", t, m[1])
+			}
+			return fmt.Sprintf("!%s.IsNaN(%s)", t, m[1])
+		})
+	}
+	return r
+}
+
+// removeUnusedLocals removes the declarations of the wasm local variables that are never used in the body.
+func removeUnusedLocals(locals []string, body []string) []string {
+	counts := identCounts(body)
+	var r []string
+	for _, l := range locals {
+		// A local is declared like "int local1 = 0;".
+		if ts := strings.Fields(l); len(ts) >= 2 && counts[ts[1]] == 0 {
+			continue
+		}
+		r = append(r, l)
+	}
+	return r
+}
+
+func identCounts(body []string) map[string]int {
+	counts := map[string]int{}
+	for _, l := range body {
+		// Skip comments like "// 1.500000".
+		if i := strings.Index(l, "//"); i >= 0 {
+			l = l[:i]
+		}
+		for _, id := range identRe.FindAllString(l, -1) {
+			counts[id]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+const clock = `    // IGoClock is the source of time for the Go program.
+    // Implement this to freeze or fast-forward time in tests.
+    public interface IGoClock
+    {
+        // UtcNow is the current wall clock time.
+        DateTime UtcNow { get; }
+
+        // MonotonicNanoseconds is the current time of a monotonic clock in nanoseconds.
+        long MonotonicNanoseconds { get; }
+
+        // Schedule invokes the callback after the delay. The callback may be invoked on any thread.
+        // Disposing the returned object cancels the callback.
+        IDisposable Schedule(TimeSpan delay, Action callback);
+    }
+
+    // SystemGoClock is an IGoClock backed by the system clock and timers.
+    public sealed class SystemGoClock : IGoClock
+    {
+        public DateTime UtcNow
+        {
+            get
+            {
+                return DateTime.UtcNow;
+            }
+        }
+
+        public long MonotonicNanoseconds
+        {
+            get
+            {
+                // Scale the ticks without losing precision even when the frequency doesn't divide 10^9,
+                // and without overflowing.
+                var ticks = this.stopwatch.ElapsedTicks;
+                var freq = Stopwatch.Frequency;
+                return ticks / freq * 1_000_000_000L + ticks % freq * 1_000_000_000L / freq;
+            }
+        }
+
+        public IDisposable Schedule(TimeSpan delay, Action callback)
+        {
+            Timer timer = new Timer(Math.Max(delay.TotalMilliseconds, 1));
+            timer.Elapsed += (sender, e) => {
+                callback();
+            };
+            timer.AutoReset = false;
+            timer.Start();
+            return timer;
+        }
+
+        private Stopwatch stopwatch = Stopwatch.StartNew();
+    }
+
+    // ManualGoClock is an IGoClock whose time advances only when Advance is called.
+    public sealed class ManualGoClock : IGoClock
+    {
+        public ManualGoClock()
+            : this(new DateTime(2000, 1, 1, 0, 0, 0, DateTimeKind.Utc))
+        {
+        }
+
+        public ManualGoClock(DateTime utcNow)
+        {
+            this.utcNow = utcNow;
+        }
+
+        public DateTime UtcNow
+        {
+            get
+            {
+                lock (this.timers)
+                {
+                    return this.utcNow;
+                }
+            }
+        }
+
+        public long MonotonicNanoseconds
+        {
+            get
+            {
+                lock (this.timers)
+                {
+                    return this.elapsed.Ticks * 100;
+                }
+            }
+        }
+
+        public IDisposable Schedule(TimeSpan delay, Action callback)
+        {
+            lock (this.timers)
+            {
+                var timer = new ManualTimer(this, this.elapsed + delay, callback);
+                this.timers.Add(timer);
+                return timer;
+            }
+        }
+
+        // Advance moves the time forward, and invokes the callbacks whose time has come in order.
+        public void Advance(TimeSpan delta)
+        {
+            TimeSpan end;
+            lock (this.timers)
+            {
+                end = this.elapsed + delta;
+            }
+            while (true)
+            {
+                ManualTimer next;
+                lock (this.timers)
+                {
+                    next = this.timers.Where(t => t.Due <= end).OrderBy(t => t.Due).FirstOrDefault();
+                    if (next == null)
+                    {
+                        this.utcNow += end - this.elapsed;
+                        this.elapsed = end;
+                        return;
+                    }
+                    this.timers.Remove(next);
+                    if (next.Due > this.elapsed)
+                    {
+                        this.utcNow += next.Due - this.elapsed;
+                        this.elapsed = next.Due;
+                    }
+                }
+                next.Callback();
+            }
+        }
+
+        sealed class ManualTimer : IDisposable
+        {
+            public ManualTimer(ManualGoClock clock, TimeSpan due, Action callback)
+            {
+                this.clock = clock;
+                this.Due = due;
+                this.Callback = callback;
+            }
+
+            public TimeSpan Due { get; }
+            public Action Callback { get; }
+
+            public void Dispose()
+            {
+                lock (this.clock.timers)
+                {
+                    this.clock.timers.Remove(this);
+                }
+            }
+
+            private ManualGoClock clock;
+        }
+
+        private DateTime utcNow;
+        private TimeSpan elapsed;
+        private List<ManualTimer> timers = new List<ManualTimer>();
+    }`
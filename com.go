@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"text/template"
+)
+
+// maxProgIDLen is the maximum length of a COM ProgID.
+const maxProgIDLen = 39
+
+// comGUIDNamespace is the namespace UUID to derive the GUIDs of the COM types from their names.
+var comGUIDNamespace = [16]byte{0x5c, 0x0e, 0x9a, 0x4d, 0x2b, 0x61, 0x4f, 0x3a, 0x9d, 0x27, 0x43, 0x8e, 0x1f, 0x70, 0xb2, 0x6c}
+
+// comGUID returns a name-based UUID (version 5) for the name.
+// The GUIDs must not change between generations, or COM clients would have to be registered again.
+func comGUID(name string) string {
+	h := sha1.New()
+	h.Write(comGUIDNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+var comTmpl = template.Must(template.New("com").Parse(`    // IGoCom is the COM interface of GoCom.
+    [ComVisible(true)]
+    [Guid("{{.InterfaceGUID}}")]
+    [InterfaceType(ComInterfaceType.InterfaceIsDual)]
+    public interface IGoCom
+    {
+        void Start(string args);
+        int Wait();
+{{- range $value := .Exports}}{{if $value.IsAPI}}
+{{$value.InterfaceCSharp "        "}}
+{{- end}}{{end}}
+    }
+
+    // GoCom is a COM-visible facade of Go for applications hosting .NET via COM, e.g. VBA.
+    // Start runs the program on a dedicated thread, and the exported functions can be called while the program runs.
+    [ComVisible(true)]
+    [Guid("{{.ClassGUID}}")]
+    [ClassInterface(ClassInterfaceType.None)]
+    [ProgId("{{.ProgID}}")]
+    public class GoCom : IGoCom
+    {
+        private readonly Go go = new Go
+        {
+            ThreadingModel = GoThreadingModel.DedicatedThread,
+            SerializeHostCalls = true,
+        };
+        private Task<int> task;
+
+        // Start starts the Go program with the arguments separated by spaces, and returns when the exported functions
+        // can be called.
+        public void Start(string args)
+        {
+            if (this.task != null)
+            {
+                throw new InvalidOperationException("Go program is already started");
+            }
+            var argv = (args ?? "").Split(new char[] { ' ', '\t' }, StringSplitOptions.RemoveEmptyEntries);
+            this.task = this.go.RunAsync(argv);
+            System.Threading.SpinWait.SpinUntil(() => this.go.Instance != null || this.task.IsCompleted);
+        }
+
+        // Wait waits for the Go program to exit, and returns the exit code.
+        public int Wait()
+        {
+            if (this.task == null)
+            {
+                throw new InvalidOperationException("Go program is not started");
+            }
+            return this.task.GetAwaiter().GetResult();
+        }
+{{- range $value := .Exports}}{{if $value.IsAPI}}
+
+{{$value.ComCSharp "        "}}
+{{- end}}{{end}}
+    }
+`))
+
+// comFacade returns the C# code of the COM-visible facade of the exported functions.
+func comFacade(namespace string, exports []*Export) (string, error) {
+	progID := namespace + ".GoCom"
+	if len(progID) > maxProgIDLen {
+		return "", fmt.Errorf("-com: the ProgID %q must be at most %d characters; use a shorter -namespace", progID, maxProgIDLen)
+	}
+
+	var buf bytes.Buffer
+	if err := comTmpl.Execute(&buf, struct {
+		InterfaceGUID string
+		ClassGUID     string
+		ProgID        string
+		Exports       []*Export
+	}{
+		InterfaceGUID: comGUID(namespace + ".IGoCom"),
+		ClassGUID:     comGUID(namespace + ".GoCom"),
+		ProgID:        progID,
+		Exports:       exports,
+	}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
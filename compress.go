@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// compressData concatenates the data segments in order and compresses them by the method of -compress.
+//
+// The segments are compressed as one stream rather than one by one, as Go's rodata is split into many segments that
+// share a lot.
+func compressData(data []Data, method string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch method {
+	case "gzip":
+		w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range data {
+			if _, err := w.Write(d.Data); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown -compress value %q", method)
+	}
+	return buf.Bytes(), nil
+}
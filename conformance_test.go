@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-interpreter/wagon/disasm"
+)
+
+// TestConformance runs the programs in testdata/conformance with Node.js and as the translated C# code, like the
+// conformance subcommand without translation flags.
+func TestConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode, as each program is built with the .NET SDK")
+	}
+	for _, c := range []string{"node", "dotnet"} {
+		if _, err := exec.LookPath(c); err != nil {
+			t.Skipf("%s is not in PATH", c)
+		}
+	}
+	wasmExec, err := wasmExecNode()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "go2dotnet-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The Go toolchain might emit instructions that the wasm decoder doesn't support yet, e.g. the non-trapping
+	// float-to-int conversions. Then every program would fail in the same way.
+	if err := conformanceDecodable(filepath.Join(conformanceCorpus, "hello"), filepath.Join(dir, "hello.wasm")); err != nil {
+		t.Skipf("the wasm files of the Go toolchain are not supported: %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(conformanceCorpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		t.Run(name, func(t *testing.T) {
+			src, err := filepath.Abs(filepath.Join(conformanceCorpus, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, got, err := conformanceRun(src, filepath.Join(dir, name), wasmExec, translator, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := want.diff(got); err != nil {
+				t.Errorf("%v\n--- node (exit code %d)\n%s--- dotnet (exit code %d)\n%s", err, want.ExitCode, want.Stdout, got.ExitCode, got.Stdout)
+			}
+		})
+	}
+}
+
+// conformanceDecodable builds the program in src to wasmPath, and reports whether its functions can be decoded.
+func conformanceDecodable(src, wasmPath string) (err error) {
+	src, err = filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if err := conformanceBuild(src, wasmPath); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(wasmPath)
+	if err != nil {
+		return err
+	}
+	mod, err := decodeModule(b)
+	if err != nil {
+		return err
+	}
+	if mod.Code == nil {
+		return nil
+	}
+	// The disassembler panics on an unknown opcode.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	for _, body := range mod.Code.Bodies {
+		if _, err := disasm.Disassemble(body.Code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// conformanceNamespace is the namespace of the code generated by the conformance subcommand.
+const conformanceNamespace = "Go2DotNet.Conformance"
+
+// conformanceCorpus is the default directory of the programs of the conformance subcommand. Each subdirectory is a
+// Go program.
+const conformanceCorpus = "testdata/conformance"
+
+var conformanceRunnerTmpl = template.Must(template.New("runner").Parse(`// Code generated by go2dotnet conformance. DO NOT EDIT.
+
+using System;
+
+namespace {{.Namespace}}.Runner
+{
+    public static class Program
+    {
+        public static int Main(string[] args)
+        {
+            try
+            {
+                return new {{.Namespace}}.Go().Run(args);
+            }
+            catch (GoPanicException e)
+            {
+                // Go's runtime prints the panic to the standard error, and exits with the code.
+                Console.Error.WriteLine(e.Message);
+                Console.Error.WriteLine(e.GoStackTrace);
+                return e.ExitCode;
+            }
+        }
+    }
+}
+`))
+
+// conformanceResult is the output of a program.
+type conformanceResult struct {
+	Stdout   []byte
+	ExitCode int
+}
+
+// runConformance runs the conformance subcommand with the arguments after "conformance".
+//
+// The subcommand builds each program of the corpus to wasm, runs it with Node.js and Go's wasm_exec_node.js, and runs
+// the C# code translated with the translation flags after "--". The standard outputs and the exit codes must be the
+// same. This catches regressions in the translation of the instructions and in the runtime, e.g. the imports of
+// wasm_exec.js, on real programs.
+func runConformance(args []string) error {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: go2dotnet conformance [flags] [corpus] [-- translation flags]\n")
+		fs.PrintDefaults()
+	}
+	runRe := fs.String("run", "", "Regular expression to select the programs to run by the directory names")
+	keep := fs.String("keep", "", "Directory to keep the wasm files and the projects in, instead of a temporary directory")
+	verbose := fs.Bool("v", false, "Print the outputs of the programs that fail")
+
+	var transFlags []string
+	for i, a := range args {
+		if a == "--" {
+			args, transFlags = args[:i], args[i+1:]
+			break
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, f := range transFlags {
+		name := strings.SplitN(strings.TrimLeft(f, "-"), "=", 2)[0]
+		for _, r := range benchReservedFlags {
+			if strings.HasPrefix(f, "-") && name == r {
+				return fmt.Errorf("conformance: -%s cannot be given as a translation flag", r)
+			}
+		}
+	}
+	corpus := conformanceCorpus
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		corpus = fs.Arg(0)
+	default:
+		return fmt.Errorf("conformance: too many arguments: %q", fs.Args())
+	}
+	var re *regexp.Regexp
+	if *runRe != "" {
+		r, err := regexp.Compile(*runRe)
+		if err != nil {
+			return fmt.Errorf("conformance: -run: %v", err)
+		}
+		re = r
+	}
+
+	corpus, err := filepath.Abs(corpus)
+	if err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(corpus)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || (re != nil && !re.MatchString(e.Name())) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("conformance: no programs in %s", corpus)
+	}
+
+	wasmExec, err := wasmExecNode()
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	dir := *keep
+	if dir == "" {
+		d, err := ioutil.TempDir("", "go2dotnet-conformance")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(d)
+		dir = d
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, name := range names {
+		want, got, err := conformanceRun(filepath.Join(corpus, name), filepath.Join(dir, name), wasmExec, self, transFlags)
+		if err == nil {
+			err = want.diff(got)
+		}
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			if *verbose && want != nil {
+				fmt.Printf("--- node (exit code %d)\n%s", want.ExitCode, want.Stdout)
+				if got != nil {
+					fmt.Printf("--- dotnet (exit code %d)\n%s", got.ExitCode, got.Stdout)
+				}
+			}
+			failed = append(failed, name)
+			continue
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("conformance: %d of %d programs failed: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// conformanceRun builds the program in src to the directory dir, and returns the results of the program under
+// Node.js and of the program translated by the go2dotnet binary translator.
+func conformanceRun(src, dir, wasmExec, translator string, transFlags []string) (*conformanceResult, *conformanceResult, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	wasmPath := filepath.Join(dir, "prog.wasm")
+	if err := conformanceBuild(src, wasmPath); err != nil {
+		return nil, nil, err
+	}
+
+	want, err := conformanceExec(exec.Command("node", wasmExec, wasmPath))
+	if err != nil {
+		return nil, nil, fmt.Errorf("node failed: %v", err)
+	}
+
+	genDir := filepath.Join(dir, "gen")
+	trans := exec.Command(translator, append(transFlags, "-trusted", "-wasm", wasmPath, "-namespace", conformanceNamespace, "-out", genDir)...)
+	if out, err := trans.CombinedOutput(); err != nil {
+		return want, nil, fmt.Errorf("translation failed: %v\n%s", err, out)
+	}
+
+	var code bytes.Buffer
+	if err := conformanceRunnerTmpl.Execute(&code, struct {
+		Namespace string
+	}{
+		Namespace: conformanceNamespace,
+	}); err != nil {
+		return want, nil, err
+	}
+	runner := &subproject{
+		Name:            "Runner",
+		Project:         filepath.Join("gen", projectName(wasmPath)),
+		TargetFramework: "net8.0",
+		Exe:             true,
+		Files: map[string][]byte{
+			"Program.cs": code.Bytes(),
+		},
+	}
+	if err := runner.write(dir); err != nil {
+		return want, nil, err
+	}
+	csproj := filepath.Join(dir, runner.Name, runner.Name+".csproj")
+	// The generated code must build without warnings, e.g. of unreachable code or self-assignments.
+	if out, err := exec.Command("dotnet", "build", "-c", "Release", "-warnaserror", csproj).CombinedOutput(); err != nil {
+		return want, nil, fmt.Errorf("dotnet build failed: %v\n%s", err, out)
+	}
+	got, err := conformanceExec(exec.Command("dotnet", "run", "-c", "Release", "--no-build", "--project", csproj))
+	if err != nil {
+		return want, nil, fmt.Errorf("dotnet run failed: %v", err)
+	}
+	return want, got, nil
+}
+
+// conformanceBuild builds the program in src to the wasm file wasmPath.
+func conformanceBuild(src, wasmPath string) error {
+	build := exec.Command("go", "build", "-o", wasmPath, ".")
+	build.Dir = src
+	build.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	if out, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build failed: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// conformanceExec runs the command, and returns its standard output and its exit code. A non-zero exit code is not
+// an error, as a program might exit with it, e.g. by a panic.
+func conformanceExec(cmd *exec.Cmd) (*conformanceResult, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return &conformanceResult{Stdout: stdout.Bytes(), ExitCode: e.ExitCode()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conformanceResult{Stdout: stdout.Bytes()}, nil
+}
+
+// diff returns an error that describes the first difference between the results of Node.js and the translated
+// program, or nil if they are the same.
+func (want *conformanceResult) diff(got *conformanceResult) error {
+	if want.ExitCode != got.ExitCode {
+		return fmt.Errorf("exit code %d, want %d", got.ExitCode, want.ExitCode)
+	}
+	if bytes.Equal(want.Stdout, got.Stdout) {
+		return nil
+	}
+	wls := strings.Split(string(want.Stdout), "\n")
+	gls := strings.Split(string(got.Stdout), "\n")
+	for i := 0; i < len(wls) && i < len(gls); i++ {
+		if wls[i] != gls[i] {
+			return fmt.Errorf("stdout line %d: %q, want %q", i+1, gls[i], wls[i])
+		}
+	}
+	return fmt.Errorf("stdout has %d lines, want %d", len(gls), len(wls))
+}
+
+// wasmExecNode returns the path of wasm_exec_node.js of the Go toolchain, which runs a wasm file with Node.js.
+func wasmExecNode() (string, error) {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOROOT failed: %v", err)
+	}
+	root := strings.TrimSpace(string(out))
+	// The file is in lib/wasm since Go 1.24, and in misc/wasm before.
+	for _, d := range []string{"lib", "misc"} {
+		p := filepath.Join(root, d, "wasm", "wasm_exec_node.js")
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("wasm_exec_node.js is not found in %s", root)
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// goFunc is a Go function in the source.
+type goFunc struct {
+	// Doc is the doc comment, or an empty string if none.
+	Doc string
+
+	// Params is the parameters.
+	Params []goParam
+
+	// Results is the results.
+	Results []goParam
+}
+
+// goParam is a parameter of a Go function.
+type goParam struct {
+	// Name is the name of the parameter, or an empty string if unnamed.
+	Name string
+
+	// Type is the type as written in the source, e.g. "*byte" or "unsafe.Pointer".
+	Type string
+}
+
+// loadGoFuncs parses the Go package in the directory and returns the functions, keyed by the wasm export names.
+//
+// A function with a //go:wasmexport directive is keyed by the name in the directive. Other functions are keyed by their Go names
+// so that exports with the same names, if any, are documented.
+func loadGoFuncs(dir string) (map[string]*goFunc, error) {
+	ctx := build.Default
+	ctx.GOOS = "js"
+	ctx.GOARCH = "wasm"
+	pkg, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := map[string]*goFunc{}
+	fset := token.NewFileSet()
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil {
+				continue
+			}
+			fn := &goFunc{
+				Params:  goParams(fd.Type.Params),
+				Results: goParams(fd.Type.Results),
+			}
+			if fd.Doc != nil {
+				fn.Doc = strings.TrimSpace(fd.Doc.Text())
+				if name := wasmExportName(fd.Doc); name != "" {
+					funcs[name] = fn
+					continue
+				}
+			}
+			if _, ok := funcs[fd.Name.Name]; !ok {
+				funcs[fd.Name.Name] = fn
+			}
+		}
+	}
+	return funcs, nil
+}
+
+// goParams returns the parameters of the field list, one for each name.
+func goParams(fields *ast.FieldList) []goParam {
+	if fields == nil {
+		return nil
+	}
+	var params []goParam
+	for _, field := range fields.List {
+		t := types.ExprString(field.Type)
+		if len(field.Names) == 0 {
+			params = append(params, goParam{Type: t})
+			continue
+		}
+		for _, n := range field.Names {
+			params = append(params, goParam{Name: n.Name, Type: t})
+		}
+	}
+	return params
+}
+
+// wasmExportName returns the name in the //go:wasmexport directive of the comments, or an empty string if there is no directive.
+func wasmExportName(doc *ast.CommentGroup) string {
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, "//go:wasmexport ") {
+			continue
+		}
+		return strings.TrimSpace(strings.TrimPrefix(c.Text, "//go:wasmexport "))
+	}
+	return ""
+}
+
+// xmlDoc returns the doc comment as a C# XML documentation comment.
+func xmlDoc(doc string, indent string) string {
+	var buf bytes.Buffer
+	buf.WriteString(indent + "/// <summary>\n")
+	for _, l := range strings.Split(doc, "\n") {
+		var b bytes.Buffer
+		// Code blocks in Go doc comments are indented with tabs, which EscapeText would escape.
+		xml.EscapeText(&b, []byte(strings.Replace(l, "\t", "    ", -1)))
+		if b.Len() == 0 {
+			buf.WriteString(indent + "///\n")
+			continue
+		}
+		buf.WriteString(indent + "/// " + b.String() + "\n")
+	}
+	buf.WriteString(indent + "/// </summary>\n")
+	return buf.String()
+}
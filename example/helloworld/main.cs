@@ -7,10 +7,10 @@ namespace Go2DotNet.Example.HelloWorld
 {
     class Program
     {
-        static void Main(string[] args)
+        static int Main(string[] args)
         {
             Go go = new Go();
-            go.Run().Wait();
+            return go.Run();
         }
     }    
 }
@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// exportsReservedNames is the names that the methods of Exports cannot have: the class name, the other members and
+// the methods of object. A method of such a name is suffixed with an underscore, which identifierFromString never ends a name with.
+var exportsReservedNames = map[string]bool{
+	"Exports":         true,
+	"Delegates":       true,
+	"GetDelegates":    true,
+	"delegates":       true,
+	"go":              true,
+	"Equals":          true,
+	"Finalize":        true,
+	"GetHashCode":     true,
+	"GetType":         true,
+	"MemberwiseClone": true,
+	"ReferenceEquals": true,
+	"ToString":        true,
+}
+
+// goTypeToCSharp returns the .NET type of the Go type passed as the wasm type, or the wasm type's C# type if the Go
+// type is not a number or bool.
+func goTypeToCSharp(goType string, t ReturnType) string {
+	switch t {
+	case ReturnTypeI32:
+		switch goType {
+		case "bool":
+			return "bool"
+		case "int8":
+			return "sbyte"
+		case "uint8", "byte":
+			return "byte"
+		case "int16":
+			return "short"
+		case "uint16":
+			return "ushort"
+		case "uint", "uint32", "uintptr":
+			return "uint"
+		}
+	case ReturnTypeI64:
+		switch goType {
+		case "uint", "uint64", "uintptr":
+			return "ulong"
+		}
+	}
+	return t.CSharp()
+}
+
+// toWasmValue returns the C# expression to convert the value of the .NET type to the wasm type.
+func toWasmValue(expr string, csType string, t ReturnType) string {
+	switch {
+	case csType == t.CSharp():
+		return expr
+	case csType == "bool":
+		return fmt.Sprintf("(%s ? 1 : 0)", expr)
+	default:
+		return fmt.Sprintf("unchecked((%s)%s)", t.CSharp(), expr)
+	}
+}
+
+// fromWasmValue returns the C# expression to convert the value of the wasm type to the .NET type.
+func fromWasmValue(expr string, csType string, t ReturnType) string {
+	switch {
+	case csType == t.CSharp():
+		return expr
+	case csType == "bool":
+		return fmt.Sprintf("%s != 0", expr)
+	default:
+		return fmt.Sprintf("unchecked((%s)%s)", csType, expr)
+	}
+}
+
+// FacadeName returns the name of the method of Exports, which is the export name as a C# identifier.
+func (e *Export) FacadeName() string {
+	n := identifierFromString(e.Name)
+	if exportsReservedNames[n] {
+		return n + "_"
+	}
+	if csharpKeywords[n] {
+		return "@" + n
+	}
+	return n
+}
+
+// isFacadeString reports whether the Go parameter is a string that Exports passes as a pointer and a length in bytes.
+// The string is copied into memory allocated by the module's allocator, so this requires the allocator.
+func (e *Export) isFacadeString(p goParam) bool {
+	return p.Type == "string" && e.Malloc
+}
+
+// facadeGoParams returns the Go parameters if they match the wasm parameters, or nil. A string is two i32 parameters.
+func (e *Export) facadeGoParams() []goParam {
+	ts := e.Funcs[e.Index].Wasm.Sig.ParamTypes
+	i := 0
+	for _, p := range e.GoParams {
+		if !e.isFacadeString(p) {
+			i++
+			continue
+		}
+		if i+1 >= len(ts) || ts[i] != wasm.ValueTypeI32 || ts[i+1] != wasm.ValueTypeI32 {
+			return nil
+		}
+		i += 2
+	}
+	if i != len(ts) {
+		// The source doesn't match the wasm file.
+		return nil
+	}
+	return e.GoParams
+}
+
+// facadeParamNames returns the parameter names of the method of Exports. A parameter is named after the local name
+// in the name section, or the Go parameter by -src, or argN if neither is available or the name is not unique.
+// A string is named after the Go parameter.
+func (e *Export) facadeParamNames() []string {
+	goParams := e.facadeGoParams()
+	n := len(e.Funcs[e.Index].Wasm.Sig.ParamTypes)
+	if goParams != nil {
+		n = len(goParams)
+	}
+	names := make([]string, n)
+	used := map[string]bool{}
+	wasmIndex := 0
+	for i := 0; i < n; i++ {
+		var name string
+		str := goParams != nil && e.isFacadeString(goParams[i])
+		if !str && wasmIndex < len(e.ParamNames) {
+			name = e.ParamNames[wasmIndex]
+		}
+		if name == "" && goParams != nil {
+			name = goParams[i].Name
+		}
+		if name != "" && name != "_" {
+			name = identifierFromString(name)
+			if csharpKeywords[name] {
+				name = "@" + name
+			}
+		}
+		if name == "" || name == "_" || used[name] || reservedIdentifierRe.MatchString(name) {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		used[name] = true
+		names[i] = name
+		wasmIndex++
+		if str {
+			wasmIndex++
+		}
+	}
+	return names
+}
+
+// FacadeField returns the name of the field of Exports.Delegates that has the typed delegate of the exported function.
+// As the identifiers never end with an underscore except _, the field never has the name of another member or a
+// keyword.
+func (e *Export) FacadeField() string {
+	return strings.TrimPrefix(e.Ident, "@") + "_"
+}
+
+// FacadeCSharp returns the method of Exports that calls the exported function.
+//
+// The typed delegate is resolved by GetExport once for each instance, and the method calls the delegate in the field.
+//
+// The parameter and return types are the .NET types of the Go types if known by -src and they are passed as the wasm
+// types as they are, e.g. uint for uint32 and bool for bool. Otherwise, they are the wasm types. A Go string is
+// a string, which is copied by WriteString and passed as the pointer and the length if the module exports an
+// allocator. The Go function owns the copied string.
+func (e *Export) FacadeCSharp(indent string) (string, error) {
+	sig := e.Funcs[e.Index].Wasm.Sig
+	var retType ReturnType
+	switch ts := sig.ReturnTypes; len(ts) {
+	case 0:
+		retType = ReturnTypeVoid
+	case 1:
+		retType = wasmTypeToReturnType(ts[0])
+	default:
+		return "", fmt.Errorf("the number of return values must be 0 or 1 but %d", len(ts))
+	}
+
+	goParams := e.facadeGoParams()
+	names := e.facadeParamNames()
+	var params []string
+	var args []string
+	var lens []string
+	wasmIndex := 0
+	for i, name := range names {
+		if goParams != nil && e.isFacadeString(goParams[i]) {
+			l := fmt.Sprintf("tmp%d", len(lens))
+			lens = append(lens, l)
+			params = append(params, fmt.Sprintf("string %s", name))
+			args = append(args, fmt.Sprintf("this.go.WriteString(%s, out %s)", name, l), l)
+			wasmIndex += 2
+			continue
+		}
+		rt := wasmTypeToReturnType(sig.ParamTypes[wasmIndex])
+		csType := rt.CSharp()
+		if goParams != nil {
+			csType = goTypeToCSharp(goParams[i].Type, rt)
+		}
+		params = append(params, fmt.Sprintf("%s %s", csType, name))
+		args = append(args, toWasmValue(name, csType, rt))
+		wasmIndex++
+	}
+
+	call := fmt.Sprintf("this.GetDelegates().%s(%s)", e.FacadeField(), strings.Join(args, ", "))
+	csRetType := retType.CSharp()
+	body := call + ";"
+	if retType != ReturnTypeVoid {
+		if len(e.GoResults) == 1 {
+			csRetType = goTypeToCSharp(e.GoResults[0].Type, retType)
+		}
+		body = fmt.Sprintf("return %s;", fromWasmValue(call, csRetType, retType))
+	}
+	if len(lens) > 0 {
+		body = fmt.Sprintf("int %s;\n    %s", strings.Join(lens, ", "), body)
+	}
+
+	str := fmt.Sprintf(`public %s %s(%s)
+{
+    %s
+}`, csRetType, e.FacadeName(), strings.Join(params, ", "), body)
+
+	lines := strings.Split(str, "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	str = strings.Join(lines, "\n")
+	if e.Doc != "" {
+		str = xmlDoc(e.Doc, indent) + str
+	}
+	return str, nil
+}
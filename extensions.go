@@ -0,0 +1,393 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// Go's wasm backend emits the instructions of the sign extension, the non-trapping float-to-int conversion and the
+// bulk memory proposals since Go 1.21, and wagon decodes none of them. lowerExtensions rewrites them into MVP
+// instructions right after decoding, so that the validation, the translation and the interpreter of verify see an
+// MVP module.
+
+// The opcodes of the extensions. prefixFC is followed by the number of the instruction in LEB128.
+const (
+	opI32Extend8S  = 0xc0
+	opI32Extend16S = 0xc1
+	opI64Extend8S  = 0xc2
+	opI64Extend16S = 0xc3
+	opI64Extend32S = 0xc4
+	prefixFC       = 0xfc
+)
+
+// The numbers of the instructions with prefixFC that are lowered, besides the conversions 0 to 7.
+const (
+	fcMemoryCopy = 10
+	fcMemoryFill = 11
+)
+
+// extensionFuncPrefix is the prefix of the names of the helper functions that lowerExtensions appends.
+const extensionFuncPrefix = "go2dotnet."
+
+// signExtShifts is the number of the bits to shift a value left and back for each sign extension.
+var signExtShifts = map[byte]int{
+	opI32Extend8S:  24,
+	opI32Extend16S: 16,
+	opI64Extend8S:  56,
+	opI64Extend16S: 48,
+	opI64Extend32S: 32,
+}
+
+// truncSat is a non-trapping float-to-int conversion, which the helper function implements with the trapping one
+// for the values in [min, max) and the saturated results for the others.
+type truncSat struct {
+	name       string
+	from       wasm.ValueType
+	to         wasm.ValueType
+	trunc      byte
+	min, max   float64
+	minResult  int64
+	maxResult  int64
+	floatBits  func(float64) []byte
+	floatCmpNe byte
+	floatCmpLt byte
+	floatCmpGe byte
+}
+
+// truncSats is the conversions by the instruction numbers with prefixFC.
+var truncSats = func() []truncSat {
+	f32 := func(f float64) []byte {
+		b := make([]byte, 5)
+		b[0] = operators.F32Const
+		bits := math.Float32bits(float32(f))
+		for i := 0; i < 4; i++ {
+			b[1+i] = byte(bits >> (8 * i))
+		}
+		return b
+	}
+	f64 := func(f float64) []byte {
+		b := make([]byte, 9)
+		b[0] = operators.F64Const
+		bits := math.Float64bits(f)
+		for i := 0; i < 8; i++ {
+			b[1+i] = byte(bits >> (8 * i))
+		}
+		return b
+	}
+	fromF32 := func(t truncSat) truncSat {
+		t.from, t.floatBits = wasm.ValueTypeF32, f32
+		t.floatCmpNe, t.floatCmpLt, t.floatCmpGe = operators.F32Ne, operators.F32Lt, operators.F32Ge
+		return t
+	}
+	fromF64 := func(t truncSat) truncSat {
+		t.from, t.floatBits = wasm.ValueTypeF64, f64
+		t.floatCmpNe, t.floatCmpLt, t.floatCmpGe = operators.F64Ne, operators.F64Lt, operators.F64Ge
+		return t
+	}
+	i32s := truncSat{to: wasm.ValueTypeI32, min: -1 << 31, max: 1 << 31, minResult: math.MinInt32, maxResult: math.MaxInt32}
+	i32u := truncSat{to: wasm.ValueTypeI32, min: 0, max: 1 << 32, minResult: 0, maxResult: -1}
+	i64s := truncSat{to: wasm.ValueTypeI64, min: -1 << 63, max: 1 << 63, minResult: math.MinInt64, maxResult: math.MaxInt64}
+	i64u := truncSat{to: wasm.ValueTypeI64, min: 0, max: 1 << 64, minResult: 0, maxResult: -1}
+	named := func(t truncSat, name string, trunc byte) truncSat {
+		t.name, t.trunc = name, trunc
+		return t
+	}
+	return []truncSat{
+		named(fromF32(i32s), "i32.trunc_sat_f32_s", operators.I32TruncSF32),
+		named(fromF32(i32u), "i32.trunc_sat_f32_u", operators.I32TruncUF32),
+		named(fromF64(i32s), "i32.trunc_sat_f64_s", operators.I32TruncSF64),
+		named(fromF64(i32u), "i32.trunc_sat_f64_u", operators.I32TruncUF64),
+		named(fromF32(i64s), "i64.trunc_sat_f32_s", operators.I64TruncSF32),
+		named(fromF32(i64u), "i64.trunc_sat_f32_u", operators.I64TruncUF32),
+		named(fromF64(i64s), "i64.trunc_sat_f64_s", operators.I64TruncSF64),
+		named(fromF64(i64u), "i64.trunc_sat_f64_u", operators.I64TruncUF64),
+	}
+}()
+
+// extensionFunc is a helper function that implements an instruction with prefixFC. The code doesn't end with the
+// end of the function, like the bodies that wagon decodes.
+type extensionFunc struct {
+	name string
+	sig  wasm.FunctionSig
+	code []byte
+}
+
+// newExtensionFunc returns the helper function of the instruction number with prefixFC.
+func newExtensionFunc(n uint32) (*extensionFunc, error) {
+	i32 := wasm.ValueTypeI32
+	switch {
+	case n < uint32(len(truncSats)):
+		t := truncSats[n]
+		iconst := func(v int64) []byte {
+			if t.to == wasm.ValueTypeI32 {
+				return leb128.AppendSleb128([]byte{operators.I32Const}, int64(int32(v)))
+			}
+			return leb128.AppendSleb128([]byte{operators.I64Const}, v)
+		}
+		// NaN is 0, and the values out of the range are the minimum or the maximum. The checks are nested if-else
+		// blocks with the result.
+		var code []byte
+		for _, c := range []struct {
+			cmp    []byte
+			result int64
+		}{
+			{cmp: []byte{operators.GetLocal, 0, t.floatCmpNe}, result: 0},
+			{cmp: append(t.floatBits(t.min), t.floatCmpLt), result: t.minResult},
+			{cmp: append(t.floatBits(t.max), t.floatCmpGe), result: t.maxResult},
+		} {
+			code = append(code, operators.GetLocal, 0)
+			code = append(code, c.cmp...)
+			code = append(code, operators.If, byte(t.to))
+			code = append(code, iconst(c.result)...)
+			code = append(code, operators.Else)
+		}
+		code = append(code, operators.GetLocal, 0, t.trunc, operators.End, operators.End, operators.End)
+		return &extensionFunc{
+			name: t.name,
+			sig:  wasm.FunctionSig{Form: 0x60, ParamTypes: []wasm.ValueType{t.from}, ReturnTypes: []wasm.ValueType{t.to}},
+			code: code,
+		}, nil
+
+	case n == fcMemoryCopy:
+		// The parameters are the destination, the source and the length. The ranges can overlap, so the bytes are
+		// copied backward if the destination is after the source.
+		code := extensionBoundsCheck(0, 2)
+		code = append(code, extensionBoundsCheck(1, 2)...)
+		code = append(code,
+			operators.GetLocal, 0, operators.GetLocal, 1, operators.I32LeU,
+			operators.If, byte(wasm.BlockTypeEmpty),
+			operators.Block, byte(wasm.BlockTypeEmpty), operators.Loop, byte(wasm.BlockTypeEmpty),
+			operators.GetLocal, 2, operators.I32Eqz, operators.BrIf, 1,
+			operators.GetLocal, 0, operators.GetLocal, 1, operators.I32Load8u, 0, 0, operators.I32Store8, 0, 0,
+			operators.GetLocal, 0, operators.I32Const, 1, operators.I32Add, operators.SetLocal, 0,
+			operators.GetLocal, 1, operators.I32Const, 1, operators.I32Add, operators.SetLocal, 1,
+			operators.GetLocal, 2, operators.I32Const, 1, operators.I32Sub, operators.SetLocal, 2,
+			operators.Br, 0,
+			operators.End, operators.End,
+			operators.Else,
+			operators.Block, byte(wasm.BlockTypeEmpty), operators.Loop, byte(wasm.BlockTypeEmpty),
+			operators.GetLocal, 2, operators.I32Eqz, operators.BrIf, 1,
+			operators.GetLocal, 2, operators.I32Const, 1, operators.I32Sub, operators.SetLocal, 2,
+			operators.GetLocal, 0, operators.GetLocal, 2, operators.I32Add,
+			operators.GetLocal, 1, operators.GetLocal, 2, operators.I32Add, operators.I32Load8u, 0, 0,
+			operators.I32Store8, 0, 0,
+			operators.Br, 0,
+			operators.End, operators.End,
+			operators.End,
+		)
+		return &extensionFunc{
+			name: "memory.copy",
+			sig:  wasm.FunctionSig{Form: 0x60, ParamTypes: []wasm.ValueType{i32, i32, i32}},
+			code: code,
+		}, nil
+
+	case n == fcMemoryFill:
+		// The parameters are the destination, the byte value and the length.
+		code := extensionBoundsCheck(0, 2)
+		code = append(code,
+			operators.Block, byte(wasm.BlockTypeEmpty), operators.Loop, byte(wasm.BlockTypeEmpty),
+			operators.GetLocal, 2, operators.I32Eqz, operators.BrIf, 1,
+			operators.GetLocal, 0, operators.GetLocal, 1, operators.I32Store8, 0, 0,
+			operators.GetLocal, 0, operators.I32Const, 1, operators.I32Add, operators.SetLocal, 0,
+			operators.GetLocal, 2, operators.I32Const, 1, operators.I32Sub, operators.SetLocal, 2,
+			operators.Br, 0,
+			operators.End, operators.End,
+		)
+		return &extensionFunc{
+			name: "memory.fill",
+			sig:  wasm.FunctionSig{Form: 0x60, ParamTypes: []wasm.ValueType{i32, i32, i32}},
+			code: code,
+		}, nil
+	}
+	return nil, fmt.Errorf("instruction 0xfc %d is not supported", n)
+}
+
+// extensionBoundsCheck returns the instructions that trap if the range of the address and the length in the
+// locals is out of the memory, before any byte is written. The end is computed in i64 so that it doesn't overflow.
+func extensionBoundsCheck(addr, length byte) []byte {
+	return []byte{
+		operators.GetLocal, addr, operators.I64ExtendUI32,
+		operators.GetLocal, length, operators.I64ExtendUI32,
+		operators.I64Add,
+		operators.CurrentMemory, 0, operators.I64ExtendUI32, operators.I64Const, 16, operators.I64Shl,
+		operators.I64GtU,
+		operators.If, byte(wasm.BlockTypeEmpty), operators.Unreachable, operators.End,
+	}
+}
+
+// readExtensionFC reads the instruction number after prefixFC and skips the immediates of the instruction.
+func readExtensionFC(r *bytes.Reader) (uint32, error) {
+	n, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case fcMemoryCopy:
+		// The memory indices of the destination and the source.
+		_, err = r.Seek(2, io.SeekCurrent)
+	case fcMemoryFill:
+		// The memory index.
+		_, err = r.ReadByte()
+	}
+	return n, err
+}
+
+// loweredInstrs returns the number of the instructions that the instruction at the start of code is lowered into.
+func loweredInstrs(code []byte) int {
+	if _, ok := signExtShifts[code[0]]; ok {
+		return 4
+	}
+	return 1
+}
+
+// lowerExtensions rewrites the instructions of the extensions in the function bodies of the module. A sign
+// extension is a pair of shifts, and an instruction with prefixFC is a call of a helper function that is appended to
+// the module and named extensionFuncPrefix + the instruction name, e.g. "go2dotnet.memory.copy". The helper functions
+// are MVP code, and the translation replaces the ones of memory.copy and memory.fill with Mem.Copy and Mem.Fill.
+//
+// The helper functions are added to the name section, and their signatures to the type section, so that the module
+// can be encoded again.
+func lowerExtensions(mod *wasm.Module) error {
+	if mod.Code == nil || mod.Function == nil {
+		return nil
+	}
+
+	// Find the instructions with prefixFC first, as the indices of their helper functions are in the calls.
+	offsets := make([][]uint64, len(mod.Code.Bodies))
+	used := map[uint32]bool{}
+	lower := false
+	for i, body := range mod.Code.Bodies {
+		os, err := instrOffsets(body.Code)
+		if err != nil {
+			return fmt.Errorf("function body %d: %v", i, err)
+		}
+		offsets[i] = os
+		for _, o := range os {
+			switch op := body.Code[o]; {
+			case op == prefixFC:
+				n, err := readExtensionFC(bytes.NewReader(body.Code[o+1:]))
+				if err != nil {
+					return fmt.Errorf("function body %d: %v", i, err)
+				}
+				used[n] = true
+				lower = true
+			case signExtShifts[op] != 0:
+				lower = true
+			}
+		}
+	}
+	if !lower {
+		return nil
+	}
+
+	var numImports int
+	if mod.Import != nil {
+		for _, e := range mod.Import.Entries {
+			if _, ok := e.Type.(wasm.FuncImport); ok {
+				numImports++
+			}
+		}
+	}
+	var ns []uint32
+	for n := range used {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i] < ns[j] })
+	var helpers []*extensionFunc
+	indices := map[uint32]uint32{}
+	for _, n := range ns {
+		h, err := newExtensionFunc(n)
+		if err != nil {
+			return err
+		}
+		indices[n] = uint32(numImports + len(mod.Function.Types) + len(helpers))
+		helpers = append(helpers, h)
+	}
+
+	for i := range mod.Code.Bodies {
+		code := mod.Code.Bodies[i].Code
+		os := offsets[i]
+		var lowered []byte
+		for j, o := range os {
+			end := uint64(len(code))
+			if j+1 < len(os) {
+				end = os[j+1]
+			}
+			switch op := code[o]; {
+			case op == prefixFC:
+				n, _ := readExtensionFC(bytes.NewReader(code[o+1 : end]))
+				lowered = leb128.AppendUleb128(append(lowered, operators.Call), uint64(indices[n]))
+			case signExtShifts[op] != 0:
+				shl, shr, c := operators.I32Shl, operators.I32ShrS, operators.I32Const
+				if op >= opI64Extend8S {
+					shl, shr, c = operators.I64Shl, operators.I64ShrS, operators.I64Const
+				}
+				s := byte(signExtShifts[op])
+				lowered = append(lowered, c, s, shl, c, s, shr)
+			default:
+				lowered = append(lowered, code[o:end]...)
+			}
+		}
+		mod.Code.Bodies[i].Code = lowered
+	}
+
+	var names wasm.NameMap
+	nsec := &wasm.NameSection{Types: map[wasm.NameType][]byte{}}
+	custom := mod.Custom(wasm.CustomSectionName)
+	if custom != nil {
+		if err := nsec.UnmarshalWASM(bytes.NewReader(custom.Data)); err != nil {
+			return err
+		}
+		if sub, err := nsec.Decode(wasm.NameFunction); err != nil {
+			return err
+		} else if sub != nil {
+			names = sub.(*wasm.FunctionNames).Names
+		}
+	}
+	if names == nil {
+		names = wasm.NameMap{}
+	}
+	for _, h := range helpers {
+		idx := uint32(numImports + len(mod.Function.Types))
+		mod.Function.Types = append(mod.Function.Types, extensionType(mod, h.sig))
+		mod.Code.Bodies = append(mod.Code.Bodies, wasm.FunctionBody{Module: mod, Code: h.code})
+		names[idx] = extensionFuncPrefix + h.name
+	}
+
+	var buf bytes.Buffer
+	if err := (&wasm.FunctionNames{Names: names}).MarshalWASM(&buf); err != nil {
+		return err
+	}
+	nsec.Types[wasm.NameFunction] = buf.Bytes()
+	buf = bytes.Buffer{}
+	if err := nsec.MarshalWASM(&buf); err != nil {
+		return err
+	}
+	if custom == nil {
+		custom = &wasm.SectionCustom{Name: wasm.CustomSectionName}
+		mod.Customs = append(mod.Customs, custom)
+		mod.Sections = append(mod.Sections, custom)
+	}
+	custom.Data = buf.Bytes()
+	return nil
+}
+
+// extensionType returns the index of the signature in the type section, appending it if it is not there.
+func extensionType(mod *wasm.Module, sig wasm.FunctionSig) uint32 {
+	for i, t := range mod.Types.Entries {
+		if sameSig(&t, &sig) {
+			return uint32(i)
+		}
+	}
+	mod.Types.Entries = append(mod.Types.Entries, sig)
+	return uint32(len(mod.Types.Entries) - 1)
+}
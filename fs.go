@@ -0,0 +1,594 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+const fileSystem = `    // GoFileInfo describes a file or a directory in IGoFileSystem.
+    public sealed class GoFileInfo
+    {
+        public GoFileInfo(bool isDirectory, long size, DateTime modTimeUtc)
+        {
+            this.IsDirectory = isDirectory;
+            this.Size = size;
+            this.ModTimeUtc = modTimeUtc;
+        }
+
+        public bool IsDirectory { get; }
+        public long Size { get; }
+        public DateTime ModTimeUtc { get; }
+    }
+
+    // IGoFileSystem is the file system the Go program accesses via the os package.
+    // Implement this to give the program a zip archive, embedded resources or an in-memory fake instead of the real disk.
+    public interface IGoFileSystem
+    {
+        // Open opens the file at path. This throws FileNotFoundException when the file doesn't exist.
+        Stream Open(string path, FileMode mode, FileAccess access);
+
+        // Read reads bytes from the stream returned by Open. If position is not null, the bytes are read at the position.
+        int Read(Stream stream, byte[] buffer, int offset, int count, long? position);
+
+        // Write writes bytes to the stream returned by Open. If position is not null, the bytes are written at the position.
+        int Write(Stream stream, byte[] buffer, int offset, int count, long? position);
+
+        // Stat returns the information of the file at path, or null when the file doesn't exist.
+        GoFileInfo Stat(string path);
+
+        // ReadDir returns the names of the entries in the directory at path.
+        string[] ReadDir(string path);
+    }
+
+    // DefaultGoFileSystem is an IGoFileSystem backed by the real file system.
+    public class DefaultGoFileSystem : IGoFileSystem
+    {
+        public virtual Stream Open(string path, FileMode mode, FileAccess access)
+        {
+            return new FileStream(path, mode, access);
+        }
+
+        public virtual int Read(Stream stream, byte[] buffer, int offset, int count, long? position)
+        {
+            if (!position.HasValue)
+            {
+                return stream.Read(buffer, offset, count);
+            }
+            var current = stream.Position;
+            try
+            {
+                stream.Position = position.Value;
+                return stream.Read(buffer, offset, count);
+            }
+            finally
+            {
+                stream.Position = current;
+            }
+        }
+
+        public virtual int Write(Stream stream, byte[] buffer, int offset, int count, long? position)
+        {
+            if (!position.HasValue)
+            {
+                stream.Write(buffer, offset, count);
+                stream.Flush();
+                return count;
+            }
+            var current = stream.Position;
+            try
+            {
+                stream.Position = position.Value;
+                stream.Write(buffer, offset, count);
+                stream.Flush();
+                return count;
+            }
+            finally
+            {
+                stream.Position = current;
+            }
+        }
+
+        public virtual GoFileInfo Stat(string path)
+        {
+            if (Directory.Exists(path))
+            {
+                return new GoFileInfo(true, 0, Directory.GetLastWriteTimeUtc(path));
+            }
+            if (File.Exists(path))
+            {
+                var info = new FileInfo(path);
+                return new GoFileInfo(false, info.Length, info.LastWriteTimeUtc);
+            }
+            return null;
+        }
+
+        public virtual string[] ReadDir(string path)
+        {
+            return Directory.EnumerateFileSystemEntries(path).Select(p => Path.GetFileName(p)).ToArray();
+        }
+    }
+
+    // TextReaderStream is a read-only stream of the UTF-8 bytes of the text from a TextReader.
+    sealed class TextReaderStream : Stream
+    {
+        public TextReaderStream(TextReader reader)
+        {
+            this.reader = reader;
+        }
+
+        public override bool CanRead => true;
+        public override bool CanSeek => false;
+        public override bool CanWrite => false;
+        public override long Length => throw new NotSupportedException();
+
+        public override long Position
+        {
+            get => throw new NotSupportedException();
+            set => throw new NotSupportedException();
+        }
+
+        public override int Read(byte[] buffer, int offset, int count)
+        {
+            if (this.pending.Length == this.pendingOffset)
+            {
+                // Read a line at a time so that an interactive program gets the input as soon as possible.
+                var line = this.reader.ReadLine();
+                if (line == null)
+                {
+                    return 0;
+                }
+                this.pending = Encoding.UTF8.GetBytes(line + "\n");
+                this.pendingOffset = 0;
+            }
+            var n = Math.Min(count, this.pending.Length - this.pendingOffset);
+            Array.Copy(this.pending, this.pendingOffset, buffer, offset, n);
+            this.pendingOffset += n;
+            return n;
+        }
+
+        public override void Flush()
+        {
+        }
+
+        public override long Seek(long offset, SeekOrigin origin)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void SetLength(long value)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void Write(byte[] buffer, int offset, int count)
+        {
+            throw new NotSupportedException();
+        }
+
+        private TextReader reader;
+        private byte[] pending = new byte[0];
+        private int pendingOffset;
+    }
+
+    // TranscodingStream is a write-only stream that converts UTF-8 bytes into another encoding.
+    sealed class TranscodingStream : Stream
+    {
+        public TranscodingStream(Stream stream, Encoding encoding)
+        {
+            this.stream = stream;
+            this.encoding = encoding;
+        }
+
+        public override bool CanRead => false;
+        public override bool CanSeek => false;
+        public override bool CanWrite => true;
+        public override long Length => throw new NotSupportedException();
+
+        public override long Position
+        {
+            get => throw new NotSupportedException();
+            set => throw new NotSupportedException();
+        }
+
+        public override int Read(byte[] buffer, int offset, int count)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void Flush()
+        {
+            this.stream.Flush();
+        }
+
+        public override long Seek(long offset, SeekOrigin origin)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void SetLength(long value)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void Write(byte[] buffer, int offset, int count)
+        {
+            // The decoder keeps an incomplete UTF-8 sequence at the end until the next write.
+            var chars = new char[this.decoder.GetCharCount(buffer, offset, count)];
+            var n = this.decoder.GetChars(buffer, offset, count, chars, 0);
+            var bytes = this.encoding.GetBytes(chars, 0, n);
+            this.stream.Write(bytes, 0, bytes.Length);
+        }
+
+        private Stream stream;
+        private Encoding encoding;
+        private Decoder decoder = new UTF8Encoding(false).GetDecoder();
+    }
+
+    sealed class JSErrnoException : Exception
+    {
+        public JSErrnoException(string code)
+            : base(code)
+        {
+            this.Code = code;
+        }
+
+        public string Code { get; }
+    }
+
+    // JSFileSystem is Node.js's fs module for syscall/js, backed by IGoFileSystem.
+    sealed class JSFileSystem
+    {
+        // The values of Node.js's fs.constants on Linux.
+        const int OWronly = 1;
+        const int ORdwr = 2;
+        const int OCreat = 64;
+        const int OExcl = 128;
+        const int OTrunc = 512;
+        const int OAppend = 1024;
+        const int ODirectory = 65536;
+
+        const int SIfchr = 0x2000;
+        const int SIfdir = 0x4000;
+        const int SIfreg = 0x8000;
+
+        public JSFileSystem(IGoFileSystem fs, Stream stdin, Stream stdout, Stream stderr, Action<ArraySegment<byte>> stderrObserver, Action<Func<object>, Action<object, Exception>> startBackgroundTask)
+        {
+            this.fs = fs;
+            this.stderrObserver = stderrObserver;
+            this.startBackgroundTask = startBackgroundTask;
+            this.files[0] = stdin;
+            this.files[1] = stdout;
+            this.files[2] = stderr;
+
+            var values = new Dictionary<string, object>()
+            {
+                {"constants", new JSObject(new Dictionary<string, object>()
+                    {
+                        {"O_WRONLY", OWronly},
+                        {"O_RDWR", ORdwr},
+                        {"O_CREAT", OCreat},
+                        {"O_TRUNC", OTrunc},
+                        {"O_APPEND", OAppend},
+                        {"O_EXCL", OExcl},
+                        {"O_DIRECTORY", ODirectory},
+                    })},
+                {"writeSync", new JSFunction("writeSync", (object self, object[] args) => {
+                    var buf = (byte[])args[1];
+                    return this.Write((int)JSObject.ToNumber(args[0]), buf, 0, buf.Length, null);
+                })},
+                {"write", AsyncFunction("write", (object[] args) => {
+                    return this.Write((int)JSObject.ToNumber(args[0]), (byte[])args[1], (int)JSObject.ToNumber(args[2]), (int)JSObject.ToNumber(args[3]), ToPosition(args[4]));
+                })},
+                {"read", new JSFunction("read", (object self, object[] args) => {
+                    if ((int)JSObject.ToNumber(args[0]) == 0 && this.startBackgroundTask != null)
+                    {
+                        return this.ReadStdin((byte[])args[1], (int)JSObject.ToNumber(args[2]), (int)JSObject.ToNumber(args[3]), args[5]);
+                    }
+                    return JSObject.ReflectApply(this.read, self, args);
+                })},
+                {"open", AsyncFunction("open", (object[] args) => {
+                    return this.Open(JSObject.Stringify(args[0]), (int)JSObject.ToNumber(args[1]));
+                })},
+                {"close", AsyncFunction("close", (object[] args) => {
+                    this.Close((int)JSObject.ToNumber(args[0]));
+                    return null;
+                })},
+                {"fsync", AsyncFunction("fsync", (object[] args) => {
+                    this.GetStream((int)JSObject.ToNumber(args[0])).Flush();
+                    return null;
+                })},
+                {"fstat", AsyncFunction("fstat", (object[] args) => {
+                    return this.Fstat((int)JSObject.ToNumber(args[0]));
+                })},
+                {"stat", AsyncFunction("stat", (object[] args) => {
+                    return this.Stat(JSObject.Stringify(args[0]));
+                })},
+                {"lstat", AsyncFunction("lstat", (object[] args) => {
+                    return this.Stat(JSObject.Stringify(args[0]));
+                })},
+                {"readdir", AsyncFunction("readdir", (object[] args) => {
+                    return this.fs.ReadDir(JSObject.Stringify(args[0])).Cast<object>().ToList();
+                })},
+            };
+            foreach (var name in new string[] { "chmod", "chown", "fchmod", "fchown", "ftruncate", "lchown", "link", "mkdir", "readlink", "rename", "rmdir", "symlink", "truncate", "unlink", "utimes" })
+            {
+                values[name] = AsyncFunction(name, (object[] args) => {
+                    throw new JSErrnoException("ENOSYS");
+                });
+            }
+            this.read = AsyncFunction("read", (object[] args) => {
+                return this.Read((int)JSObject.ToNumber(args[0]), (byte[])args[1], (int)JSObject.ToNumber(args[2]), (int)JSObject.ToNumber(args[3]), ToPosition(args[4]));
+            });
+            this.Object = new JSObject("fs", values);
+        }
+
+        public JSObject Object { get; }
+
+        // CloseFiles closes all the files opened by the Go program.
+        public void CloseFiles()
+        {
+            foreach (var fd in this.files.Keys.Where(fd => fd > 2).ToList())
+            {
+                this.files[fd].Dispose();
+                this.files.Remove(fd);
+            }
+            this.paths.Clear();
+            this.dirs.Clear();
+            this.appends.Clear();
+        }
+
+        private static JSFunction AsyncFunction(string name, Func<object[], object> f)
+        {
+            // Node.js's asynchronous API takes a callback as the last argument.
+            // The callback is invoked synchronously, which syscall/js allows.
+            return new JSFunction(name, (object self, object[] args) => {
+                var callback = args[args.Length - 1];
+                object result;
+                try
+                {
+                    result = f(args.Take(args.Length - 1).ToArray());
+                }
+                catch (Exception e)
+                {
+                    JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { ToJSError(e) });
+                    return JSObject.Undefined;
+                }
+                JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { null, result });
+                return JSObject.Undefined;
+            });
+        }
+
+        private static JSObject ToJSError(Exception e)
+        {
+            string code;
+            switch (e)
+            {
+            case JSErrnoException errno:
+                code = errno.Code;
+                break;
+            case FileNotFoundException _:
+            case DirectoryNotFoundException _:
+                code = "ENOENT";
+                break;
+            case UnauthorizedAccessException _:
+                code = "EACCES";
+                break;
+            case NotSupportedException _:
+                code = "ENOSYS";
+                break;
+            default:
+                code = "EIO";
+                break;
+            }
+            return new JSObject("Error", new Dictionary<string, object>()
+            {
+                {"message", e.Message},
+                {"code", code},
+            });
+        }
+
+        private static long? ToPosition(object value)
+        {
+            if (value == null || value == JSObject.Undefined)
+            {
+                return null;
+            }
+            return (long)JSObject.ToNumber(value);
+        }
+
+        private Stream GetStream(int fd)
+        {
+            if (!this.files.ContainsKey(fd))
+            {
+                throw new JSErrnoException(this.dirs.ContainsKey(fd) ? "EISDIR" : "EBADF");
+            }
+            return this.files[fd];
+        }
+
+        private int Read(int fd, byte[] buffer, int offset, int length, long? position)
+        {
+            var stream = this.GetStream(fd);
+            if (fd <= 2)
+            {
+                return stream.Read(buffer, offset, length);
+            }
+            return this.fs.Read(stream, buffer, offset, length, position);
+        }
+
+        private object ReadStdin(byte[] buffer, int offset, int length, object callback)
+        {
+            // Reading the standard input might block for a long time. Read it on another thread
+            // so that other goroutines and timers keep running, and then call back on the event loop.
+            this.startBackgroundTask(() => {
+                return this.files[0].Read(buffer, offset, length);
+            }, (object n, Exception e) => {
+                if (e != null)
+                {
+                    JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { ToJSError(e) });
+                    return;
+                }
+                JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { null, n });
+            });
+            return JSObject.Undefined;
+        }
+
+        private int Write(int fd, byte[] buffer, int offset, int length, long? position)
+        {
+            var stream = this.GetStream(fd);
+            if (fd <= 2)
+            {
+                stream.Write(buffer, offset, length);
+                stream.Flush();
+                if (fd == 2 && this.stderrObserver != null)
+                {
+                    this.stderrObserver(new ArraySegment<byte>(buffer, offset, length));
+                }
+                return length;
+            }
+            if (this.appends.Contains(fd))
+            {
+                stream.Seek(0, SeekOrigin.End);
+            }
+            return this.fs.Write(stream, buffer, offset, length, position);
+        }
+
+        private int Open(string path, int flags)
+        {
+            var info = this.fs.Stat(path);
+            if (info != null && info.IsDirectory)
+            {
+                if ((flags & (OWronly | ORdwr)) != 0)
+                {
+                    throw new JSErrnoException("EISDIR");
+                }
+                this.dirs[this.nextFd] = path;
+                return this.nextFd++;
+            }
+            if ((flags & ODirectory) != 0)
+            {
+                throw new JSErrnoException(info == null ? "ENOENT" : "ENOTDIR");
+            }
+
+            FileMode mode = FileMode.Open;
+            if ((flags & OCreat) != 0)
+            {
+                if ((flags & OExcl) != 0)
+                {
+                    if (info != null)
+                    {
+                        throw new JSErrnoException("EEXIST");
+                    }
+                    mode = FileMode.CreateNew;
+                }
+                else if ((flags & OTrunc) != 0)
+                {
+                    mode = FileMode.Create;
+                }
+                else
+                {
+                    mode = FileMode.OpenOrCreate;
+                }
+            }
+            else if ((flags & OTrunc) != 0)
+            {
+                mode = FileMode.Truncate;
+            }
+            else if (info == null)
+            {
+                throw new JSErrnoException("ENOENT");
+            }
+
+            FileAccess access = FileAccess.Read;
+            if ((flags & ORdwr) != 0)
+            {
+                access = FileAccess.ReadWrite;
+            }
+            else if ((flags & OWronly) != 0)
+            {
+                access = FileAccess.Write;
+            }
+
+            this.files[this.nextFd] = this.fs.Open(path, mode, access);
+            this.paths[this.nextFd] = path;
+            if ((flags & OAppend) != 0)
+            {
+                this.appends.Add(this.nextFd);
+            }
+            return this.nextFd++;
+        }
+
+        private void Close(int fd)
+        {
+            if (this.dirs.Remove(fd))
+            {
+                return;
+            }
+            var stream = this.GetStream(fd);
+            if (fd > 2)
+            {
+                stream.Dispose();
+            }
+            this.files.Remove(fd);
+            this.paths.Remove(fd);
+            this.appends.Remove(fd);
+        }
+
+        private JSObject Fstat(int fd)
+        {
+            if (this.dirs.ContainsKey(fd))
+            {
+                return this.Stat(this.dirs[fd]);
+            }
+            this.GetStream(fd);
+            if (this.paths.ContainsKey(fd))
+            {
+                return this.Stat(this.paths[fd]);
+            }
+            return NewStats(SIfchr | 0x1b6, 0, DateTime.UtcNow);
+        }
+
+        private JSObject Stat(string path)
+        {
+            var info = this.fs.Stat(path);
+            if (info == null)
+            {
+                throw new JSErrnoException("ENOENT");
+            }
+            if (info.IsDirectory)
+            {
+                return NewStats(SIfdir | 0x1ed, 0, info.ModTimeUtc);
+            }
+            return NewStats(SIfreg | 0x1a4, info.Size, info.ModTimeUtc);
+        }
+
+        private static JSObject NewStats(int mode, long size, DateTime modTimeUtc)
+        {
+            var ms = (modTimeUtc - new DateTime(1970, 1, 1, 0, 0, 0, DateTimeKind.Utc)).TotalMilliseconds;
+            return new JSObject("Stats", new Dictionary<string, object>()
+            {
+                {"dev", 0},
+                {"ino", 0},
+                {"mode", mode},
+                {"nlink", 1},
+                {"uid", 0},
+                {"gid", 0},
+                {"rdev", 0},
+                {"size", size},
+                {"blksize", 4096},
+                {"blocks", (size + 511) / 512},
+                {"atimeMs", ms},
+                {"mtimeMs", ms},
+                {"ctimeMs", ms},
+                {"isDirectory", new JSFunction("isDirectory", (object self, object[] args) => {
+                    return (mode & SIfdir) != 0;
+                })},
+            });
+        }
+
+        private IGoFileSystem fs;
+        private Action<ArraySegment<byte>> stderrObserver;
+        private Action<Func<object>, Action<object, Exception>> startBackgroundTask;
+        private JSFunction read;
+        private Dictionary<int, Stream> files = new Dictionary<int, Stream>();
+        private Dictionary<int, string> paths = new Dictionary<int, string>();
+        private Dictionary<int, string> dirs = new Dictionary<int, string>();
+        private HashSet<int> appends = new HashSet<int>();
+        private int nextFd = 3;
+    }`
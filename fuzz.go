@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build gofuzz
+// +build gofuzz
+
+package main
+
+// Fuzz is the entry point for go-fuzz. Fuzz decodes the bytes as a wasm module, validates it, and translates the
+// functions of a valid module into C# with the default flags, in the same way as run. A malformed module must be an
+// error, not a panic.
+//
+//	go-fuzz-build -tags gofuzz
+//	go-fuzz -bin go2dotnet-fuzz.zip -workdir fuzz
+func Fuzz(data []byte) int {
+	mod, err := decodeModule(data)
+	if err != nil {
+		return 0
+	}
+	if err := validateModule(mod); err != nil {
+		return 0
+	}
+
+	tgt, err := lookupTarget(defaultTarget)
+	if err != nil {
+		panic(err)
+	}
+	style, err := newCodeStyle("block", "var", "newline", "4")
+	if err != nil {
+		panic(err)
+	}
+	t, err := newTranslation(mod, tgt, style, false, nil, "")
+	if err != nil {
+		return 0
+	}
+	if err := generateFuncs(t.Funcs, 1, nil); err != nil {
+		return 0
+	}
+	return 1
+}
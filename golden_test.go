@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+//go:generate go run testdata/gen.go
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var flagUpdate = flag.Bool("update", false, "Write the generated code to the golden files instead of comparing them")
+
+// goldenDir is the directory of the golden files. The wasm files are generated by testdata/gen.go.
+const goldenDir = "testdata/golden"
+
+// goldenVersionRe matches the version of go2dotnet in the header, which changes with every commit.
+var goldenVersionRe = regexp.MustCompile(`(?m)^// go2dotnet version: .*$`)
+
+// TestGolden translates each <name>.wasm in testdata/golden with the flags in <name>.flags, if any, and compares the
+// C# code with <name>.cs. With -update, it writes the C# code to <name>.cs instead, so that a change of the templates
+// or the translation is reviewed as a diff of the golden files.
+func TestGolden(t *testing.T) {
+	wasms, err := filepath.Glob(filepath.Join(goldenDir, "*.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wasms) == 0 {
+		t.Fatalf("no wasm files in %s", goldenDir)
+	}
+	for _, w := range wasms {
+		name := strings.TrimSuffix(filepath.Base(w), ".wasm")
+		t.Run(name, func(t *testing.T) {
+			got, err := goldenGenerate(goldenDir, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(goldenDir, name+".cs")
+			if *flagUpdate {
+				if err := ioutil.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v; run with -update", err)
+			}
+			if !bytes.Equal(want, got) {
+				t.Errorf("%s differs: %s; run with -update if the change is intended", path, firstLineDiff(want, got))
+			}
+		})
+	}
+}
+
+// goldenGenerate translates <name>.wasm in dir with the flags in <name>.flags, and returns the C# code.
+//
+// The translation runs in dir with the relative path, so that the header doesn't depend on the environment. The version of go2dotnet in the header is replaced with a placeholder.
+func goldenGenerate(dir, name string) ([]byte, error) {
+	var flags []string
+	if b, err := ioutil.ReadFile(filepath.Join(dir, name+".flags")); err == nil {
+		flags = strings.Fields(string(b))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	cmd := exec.Command(translator, append([]string{"-wasm", name + ".wasm", "-namespace", "Go2DotNet.Golden"}, flags...)...)
+	cmd.Args[0] = "go2dotnet"
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("translation failed: %v\n%s", err, stderr.Bytes())
+	}
+	return goldenVersionRe.ReplaceAll(stdout.Bytes(), []byte("// go2dotnet version: (golden)")), nil
+}
+
+// firstLineDiff describes the first line that differs between want and got.
+func firstLineDiff(want, got []byte) string {
+	wls := strings.Split(string(want), "\n")
+	gls := strings.Split(string(got), "\n")
+	for i := 0; i < len(wls) && i < len(gls); i++ {
+		if wls[i] != gls[i] {
+			return fmt.Sprintf("line %d: %q, want %q", i+1, gls[i], wls[i])
+		}
+	}
+	return fmt.Sprintf("%d lines, want %d", len(gls), len(wls))
+}
@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// grpcPackageVersion is the version of Grpc.AspNetCore the project refers to with -grpc.
+const grpcPackageVersion = "2.57.0"
+
+var protoIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// grpcField is a field of a request message.
+type grpcField struct {
+	// Name is the field name in the .proto.
+	Name string
+
+	// Number is the field number in the .proto.
+	Number int
+
+	// Type is the field type in the .proto.
+	Type string
+
+	// Bytes reports whether the field is passed to the Go function as a pair of a pointer and a length.
+	Bytes bool
+}
+
+// Property returns the name of the C# property that protoc generates for the field.
+func (f *grpcField) Property() string {
+	return protoCSharpName(f.Name)
+}
+
+// grpcMethod is an RPC method that calls an exported function.
+type grpcMethod struct {
+	// Name is the RPC name, which is also the prefix of the message names.
+	Name string
+
+	// Export is the exported function to call.
+	Export *Export
+
+	// Fields is the fields of the request message.
+	Fields []*grpcField
+
+	// Result is the type of the result field of the response message, or an empty string if the function returns nothing.
+	Result string
+}
+
+// BodyCSharp returns the body of the service method that calls the exported function with the request.
+func (m *grpcMethod) BodyCSharp(indent string, namespace string) string {
+	lines := []string{
+		fmt.Sprintf("var response = new global::%s.Rpc.%sResponse();", namespace, m.Name),
+	}
+	var args []string
+	for i, f := range m.Fields {
+		if !f.Bytes {
+			args = append(args, "request."+f.Property())
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("int ptr%d = this.go.WriteBytes(request.%s.Span);", i, f.Property()))
+		args = append(args, fmt.Sprintf("ptr%d", i), fmt.Sprintf("request.%s.Length", f.Property()))
+	}
+	call := fmt.Sprintf("((IGoApp)this.go).%s(%s)", m.Export.Ident, strings.Join(args, ", "))
+	if m.Result != "" {
+		lines = append(lines, fmt.Sprintf("response.Result = %s;", call))
+	} else {
+		lines = append(lines, call+";")
+	}
+	lines = append(lines, "return Task.FromResult(response);")
+
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// protoCSharpName converts the name in the .proto to the C# name as protoc does, e.g. "my_field" to "MyField".
+func protoCSharpName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		b.WriteRune(r)
+		upper = '0' <= r && r <= '9'
+	}
+	return b.String()
+}
+
+func protoType(t wasm.ValueType) string {
+	switch wasmTypeToReturnType(t) {
+	case ReturnTypeI32:
+		return "int32"
+	case ReturnTypeI64:
+		return "int64"
+	case ReturnTypeF32:
+		return "float"
+	case ReturnTypeF64:
+		return "double"
+	default:
+		panic("not reached")
+	}
+}
+
+// isBytesPair reports whether the Go parameters are a pointer and a length of bytes.
+func isBytesPair(ptr, len goParam) bool {
+	switch ptr.Type {
+	case "*byte", "*uint8", "unsafe.Pointer":
+	default:
+		return false
+	}
+	switch len.Type {
+	case "int32", "uint32", "uintptr":
+		return true
+	}
+	return false
+}
+
+// grpcMethods returns the RPC methods for the exported functions.
+//
+// The parameters are named after the Go parameters if known by -src. A pair of a byte pointer and a length is
+// a bytes field if the module exports malloc, which allocates the memory to copy the bytes into.
+func grpcMethods(exports []*Export, malloc bool) ([]*grpcMethod, error) {
+	var methods []*grpcMethod
+	exportNames := map[string]string{}
+	for _, e := range exports {
+		if !e.IsAPI() {
+			continue
+		}
+		if !protoIdentRe.MatchString(e.Name) {
+			return nil, fmt.Errorf("-grpc: the export name %q is not an identifier in .proto", e.Name)
+		}
+		m := &grpcMethod{
+			Name:   protoCSharpName(e.Name),
+			Export: e,
+		}
+		if n, ok := exportNames[m.Name]; ok {
+			return nil, fmt.Errorf("-grpc: the exports %q and %q have the same RPC name %s", n, e.Name, m.Name)
+		}
+		exportNames[m.Name] = e.Name
+
+		sig := e.Funcs[e.Index].Wasm.Sig
+		switch len(sig.ReturnTypes) {
+		case 0:
+		case 1:
+			m.Result = protoType(sig.ReturnTypes[0])
+		default:
+			return nil, fmt.Errorf("the number of return values must be 0 or 1 but %d", len(sig.ReturnTypes))
+		}
+
+		params := e.GoParams
+		if len(params) != len(sig.ParamTypes) {
+			// The source doesn't match the wasm file.
+			params = nil
+		}
+		for i := 0; i < len(sig.ParamTypes); i++ {
+			f := &grpcField{
+				Name:   fmt.Sprintf("arg%d", i),
+				Number: len(m.Fields) + 1,
+				Type:   protoType(sig.ParamTypes[i]),
+			}
+			if params != nil && params[i].Name != "_" && protoIdentRe.MatchString(params[i].Name) {
+				f.Name = params[i].Name
+			}
+			if malloc && params != nil && i+1 < len(params) && isBytesPair(params[i], params[i+1]) &&
+				sig.ParamTypes[i] == wasm.ValueTypeI32 && sig.ParamTypes[i+1] == wasm.ValueTypeI32 {
+				f.Type = "bytes"
+				f.Bytes = true
+				i++
+			}
+			m.Fields = append(m.Fields, f)
+		}
+		methods = append(methods, m)
+	}
+	return methods, nil
+}
+
+var protoTmpl = template.Must(template.New("proto").Parse(`{{with .Header}}{{range .License}}{{.}}
+{{end}}{{if .License}}
+{{end}}{{end}}// Code generated by go2dotnet. DO NOT EDIT.
+{{- with .Header}}
+//
+// go2dotnet version: {{.Version}}
+// Input SHA-256:     {{.InputSHA256}}
+// Command line:      {{.CommandLine}}
+{{- end}}
+
+syntax = "proto3";
+
+option csharp_namespace = "{{.Namespace}}.Rpc";
+
+package {{.Package}};
+
+// GoService calls the exported functions of the Go program.
+service GoService {
+{{- range .Methods}}
+  rpc {{.Name}} ({{.Name}}Request) returns ({{.Name}}Response);
+{{- end}}
+}
+{{range .Methods}}
+message {{.Name}}Request {
+{{- range .Fields}}
+  {{.Type}} {{.Name}} = {{.Number}};
+{{- end}}
+}
+
+message {{.Name}}Response {
+{{- if .Result}}
+  {{.Result}} result = 1;
+{{- end}}
+}
+{{end}}`))
+
+var grpcServiceTmpl = template.Must(template.New("grpc").Parse(`    // GoGrpcService is the gRPC service that calls the exported functions of the Go program.
+    // Register Go running the program as a singleton, and map this service with MapGrpcService<GoGrpcService>().
+    // As RPCs can be called concurrently, SerializeHostCalls must be true.
+    //
+    // A bytes field is copied into memory allocated by the module's malloc, and the Go function owns the memory.
+    public class GoGrpcService : global::{{.Namespace}}.Rpc.GoService.GoServiceBase
+    {
+        private readonly Go go;
+
+        public GoGrpcService(Go go)
+        {
+            this.go = go;
+        }
+{{- range .Methods}}
+
+        public override Task<global::{{$.Namespace}}.Rpc.{{.Name}}Response> {{.Name}}(global::{{$.Namespace}}.Rpc.{{.Name}}Request request, global::Grpc.Core.ServerCallContext context)
+        {
+{{.BodyCSharp "            " $.Namespace}}
+        }
+{{- end}}
+    }`))
+
+// grpcFiles returns the .proto and C# code of the gRPC service for the exported functions.
+func grpcFiles(header *fileHeader, namespace string, exports []*Export, malloc bool) (proto []byte, service string, err error) {
+	methods, err := grpcMethods(exports, malloc)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var protoBuf bytes.Buffer
+	if err := protoTmpl.Execute(&protoBuf, struct {
+		Header    *fileHeader
+		Namespace string
+		Package   string
+		Methods   []*grpcMethod
+	}{
+		Header:    header,
+		Namespace: namespace,
+		Package:   strings.ToLower(namespace),
+		Methods:   methods,
+	}); err != nil {
+		return nil, "", err
+	}
+
+	var serviceBuf bytes.Buffer
+	if err := grpcServiceTmpl.Execute(&serviceBuf, struct {
+		Namespace string
+		Methods   []*grpcMethod
+	}{
+		Namespace: namespace,
+		Methods:   methods,
+	}); err != nil {
+		return nil, "", err
+	}
+	return protoBuf.Bytes(), serviceBuf.String(), nil
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// fileHeader is the comment at the top of the generated C# files: the license header and the provenance.
+type fileHeader struct {
+	// License is the comment lines of the license header by -header.
+	License []string
+
+	// Version is the version of go2dotnet.
+	Version string
+
+	// InputSHA256 is the SHA-256 hash of the wasm file in hexadecimal.
+	InputSHA256 string
+
+	// CommandLine is the command line that generated the files.
+	CommandLine string
+}
+
+// newFileHeader returns the header for the wasm file. licenseFile is the path of the license header, or an empty
+// string if none.
+func newFileHeader(licenseFile string, wasm []byte) (*fileHeader, error) {
+	h := &fileHeader{
+		Version:     toolVersion(),
+		CommandLine: commandLine(os.Args),
+	}
+	sum := sha256.Sum256(wasm)
+	h.InputSHA256 = hex.EncodeToString(sum[:])
+
+	if licenseFile != "" {
+		c, err := ioutil.ReadFile(licenseFile)
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimRight(strings.Replace(string(c), "\r\n", "\n", -1), "\n")
+		for _, l := range strings.Split(text, "\n") {
+			l = strings.TrimRight(l, " \t")
+			// Keep the lines that are already comments, so that the file can be a C# comment as is.
+			switch {
+			case strings.HasPrefix(l, "//"):
+			case l == "":
+				l = "//"
+			default:
+				l = "// " + l
+			}
+			h.License = append(h.License, l)
+		}
+	}
+	return h, nil
+}
+
+// toolVersion returns the module version of go2dotnet, or "(devel)" if it is built from a local checkout.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+var plainArgRe = regexp.MustCompile(`^[A-Za-z0-9_./=:,+@%-]+$`)
+
+// commandLine returns the command line in one line. Arguments with spaces or special characters are quoted.
+func commandLine(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	// The path of the program depends on the environment, e.g. a temporary directory for go run.
+	strs := []string{filepath.Base(args[0])}
+	for _, a := range args[1:] {
+		if !plainArgRe.MatchString(a) {
+			a = strconv.Quote(a)
+		}
+		strs = append(strs, a)
+	}
+	return strings.Join(strs, " ")
+}
@@ -22,12 +22,15 @@ var importFuncBodies = map[string]string{
     var p = go.mem.LoadInt64(local0 + 16);
     var n = go.mem.LoadInt32(local0 + 24);
 
-    // Note that runtime.wasmWrite is used only for print/println so far.
-    // Write the buffer to the standard output regardless of fd.
-    go.DebugWrite(go.mem.LoadSliceDirectly(p, n));`,
+    // runtime.wasmWrite is used for print/println and the runtime's diagnostic output like GODEBUG traces.
+    go.DebugWrite(fd, go.mem.LoadSliceDirectly(p, n));
+    if (fd == 2)
+    {
+        go.ObserveStderr(go.mem.LoadSliceDirectly(p, n));
+    }`,
 
 	// func resetMemoryDataView()
-	"runtime.resetMemoryDataView": `    // Do nothing.`,
+	"runtime.resetMemoryDataView": `    go.ResetMemoryDataView();`,
 
 	// func nanotime1() int64
 	"runtime.nanotime1": `    go.mem.StoreInt64(local0 + 8, go.PreciseNowInNanoseconds());`,
@@ -35,6 +38,11 @@ var importFuncBodies = map[string]string{
 	// func walltime1() (sec int64, nsec int32)
 	"runtime.walltime1": `    var now = go.UnixNowInMilliseconds();
     go.mem.StoreInt64(local0 + 8, (long)(now / 1000));
+    go.mem.StoreInt32(local0 + 16, (int)((now % 1000) * 1_000_000));`,
+
+	// func walltime() (sec int64, nsec int32), which is walltime1 renamed since Go 1.17
+	"runtime.walltime": `    var now = go.UnixNowInMilliseconds();
+    go.mem.StoreInt64(local0 + 8, (long)(now / 1000));
     go.mem.StoreInt32(local0 + 16, (int)((now % 1000) * 1_000_000));`,
 
 	// func scheduleTimeoutEvent(delay int64) int32
@@ -47,152 +55,150 @@ var importFuncBodies = map[string]string{
     go.ClearTimeout(id);`,
 
 	// func getRandomData(r []byte)
-	"runtime.getRandomData": `    var slice = go.mem.LoadSlice(local0 + 8);
-    var bytes = go.GetRandomBytes(slice.Count);
-    for (int i = 0; i < slice.Count; i++) {
-        slice[i] = bytes[i];
-    }`,
+	"runtime.getRandomData": `    go.FillRandomBytes(go.mem.LoadSlice(local0 + 8));`,
 
 	// func finalizeRef(v ref)
 	"syscall/js.finalizeRef": `    int id = (int)go.mem.LoadUint32(local0 + 8);
-    go.goRefCounts[id]--;
-    if (go.goRefCounts[id] == 0)
-    {
-        var v = go.values[id];
-        go.values[id] = null;
-        go.ids.Remove(v);
-        go.idPool.Push(id);
-    }`,
+    go.FinalizeRef(id);`,
 
 	// func stringVal(value string) ref
 	"syscall/js.stringVal": `    go.StoreValue(local0 + 24, go.mem.LoadString(local0 + 8));`,
 
 	// func valueGet(v ref, p string) ref
-	"syscall/js.valueGet": `    var result = JSObject.ReflectGet(go.LoadValue(local0 + 8), go.mem.LoadString(local0 + 16));
-    local0 = go.inst.getsp();
+	"syscall/js.valueGet": `    var result = JSObject.ReflectGet(go.LoadValue(local0 + 8), go.LoadPropertyName(local0 + 16));
+    local0 = go.GetSP();
     go.StoreValue(local0 + 32, result);`,
-	/*(sp) => {
-						const result = Reflect.get(loadValue(sp + 8), loadString(sp + 16));
-						sp = this._inst.exports.getsp(); // see comment above
-						storeValue(sp + 32, result);
-					},
-
-					// func valueSet(v ref, p string, x ref)
-					"syscall/js.valueSet": (sp) => {
-						Reflect.set(loadValue(sp + 8), loadString(sp + 16), loadValue(sp + 32));
-					},
-
-					// func valueDelete(v ref, p string)
-					"syscall/js.valueDelete": (sp) => {
-						Reflect.deleteProperty(loadValue(sp + 8), loadString(sp + 16));
-					},
-
-					// func valueIndex(v ref, i int) ref
-					"syscall/js.valueIndex": (sp) => {
-						storeValue(sp + 24, Reflect.get(loadValue(sp + 8), getInt64(sp + 16)));
-					},
-
-					// valueSetIndex(v ref, i int, x ref)
-					"syscall/js.valueSetIndex": (sp) => {
-						Reflect.set(loadValue(sp + 8), getInt64(sp + 16), loadValue(sp + 24));
-					},
-
-					// func valueCall(v ref, m string, args []ref) (ref, bool)
-					"syscall/js.valueCall": (sp) => {
-						try {
-							const v = loadValue(sp + 8);
-							const m = Reflect.get(v, loadString(sp + 16));
-							const args = loadSliceOfValues(sp + 32);
-							const result = Reflect.apply(m, v, args);
-							sp = this._inst.exports.getsp(); // see comment above
-							storeValue(sp + 56, result);
-							this.mem.setUint8(sp + 64, 1);
-						} catch (err) {
-							storeValue(sp + 56, err);
-							this.mem.setUint8(sp + 64, 0);
-						}
-					},
-
-					// func valueInvoke(v ref, args []ref) (ref, bool)
-					"syscall/js.valueInvoke": (sp) => {
-						try {
-							const v = loadValue(sp + 8);
-							const args = loadSliceOfValues(sp + 16);
-							const result = Reflect.apply(v, undefined, args);
-							sp = this._inst.exports.getsp(); // see comment above
-							storeValue(sp + 40, result);
-							this.mem.setUint8(sp + 48, 1);
-						} catch (err) {
-							storeValue(sp + 40, err);
-							this.mem.setUint8(sp + 48, 0);
-						}
-					},
-
-					// func valueNew(v ref, args []ref) (ref, bool)
-					"syscall/js.valueNew": (sp) => {
-						try {
-							const v = loadValue(sp + 8);
-							const args = loadSliceOfValues(sp + 16);
-							const result = Reflect.construct(v, args);
-							sp = this._inst.exports.getsp(); // see comment above
-							storeValue(sp + 40, result);
-							this.mem.setUint8(sp + 48, 1);
-						} catch (err) {
-							storeValue(sp + 40, err);
-							this.mem.setUint8(sp + 48, 0);
-						}
-					},
-
-					// func valueLength(v ref) int
-					"syscall/js.valueLength": (sp) => {
-						setInt64(sp + 16, parseInt(loadValue(sp + 8).length));
-					},
-
-					// valuePrepareString(v ref) (ref, int)
-					"syscall/js.valuePrepareString": (sp) => {
-						const str = encoder.encode(String(loadValue(sp + 8)));
-						storeValue(sp + 16, str);
-						setInt64(sp + 24, str.length);
-					},
-
-					// valueLoadString(v ref, b []byte)
-					"syscall/js.valueLoadString": (sp) => {
-						const str = loadValue(sp + 8);
-						loadSlice(sp + 16).set(str);
-					},
-
-					// func valueInstanceOf(v ref, t ref) bool
-					"syscall/js.valueInstanceOf": (sp) => {
-						this.mem.setUint8(sp + 24, loadValue(sp + 8) instanceof loadValue(sp + 16));
-					},
-
-					// func copyBytesToGo(dst []byte, src ref) (int, bool)
-					"syscall/js.copyBytesToGo": (sp) => {
-						const dst = loadSlice(sp + 8);
-						const src = loadValue(sp + 32);
-						if (!(src instanceof Uint8Array)) {
-							this.mem.setUint8(sp + 48, 0);
-							return;
-						}
-						const toCopy = src.subarray(0, dst.length);
-						dst.set(toCopy);
-						setInt64(sp + 40, toCopy.length);
-						this.mem.setUint8(sp + 48, 1);
-					},
-
-					// func copyBytesToJS(dst ref, src []byte) (int, bool)
-					"syscall/js.copyBytesToJS": (sp) => {
-						const dst = loadValue(sp + 8);
-						const src = loadSlice(sp + 16);
-						if (!(dst instanceof Uint8Array)) {
-							this.mem.setUint8(sp + 48, 0);
-							return;
-						}
-						const toCopy = src.subarray(0, dst.length);
-						dst.set(toCopy);
-						setInt64(sp + 40, toCopy.length);
-						this.mem.setUint8(sp + 48, 1);
-					},*/
+
+	// func valueSet(v ref, p string, x ref)
+	"syscall/js.valueSet": `    JSObject.ReflectSet(go.LoadValue(local0 + 8), go.LoadPropertyName(local0 + 16), go.LoadValue(local0 + 32));`,
+
+	// func valueDelete(v ref, p string)
+	"syscall/js.valueDelete": `    JSObject.ReflectDelete(go.LoadValue(local0 + 8), go.LoadPropertyName(local0 + 16));`,
+
+	// func valueIndex(v ref, i int) ref
+	"syscall/js.valueIndex": `    go.StoreValue(local0 + 24, JSObject.ReflectGetIndex(go.LoadValue(local0 + 8), go.mem.LoadInt64(local0 + 16)));`,
+
+	// valueSetIndex(v ref, i int, x ref)
+	"syscall/js.valueSetIndex": `    JSObject.ReflectSetIndex(go.LoadValue(local0 + 8), go.mem.LoadInt64(local0 + 16), go.LoadValue(local0 + 24));`,
+
+	// func valueCall(v ref, m string, args []ref) (ref, bool)
+	"syscall/js.valueCall": `    object[] args = null;
+    try
+    {
+        var v = go.LoadValue(local0 + 8);
+        var m = JSObject.ReflectGet(v, go.LoadPropertyName(local0 + 16));
+        args = go.LoadSliceOfValues(local0 + 32);
+        var result = JSObject.ReflectApply(m, v, args);
+        local0 = go.GetSP();
+        go.StoreValue(local0 + 56, result);
+        go.mem.StoreInt8(local0 + 64, 1);
+    }
+    catch (Exception e)
+    {
+        local0 = go.GetSP();
+        go.StoreValue(local0 + 56, e);
+        go.mem.StoreInt8(local0 + 64, 0);
+    }
+    finally
+    {
+        if (args != null)
+        {
+            go.ReturnSliceOfValues(args);
+        }
+    }`,
+
+	// func valueInvoke(v ref, args []ref) (ref, bool)
+	"syscall/js.valueInvoke": `    object[] args = null;
+    try
+    {
+        var v = go.LoadValue(local0 + 8);
+        args = go.LoadSliceOfValues(local0 + 16);
+        var result = JSObject.ReflectApply(v, JSObject.Undefined, args);
+        local0 = go.GetSP();
+        go.StoreValue(local0 + 40, result);
+        go.mem.StoreInt8(local0 + 48, 1);
+    }
+    catch (Exception e)
+    {
+        local0 = go.GetSP();
+        go.StoreValue(local0 + 40, e);
+        go.mem.StoreInt8(local0 + 48, 0);
+    }
+    finally
+    {
+        if (args != null)
+        {
+            go.ReturnSliceOfValues(args);
+        }
+    }`,
+
+	// func valueNew(v ref, args []ref) (ref, bool)
+	"syscall/js.valueNew": `    object[] args = null;
+    try
+    {
+        var v = go.LoadValue(local0 + 8);
+        args = go.LoadSliceOfValues(local0 + 16);
+        var result = JSObject.ReflectConstruct(v, args);
+        local0 = go.GetSP();
+        go.StoreValue(local0 + 40, result);
+        go.mem.StoreInt8(local0 + 48, 1);
+    }
+    catch (Exception e)
+    {
+        local0 = go.GetSP();
+        go.StoreValue(local0 + 40, e);
+        go.mem.StoreInt8(local0 + 48, 0);
+    }
+    finally
+    {
+        if (args != null)
+        {
+            go.ReturnSliceOfValues(args);
+        }
+    }`,
+
+	// func valueLength(v ref) int
+	"syscall/js.valueLength": `    go.mem.StoreInt64(local0 + 16, (long)JSObject.Length(go.LoadValue(local0 + 8)));`,
+
+	// valuePrepareString(v ref) (ref, int)
+	"syscall/js.valuePrepareString": `    var str = Encoding.UTF8.GetBytes(JSObject.Stringify(go.LoadValue(local0 + 8)));
+    go.StoreValue(local0 + 16, str);
+    go.mem.StoreInt64(local0 + 24, str.Length);`,
+
+	// valueLoadString(v ref, b []byte)
+	"syscall/js.valueLoadString": `    var str = (byte[])go.LoadValue(local0 + 8);
+    var slice = go.mem.LoadSlice(local0 + 16);
+    Array.Copy(str, 0, slice.Array, slice.Offset, Math.Min(str.Length, slice.Count));`,
+
+	// func valueInstanceOf(v ref, t ref) bool
+	"syscall/js.valueInstanceOf": `    var result = JSObject.InstanceOf(go.LoadValue(local0 + 8), go.LoadValue(local0 + 16));
+    go.mem.StoreInt8(local0 + 24, (sbyte)(result ? 1 : 0));`,
+
+	// func copyBytesToGo(dst []byte, src ref) (int, bool)
+	"syscall/js.copyBytesToGo": `    var dst = go.mem.LoadSlice(local0 + 8);
+    var src = go.LoadValue(local0 + 32) as byte[];
+    if (src == null)
+    {
+        go.mem.StoreInt8(local0 + 48, 0);
+        return;
+    }
+    var toCopy = Math.Min(src.Length, dst.Count);
+    Array.Copy(src, 0, dst.Array, dst.Offset, toCopy);
+    go.mem.StoreInt64(local0 + 40, toCopy);
+    go.mem.StoreInt8(local0 + 48, 1);`,
+
+	// func copyBytesToJS(dst ref, src []byte) (int, bool)
+	"syscall/js.copyBytesToJS": `    var dst = go.LoadValue(local0 + 8) as byte[];
+    var src = go.mem.LoadSlice(local0 + 16);
+    if (dst == null)
+    {
+        go.mem.StoreInt8(local0 + 48, 0);
+        return;
+    }
+    var toCopy = Math.Min(src.Count, dst.Length);
+    Array.Copy(src.Array, src.Offset, dst, 0, toCopy);
+    go.mem.StoreInt64(local0 + 40, toCopy);
+    go.mem.StoreInt8(local0 + 48, 1);`,
 
 	"debug": `    Console.WriteLine(local0);`,
 }
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// evalInitExpr evaluates the initializer expression of a global, an element segment or a data segment at generation
+// time, so that the generated code has the value as a constant. The value is int32, int64, float32 or float64.
+//
+// An expression that refers to a global cannot be evaluated, as the globals that initializers can refer to are the
+// imported ones, which are known only at instantiation.
+func evalInitExpr(mod *wasm.Module, expr []byte) (interface{}, error) {
+	if len(expr) > 0 && expr[0] == operators.GetGlobal {
+		return nil, fmt.Errorf("an initializer expression referring to an imported global is not supported")
+	}
+	return mod.ExecInitExpr(expr)
+}
+
+// csConstant returns the C# expression of the constant value of the wasm type t.
+func csConstant(v interface{}, t wasm.ValueType) (string, error) {
+	switch t {
+	case wasm.ValueTypeI32:
+		if v, ok := v.(int32); ok {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+	case wasm.ValueTypeI64:
+		if v, ok := v.(int64); ok {
+			return strconv.FormatInt(v, 10) + "L", nil
+		}
+	case wasm.ValueTypeF32:
+		if v, ok := v.(float32); ok {
+			if math.IsNaN(float64(v)) {
+				// Keep the bits, e.g. the payload, as float.NaN is not wasm's canonical NaN.
+				return fmt.Sprintf("BitConverter.ToSingle(BitConverter.GetBytes(%d), 0)", int32(math.Float32bits(v))), nil
+			}
+			return csFloat(float64(v), 32, "float", "f"), nil
+		}
+	case wasm.ValueTypeF64:
+		if v, ok := v.(float64); ok {
+			if math.IsNaN(v) {
+				return fmt.Sprintf("BitConverter.Int64BitsToDouble(%dL)", int64(math.Float64bits(v))), nil
+			}
+			return csFloat(v, 64, "double", "d"), nil
+		}
+	}
+	return "", fmt.Errorf("the initial value %v doesn't match the type %s", v, t)
+}
+
+// csFloat returns the C# literal of the floating-point number that is not NaN. typ is the C# type, and suffix is the
+// suffix of the literal.
+func csFloat(v float64, bitSize int, typ, suffix string) string {
+	switch {
+	case math.IsInf(v, 1):
+		return typ + ".PositiveInfinity"
+	case math.IsInf(v, -1):
+		return typ + ".NegativeInfinity"
+	case v == 0 && math.Signbit(v):
+		return "-0" + suffix
+	}
+	return strconv.FormatFloat(v, 'g', -1, bitSize) + suffix
+}
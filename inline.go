@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// inlinable returns the instructions of the function without the last end if the function can be inlined at call
+// sites with the given maximum number of instructions, or nil.
+//
+// A function can be inlined if it has no control flow, no calls and no locals other than the parameters, like the
+// wrappers that Go's compiler emits. Such a function's body is a straight sequence of instructions that leaves the
+// results on the stack, so it can replace the call as it is.
+func inlinable(f *Func, max int) ([]disasm.Instr, error) {
+	if f.Import || f.Wasm.Body == nil || f.BodyStr != "" {
+		return nil, nil
+	}
+	for _, e := range f.Wasm.Body.Locals {
+		if e.Count > 0 {
+			return nil, nil
+		}
+	}
+	instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+	if err != nil {
+		return nil, err
+	}
+	if len(instrs) > 0 && instrs[len(instrs)-1].Op.Code == operators.End {
+		instrs = instrs[:len(instrs)-1]
+	}
+	if len(instrs) > max {
+		return nil, nil
+	}
+	for _, instr := range instrs {
+		switch instr.Op.Code {
+		case operators.Unreachable, operators.Block, operators.Loop, operators.If, operators.Else, operators.End,
+			operators.Br, operators.BrIf, operators.BrTable, operators.Return, operators.Call, operators.CallIndirect:
+			return nil, nil
+		}
+	}
+	return instrs, nil
+}
+
+// inlineFuncs replaces the calls of the small functions with the bodies of the functions, by -inline.
+//
+// The arguments of an inlined call are popped into new locals of the caller, and the callee's parameters are
+// replaced with the locals. The locals are shared among the inlined calls in a function, as an inlined body never
+// outlives the next call. If callers is not nil, only the calls in the functions of the indices in callers are
+// inlined, e.g. the hot functions by -pgo.
+func inlineFuncs(fs []*Func, max int, callers map[int]bool) error {
+	callees := map[uint32][]disasm.Instr{}
+	for _, f := range fs {
+		instrs, err := inlinable(f, max)
+		if err != nil {
+			return err
+		}
+		if instrs != nil {
+			callees[uint32(f.Index)] = instrs
+		}
+	}
+	if len(callees) == 0 {
+		return nil
+	}
+
+	setLocal, err := operators.New(operators.SetLocal)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		if f.Import || f.Wasm.Body == nil {
+			continue
+		}
+		if callers != nil && !callers[f.Index] {
+			continue
+		}
+		instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+		if err != nil {
+			return err
+		}
+
+		numLocals := uint32(len(f.Wasm.Sig.ParamTypes))
+		for _, e := range f.Wasm.Body.Locals {
+			numLocals += e.Count
+		}
+		var newLocals []wasm.LocalEntry
+		// temps is the new locals by type.
+		temps := map[wasm.ValueType][]uint32{}
+
+		var inlined bool
+		var code []disasm.Instr
+		for _, instr := range instrs {
+			if instr.Op.Code != operators.Call {
+				code = append(code, instr)
+				continue
+			}
+			idx := instr.Immediates[0].(uint32)
+			body, ok := callees[idx]
+			if !ok {
+				code = append(code, instr)
+				continue
+			}
+			inlined = true
+
+			params := fs[idx].Wasm.Sig.ParamTypes
+			locals := make([]uint32, len(params))
+			used := map[wasm.ValueType]int{}
+			for i, t := range params {
+				if used[t] == len(temps[t]) {
+					temps[t] = append(temps[t], numLocals)
+					newLocals = append(newLocals, wasm.LocalEntry{Count: 1, Type: t})
+					numLocals++
+				}
+				locals[i] = temps[t][used[t]]
+				used[t]++
+			}
+			// The last argument is on the top of the stack.
+			for i := len(params) - 1; i >= 0; i-- {
+				code = append(code, disasm.Instr{
+					Op:         setLocal,
+					Immediates: []interface{}{locals[i]},
+				})
+			}
+			for _, instr := range body {
+				switch instr.Op.Code {
+				case operators.GetLocal, operators.SetLocal, operators.TeeLocal:
+					instr.Immediates = []interface{}{locals[instr.Immediates[0].(uint32)]}
+				}
+				code = append(code, instr)
+			}
+		}
+		if !inlined {
+			continue
+		}
+
+		if err := f.setCode(code, newLocals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCode replaces the function's body with the instructions and the locals appended to the original ones.
+// The original body is not modified as its locals are shared with the module.
+func (f *Func) setCode(code []disasm.Instr, newLocals []wasm.LocalEntry) error {
+	bin, err := disasm.Assemble(code)
+	if err != nil {
+		return err
+	}
+	body := *f.Wasm.Body
+	body.Locals = append(append([]wasm.LocalEntry{}, body.Locals...), newLocals...)
+	body.Code = bin
+	f.Wasm.Body = &body
+	return nil
+}
@@ -4,50 +4,446 @@ package main
 
 const js = `    class JSObject
     {
-        public static JSObject Undefined = new JSObject("undefined");
-        public static JSObject Global;
+        public static readonly JSObject Undefined = new JSObject("undefined");
 
-        static JSObject()
+        // NewGlobal creates a global object with the given fs module.
+        // post is used to invoke promise reactions asynchronously.
+        // fallback is used to resolve properties that don't exist.
+        public static JSObject NewGlobal(JSObject fs, Action<Action> post, Func<string, object> fallback)
         {
-            JSObject obj = new JSObject("Object");
-            JSObject arr = new JSObject("Array");
+            // The constructors are created per global so that a Go program mutating them doesn't affect
+            // other instances.
+            var objectConstructor = new JSFunction("Object", null, (object[] args) => {
+                if (args.Length > 0 && args[0] != null && args[0] != Undefined)
+                {
+                    return args[0];
+                }
+                return new JSObject(new Dictionary<string, object>());
+            }, typeof(JSObject));
+            objectConstructor.Set("keys", new JSFunction("keys", (object self, object[] args) => {
+                return Keys(args[0]).Cast<object>().ToList();
+            }));
+            var arrayConstructor = new JSFunction("Array", null, (object[] args) => {
+                if (args.Length == 1 && !(args[0] is string))
+                {
+                    return Enumerable.Repeat((object)Undefined, (int)ToNumber(args[0])).ToList();
+                }
+                return new List<object>(args);
+            }, typeof(List<object>));
+            var uint8ArrayConstructor = new JSFunction("Uint8Array", null, (object[] args) => {
+                if (args.Length == 0)
+                {
+                    return new byte[0];
+                }
+                return new byte[(int)ToNumber(args[0])];
+            }, typeof(byte[]));
 
-            JSObject fs = new JSObject("fs", new Dictionary<string, object>()
+            JSObject process = new JSObject("process", new Dictionary<string, object>()
             {
-                {"constants", new JSObject(new Dictionary<string, object>()
-                    {
-                        {"O_WRONLY", -1},
-                        {"O_RDWR", -1},
-                        {"O_CREAT", -1},
-                        {"O_TRUNC", -1},
-                        {"O_APPEND", -1},
-                        {"O_EXCL", -1},
-                    })},
+                {"pid", -1},
+                {"ppid", -1},
+                {"cwd", new JSFunction("cwd", (object self, object[] args) => {
+                    return Directory.GetCurrentDirectory();
+                })},
             });
+            foreach (var name in new string[] { "getuid", "getgid", "geteuid", "getegid" })
+            {
+                process.Set(name, new JSFunction(name, (object self, object[] args) => {
+                    return -1;
+                }));
+            }
 
-            Global = new JSObject("global", new Dictionary<string, object>()
+            return new JSGlobal(fallback, new Dictionary<string, object>()
             {
-                {"Object", obj},
-                {"Array", arr},
-                {"process", null},
+                {"Object", objectConstructor},
+                {"Array", arrayConstructor},
+                {"process", process},
                 {"fs", fs},
-                {"Uint8Array", null},
+                {"Uint8Array", uint8ArrayConstructor},
+                {"Promise", JSPromise.NewConstructor(post)},
             });
         }
-        
+
+        public static double ToNumber(object value)
+        {
+            if (value == null)
+            {
+                return 0;
+            }
+            if (value == Undefined)
+            {
+                return double.NaN;
+            }
+            if (value is bool)
+            {
+                return (bool)value ? 1 : 0;
+            }
+            if (value is string)
+            {
+                return StringToNumber((string)value);
+            }
+            if (value is IConvertible)
+            {
+                return Convert.ToDouble(value, CultureInfo.InvariantCulture);
+            }
+            return double.NaN;
+        }
+
+        // StringToNumber converts the string to a number in the same way as JavaScript's Number(str),
+        // regardless of the current culture.
+        public static double StringToNumber(string str)
+        {
+            str = str.Trim();
+            if (str == "")
+            {
+                return 0;
+            }
+            switch (str)
+            {
+            case "Infinity":
+            case "+Infinity":
+                return double.PositiveInfinity;
+            case "-Infinity":
+                return double.NegativeInfinity;
+            }
+            if (str.Length > 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X'))
+            {
+                ulong hex;
+                if (ulong.TryParse(str.Substring(2), NumberStyles.AllowHexSpecifier, CultureInfo.InvariantCulture, out hex))
+                {
+                    return hex;
+                }
+                return double.NaN;
+            }
+            double d;
+            if (str.All(c => ('0' <= c && c <= '9') || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-') &&
+                double.TryParse(str, NumberStyles.Float, CultureInfo.InvariantCulture, out d))
+            {
+                return d;
+            }
+            return double.NaN;
+        }
+
+        // NumberToString converts the number to a string in the same way as JavaScript's Number.prototype.toString,
+        // regardless of the current culture.
+        public static string NumberToString(double d)
+        {
+            if (double.IsNaN(d))
+            {
+                return "NaN";
+            }
+            if (double.IsPositiveInfinity(d))
+            {
+                return "Infinity";
+            }
+            if (double.IsNegativeInfinity(d))
+            {
+                return "-Infinity";
+            }
+            if (d == 0)
+            {
+                // This includes -0.
+                return "0";
+            }
+
+            // Get the shortest digits that round-trip, and the position of the decimal point.
+            var sign = d < 0 ? "-" : "";
+            var r = Math.Abs(d).ToString("R", CultureInfo.InvariantCulture);
+            var exp = 0;
+            var idx = r.IndexOfAny(new char[] { 'E', 'e' });
+            if (idx >= 0)
+            {
+                exp = int.Parse(r.Substring(idx + 1), NumberStyles.AllowLeadingSign, CultureInfo.InvariantCulture);
+                r = r.Substring(0, idx);
+            }
+            var point = r.IndexOf('.');
+            var n = (point >= 0 ? point : r.Length) + exp;
+            var digits = r.Replace(".", "");
+            var leadingZeros = digits.Length - digits.TrimStart('0').Length;
+            digits = digits.Substring(leadingZeros).TrimEnd('0');
+            n -= leadingZeros;
+            var k = digits.Length;
+
+            if (k <= n && n <= 21)
+            {
+                return sign + digits + new string('0', n - k);
+            }
+            if (0 < n && n <= 21)
+            {
+                return sign + digits.Substring(0, n) + "." + digits.Substring(n);
+            }
+            if (-6 < n && n <= 0)
+            {
+                return sign + "0." + new string('0', -n) + digits;
+            }
+            var e = n - 1;
+            var expStr = (e < 0 ? "e-" : "e+") + Math.Abs(e).ToString(CultureInfo.InvariantCulture);
+            if (k == 1)
+            {
+                return sign + digits + expStr;
+            }
+            return sign + digits.Substring(0, 1) + "." + digits.Substring(1) + expStr;
+        }
+
+        // Stringify converts the value to a string in the same way as JavaScript's String(value).
+        public static string Stringify(object value)
+        {
+            if (value == null)
+            {
+                return "null";
+            }
+            if (value is string)
+            {
+                return (string)value;
+            }
+            if (value is bool)
+            {
+                return (bool)value ? "true" : "false";
+            }
+            if (value is byte[])
+            {
+                return string.Join(",", ((byte[])value).Select(b => b.ToString(CultureInfo.InvariantCulture)));
+            }
+            if (value is List<object>)
+            {
+                return string.Join(",", ((List<object>)value).Select(v => (v == null || v == Undefined) ? "" : Stringify(v)));
+            }
+            if (value is JSObject)
+            {
+                return value.ToString();
+            }
+            if (value is Exception)
+            {
+                return "Error: " + ((Exception)value).Message;
+            }
+            if (!(value is IConvertible))
+            {
+                return "[object Object]";
+            }
+            return NumberToString(ToNumber(value));
+        }
+
         public static object ReflectGet(object target, string key)
         {
-            if (target == Undefined)
+            if (target == Undefined || target == null)
             {
-                throw new Exception("undefined.{key} not found");
+                throw new Exception($"cannot read property {key} of {Stringify(target)}");
             }
             if (target is JSObject)
             {
                 return ((JSObject)target).Get(key);
             }
+            if (target is IDictionary<string, object>)
+            {
+                object value;
+                if (((IDictionary<string, object>)target).TryGetValue(key, out value))
+                {
+                    return value;
+                }
+                return Undefined;
+            }
+            if (key == "length")
+            {
+                if (target is string)
+                {
+                    return ((string)target).Length;
+                }
+                if (target is byte[])
+                {
+                    return ((byte[])target).Length;
+                }
+                if (target is List<object>)
+                {
+                    return ((List<object>)target).Count;
+                }
+            }
+            if (target is Exception && key == "message")
+            {
+                return ((Exception)target).Message;
+            }
             throw new Exception($"{target}.{key} not found");
         }
 
+        public static void ReflectSet(object target, string key, object value)
+        {
+            if (target is JSObject && target != Undefined)
+            {
+                ((JSObject)target).Set(key, value);
+                return;
+            }
+            if (target is IDictionary<string, object>)
+            {
+                ((IDictionary<string, object>)target)[key] = value;
+                return;
+            }
+            throw new Exception($"cannot set {key} on {Stringify(target)}");
+        }
+
+        public static void ReflectDelete(object target, string key)
+        {
+            if (target is JSObject && target != Undefined)
+            {
+                ((JSObject)target).Delete(key);
+                return;
+            }
+            if (target is IDictionary<string, object>)
+            {
+                ((IDictionary<string, object>)target).Remove(key);
+                return;
+            }
+            throw new Exception($"cannot delete {key} from {Stringify(target)}");
+        }
+
+        public static object ReflectGetIndex(object target, long index)
+        {
+            if (target is List<object>)
+            {
+                var list = (List<object>)target;
+                if (index < 0 || index >= list.Count)
+                {
+                    return Undefined;
+                }
+                return list[(int)index];
+            }
+            if (target is byte[])
+            {
+                var bytes = (byte[])target;
+                if (index < 0 || index >= bytes.Length)
+                {
+                    return Undefined;
+                }
+                return bytes[index];
+            }
+            return ReflectGet(target, index.ToString(CultureInfo.InvariantCulture));
+        }
+
+        public static void ReflectSetIndex(object target, long index, object value)
+        {
+            if (target is List<object>)
+            {
+                var list = (List<object>)target;
+                while (list.Count <= index)
+                {
+                    list.Add(Undefined);
+                }
+                list[(int)index] = value;
+                return;
+            }
+            if (target is byte[])
+            {
+                var bytes = (byte[])target;
+                if (0 <= index && index < bytes.Length)
+                {
+                    bytes[index] = (byte)ToNumber(value);
+                }
+                return;
+            }
+            ReflectSet(target, index.ToString(CultureInfo.InvariantCulture), value);
+        }
+
+        public static object ReflectApply(object target, object thisArgument, object[] args)
+        {
+            if (target is JSFunction)
+            {
+                return ((JSFunction)target).Invoke(thisArgument, args);
+            }
+            throw new Exception($"{Stringify(target)} is not a function");
+        }
+
+        public static object ReflectConstruct(object target, object[] args)
+        {
+            if (target is JSFunction)
+            {
+                return ((JSFunction)target).Construct(args);
+            }
+            throw new Exception($"{Stringify(target)} is not a constructor");
+        }
+
+        // Keys returns the property names of the object in the same way as JavaScript's Object.keys.
+        public static IEnumerable<string> Keys(object target)
+        {
+            if (target is JSObject && target != Undefined)
+            {
+                return ((JSObject)target).values.Keys.ToList();
+            }
+            if (target is IDictionary<string, object>)
+            {
+                return ((IDictionary<string, object>)target).Keys.ToList();
+            }
+            if (target is List<object>)
+            {
+                return Enumerable.Range(0, ((List<object>)target).Count).Select(i => i.ToString(CultureInfo.InvariantCulture)).ToList();
+            }
+            if (target is byte[])
+            {
+                return Enumerable.Range(0, ((byte[])target).Length).Select(i => i.ToString(CultureInfo.InvariantCulture)).ToList();
+            }
+            if (target == null || target == Undefined)
+            {
+                throw new Exception($"cannot convert {Stringify(target)} to object");
+            }
+            return Enumerable.Empty<string>();
+        }
+
+        // ToDynamic converts the JavaScript value into a .NET object graph.
+        // Objects are converted into ExpandoObject, arrays into List<object> and undefined into null.
+        public static object ToDynamic(object value)
+        {
+            return ToDynamic(value, new Dictionary<object, object>());
+        }
+
+        private static object ToDynamic(object value, Dictionary<object, object> converted)
+        {
+            if (value == Undefined)
+            {
+                return null;
+            }
+            if (value == null || value is JSFunction)
+            {
+                return value;
+            }
+            if (converted.ContainsKey(value))
+            {
+                return converted[value];
+            }
+            if (value is JSObject || value is IDictionary<string, object>)
+            {
+                var obj = new ExpandoObject();
+                converted[value] = obj;
+                var dict = (IDictionary<string, object>)obj;
+                foreach (var key in Keys(value))
+                {
+                    dict[key] = ToDynamic(ReflectGet(value, key), converted);
+                }
+                return obj;
+            }
+            if (value is List<object>)
+            {
+                var list = new List<object>();
+                converted[value] = list;
+                foreach (var v in (List<object>)value)
+                {
+                    list.Add(ToDynamic(v, converted));
+                }
+                return list;
+            }
+            return value;
+        }
+
+        public static double Length(object target)
+        {
+            return ToNumber(ReflectGet(target, "length"));
+        }
+
+        public static bool InstanceOf(object value, object type)
+        {
+            if (type is JSFunction)
+            {
+                return ((JSFunction)type).IsInstance(value);
+            }
+            throw new Exception($"right-hand side of instanceof is not callable: {Stringify(type)}");
+        }
+
         public JSObject(Dictionary<string, object> values)
             : this("(JSObject)", values)
         {
@@ -64,13 +460,20 @@ const js = `    class JSObject
             this.values = values;
         }
 
+        // Get returns the property value, or undefined if the property doesn't exist as JavaScript does.
         public virtual object Get(string key)
         {
-            if (this.values.ContainsKey(key))
+            object value;
+            if (this.values.TryGetValue(key, out value))
             {
-                return this.values[key];
+                return value;
             }
-            throw new Exception($"{this}.{key} not found");
+            return Undefined;
+        }
+
+        public bool Has(string key)
+        {
+            return this.values.ContainsKey(key);
         }
 
         public void Set(string key, object value)
@@ -78,6 +481,11 @@ const js = `    class JSObject
             this.values[key] = value;
         }
 
+        public void Delete(string key)
+        {
+            this.values.Remove(key);
+        }
+
         public override string ToString()
         {
             return this.name;
@@ -85,4 +493,71 @@ const js = `    class JSObject
 
         private Dictionary<string, object> values;
         private string name;
+    }
+
+    sealed class JSGlobal : JSObject
+    {
+        public JSGlobal(Func<string, object> fallback, Dictionary<string, object> values)
+            : base("global", values)
+        {
+            this.fallback = fallback;
+        }
+
+        public override object Get(string key)
+        {
+            if (this.Has(key) || this.fallback == null)
+            {
+                return base.Get(key);
+            }
+            return this.fallback(key);
+        }
+
+        private Func<string, object> fallback;
+    }
+
+    class JSFunction : JSObject
+    {
+        public JSFunction(string name, Func<object, object[], object> invoke)
+            : this(name, invoke, null, null)
+        {
+        }
+
+        public JSFunction(string name, Func<object, object[], object> invoke, Func<object[], object> construct, Type instanceType)
+            : base(name)
+        {
+            this.invoke = invoke;
+            this.construct = construct;
+            this.instanceType = instanceType;
+        }
+
+        public object Invoke(object thisArgument, object[] args)
+        {
+            if (this.invoke == null)
+            {
+                throw new Exception($"{this} is not a function");
+            }
+            return this.invoke(thisArgument, args);
+        }
+
+        public object Construct(object[] args)
+        {
+            if (this.construct == null)
+            {
+                throw new Exception($"{this} is not a constructor");
+            }
+            return this.construct(args);
+        }
+
+        public bool IsInstance(object value)
+        {
+            if (this.instanceType == null || value == null || value == Undefined)
+            {
+                return false;
+            }
+            return this.instanceType.IsInstanceOfType(value);
+        }
+
+        private Func<object, object[], object> invoke;
+        private Func<object[], object> construct;
+        private Type instanceType;
     }`
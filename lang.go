@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+)
+
+// csharpVersions is the C# language versions that -csharp-version accepts, and their numbers for comparison.
+var csharpVersions = map[string]int{
+	"7.3":  73,
+	"8.0":  80,
+	"9.0":  90,
+	"10.0": 100,
+	"11.0": 110,
+	"12.0": 120,
+}
+
+// lookupCSharpVersion returns the number of the C# language version for comparison, e.g. 73 for "7.3".
+func lookupCSharpVersion(version string) (int, error) {
+	v, ok := csharpVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown -csharp-version value %q (available: 7.3, 8.0, 9.0, 10.0, 11.0 or 12.0)", version)
+	}
+	return v, nil
+}
@@ -0,0 +1,352 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// lineRow is a row of the DWARF line table.
+type lineRow struct {
+	// Address is the offset of the instruction from the start of the code section's content.
+	Address uint64
+
+	// File is the path of the source file.
+	File string
+
+	// Line is the line number, or 0 if the instruction has no source line.
+	Line int
+
+	// End reports whether the row is the end of a sequence, which has no instruction.
+	End bool
+}
+
+// lineTable maps the instructions of the wasm functions to the source lines by the DWARF debug info in the custom
+// sections (.debug_info, .debug_line and so on).
+//
+// Go's linker doesn't emit DWARF for wasm; it has the line info only in the runtime's pclntab. The toolchains based
+// on LLVM like TinyGo emit DWARF unless it is stripped.
+type lineTable struct {
+	// codeStarts is the addresses of the first instructions of the function bodies, without the local declarations.
+	codeStarts []uint64
+
+	// codes is the code of the function bodies in the wasm file, before lowerExtensions.
+	codes [][]byte
+
+	// rows is the rows of all the sequences, sorted by the address.
+	rows []lineRow
+}
+
+// readSectionHeader reads the ID and the content of a wasm section.
+func readSectionHeader(r *bytes.Reader) (id byte, content []byte, err error) {
+	id, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if int64(size) > int64(r.Len()) {
+		return 0, nil, fmt.Errorf("section %d is truncated", id)
+	}
+	content = make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return id, content, nil
+}
+
+// loadLineTable returns the line table of the wasm file, or nil if the file has no DWARF.
+func loadLineTable(wasmBytes []byte) (*lineTable, error) {
+	if len(wasmBytes) < 8 {
+		return nil, fmt.Errorf("the wasm file is too short")
+	}
+	t := &lineTable{}
+	debugSections := map[string][]byte{}
+
+	r := bytes.NewReader(wasmBytes[8:])
+	for r.Len() > 0 {
+		id, content, err := readSectionHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case 0:
+			cr := bytes.NewReader(content)
+			n, err := leb128.ReadVarUint32(cr)
+			if err != nil {
+				return nil, err
+			}
+			if int64(n) > int64(cr.Len()) {
+				return nil, fmt.Errorf("the name of a custom section is truncated")
+			}
+			name := make([]byte, n)
+			if _, err := io.ReadFull(cr, name); err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(string(name), ".debug_") {
+				debugSections[string(name)] = content[len(content)-cr.Len():]
+			}
+		case 10:
+			cr := bytes.NewReader(content)
+			n, err := leb128.ReadVarUint32(cr)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < int(n); i++ {
+				size, err := leb128.ReadVarUint32(cr)
+				if err != nil {
+					return nil, err
+				}
+				end := int64(len(content)-cr.Len()) + int64(size)
+				localEntries, err := leb128.ReadVarUint32(cr)
+				if err != nil {
+					return nil, err
+				}
+				for j := 0; j < int(localEntries); j++ {
+					if _, err := leb128.ReadVarUint32(cr); err != nil {
+						return nil, err
+					}
+					if _, err := cr.ReadByte(); err != nil {
+						return nil, err
+					}
+				}
+				start := int64(len(content) - cr.Len())
+				t.codeStarts = append(t.codeStarts, uint64(start))
+				t.codes = append(t.codes, content[start:end])
+				if _, err := cr.Seek(end, io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if debugSections[".debug_info"] == nil || debugSections[".debug_line"] == nil {
+		return nil, nil
+	}
+	d, err := dwarf.New(debugSections[".debug_abbrev"], debugSections[".debug_aranges"], debugSections[".debug_frame"],
+		debugSections[".debug_info"], debugSections[".debug_line"], debugSections[".debug_pubnames"],
+		debugSections[".debug_ranges"], debugSections[".debug_str"])
+	if err != nil {
+		return nil, err
+	}
+	// DWARF 5 sections.
+	for _, name := range []string{".debug_addr", ".debug_line_str", ".debug_str_offsets", ".debug_rnglists"} {
+		if s, ok := debugSections[name]; ok {
+			if err := d.AddSection(name, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dr := d.Reader()
+	for {
+		e, err := dr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			dr.SkipChildren()
+			continue
+		}
+		lr, err := d.LineReader(e)
+		if err != nil {
+			return nil, err
+		}
+		dr.SkipChildren()
+		if lr == nil {
+			continue
+		}
+		if err := t.addRows(lr); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(t.rows, func(i, j int) bool {
+		return t.rows[i].Address < t.rows[j].Address
+	})
+	return t, nil
+}
+
+// addRows adds the rows of the line program to the table.
+func (t *lineTable) addRows(lr *dwarf.LineReader) error {
+	var seq []lineRow
+	for {
+		var e dwarf.LineEntry
+		if err := lr.Next(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		row := lineRow{
+			Address: e.Address,
+			Line:    e.Line,
+			End:     e.EndSequence,
+		}
+		if e.File != nil {
+			row.File = e.File.Name
+		}
+		seq = append(seq, row)
+		if !e.EndSequence {
+			continue
+		}
+		// The linker relocates the sequence of a removed function to 0 or -1 (a tombstone). Address 0 is never
+		// an instruction, as the code section starts with the number of the functions.
+		if a := seq[0].Address; a != 0 && a < 0xfffffffe {
+			t.rows = append(t.rows, seq...)
+		}
+		seq = nil
+	}
+}
+
+// lookup returns the row of the instruction at the address.
+func (t *lineTable) lookup(addr uint64) (lineRow, bool) {
+	i := sort.Search(len(t.rows), func(i int) bool {
+		return t.rows[i].Address > addr
+	})
+	if i == 0 || t.rows[i-1].End {
+		return lineRow{}, false
+	}
+	return t.rows[i-1], true
+}
+
+// instrOffsets returns the offsets of the instructions in the function body's code.
+func instrOffsets(code []byte) ([]uint64, error) {
+	r := bytes.NewReader(code)
+	skipLEBs := func(n int) error {
+		for i := 0; i < n; i++ {
+			if _, err := leb128.ReadVarint64(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var offsets []uint64
+	for r.Len() > 0 {
+		offsets = append(offsets, uint64(len(code)-r.Len()))
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case op == operators.Block, op == operators.Loop, op == operators.If:
+			if _, err := r.ReadByte(); err != nil {
+				return nil, err
+			}
+		case op == operators.Br, op == operators.BrIf, op == operators.Call,
+			op == operators.GetLocal, op == operators.SetLocal, op == operators.TeeLocal,
+			op == operators.GetGlobal, op == operators.SetGlobal,
+			op == operators.CurrentMemory, op == operators.GrowMemory,
+			op == operators.I32Const, op == operators.I64Const:
+			if err := skipLEBs(1); err != nil {
+				return nil, err
+			}
+		case op == operators.CallIndirect, operators.I32Load <= op && op <= operators.I64Store32:
+			if err := skipLEBs(2); err != nil {
+				return nil, err
+			}
+		case op == operators.BrTable:
+			n, err := leb128.ReadVarUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := skipLEBs(int(n) + 1); err != nil {
+				return nil, err
+			}
+		case op == operators.F32Const:
+			if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		case op == operators.F64Const:
+			if _, err := r.Seek(8, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		case op == prefixFC:
+			if _, err := readExtensionFC(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// instrAddrs returns the addresses of the disassembled instructions of the body-th function body.
+//
+// The disassembly omits unreachable instructions, so the instructions are matched with the ones in the code by
+// the opcodes in order. An instruction just after an omitted one of the same opcode might get the omitted one's
+// address, but both are in the same unreachable region.
+//
+// The code is lowered by lowerExtensions, and the instructions that an instruction is lowered into have its address.
+// The helper functions that lowerExtensions appends are not in the wasm file, and have no addresses.
+func (t *lineTable) instrAddrs(body int, code []byte, instrs []disasm.Instr) ([]uint64, error) {
+	if body >= len(t.codeStarts) {
+		return nil, nil
+	}
+	offsets, err := instrOffsets(code)
+	if err != nil {
+		return nil, err
+	}
+	orig := t.codes[body]
+	origOffsets, err := instrOffsets(orig)
+	if err != nil {
+		return nil, err
+	}
+	var loweredOffsets []uint64
+	for _, o := range origOffsets {
+		for i := 0; i < loweredInstrs(orig[o:]); i++ {
+			loweredOffsets = append(loweredOffsets, o)
+		}
+	}
+	if len(loweredOffsets) != len(offsets) {
+		return nil, fmt.Errorf("function body %d doesn't match the code in the wasm file", body)
+	}
+	addrs := make([]uint64, len(instrs))
+	var j int
+	for i, instr := range instrs {
+		for j < len(offsets) && code[offsets[j]] != instr.Op.Code {
+			j++
+		}
+		if j == len(offsets) {
+			return nil, fmt.Errorf("instruction %d of function body %d doesn't match the code", i, body)
+		}
+		addrs[i] = t.codeStarts[body] + loweredOffsets[j]
+		j++
+	}
+	return addrs, nil
+}
+
+// lineDirective returns the #line directive for the row.
+func lineDirective(row lineRow) string {
+	// The file name of #line is not unescaped, and cannot have double quotes.
+	if row.Line <= 0 || row.File == "" || strings.ContainsAny(row.File, "\"\r\n") {
+		return "#line hidden"
+	}
+	return fmt.Sprintf("#line %d \"%s\"", row.Line, row.File)
+}
+
+// removeEmptyLineDirectives removes the #line directives that are followed by another one, as the statements
+// between them are removed as warnings.
+func removeEmptyLineDirectives(body []string) []string {
+	var r []string
+	for i, l := range body {
+		if strings.HasPrefix(strings.TrimSpace(l), "#line ") && i+1 < len(body) && strings.HasPrefix(strings.TrimSpace(body[i+1]), "#line ") {
+			continue
+		}
+		r = append(r, l)
+	}
+	return r
+}
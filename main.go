@@ -5,10 +5,13 @@ package main
 import (
 	"bytes"
 	"bufio"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"text/template"
 
@@ -20,9 +23,79 @@ var (
 	flagWasm      = flag.String("wasm", "", "WebAssembly file generated by Go")
 	flagNamespace = flag.String("namespace", "", "Namespace")
 	flagProfile   = flag.Bool("profile", false, "Take profiles")
+	flagTarget    = flag.String("target", defaultTarget, "Target framework (netstandard2.0, netstandard2.1, net48, netcoreapp3.1, net6.0 or net8.0), or target frameworks separated by semicolons for a multi-targeting project, e.g. \"net48;net8.0\"")
+	flagTgtProf   = flag.String("target-profile", "", "Profile to generate code for. unity restricts the code to what Unity's IL2CPP supports, and writes an .asmdef with -out. blazor adds extensions to host the program in a Blazor WebAssembly app")
+	flagEmit      = flag.String("emit", "cs", "What to write to -out: cs (C# code and a .csproj) or dll (an assembly and its portable PDB compiled from the C# code)")
+	flagMemory    = flag.String("memory", "array", "How to access the linear memory: array (byte by byte) or unsafe (Unsafe.ReadUnaligned and WriteUnaligned)")
+	flagUnsafeMem = flag.Bool("unsafe-memory", false, "Access the linear memory by pointers to the pinned memory without bounds checks, except for constant addresses checked once per function. An access out of the memory is undefined behavior instead of a trap")
+	flagData      = flag.String("data", "array", "How to embed data segments: array (array literals), base64 (base64 strings), span (static ReadOnlySpan<byte> data) or resource (an embedded resource, requires -out)")
+	flagCompress  = flag.String("compress", "none", "How to compress the embedded data segments: none or gzip. The data is decompressed when the memory is created")
+	flagSrc       = flag.String("src", "", "Directory of the Go package the wasm file is built from. The doc comments of the exported functions are emitted as XML docs, and the Go module is stamped into the assembly if the wasm file has no build information")
+	flagVisible   = flag.String("visibility", "public", "Accessibility of the generated types: public (for class libraries) or internal (for embedding the code into another assembly)")
+	flagOuter     = flag.String("outer", "", "Name of a static class to put the generated types in")
+	flagAOT       = flag.Bool("aot", false, "Generate code without reflection for Native AOT, and enable PublishAot in the .csproj (requires -target net8.0)")
+	flagCSharp    = flag.String("csharp-version", "", "C# language version of the generated code: 7.3, 8.0, 9.0, 10.0, 11.0 or 12.0. With 7.3, the code avoids newer syntax like switch expressions and #nullable for older compilers, e.g. old Unity or MSBuild (default: 8.0, or 10.0 with -style-namespace=file)")
+	flagStyleNS   = flag.String("style-namespace", "block", "Namespace declaration style: block or file (file-scoped, C# 10)")
+	flagStyleType = flag.String("style-types", "var", "Variable declaration style in functions: var or explicit")
+	flagStyleBr   = flag.String("style-braces", "newline", "Brace style: newline (Allman) or sameline (K&R)")
+	flagStyleInd  = flag.String("style-indent", "4", "Indentation width in spaces, or tab")
+	flagRuntime   = flag.Bool("runtime-files", false, "Write the runtime that doesn't depend on the wasm file to separate files in the runtime directory (requires -out)")
+	flagIndirect  = flag.String("indirect", "table", "How to dispatch call_indirect: table (a delegate array for each signature) switch (a method with a switch on the table index for each signature, without delegates) or cache (the delegate array, with the last callee cached at each call site)")
+	flagStatic    = flag.Bool("static", false, "Make the state and the functions of the wasm module static, for programs that have only one instance at a time")
+	flagCOM       = flag.Bool("com", false, "Generate a COM-visible facade GoCom over the exported functions, and enable the COM registration in the .csproj. The project can be built only on Windows")
+	flagGRPC      = flag.Bool("grpc", false, "Write a .proto and an ASP.NET Core gRPC service that calls the exported functions (requires -out and -target net6.0 or net8.0)")
+	flagBench     = flag.String("emit-bench", "", "Write a BenchmarkDotNet project that calls the given exported functions (comma-separated, or all), with the wasm file under Wasmtime as the baseline if it imports only WASI (requires -out)")
+	flagTests     = flag.String("emit-tests", "", "Write an xUnit test project with a test for each of the given exported functions (comma-separated, or all) (requires -out)")
+	flagImports   = flag.Bool("emit-imports", false, "Write <name>.imports.json and <name>.imports.md that list the imported functions and whether the runtime implements them or the host must supply them (requires -out)")
+	flagAggrInl   = flag.Int("aggressive-inlining", 8, "Mark the functions of at most the given number of instructions with MethodImplOptions.AggressiveInlining (0 disables). The helpers like the memory accessors are always marked")
+	flagAggrOpt   = flag.Int("aggressive-optimization", 0, "Mark the functions of at least the given number of instructions with MethodImplOptions.AggressiveOptimization, which skips tiered compilation (0 disables; requires .NET Core 3.0 or later)")
+	flagParallel  = flag.Int("p", runtime.NumCPU(), "Number of functions to translate in parallel")
+	flagCache     = flag.Bool("cache", false, "Reuse the functions translated by the same go2dotnet from the same wasm file with the same options, in the user cache directory")
+	flagMaxLines  = flag.Int("max-method-lines", 0, "Split the functions of more than the given number of C# lines into methods of about the number of lines, which RyuJIT can compile fast and optimize, e.g. 10000 (0 disables splitting)")
+	flagMaxOps    = flag.Int("max-method-ops", 0, "Split the functions of more than the given number of wasm instructions into methods of about the number of instructions, so that the methods stay under the size that RyuJIT optimizes with tiered compilation (0 disables the limit)")
+	flagPGOGen    = flag.Bool("pgo-gen", false, "Instrument the functions to count their calls, and write the counts to the file of the environment variable GO2DOTNET_PGO or default.pgo when the process exits")
+	flagPGO       = flag.String("pgo", "", "Profile written by the code generated with -pgo-gen, to inline the functions into the hot functions, mark the hot functions with AggressiveOptimization and split them less")
+	flagInline    = flag.Int("inline", 0, "Inline the functions of at most the given number of instructions without control flow, calls or locals, like the wrappers by Go's compiler, at the call sites (0 disables inlining)")
+	flagWasmOpt   = flag.String("wasm-opt", "", "Options of Binaryen's wasm-opt, e.g. \"-O2\", to optimize the wasm file with before the translation (requires wasm-opt in PATH)")
+	flagTrusted   = flag.Bool("trusted", false, "Skip the structural validation of the function bodies, for a wasm file that go build has just produced. Validation takes time on a large module, and is on by default for wasm files from elsewhere")
+	flagLine      = flag.Bool("line", false, "Emit #line directives that map the generated code to the source lines by the DWARF debug info in the wasm file (e.g. by TinyGo, not by Go)")
+	flagHeader    = flag.String("header", "", "Text file of a license header to put at the top of the generated C# files")
+	flagTemplates = flag.String("templates", "", "Directory of templates to replace the built-in ones (out.cs, partial.cs, runtime.cs and func)")
+	flagVerbose   = flag.Bool("v", false, "Print the functions that exceed -max-method-lines or -max-method-ops and whether they are split to the standard error")
+	flagOut       = flag.String("out", "", "Directory to write the generated code and a .csproj to, instead of the standard output")
+	flagSln       = flag.Bool("sln", false, "Write a .sln with the .csproj (requires -out)")
+	flagSplit     = flag.Int("split", 0, "Split the functions into files of at most the given number of functions (requires -out)")
+	flagPack      = flag.Bool("pack", false, "Run dotnet pack to make a NuGet package (requires -out)")
+	flagPackageID = flag.String("package-id", "", "NuGet package ID (default: the name of the wasm file)")
+	flagVersion   = flag.String("package-version", "", "NuGet package version (default: the Go main module version)")
+	flagLicense   = flag.String("package-license", "", "NuGet package license as an SPDX expression")
+)
+
+// The defaults of -max-method-lines and -max-method-ops with -target-profile=unity.
+const (
+	unityMaxMethodLines = 10000
+	unityMaxMethodOps   = 10000
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		if err := runConformance(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerify(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
 	flag.Parse()
 	if *flagProfile {
 		defer profile.Start().Stop()
@@ -32,50 +105,73 @@ func main() {
 	}
 }
 
-func identifierFromString(str string) string {
-	var ident string
-	for _, r := range []rune(str) {
-		if r > 0xff {
-			panic("identifiers cannot include non-Latin1 characters")
-		}
-		if '0' <= r && r <= '9' {
-			ident += string(r)
-			continue
-		}
-		if 'a' <= r && r <= 'z' {
-			ident += string(r)
-			continue
-		}
-		if 'A' <= r && r <= 'Z' {
-			ident += string(r)
-			continue
-		}
-		ident += fmt.Sprintf("_%02x", r)
-	}
-	if len(ident) > 512 {
-		ident = ident[:511]
-	}
-	return ident
-}
-
 type Func struct {
-	Mod     *wasm.Module
-	Funcs   []*Func
-	Types   []*Type
-	Type    *Type
-	Wasm    wasm.Function
-	Index   int
-	Import  bool
-	BodyStr string
+	Mod        *wasm.Module
+	Funcs      []*Func
+	Types      []*Type
+	Type       *Type
+	Target     *target
+	Style      *codeStyle
+	Static     bool
+	Wasm       wasm.Function
+	Index      int
+	Import     bool
+	ModuleName string
+	BodyStr    string
+
+	// Ident is the C# identifier of the function, assigned by mangler.
+	Ident string
+
+	// Strings is the string constants that have the names of the imported functions resolved by the host.
+	Strings *stringConsts
+
+	// Lines is the line table to emit #line directives by, or nil.
+	Lines *lineTable
+
+	// IndirectSwitch reports whether call_indirect calls the dispatch method with a switch instead of the delegate
+	// table, by -indirect=switch.
+	IndirectSwitch bool
+
+	// IndirectCache reports whether each call site of call_indirect caches the last callee, by -indirect=cache.
+	IndirectCache bool
+
+	// PointerMemory reports whether the memory is accessed without bounds checks, by -unsafe-memory.
+	PointerMemory bool
+
+	// AggressiveInlining is the maximum number of instructions of the function to be marked with AggressiveInlining,
+	// and AggressiveOptimization is the minimum number to be marked with AggressiveOptimization. 0 marks none.
+	AggressiveInlining     int
+	AggressiveOptimization int
+
+	// MaxMethodLines is the maximum number of the lines of the body before the function is split, by
+	// -max-method-lines. 0 disables splitting.
+	MaxMethodLines int
+
+	// MaxMethodOps is the maximum number of the wasm instructions before the function is split, by -max-method-ops.
+	// 0 disables the limit.
+	MaxMethodOps int
+
+	// ProfileGen reports whether the function counts its executions, by -pgo-gen.
+	ProfileGen bool
+
+	// Hot reports whether the function is hot in the profile by -pgo.
+	Hot bool
+
+	// generated is the code generated in advance by generateFuncs, keyed by the arguments of CSharp.
+	generated map[funcCodeKey]string
+
+	// stats is the size of the function and how it is split, set by CSharp with the body.
+	stats funcStats
 }
 
 func (f *Func) Identifier() string {
-	return identifierFromString(f.Wasm.Name)
+	return f.Ident
 }
 
 var funcTmpl = template.Must(template.New("func").Parse(`// OriginalName: {{.OriginalName}}
 // Index:        {{.Index}}
-{{if .WithBody}}{{if .Public}}public{{else}}private{{end}} {{end}}{{.ReturnType}} {{.Name}}({{.Args}}){{if .WithBody}}
+{{if .WithBody}}{{if .MethodImpl}}[MethodImpl({{.MethodImpl}})]
+{{end}}{{if .Public}}public{{else}}private{{end}} {{if .Static}}static {{end}}{{end}}{{.ReturnType}} {{.Name}}({{.Args}}){{if .WithBody}}
 {
 {{range .Locals}}    {{.}}
 {{end}}{{if .Locals}}
@@ -98,6 +194,10 @@ func wasmTypeToReturnType(v wasm.ValueType) ReturnType {
 }
 
 func (f *Func) CSharp(indent string, public bool, withBody bool) (string, error) {
+	if code, ok := f.generated[funcCodeKey{indent: indent, public: public, withBody: withBody}]; ok {
+		return code, nil
+	}
+
 	var retType ReturnType
 	switch ts := f.Wasm.Sig.ReturnTypes; len(ts) {
 	case 0:
@@ -115,6 +215,9 @@ func (f *Func) CSharp(indent string, public bool, withBody bool) (string, error)
 
 	var locals []string
 	var body []string
+	var methodImpl string
+	// members is the C# code of the members that follow the function, like the parts of a split function.
+	var members []string
 	if withBody {
 		if f.BodyStr != "" {
 			body = strings.Split(f.BodyStr, "\n")
@@ -126,40 +229,98 @@ func (f *Func) CSharp(indent string, public bool, withBody bool) (string, error)
 					idx++
 				}
 			}
+			var varTypes map[string]string
 			var err error
-			body, err = f.bodyToCSharp()
+			methodImpl, err = f.methodImpl()
+			if err != nil {
+				return "", err
+			}
+			body, varTypes, err = f.bodyToCSharp()
+			if err != nil {
+				return "", err
+			}
+			body = removeWarnings(body)
+			body = forwardSubstitute(body)
+			slots, b, varTypes := reuseStackSlots(body, varTypes)
+			locals = append(locals, slots...)
+			body = removeSelfAssignments(b)
+			body = replaceNaNChecks(body, append(append([]string{}, args...), locals...), varTypes)
+			locals = removeUnusedLocals(locals, body)
+			if fields := callSiteFields(body, f.Static); len(fields) > 0 {
+				members = append(members, strings.Join(fields, "\n"))
+			}
+			if f.Lines != nil {
+				body = removeEmptyLineDirectives(body)
+				// Map the rest of the file to the generated code itself.
+				body = append(body, "    #line default")
+			}
+			maxLines, maxOps := f.MaxMethodLines, f.MaxMethodOps
+			if f.Hot {
+				maxLines *= pgoSplitFactor
+				maxOps *= pgoSplitFactor
+			}
+			f.stats = funcStats{
+				Lines: len(body),
+			}
+			if maxOps > 0 {
+				n, err := f.numInstrs()
+				if err != nil {
+					return "", err
+				}
+				f.stats.Ops = n
+				if n > maxOps {
+					// Split into the parts of about maxOps instructions, as if the instructions were spread evenly
+					// over the lines.
+					l := len(body) * maxOps / n
+					if l < 1 {
+						l = 1
+					}
+					if maxLines == 0 || l < maxLines {
+						maxLines = l
+					}
+				}
+			}
+			if maxLines > 0 && len(body) > maxLines {
+				f.stats.OverLimit = true
+				if split := f.splitBody(locals, body, varTypes, maxLines); split != nil {
+					locals = nil
+					body = split.Body
+					members = append(members, split.Methods...)
+					f.stats.Parts = split.Parts
+				}
+			}
+		} else if f.Import {
+			b, err := f.resolvedImportBody()
 			if err != nil {
 				return "", err
 			}
+			body = b
+			members = append(members, fmt.Sprintf("private %s %s_;", delegateType(f.Wasm.Sig), f.Identifier()))
 		} else {
 			body = []string{"    throw new NotImplementedException();"}
 		}
 	}
 
 	var buf bytes.Buffer
-	if err := funcTmpl.Execute(&buf, struct {
-		OriginalName string
-		Name         string
-		Index        int
-		ReturnType   string
-		Args         string
-		Locals       []string
-		Body         []string
-		Public       bool
-		WithBody     bool
-	}{
-		OriginalName: f.Wasm.Name,
-		Name:         identifierFromString(f.Wasm.Name),
+	if err := funcTmpl.Execute(&buf, &funcData{
+		OriginalName: originalName(f.Wasm.Name),
+		Name:         f.Identifier(),
 		Index:        f.Index,
 		ReturnType:   retType.CSharp(),
 		Args:         strings.Join(args, ", "),
 		Locals:       locals,
 		Body:         body,
 		Public:       public,
+		Static:       f.Static,
 		WithBody:     withBody,
+		MethodImpl:   methodImpl,
 	}); err != nil {
 		return "", err
 	}
+	for _, m := range members {
+		buf.WriteString("\n\n")
+		buf.WriteString(m)
+	}
 
 	// Add indentations
 	var lines []string
@@ -169,10 +330,104 @@ func (f *Func) CSharp(indent string, public bool, withBody bool) (string, error)
 	return strings.Join(lines, "\n") + "\n", nil
 }
 
+// resolvedImportBody returns the body of an import function that go2dotnet doesn't know.
+// The implementation is provided by the host via IImportResolver as Action<...> or Func<...>, and is kept in the
+// field named after the function so that a call doesn't look it up.
+func (f *Func) resolvedImportBody() ([]string, error) {
+	module, err := f.Strings.Ref(f.ModuleName)
+	if err != nil {
+		return nil, err
+	}
+	name, err := f.Strings.Ref(f.Wasm.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for i := range f.Wasm.Sig.ParamTypes {
+		args = append(args, fmt.Sprintf("local%d", i))
+	}
+
+	var ret string
+	if len(f.Wasm.Sig.ReturnTypes) > 0 {
+		ret = "return "
+	}
+	dtype := delegateType(f.Wasm.Sig)
+
+	return []string{
+		fmt.Sprintf("    var f = this.%s_;", f.Identifier()),
+		"    if (f == null)",
+		"    {",
+		fmt.Sprintf("        f = go.ResolveImport<%s>(%s, %s);", dtype, module, name),
+		fmt.Sprintf("        this.%s_ = f;", f.Identifier()),
+		"    }",
+		fmt.Sprintf("    %sf(%s);", ret, strings.Join(args, ", ")),
+	}, nil
+}
+
+// delegateType returns the C# delegate type (Action<...> or Func<...>) for the function signature.
+func delegateType(sig *wasm.FunctionSig) string {
+	var types []string
+	for _, t := range sig.ParamTypes {
+		types = append(types, wasmTypeToReturnType(t).CSharp())
+	}
+
+	switch {
+	case len(sig.ReturnTypes) > 0:
+		types = append(types, wasmTypeToReturnType(sig.ReturnTypes[0]).CSharp())
+		return fmt.Sprintf("Func<%s>", strings.Join(types, ", "))
+	case len(types) > 0:
+		return fmt.Sprintf("Action<%s>", strings.Join(types, ", "))
+	default:
+		return "Action"
+	}
+}
+
 type Export struct {
 	Funcs []*Func
 	Index int
 	Name  string
+
+	// Ident is the C# identifier of the wrapper methods, which is mangled from Name. Name is still used for the string
+	// constant, e.g. the argument of GetExport.
+	Ident string
+
+	// AsyncIdent is the C# identifier of the Task-returning wrapper.
+	AsyncIdent string
+
+	// Doc is the doc comment of the Go function, or an empty string if unknown.
+	Doc string
+
+	// GoParams is the parameters of the Go function, or nil if unknown.
+	GoParams []goParam
+
+	// GoResults is the results of the Go function, or nil if unknown.
+	GoResults []goParam
+
+	// ParamNames is the names of the parameters in the name section, or nil if unknown.
+	ParamNames []string
+
+	// Static reports whether the function is static, by -static.
+	Static bool
+
+	// Malloc reports whether the module exports an allocator, which Exports uses to pass Go strings.
+	Malloc bool
+
+	// Strings is the string constants that have the export name.
+	Strings *stringConsts
+}
+
+// NameConst returns the C# expression of the constant of the export name.
+func (e *Export) NameConst() (string, error) {
+	return e.Strings.Ref(e.Name)
+}
+
+// self returns the C# expression to refer to the members of Inst.
+func (e *Export) self() string {
+	if e.Static {
+		return "Inst"
+	}
+	return "this"
 }
 
 func (e *Export) CSharp(indent string) (string, error) {
@@ -197,11 +452,204 @@ func (e *Export) CSharp(indent string) (string, error) {
 		argsToPass = append(argsToPass, fmt.Sprintf("arg%d", i))
 	}
 
-	str := fmt.Sprintf(`public %s %s(%s)
+	var static string
+	if e.Static {
+		static = "static "
+	}
+	str := fmt.Sprintf(`public %s%s %s(%s)
 {
     %s%s(%s);
 }
-`, retType.CSharp(), e.Name, strings.Join(args, ", "), ret, identifierFromString(f.Wasm.Name), strings.Join(argsToPass, ", "))
+`, static, retType.CSharp(), e.Ident, strings.Join(args, ", "), ret, f.Identifier(), strings.Join(argsToPass, ", "))
+
+	lines := strings.Split(str, "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	str = strings.Join(lines, "\n")
+	if e.Doc != "" {
+		str = xmlDoc(e.Doc, indent) + str
+	}
+	return str, nil
+}
+
+// InvokeCSharp returns C# statements that call the exported function with the arguments in the object[] args,
+// and return the result or null.
+func (e *Export) InvokeCSharp(indent string) string {
+	sig := e.Funcs[e.Index].Wasm.Sig
+	var args []string
+	for i, t := range sig.ParamTypes {
+		var conv string
+		switch wasmTypeToReturnType(t) {
+		case ReturnTypeI32:
+			conv = "ToInt32"
+		case ReturnTypeI64:
+			conv = "ToInt64"
+		case ReturnTypeF32:
+			conv = "ToSingle"
+		case ReturnTypeF64:
+			conv = "ToDouble"
+		}
+		args = append(args, fmt.Sprintf("Convert.%s(args[%d], CultureInfo.InvariantCulture)", conv, i))
+	}
+	call := fmt.Sprintf("%s.%s(%s)", e.self(), e.Ident, strings.Join(args, ", "))
+	var ret string
+	if len(sig.ReturnTypes) > 0 {
+		ret = fmt.Sprintf("return %s;", call)
+	} else {
+		ret = fmt.Sprintf("%s;\nreturn null;", call)
+	}
+	str := fmt.Sprintf(`if (args.Length != %[1]d)
+{
+    throw new ArgumentException($"function %[2]s takes %[1]d arguments but {args.Length} were given", nameof(args));
+}
+%[3]s`, len(sig.ParamTypes), e.Name, ret)
+
+	lines := strings.Split(str, "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// goABIExports is the exported functions that wasm_exec.js calls to run the Go program. These are not part of the API of
+// the program.
+var goABIExports = map[string]bool{
+	"run":    true,
+	"resume": true,
+	"getsp":  true,
+	"_start": true,
+}
+
+// IsAPI reports whether the exported function is a part of the API of the program, i.e. IGoApp.
+func (e *Export) IsAPI() bool {
+	return !goABIExports[e.Name]
+}
+
+// signature returns the C# return type and the parameter list of the exported function.
+func (e *Export) signature() (ReturnType, []string, []string, error) {
+	sig := e.Funcs[e.Index].Wasm.Sig
+	var retType ReturnType
+	switch ts := sig.ReturnTypes; len(ts) {
+	case 0:
+		retType = ReturnTypeVoid
+	case 1:
+		retType = wasmTypeToReturnType(ts[0])
+	default:
+		return 0, nil, nil, fmt.Errorf("the number of return values must be 0 or 1 but %d", len(ts))
+	}
+	var params []string
+	var args []string
+	for i, t := range sig.ParamTypes {
+		params = append(params, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).CSharp(), i))
+		args = append(args, fmt.Sprintf("arg%d", i))
+	}
+	return retType, params, args, nil
+}
+
+// InterfaceCSharp returns the declaration of the exported function in IGoApp.
+func (e *Export) InterfaceCSharp(indent string) (string, error) {
+	retType, params, _, err := e.signature()
+	if err != nil {
+		return "", err
+	}
+	str := fmt.Sprintf("%s%s %s(%s);", indent, retType.CSharp(), e.Ident, strings.Join(params, ", "))
+	if e.Doc != "" {
+		str = xmlDoc(e.Doc, indent) + str
+	}
+	return str, nil
+}
+
+// ImplCSharp returns the explicit implementation of the exported function of IGoApp in Go.
+// This calls the function via GetExport so that the function is called in the same way.
+func (e *Export) ImplCSharp(indent string) (string, error) {
+	retType, params, args, err := e.signature()
+	if err != nil {
+		return "", err
+	}
+	var ret string
+	if retType != ReturnTypeVoid {
+		ret = "return "
+	}
+	name, err := e.NameConst()
+	if err != nil {
+		return "", err
+	}
+	str := fmt.Sprintf(`%[1]s IGoApp.%[2]s(%[3]s)
+{
+    %[4]s((%[5]s)this.GetExport(%[6]s))(%[7]s);
+}`, retType.CSharp(), e.Ident, strings.Join(params, ", "), ret, e.DelegateType(), name, strings.Join(args, ", "))
+
+	lines := strings.Split(str, "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// ComCSharp returns the method of GoCom that calls the exported function via IGoApp.
+func (e *Export) ComCSharp(indent string) (string, error) {
+	retType, params, args, err := e.signature()
+	if err != nil {
+		return "", err
+	}
+	var ret string
+	if retType != ReturnTypeVoid {
+		ret = "return "
+	}
+	str := fmt.Sprintf(`public %[1]s %[2]s(%[3]s)
+{
+    %[4]s((IGoApp)this.go).%[2]s(%[5]s);
+}`, retType.CSharp(), e.Ident, strings.Join(params, ", "), ret, strings.Join(args, ", "))
+
+	lines := strings.Split(str, "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// BaseIdent returns Ident without the @ prefix of a keyword, to compose other identifiers.
+func (e *Export) BaseIdent() string {
+	return strings.TrimPrefix(e.Ident, "@")
+}
+
+// asyncReturnType returns the C# return type of the Task-returning wrapper.
+func asyncReturnType(retType ReturnType) string {
+	if retType == ReturnTypeVoid {
+		return "Task"
+	}
+	return fmt.Sprintf("Task<%s>", retType.CSharp())
+}
+
+// AsyncInterfaceCSharp returns the declaration of the Task-returning wrapper in IGoApp.
+func (e *Export) AsyncInterfaceCSharp(indent string) (string, error) {
+	retType, params, _, err := e.signature()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s %s(%s);", indent, asyncReturnType(retType), e.AsyncIdent, strings.Join(params, ", ")), nil
+}
+
+// AsyncCSharp returns the Task-returning wrapper in Go, which calls the exported function on the event loop.
+func (e *Export) AsyncCSharp(indent string) (string, error) {
+	retType, params, args, err := e.signature()
+	if err != nil {
+		return "", err
+	}
+	inst := "this.inst"
+	if e.Static {
+		inst = "Inst"
+	}
+	call := fmt.Sprintf("%s.%s(%s)", inst, e.Ident, strings.Join(args, ", "))
+	if retType == ReturnTypeVoid {
+		call = fmt.Sprintf("{ %s; return (object)null; }", call)
+	}
+	str := fmt.Sprintf(`// %[1]s calls %[2]s on the event loop of the Go program, and returns a task completed when the function returns.
+public %[3]s %[1]s(%[4]s)
+{
+    return this.CallExportAsync(() => %[5]s);
+}`, e.AsyncIdent, e.Ident, asyncReturnType(retType), strings.Join(params, ", "), call)
 
 	lines := strings.Split(str, "\n")
 	for i := range lines {
@@ -210,19 +658,67 @@ func (e *Export) CSharp(indent string) (string, error) {
 	return strings.Join(lines, "\n"), nil
 }
 
+// DelegateType returns the C# delegate type to call the exported function.
+func (e *Export) DelegateType() string {
+	return delegateType(e.Funcs[e.Index].Wasm.Sig)
+}
+
+// LockedDelegate returns a C# lambda expression that calls the exported function while holding the lock of syncRoot.
+func (e *Export) LockedDelegate(syncRoot string) string {
+	sig := e.Funcs[e.Index].Wasm.Sig
+	var params []string
+	var args []string
+	for i, t := range sig.ParamTypes {
+		params = append(params, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).CSharp(), i))
+		args = append(args, fmt.Sprintf("arg%d", i))
+	}
+	var ret string
+	if len(sig.ReturnTypes) > 0 {
+		ret = "return "
+	}
+	return fmt.Sprintf("(%s)((%s) => { lock (%s) { %s%s.%s(%s); } })", e.DelegateType(), strings.Join(params, ", "), syncRoot, ret, e.self(), e.Ident, strings.Join(args, ", "))
+}
+
 type Global struct {
 	Type  wasm.ValueType
 	Index int
-	Init  int
+
+	// Init is the C# constant of the initial value, evaluated from the initializer expression.
+	Init   string
+	Static bool
 }
 
 func (g *Global) CSharp(indent string) string {
-	return fmt.Sprintf("%sprivate %s global%d = %d;", indent, wasmTypeToReturnType(g.Type).CSharp(), g.Index, g.Init)
+	var static string
+	if g.Static {
+		static = "static "
+	}
+	return fmt.Sprintf("%sprivate %s%s global%d = %s;", indent, static, wasmTypeToReturnType(g.Type).CSharp(), g.Index, g.Init)
+}
+
+// BinaryReaderMethod returns the name of BinaryReader's method to read the global's value.
+func (g *Global) BinaryReaderMethod() string {
+	switch g.Type {
+	case wasm.ValueTypeI32:
+		return "ReadInt32"
+	case wasm.ValueTypeI64:
+		return "ReadInt64"
+	case wasm.ValueTypeF32:
+		return "ReadSingle"
+	case wasm.ValueTypeF64:
+		return "ReadDouble"
+	default:
+		panic("not reached")
+	}
 }
 
 type Type struct {
 	Sig   *wasm.FunctionSig
 	Index int
+
+	// Static reports whether the functions are static, by -static. Otherwise, the delegate takes the instance as the
+	// first parameter, so that the tables of call_indirect can be shared by the instances.
+	Static bool
 }
 
 func (t *Type) CSharp(indent string) (string, error) {
@@ -237,6 +733,9 @@ func (t *Type) CSharp(indent string) (string, error) {
 	}
 
 	var args []string
+	if !t.Static {
+		args = append(args, "Inst self")
+	}
 	for i, t := range t.Sig.ParamTypes {
 		args = append(args, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).CSharp(), i))
 	}
@@ -249,6 +748,10 @@ type Data struct {
 	Data   []byte
 }
 
+func (d *Data) Base64() string {
+	return base64.StdEncoding.EncodeToString(d.Data)
+}
+
 func run() error {
 	tmp, err := ioutil.TempDir("", "go2dotnet-")
 	if err != nil {
@@ -256,164 +759,408 @@ func run() error {
 	}
 	defer os.RemoveAll(tmp)
 
-	f, err := os.Open(*flagWasm)
+	if *flagTemplates != "" {
+		if err := loadTemplates(*flagTemplates); err != nil {
+			return err
+		}
+	}
+
+	targetName := *flagTarget
+	switch *flagTgtProf {
+	case "":
+	case "unity":
+		// Unity supports .NET Standard 2.1 at most. IL2CPP compiles IL ahead of time, and the generated code doesn't
+		// rely on dynamic code generation like Reflection.Emit or the dynamic keyword.
+		if targetName == defaultTarget {
+			targetName = "netstandard2.1"
+		}
+		if targetName != "netstandard2.0" && targetName != "netstandard2.1" {
+			return fmt.Errorf("-target-profile=unity requires -target=netstandard2.0 or netstandard2.1")
+		}
+		if *flagSln || *flagPack || *flagEmit != "cs" {
+			return fmt.Errorf("-target-profile=unity cannot be used with -sln, -pack or -emit=dll")
+		}
+		// IL2CPP compiles every method into a C++ function, and a huge one makes the C++ compiler slow or fail, so the
+		// methods are bounded unless the flags are given.
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		if !set["max-method-lines"] {
+			*flagMaxLines = unityMaxMethodLines
+		}
+		if !set["max-method-ops"] {
+			*flagMaxOps = unityMaxMethodOps
+		}
+	case "blazor":
+		// Blazor WebAssembly runs on .NET 6 or later.
+		if targetName == defaultTarget {
+			targetName = "net6.0"
+		}
+		if targetName != "net6.0" && targetName != "net8.0" {
+			return fmt.Errorf("-target-profile=blazor requires -target=net6.0 or net8.0")
+		}
+	default:
+		return fmt.Errorf("unknown target profile %q", *flagTgtProf)
+	}
+
+	tgt, err := lookupTarget(targetName)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if tgt.Frameworks != nil {
+		// A Unity package has no target framework, and dotnet build cannot write multiple assemblies to one directory.
+		if *flagTgtProf != "" || *flagEmit != "cs" {
+			return fmt.Errorf("multiple target frameworks cannot be used with -target-profile or -emit=dll")
+		}
+	}
 
-	mod, err := wasm.DecodeModule(f)
+	style, err := newCodeStyle(*flagStyleNS, *flagStyleType, *flagStyleBr, *flagStyleInd)
 	if err != nil {
 		return err
 	}
-
-	var types []*Type
-	for i, e := range mod.Types.Entries {
-		e := e
-		types = append(types, &Type{
-			Sig:   &e,
-			Index: i,
-		})
+	// Unity supports C# 9 at most.
+	if style.FileScopedNamespace && *flagTgtProf == "unity" {
+		return fmt.Errorf("-style-namespace=file cannot be used with -target-profile=unity")
 	}
-
-	var ifs []*Func
-	for i, e := range mod.Import.Entries {
-		name := e.FieldName
-		ifs = append(ifs, &Func{
-			Type: types[e.Type.(wasm.FuncImport).Type],
-			Wasm: wasm.Function{
-				Sig:  types[e.Type.(wasm.FuncImport).Type].Sig,
-				Name: name,
-			},
-			Index:   i,
-			Import:  true,
-			BodyStr: importFuncBodies[name],
-		})
+	langVersion := defaultLangVersion
+	if style.FileScopedNamespace {
+		langVersion = "10.0"
 	}
-
-	// There is a bug that signature and body are shifted (go-interpreter/wagon#190).
-	var names wasm.NameMap
-	if c := mod.Custom(wasm.CustomSectionName); c != nil {
-		var nsec wasm.NameSection
-		if err := nsec.UnmarshalWASM(bytes.NewReader(c.Data)); err != nil {
+	if *flagCSharp != "" {
+		v, err := lookupCSharpVersion(*flagCSharp)
+		if err != nil {
 			return err
 		}
-		if len(nsec.Types[wasm.NameFunction]) > 0 {
-			sub, err := nsec.Decode(wasm.NameFunction)
-			if err != nil {
-				return err
-			}
-			names = sub.(*wasm.FunctionNames).Names
-		}
-	}
-	var fs []*Func
-	for i, t := range mod.Function.Types {
-		name := names[uint32(i+len(mod.Import.Entries))]
-		body := mod.Code.Bodies[i]
-		fs = append(fs, &Func{
-			Type: types[t],
-			Wasm: wasm.Function{
-				Sig:  types[t].Sig,
-				Body: &body,
-				Name: name,
-			},
-			Index: i + len(mod.Import.Entries),
-		})
+		if style.FileScopedNamespace && v < 100 {
+			return fmt.Errorf("-style-namespace=file requires -csharp-version=10.0 or later")
+		}
+		langVersion = *flagCSharp
 	}
+	csharp8 := langVersion != "7.3"
 
-	var exports []*Export
-	for _, e := range mod.Export.Entries {
-		switch e.Kind {
-		case wasm.ExternalFunction:
-			exports = append(exports, &Export{
-				Index: int(e.Index),
-				Name:  e.FieldStr,
-			})
-		case wasm.ExternalMemory:
-			// Ignore
-		default:
-			return fmt.Errorf("export type %d is not implemented", e.Kind)
-		}
-	}
-
-	allfs := append(ifs, fs...)
-	for _, e := range exports {
-		e.Funcs = allfs
-	}
-	for _, f := range ifs {
-		f.Mod = mod
-		f.Funcs = allfs
-		f.Types = types
-	}
-	for _, f := range fs {
-		f.Mod = mod
-		f.Funcs = allfs
-		f.Types = types
-	}
-
-	var globals []*Global
-	for i, e := range mod.Global.Globals {
-		// TODO: Consider mutability.
-		// TODO: Use e.Type.Init.
-		globals = append(globals, &Global{
-			Type:  e.Type.Type,
-			Index: i,
-			Init:  0,
-		})
+	var unsafeMemory bool
+	var pointerMemory bool
+	switch *flagMemory {
+	case "array":
+	case "unsafe":
+		if !tgt.Unsafe {
+			return fmt.Errorf("-memory=unsafe is not available for %s", tgt.Name)
+		}
+		unsafeMemory = true
+	default:
+		return fmt.Errorf("unknown -memory value %q", *flagMemory)
+	}
+	if *flagUnsafeMem {
+		if *flagMemory != "array" {
+			return fmt.Errorf("-unsafe-memory cannot be used with -memory=%s", *flagMemory)
+		}
+		if !tgt.Unsafe {
+			return fmt.Errorf("-unsafe-memory is not available for %s", tgt.Name)
+		}
+		pointerMemory = true
 	}
 
-	if mod.Start != nil {
-		return fmt.Errorf("start section must be nil but not")
+	switch *flagIndirect {
+	case "table", "switch", "cache":
+	default:
+		return fmt.Errorf("unknown -indirect value %q", *flagIndirect)
+	}
+	if *flagAggrInl < 0 {
+		return fmt.Errorf("-aggressive-inlining must not be negative")
+	}
+	if *flagAggrOpt < 0 {
+		return fmt.Errorf("-aggressive-optimization must not be negative")
+	}
+	if *flagAggrOpt > 0 && !tgt.AggressiveOptimization {
+		return fmt.Errorf("-aggressive-optimization is not available for %s", tgt.Name)
+	}
+	if *flagMaxLines < 0 {
+		return fmt.Errorf("-max-method-lines must not be negative")
+	}
+	if *flagMaxOps < 0 {
+		return fmt.Errorf("-max-method-ops must not be negative")
+	}
+	if *flagInline < 0 {
+		return fmt.Errorf("-inline must not be negative")
+	}
+	if *flagInline > 0 && *flagLine {
+		// The line table is by the offsets of the original instructions.
+		return fmt.Errorf("-inline cannot be used with -line")
+	}
+	if *flagWasmOpt != "" && *flagLine {
+		return fmt.Errorf("-wasm-opt cannot be used with -line")
 	}
 
-	tables := make([][]uint32, len(mod.Table.Entries))
-	for _, e := range mod.Elements.Entries {
-		v, err := mod.ExecInitExpr(e.Offset)
-		if err != nil {
-			return err
+	switch *flagData {
+	case "array", "base64":
+	case "span":
+		if !tgt.Span {
+			return fmt.Errorf("-data=span is not available for %s", tgt.Name)
+		}
+	case "resource":
+		if *flagOut == "" {
+			return fmt.Errorf("-data=resource requires -out")
 		}
-		offset := v.(int32)
-		if diff := int(offset) + int(len(e.Elems)) - int(len(tables[e.Index])); diff > 0 {
-			tables[e.Index] = append(tables[e.Index], make([]uint32, diff)...)
+		if *flagTgtProf == "unity" {
+			return fmt.Errorf("-data=resource cannot be used with -target-profile=unity")
 		}
-		copy(tables[e.Index][offset:], e.Elems)
+	default:
+		return fmt.Errorf("unknown -data value %q", *flagData)
+	}
+	dataResource := projectName(*flagWasm) + ".data.bin"
+	switch *flagCompress {
+	case "none", "gzip":
+	default:
+		return fmt.Errorf("unknown -compress value %q", *flagCompress)
 	}
 
-	var data []Data
-	for _, e := range mod.Data.Entries {
-		offset, err := mod.ExecInitExpr(e.Offset)
+	wasmBytes, err := ioutil.ReadFile(*flagWasm)
+	if err != nil {
+		return err
+	}
+	header, err := newFileHeader(*flagHeader, wasmBytes)
+	if err != nil {
+		return err
+	}
+	// The header and the profiles refer to the input file, not the optimized one.
+	if *flagWasmOpt != "" {
+		wasmBytes, err = runWasmOpt(wasmBytes, *flagWasmOpt)
 		if err != nil {
 			return err
 		}
-		data = append(data, Data{
-			Offset: int(offset.(int32)),
-			Data:   e.Data,
-		})
 	}
 
-	buf := bufio.NewWriterSize(os.Stdout, 1024 * 1024)
-	if err := csTmpl.Execute(buf, struct {
-		Namespace   string
-		ImportFuncs []*Func
-		Funcs       []*Func
-		Exports     []*Export
-		Globals     []*Global
-		Types       []*Type
-		Tables      [][]uint32
-		InitPageNum int
-		Data        []Data
-		JS          string
-	}{
-		Namespace:   *flagNamespace,
-		ImportFuncs: ifs,
-		Funcs:       fs,
-		Exports:     exports,
-		Globals:     globals,
-		Types:       types,
-		Tables:      tables,
-		InitPageNum: int(mod.Memory.Entries[0].Limits.Initial),
-		Data:        data,
-		JS:          js, // defined at js.go
+	mod, err := decodeModule(wasmBytes)
+	if err != nil {
+		return err
+	}
+	if !*flagTrusted {
+		if err := validateModule(mod); err != nil {
+			return fmt.Errorf("the wasm file is invalid (%v); -trusted skips the validation", err)
+		}
+	}
+
+	var lines *lineTable
+	if *flagLine {
+		lines, err = loadLineTable(wasmBytes)
+		if err != nil {
+			return err
+		}
+		if lines == nil {
+			return fmt.Errorf("-line requires DWARF debug info (.debug_info and .debug_line) in the wasm file, which Go's linker doesn't emit for wasm")
+		}
+	}
+
+	tr, err := newTranslation(mod, tgt, style, pointerMemory, lines, header.InputSHA256)
+	if err != nil {
+		return err
+	}
+	types := tr.Types
+	ifs := tr.Imports
+	fs := tr.Funcs
+	exports := tr.Exports
+	exported := tr.Exported
+	strs := tr.Strings
+	data := tr.Data
+
+	var compressed *Data
+	if *flagCompress != "none" {
+		c, err := compressData(data, *flagCompress)
+		if err != nil {
+			return err
+		}
+		compressed = &Data{Data: c}
+	}
+
+	// The Go main module is recorded in the build info in the data, or in go.mod of the source by -src.
+	module := moduleFromData(data)
+	if module == nil && *flagSrc != "" {
+		module = moduleFromSource(*flagSrc)
+	}
+
+	var wasiCode string
+	if tr.UsesWASI {
+		wasiCode = wasi // defined at wasi.go
+	}
+
+	var blazorCode string
+	if *flagTgtProf == "blazor" {
+		blazorCode = blazor // defined at blazor.go
+	}
+
+	var comCode string
+	if *flagCOM {
+		comCode, err = comFacade(*flagNamespace, exports)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *flagSln && *flagOut == "" {
+		return fmt.Errorf("-sln requires -out")
+	}
+	if *flagPack && *flagOut == "" {
+		return fmt.Errorf("-pack requires -out")
+	}
+	switch *flagEmit {
+	case "cs":
+	case "dll":
+		if *flagOut == "" {
+			return fmt.Errorf("-emit=dll requires -out")
+		}
+		if *flagSln || *flagPack {
+			return fmt.Errorf("-emit=dll cannot be used with -sln or -pack")
+		}
+	default:
+		return fmt.Errorf("unknown -emit value %q", *flagEmit)
+	}
+	if *flagAOT {
+		if !tgt.AOT {
+			return fmt.Errorf("-aot is not available for %s", tgt.Name)
+		}
+		if *flagTgtProf != "" {
+			return fmt.Errorf("-aot cannot be used with -target-profile")
+		}
+	}
+	switch *flagVisible {
+	case "public", "internal":
+	default:
+		return fmt.Errorf("unknown -visibility value %q", *flagVisible)
+	}
+	if *flagImports && *flagOut == "" {
+		return fmt.Errorf("-emit-imports requires -out")
+	}
+	if *flagGRPC {
+		if *flagOut == "" {
+			return fmt.Errorf("-grpc requires -out")
+		}
+		// ASP.NET Core gRPC runs on .NET 6 or later.
+		if tgt.Name != "net6.0" && tgt.Name != "net8.0" {
+			return fmt.Errorf("-grpc requires -target=net6.0 or net8.0")
+		}
+		if *flagTgtProf != "" || *flagEmit != "cs" {
+			return fmt.Errorf("-grpc cannot be used with -target-profile or -emit=dll")
+		}
+	}
+	if *flagCOM {
+		if !tgt.COMHosting && !tgt.COMInterop {
+			return fmt.Errorf("-com is not available for %s", tgt.Name)
+		}
+		if *flagTgtProf != "" || *flagAOT {
+			return fmt.Errorf("-com cannot be used with -target-profile or -aot")
+		}
+		// COM clients can access only public types.
+		if *flagVisible != "public" {
+			return fmt.Errorf("-com requires -visibility=public")
+		}
+		// The .NET SDK supports EnableComHosting and RegisterForComInterop only on Windows (NETSDK1091).
+		if *flagEmit == "dll" && runtime.GOOS != "windows" {
+			return fmt.Errorf("-com with -emit=dll requires Windows, as the COM registration can be built only on Windows")
+		}
+	}
+	if *flagBench != "" || *flagTests != "" {
+		if *flagOut == "" {
+			return fmt.Errorf("-emit-bench and -emit-tests require -out")
+		}
+		if *flagTgtProf != "" || *flagEmit != "cs" {
+			return fmt.Errorf("-emit-bench and -emit-tests cannot be used with -target-profile or -emit=dll")
+		}
+		// The subprojects are other assemblies.
+		if *flagVisible != "public" {
+			return fmt.Errorf("-emit-bench and -emit-tests require -visibility=public")
+		}
+	}
+	if *flagOuter != "" {
+		if !identifierRe.MatchString(*flagOuter) {
+			return fmt.Errorf("-outer must be a C# identifier but %q", *flagOuter)
+		}
+		// Extension methods must be in a non-nested static class.
+		if *flagTgtProf == "blazor" {
+			return fmt.Errorf("-outer cannot be used with -target-profile=blazor")
+		}
+	}
+	if *flagRuntime && *flagOut == "" {
+		return fmt.Errorf("-runtime-files requires -out")
+	}
+	if *flagSplit < 0 {
+		return fmt.Errorf("-split must not be negative")
+	}
+	if *flagSplit > 0 && *flagOut == "" {
+		return fmt.Errorf("-split requires -out")
+	}
+	if *flagParallel < 1 {
+		return fmt.Errorf("-p must be positive")
+	}
+	var cache *buildCache
+	if *flagCache {
+		cache, err = newBuildCache(header)
+		if err != nil {
+			return err
+		}
+	}
+	if err := generateFuncs(fs, *flagParallel, cache); err != nil {
+		return err
+	}
+	if *flagVerbose {
+		reportSplitFuncs(os.Stderr, fs)
+	}
+
+	// With -split, the functions are written to separate files as partial classes.
+	instFuncs := fs
+	var parts [][]*Func
+	if *flagSplit > 0 {
+		instFuncs = nil
+		for i := 0; i < len(fs); i += *flagSplit {
+			end := i + *flagSplit
+			if end > len(fs) {
+				end = len(fs)
+			}
+			parts = append(parts, fs[i:end])
+		}
+	}
+
+	var code bytes.Buffer
+	buf := bufio.NewWriterSize(&code, 1024 * 1024)
+	if err := csTmpl.Execute(buf, &codeData{
+		Header:         header,
+		Namespace:      *flagNamespace,
+		ImportFuncs:    ifs,
+		Funcs:          fs,
+		InstFuncs:      instFuncs,
+		Exports:        exports,
+		Strings:        strs,
+		Globals:        tr.Globals,
+		Types:          types,
+		Tables:         tr.Tables,
+		Indirect:       tr.Indirect,
+		IndirectSwitch: *flagIndirect == "switch",
+		IndirectCache:  *flagIndirect == "cache",
+		ProfileFuncs:   tr.ProfileFuncs,
+		InitPageNum:    tr.InitPageNum,
+		MaxPageNum:     tr.MaxPageNum,
+		Data:           data,
+		Malloc:         tr.Malloc,
+		Exported:       exported,
+		Target:         tgt,
+		UnsafeMem:      unsafeMemory,
+		PointerMem:     pointerMemory,
+		DataMode:       *flagData,
+		DataResource:   dataResource,
+		Compressed:     compressed,
+		AOT:            *flagAOT,
+		COM:            comCode,
+		Static:         *flagStatic,
+		Runtime:        runtimeTypes, // defined at runtime.go
+		RuntimeFiles:   *flagRuntime,
+		JS:             js,         // defined at js.go
+		FS:             fileSystem, // defined at fs.go
+		Clock:          clock,      // defined at clock.go
+		Promise:        promise,    // defined at promise.go
+		WASI:           wasiCode,
+		Blazor:         blazorCode,
+		Module:         module,
+		CSharp8:        csharp8,
 	}); err != nil {
 		return err
 	}
@@ -422,36 +1169,408 @@ func run() error {
 		return err
 	}
 
+	var partCodes [][]byte
+	for _, part := range parts {
+		var code bytes.Buffer
+		if err := csPartialTmpl.Execute(&code, &partialData{
+			Header:    header,
+			Namespace: *flagNamespace,
+			CSharp8:   csharp8,
+			Funcs:     part,
+		}); err != nil {
+			return err
+		}
+		partCodes = append(partCodes, code.Bytes())
+	}
+
+	var proto []byte
+	var grpcCode []byte
+	if *flagGRPC {
+		p, service, err := grpcFiles(header, *flagNamespace, exports, exported["malloc"])
+		if err != nil {
+			return err
+		}
+		var code bytes.Buffer
+		if err := csRuntimeTmpl.Execute(&code, &runtimeData{
+			Header:    header,
+			Namespace: *flagNamespace,
+			CSharp8:   csharp8,
+			Code:      service,
+		}); err != nil {
+			return err
+		}
+		proto = p
+		grpcCode = code.Bytes()
+	}
+
+	runtimeCodes := map[string][]byte{}
+	if *flagRuntime {
+		for name, c := range runtimeFiles(wasiCode, blazorCode) {
+			var code bytes.Buffer
+			if err := csRuntimeTmpl.Execute(&code, &runtimeData{
+				Header:    header,
+				Namespace: *flagNamespace,
+				CSharp8:   csharp8,
+				Code:      c,
+			}); err != nil {
+				return err
+			}
+			runtimeCodes[name] = code.Bytes()
+		}
+	}
+
+	codeBytes := code.Bytes()
+	if *flagVisible == "internal" {
+		codeBytes = makeInternal(codeBytes)
+		for i := range partCodes {
+			partCodes[i] = makeInternal(partCodes[i])
+		}
+		for name, c := range runtimeCodes {
+			runtimeCodes[name] = makeInternal(c)
+		}
+		if grpcCode != nil {
+			grpcCode = makeInternal(grpcCode)
+		}
+	}
+	if *flagOuter != "" {
+		codeBytes = nestInClass(codeBytes, *flagOuter, *flagVisible)
+		for i := range partCodes {
+			partCodes[i] = nestInClass(partCodes[i], *flagOuter, *flagVisible)
+		}
+		for name, c := range runtimeCodes {
+			runtimeCodes[name] = nestInClass(c, *flagOuter, *flagVisible)
+		}
+		if grpcCode != nil {
+			grpcCode = nestInClass(grpcCode, *flagOuter, *flagVisible)
+		}
+	}
+	codeBytes = style.apply(codeBytes)
+	for i := range partCodes {
+		partCodes[i] = style.apply(partCodes[i])
+	}
+	for name, c := range runtimeCodes {
+		runtimeCodes[name] = style.apply(c)
+	}
+	if grpcCode != nil {
+		grpcCode = style.apply(grpcCode)
+	}
+
+	if *flagOut == "" {
+		if _, err := os.Stdout.Write(codeBytes); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	p := &project{
+		Name:            projectName(*flagWasm),
+		Namespace:       *flagNamespace,
+		TargetFramework: tgt.Name,
+		MultiTarget:     tgt.Frameworks != nil,
+		LangVersion:     langVersion,
+		Unity:           *flagTgtProf == "unity",
+		AOT:             *flagAOT,
+		Trimmable:       tgt.Trimming && !*flagAOT && !*flagCOM,
+		COMHosting:      *flagCOM && tgt.COMHosting,
+		COMInterop:      *flagCOM && tgt.COMInterop,
+		EmbedSources:    *flagEmit == "dll" && !*flagLine,
+		Module:          module,
+		RuntimeFiles:    runtimeCodes,
+		Proto:           proto,
+		GRPCService:     grpcCode,
+	}
+	// -unsafe-memory uses pointers.
+	p.AllowUnsafeBlocks = pointerMemory
+	if *flagData == "resource" {
+		var b []byte
+		if compressed != nil {
+			b = compressed.Data
+		} else {
+			for _, d := range data {
+				b = append(b, d.Data...)
+			}
+		}
+		p.Resources = map[string][]byte{
+			dataResource: b,
+		}
+	}
+	if *flagTgtProf == "blazor" {
+		p.PackageReferences = map[string]string{
+			"Microsoft.Extensions.DependencyInjection.Abstractions": blazorDependencyInjectionVersion,
+		}
+	}
+	var subprojects []*subproject
+	var typePrefix string
+	if *flagOuter != "" {
+		typePrefix = *flagOuter + "."
+	}
+	if *flagBench != "" {
+		calls, err := selectExports(exports, *flagBench, "-emit-bench")
+		if err != nil {
+			return err
+		}
+		// Wasmtime provides WASI, but not the imports of wasm_exec.js.
+		wasmName := filepath.Base(*flagWasm)
+		for _, f := range ifs {
+			if f.ModuleName != wasiModuleName {
+				wasmName = ""
+			}
+		}
+		c, err := benchCode(typePrefix, calls, wasmName)
+		if err != nil {
+			return err
+		}
+		s := &subproject{
+			Name:            p.Name + ".Benchmarks",
+			Project:         p.Name,
+			TargetFramework: runnableTargetFramework(tgt),
+			Exe:             true,
+			PackageReferences: map[string]string{
+				"BenchmarkDotNet": benchmarkDotNetVersion,
+			},
+			Files: map[string][]byte{},
+		}
+		if wasmName != "" {
+			s.PackageReferences["Wasmtime"] = wasmtimeVersion
+			s.Files[wasmName] = wasmBytes
+		}
+		s.Files["Benchmarks.cs"], err = subprojectCode(header, *flagNamespace+".Benchmarks", c, style)
+		if err != nil {
+			return err
+		}
+		subprojects = append(subprojects, s)
+	}
+	if *flagTests != "" {
+		calls, err := selectExports(exports, *flagTests, "-emit-tests")
+		if err != nil {
+			return err
+		}
+		c, err := testsCode(typePrefix, calls)
+		if err != nil {
+			return err
+		}
+		s := &subproject{
+			Name:              p.Name + ".Tests",
+			Project:           p.Name,
+			TargetFramework:   runnableTargetFramework(tgt),
+			Test:              true,
+			PackageReferences: testPackageVersions,
+			Files:             map[string][]byte{},
+		}
+		s.Files["GoTests.cs"], err = subprojectCode(header, *flagNamespace+".Tests", c, style)
+		if err != nil {
+			return err
+		}
+		subprojects = append(subprojects, s)
+	}
+	// Exclude the subprojects from the project, including the ones of previous runs.
+	for _, suffix := range subprojectSuffixes {
+		name := p.Name + suffix
+		_, err := os.Stat(filepath.Join(*flagOut, name))
+		written := err == nil && *flagOut != ""
+		for _, s := range subprojects {
+			if s.Name == name {
+				written = true
+			}
+		}
+		if written {
+			p.Subprojects = append(p.Subprojects, name)
+		}
+	}
+	if *flagGRPC {
+		p.PackageReferences = map[string]string{
+			"Grpc.AspNetCore": grpcPackageVersion,
+		}
+	}
+	if *flagPack {
+		p.Package = true
+		p.PackageID = *flagPackageID
+		if p.PackageID == "" {
+			p.PackageID = p.Name
+		}
+		p.Version = *flagVersion
+		if p.Version == "" && module != nil {
+			p.Version = module.NuGetVersion()
+		}
+		if p.Version == "" {
+			p.Version = defaultPackageVersion
+		}
+		p.License = *flagLicense
+	}
+	if *flagImports {
+		if err := os.MkdirAll(*flagOut, 0755); err != nil {
+			return err
+		}
+		if err := newImportsManifest(filepath.Base(*flagWasm), ifs).write(*flagOut, p.Name); err != nil {
+			return err
+		}
+	}
+	if *flagEmit == "dll" {
+		if err := p.write(tmp, codeBytes, partCodes, false); err != nil {
+			return err
+		}
+		if err := p.buildDLL(tmp, *flagOut); err != nil {
+			return err
+		}
+		return nil
+	}
+	if err := p.write(*flagOut, codeBytes, partCodes, *flagSln); err != nil {
+		return err
+	}
+	for _, s := range subprojects {
+		if err := s.write(*flagOut); err != nil {
+			return err
+		}
+	}
+	if *flagPack {
+		if err := p.pack(*flagOut); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-var csTmpl = template.Must(template.New("out.cs").Parse(`// Code generated by go2dotnet. DO NOT EDIT.
+var csTmpl = template.Must(template.New("out.cs").Parse(`{{define "header"}}{{with .Header}}{{range .License}}{{.}}
+{{end}}{{if .License}}
+{{end}}{{end}}// Code generated by go2dotnet. DO NOT EDIT.
+{{- with .Header}}
+//
+// go2dotnet version: {{.Version}}
+// Input SHA-256:     {{.InputSHA256}}
+// Command line:      {{.CommandLine}}
+{{- end}}
+{{- if .CSharp8}}
 
-#pragma warning disable 162 // unreachable code
-#pragma warning disable 164 // label
-#pragma warning disable 219 // unused local variables
+#nullable disable
+{{- end}}
 
 using System;
+using System.Collections.Concurrent;
 using System.Collections.Generic;
 using System.Diagnostics;
+using System.Dynamic;
+using System.Globalization;
+using System.IO;
 using System.Linq;
+using System.Reflection;
 using System.Runtime.CompilerServices;
+using System.Runtime.InteropServices;
 using System.Security.Cryptography;
 using System.Text;
 using System.Threading.Tasks;
 using System.Timers;
 
+using CancellationToken = System.Threading.CancellationToken;
+{{end}}{{template "header" .}}
+{{- with .Module}}
+[assembly: AssemblyMetadata("GoModulePath", {{printf "%q" .Path}})]
+{{- if .Version}}
+[assembly: AssemblyMetadata("GoModuleVersion", {{printf "%q" .Version}})]
+{{- end}}
+{{- if .Revision}}
+[assembly: AssemblyMetadata("GoVCSRevision", {{printf "%q" .Revision}})]
+{{- end}}
+{{end}}
 namespace {{.Namespace}}
 {
-    sealed class Mem
+    sealed {{if .PointerMem}}unsafe {{end}}class Mem
     {
-        const int PageSize = 64 * 1024;
+        internal const int PageSize = 64 * 1024;
 
-        public Mem()
+        // maxPages is the maximum number of pages the memory can grow to.
+        public Mem(int maxPages)
         {
+{{- if or .UnsafeMem .PointerMem}}
+            // The memory is accessed as native integers, while wasm is little endian.
+            if (!BitConverter.IsLittleEndian)
+            {
+                throw new PlatformNotSupportedException("the memory generated with {{if .PointerMem}}-unsafe-memory{{else}}-memory=unsafe{{end}} requires a little-endian machine");
+            }
+{{- end}}
+            this.maxPages = Math.Min(maxPages, {{.MaxPageNum}});
             this.bytes = new byte[{{.InitPageNum}} * PageSize];
-{{range $value := .Data}}            Array.Copy(new byte[] { {{- range $value2 := $value.Data}}{{$value2}},{{end}}}, 0, this.bytes, {{$value.Offset}}, {{len $value.Data}});
-{{end}}        }
+{{- if .PointerMem}}
+            this.Pin();
+{{- end}}
+{{- if .DataImage}}
+            var data = Mem.image.Value;
+            Buffer.BlockCopy(data, 0, this.bytes, 0, data.Length);
+{{- else}}
+{{- range $i, $value := .Data}}
+            data{{$i}}.CopyTo(this.bytes.AsSpan({{$value.Offset}}));
+{{- end}}
+{{- end}}
+        }
+{{- if .DataImage}}
+
+        // image is the initial content of the memory up to the end of the data segments. This is decoded once when
+        // the first memory is created, and copied to each memory.
+        private static readonly Lazy<byte[]> image = new Lazy<byte[]>(LoadImage);
+
+        private static byte[] LoadImage()
+        {
+            var bytes = new byte[{{.DataEnd}}];
+{{- if .Compressed}}
+{{- if eq .DataMode "resource"}}
+            using (var compressed = typeof(Mem).Assembly.GetManifestResourceStream("{{.DataResource}}"))
+{{- else if eq .DataMode "base64"}}
+            using (var compressed = new MemoryStream(Convert.FromBase64String("{{.Compressed.Base64}}")))
+{{- else if eq .DataMode "span"}}
+            using (var compressed = new MemoryStream(compressedData.ToArray()))
+{{- else}}
+            using (var compressed = new MemoryStream(new byte[] { {{- range $value := .Compressed.Data}}{{$value}},{{end}}}))
+{{- end}}
+            using (var stream = new System.IO.Compression.GZipStream(compressed, System.IO.Compression.CompressionMode.Decompress))
+            {
+{{- range $value := .Data}}
+                ReadFully(stream, bytes, {{$value.Offset}}, {{len $value.Data}});
+{{- end}}
+            }
+{{- else if eq .DataMode "base64"}}
+{{- range $value := .Data}}
+            Array.Copy(Convert.FromBase64String("{{$value.Base64}}"), 0, bytes, {{$value.Offset}}, {{len $value.Data}});
+{{- end}}
+{{- else if eq .DataMode "resource"}}
+            using (var stream = typeof(Mem).Assembly.GetManifestResourceStream("{{.DataResource}}"))
+            {
+{{- range $value := .Data}}
+                ReadFully(stream, bytes, {{$value.Offset}}, {{len $value.Data}});
+{{- end}}
+            }
+{{- else}}
+{{- range $value := .Data}}
+            Array.Copy(new byte[] { {{- range $value2 := $value.Data}}{{$value2}},{{end}}}, 0, bytes, {{$value.Offset}}, {{len $value.Data}});
+{{- end}}
+{{- end}}
+            return bytes;
+        }
+{{- end}}
+{{- if and (eq .DataMode "span") .Compressed}}
+
+        private static ReadOnlySpan<byte> compressedData => new byte[] { {{- range $value := .Compressed.Data}}{{$value}},{{end}}};
+{{- else if eq .DataMode "span"}}
+{{range $i, $value := .Data}}
+        // The C# compiler embeds the array of a ReadOnlySpan<byte> property as static data without allocations.
+        private static ReadOnlySpan<byte> data{{$i}} => new byte[] { {{- range $value2 := $value.Data}}{{$value2}},{{end}}};
+{{end}}
+{{- end}}
+{{- if or (eq .DataMode "resource") .Compressed}}
+
+        private static void ReadFully(Stream stream, byte[] buffer, int offset, int count)
+        {
+            while (count > 0)
+            {
+                int n = stream.Read(buffer, offset, count);
+                if (n == 0)
+                {
+                    throw new EndOfStreamException("the embedded data is too short");
+                }
+                offset += n;
+                count -= n;
+            }
+        }
+{{- end}}
 
         internal int Size
         {
@@ -461,120 +1580,390 @@ namespace {{.Namespace}}
             }
         }
 
+        internal int Pages
+        {
+            get
+            {
+                return this.Size / PageSize;
+            }
+        }
+
+        // Grow grows the memory by delta pages, and returns the previous number of pages.
+        // This returns -1 when the memory cannot grow, so that the Go runtime reports out of memory.
         internal int Grow(int delta)
         {
-            var prevSize = this.Size;
-            var newSize = prevSize + delta;
-            if (newSize % PageSize != 0)
+            var prevPages = this.Pages;
+            if (delta < 0 || (long)prevPages + delta > this.maxPages)
+            {
+                return -1;
+            }
+            try
+            {
+                Array.Resize(ref this.bytes, (prevPages + delta) * PageSize);
+            }
+            catch (OutOfMemoryException)
+            {
+                return -1;
+            }
+            this.Generation++;
+{{- if .PointerMem}}
+            this.Pin();
+{{- end}}
+            return prevPages;
+        }
+{{- if .PointerMem}}
+
+        // Pin pins the bytes to access them by the pointer. This must be called whenever the bytes are replaced.
+        private void Pin()
+        {
+            if (this.handle.IsAllocated)
+            {
+                this.handle.Free();
+            }
+            this.handle = GCHandle.Alloc(this.bytes, GCHandleType.Pinned);
+            this.ptr = (byte*)this.handle.AddrOfPinnedObject();
+        }
+
+        ~Mem()
+        {
+            if (this.handle.IsAllocated)
+            {
+                this.handle.Free();
+            }
+        }
+
+        // Require throws an exception if the memory is smaller than size bytes.
+        // A function that accesses the memory at constant addresses calls this once, as the memory never shrinks while it runs.
+        internal void Require(long size)
+        {
+            if (this.bytes.Length < size)
             {
-                newSize += PageSize - newSize % PageSize;
+                throw new IndexOutOfRangeException();
             }
-            Array.Resize(ref this.bytes, newSize);
-            return prevSize;
         }
 
+        // The accessors don't check the bounds. An access out of the memory is undefined behavior.
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal sbyte LoadInt8(int addr)
         {
-            return (sbyte)this.bytes[addr];
+            return *(sbyte*)(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal byte LoadUint8(int addr)
         {
-            return this.bytes[addr];
+            return this.ptr[(uint)addr];
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal short LoadInt16(int addr)
         {
-            return (short)((ushort)this.bytes[addr] | (ushort)(this.bytes[addr+1]) << 8);
+            return Unsafe.ReadUnaligned<short>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal ushort LoadUint16(int addr)
         {
-            return (ushort)((ushort)this.bytes[addr] | (ushort)(this.bytes[addr+1]) << 8);
+            return Unsafe.ReadUnaligned<ushort>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal int LoadInt32(int addr)
         {
-            return (int)((uint)this.bytes[addr] |
-                (uint)(this.bytes[addr+1]) << 8 |
-                (uint)(this.bytes[addr+2]) << 16 |
-                (uint)(this.bytes[addr+3]) << 24);
+            return Unsafe.ReadUnaligned<int>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal uint LoadUint32(int addr)
         {
-            return (uint)((uint)this.bytes[addr] |
-                (uint)(this.bytes[addr+1]) << 8 |
-                (uint)(this.bytes[addr+2]) << 16 |
-                (uint)(this.bytes[addr+3]) << 24);
+            return Unsafe.ReadUnaligned<uint>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal long LoadInt64(int addr)
         {
-            return (long)((ulong)this.bytes[addr] |
-                (ulong)(this.bytes[addr+1]) << 8 |
-                (ulong)(this.bytes[addr+2]) << 16 |
-                (ulong)(this.bytes[addr+3]) << 24 |
-                (ulong)(this.bytes[addr+4]) << 32 |
-                (ulong)(this.bytes[addr+5]) << 40 |
-                (ulong)(this.bytes[addr+6]) << 48 |
-                (ulong)(this.bytes[addr+7]) << 56);
+            return Unsafe.ReadUnaligned<long>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal float LoadFloat32(int addr)
         {
-            int bits = LoadInt32(addr);
-            return Unsafe.As<int, float>(ref bits);
+            return Unsafe.ReadUnaligned<float>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal double LoadFloat64(int addr)
         {
-            long bits = LoadInt64(addr);
-            return Unsafe.As<long, double>(ref bits);
+            return Unsafe.ReadUnaligned<double>(this.ptr + (uint)addr);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal void StoreInt8(int addr, sbyte val)
         {
-            this.bytes[addr] = (byte)val;
+            *(sbyte*)(this.ptr + (uint)addr) = val;
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal void StoreInt16(int addr, short val)
         {
-            this.bytes[addr] = (byte)val;
-            this.bytes[addr+1] = (byte)(val >> 8);
+            Unsafe.WriteUnaligned<short>(this.ptr + (uint)addr, val);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal void StoreInt32(int addr, int val)
         {
-            this.bytes[addr] = (byte)val;
-            this.bytes[addr+1] = (byte)(val >> 8);
-            this.bytes[addr+2] = (byte)(val >> 16);
-            this.bytes[addr+3] = (byte)(val >> 24);
+            Unsafe.WriteUnaligned<int>(this.ptr + (uint)addr, val);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal void StoreInt64(int addr, long val)
         {
-            this.bytes[addr] = (byte)val;
-            this.bytes[addr+1] = (byte)(val >> 8);
-            this.bytes[addr+2] = (byte)(val >> 16);
-            this.bytes[addr+3] = (byte)(val >> 24);
-            this.bytes[addr+4] = (byte)(val >> 32);
+            Unsafe.WriteUnaligned<long>(this.ptr + (uint)addr, val);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreFloat32(int addr, float val)
+        {
+            Unsafe.WriteUnaligned<float>(this.ptr + (uint)addr, val);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreFloat64(int addr, double val)
+        {
+            Unsafe.WriteUnaligned<double>(this.ptr + (uint)addr, val);
+        }
+
+{{- else}}
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal sbyte LoadInt8(int addr)
+        {
+            return (sbyte)this.bytes[addr];
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal byte LoadUint8(int addr)
+        {
+            return this.bytes[addr];
+        }
+
+{{if .UnsafeMem}}        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal short LoadInt16(int addr)
+        {
+            this.CheckRange(addr, 2);
+            return Unsafe.ReadUnaligned<short>(ref this.bytes[addr]);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal ushort LoadUint16(int addr)
+        {
+            this.CheckRange(addr, 2);
+            return Unsafe.ReadUnaligned<ushort>(ref this.bytes[addr]);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal int LoadInt32(int addr)
+        {
+            this.CheckRange(addr, 4);
+            return Unsafe.ReadUnaligned<int>(ref this.bytes[addr]);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal uint LoadUint32(int addr)
+        {
+            this.CheckRange(addr, 4);
+            return Unsafe.ReadUnaligned<uint>(ref this.bytes[addr]);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal long LoadInt64(int addr)
+        {
+            this.CheckRange(addr, 8);
+            return Unsafe.ReadUnaligned<long>(ref this.bytes[addr]);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal float LoadFloat32(int addr)
+        {
+            this.CheckRange(addr, 4);
+            return Unsafe.ReadUnaligned<float>(ref this.bytes[addr]);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal double LoadFloat64(int addr)
+        {
+            this.CheckRange(addr, 8);
+            return Unsafe.ReadUnaligned<double>(ref this.bytes[addr]);
+        }
+
+{{else}}        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal short LoadInt16(int addr)
+        {
+            return (short)((ushort)this.bytes[addr] | (ushort)(this.bytes[addr+1]) << 8);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal ushort LoadUint16(int addr)
+        {
+            return (ushort)((ushort)this.bytes[addr] | (ushort)(this.bytes[addr+1]) << 8);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal int LoadInt32(int addr)
+        {
+            return (int)((uint)this.bytes[addr] |
+                (uint)(this.bytes[addr+1]) << 8 |
+                (uint)(this.bytes[addr+2]) << 16 |
+                (uint)(this.bytes[addr+3]) << 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal uint LoadUint32(int addr)
+        {
+            return (uint)((uint)this.bytes[addr] |
+                (uint)(this.bytes[addr+1]) << 8 |
+                (uint)(this.bytes[addr+2]) << 16 |
+                (uint)(this.bytes[addr+3]) << 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal long LoadInt64(int addr)
+        {
+            return (long)((ulong)this.bytes[addr] |
+                (ulong)(this.bytes[addr+1]) << 8 |
+                (ulong)(this.bytes[addr+2]) << 16 |
+                (ulong)(this.bytes[addr+3]) << 24 |
+                (ulong)(this.bytes[addr+4]) << 32 |
+                (ulong)(this.bytes[addr+5]) << 40 |
+                (ulong)(this.bytes[addr+6]) << 48 |
+                (ulong)(this.bytes[addr+7]) << 56);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal float LoadFloat32(int addr)
+        {
+            int bits = LoadInt32(addr);
+{{- if .Target.Unsafe}}
+            return Unsafe.As<int, float>(ref bits);
+{{- else}}
+            return Bits.Int32BitsToSingle(bits);
+{{- end}}
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal double LoadFloat64(int addr)
+        {
+            long bits = LoadInt64(addr);
+{{- if .Target.Unsafe}}
+            return Unsafe.As<long, double>(ref bits);
+{{- else}}
+            return BitConverter.Int64BitsToDouble(bits);
+{{- end}}
+        }
+
+{{end}}        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt8(int addr, sbyte val)
+        {
+            this.bytes[addr] = (byte)val;
+        }
+
+{{if .UnsafeMem}}        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt16(int addr, short val)
+        {
+            this.CheckRange(addr, 2);
+            Unsafe.WriteUnaligned<short>(ref this.bytes[addr], val);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt32(int addr, int val)
+        {
+            this.CheckRange(addr, 4);
+            Unsafe.WriteUnaligned<int>(ref this.bytes[addr], val);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt64(int addr, long val)
+        {
+            this.CheckRange(addr, 8);
+            Unsafe.WriteUnaligned<long>(ref this.bytes[addr], val);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreFloat32(int addr, float val)
+        {
+            this.CheckRange(addr, 4);
+            Unsafe.WriteUnaligned<float>(ref this.bytes[addr], val);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreFloat64(int addr, double val)
+        {
+            this.CheckRange(addr, 8);
+            Unsafe.WriteUnaligned<double>(ref this.bytes[addr], val);
+        }
+
+        // CheckRange throws an exception if [addr, addr+size) is out of the memory.
+        // Unsafe.ReadUnaligned and WriteUnaligned check only the first byte's index.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        private void CheckRange(int addr, int size)
+        {
+            if ((uint)addr > (uint)(this.bytes.Length - size))
+            {
+                throw new IndexOutOfRangeException();
+            }
+        }
+
+{{else}}        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt16(int addr, short val)
+        {
+            this.bytes[addr] = (byte)val;
+            this.bytes[addr+1] = (byte)(val >> 8);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt32(int addr, int val)
+        {
+            this.bytes[addr] = (byte)val;
+            this.bytes[addr+1] = (byte)(val >> 8);
+            this.bytes[addr+2] = (byte)(val >> 16);
+            this.bytes[addr+3] = (byte)(val >> 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt64(int addr, long val)
+        {
+            this.bytes[addr] = (byte)val;
+            this.bytes[addr+1] = (byte)(val >> 8);
+            this.bytes[addr+2] = (byte)(val >> 16);
+            this.bytes[addr+3] = (byte)(val >> 24);
+            this.bytes[addr+4] = (byte)(val >> 32);
             this.bytes[addr+5] = (byte)(val >> 40);
             this.bytes[addr+6] = (byte)(val >> 48);
             this.bytes[addr+7] = (byte)(val >> 56);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal void StoreFloat32(int addr, float val)
         {
+{{- if .Target.Unsafe}}
             this.StoreInt32(addr, Unsafe.As<float, int>(ref val));
+{{- else}}
+            this.StoreInt32(addr, Bits.SingleToInt32Bits(val));
+{{- end}}
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         internal void StoreFloat64(int addr, double val)
         {
+{{- if .Target.Unsafe}}
             this.StoreInt64(addr, Unsafe.As<double, long>(ref val));
+{{- else}}
+            this.StoreInt64(addr, BitConverter.DoubleToInt64Bits(val));
+{{- end}}
         }
 
-        internal void StoreBytes(int addr, byte[] bytes)
+{{end}}{{end}}        internal void StoreBytes(int addr, byte[] bytes)
         {
             for (int i = 0; i < bytes.Length; i++)
             {
@@ -582,6 +1971,56 @@ namespace {{.Namespace}}
             }
         }
 
+        // Fill sets n bytes at addr to val, like memory.fill.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void Fill(int addr, byte val, int n)
+        {
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+{{- if or .Target.Span .Target.SpanCondition}}
+            this.bytes.AsSpan(addr, n).Fill(val);
+{{- end}}
+{{- if .Target.SpanCondition}}
+#else
+{{- end}}
+{{- if not .Target.Span}}
+            if (val == 0)
+            {
+                Array.Clear(this.bytes, addr, n);
+                return;
+            }
+            for (int i = 0; i < n; i++)
+            {
+                this.bytes[addr+i] = val;
+            }
+{{- end}}
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+        }
+
+        // Copy copies n bytes from src to dst, like memory.copy. The ranges can overlap.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void Copy(int dst, int src, int n)
+        {
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+{{- if or .Target.Span .Target.SpanCondition}}
+            this.bytes.AsSpan(src, n).CopyTo(this.bytes.AsSpan(dst, n));
+{{- end}}
+{{- if .Target.SpanCondition}}
+#else
+{{- end}}
+{{- if not .Target.Span}}
+            Buffer.BlockCopy(this.bytes, src, this.bytes, dst, n);
+{{- end}}
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+        }
+
         internal ArraySegment<byte> LoadSlice(int addr)
         {
             var array = this.LoadInt64(addr);
@@ -598,21 +2037,108 @@ namespace {{.Namespace}}
         {
             var saddr = this.LoadInt64(addr);
             var len = this.LoadInt64(addr + 8);
-            return Encoding.UTF8.GetString(this.bytes, (int)saddr, (int)len);
+            return this.LoadStringDirectly(saddr, (int)len);
+        }
+
+        internal string LoadStringDirectly(long addr, int len)
+        {
+            return Encoding.UTF8.GetString(this.bytes, (int)addr, len);
+        }
+
+        internal int StoreString(int addr, string str)
+        {
+            var bytes = Encoding.UTF8.GetBytes(str);
+            this.StoreBytes(addr, bytes);
+            return bytes.Length;
+        }
+
+        internal void Save(BinaryWriter writer)
+        {
+            writer.Write(this.Pages);
+            writer.Write(this.bytes);
+        }
+
+        // ReadSnapshot reads the memory written by Save. The memory is not modified until Reset is called.
+        internal byte[] ReadSnapshot(BinaryReader reader)
+        {
+            int pages = reader.ReadInt32();
+            if (pages < 0 || pages > this.maxPages)
+            {
+                throw new InvalidDataException($"the snapshot has {pages} pages but the memory can have at most {this.maxPages} pages");
+            }
+            var bytes = reader.ReadBytes(pages * PageSize);
+            if (bytes.Length != pages * PageSize)
+            {
+                throw new EndOfStreamException();
+            }
+            return bytes;
+        }
+
+        internal void Reset(byte[] bytes)
+        {
+            this.bytes = bytes;
+            this.Generation++;
+{{- if .PointerMem}}
+            this.Pin();
+{{- end}}
         }
 
+        // Generation is incremented whenever the bytes are replaced, which invalidates the views of the memory.
+        internal int Generation { get; private set; }
+
         private byte[] bytes;
+        private int maxPages;
+{{- if .PointerMem}}
+        private GCHandle handle;
+        private byte* ptr;
+{{- end}}
     }
 
+{{- if not .RuntimeFiles}}
+
+{{.Runtime}}
+{{- end}}
+
     internal interface IImport
     {
 {{- range $value := .ImportFuncs}}
 {{$value.CSharp "        " false false}}{{end}}
     }
+{{- if not .RuntimeFiles}}
 
 {{.JS}}
 
-    public class Go
+{{.FS}}
+
+{{.Clock}}
+
+{{.Promise}}
+{{if .WASI}}
+{{.WASI}}
+{{end}}{{if .Blazor}}
+{{.Blazor}}
+{{end}}
+{{- else}}
+
+{{end}}
+    // IGoApp is the API of the Go program: running the program and calling the exported functions.
+    // This is implemented by Go, and can be mocked in tests of the code using the program.
+    public interface IGoApp
+    {
+        int Run();
+        int Run(string[] args);
+        int Run(string[] args, CancellationToken cancellationToken);
+        Task<int> RunAsync();
+        Task<int> RunAsync(CancellationToken cancellationToken);
+        Task<int> RunAsync(string[] args);
+        Task<int> RunAsync(string[] args, CancellationToken cancellationToken);
+{{- range $value := .Exports}}{{if $value.IsAPI}}
+{{$value.InterfaceCSharp "        "}}
+{{$value.AsyncInterfaceCSharp "        "}}
+{{- end}}{{end}}
+    }
+
+    public class Go : IGoApp
     {
         class Import : IImport
         {
@@ -661,199 +2187,1414 @@ namespace {{.Namespace}}
         }
 
         public Go()
+            : this((IImportResolver)null)
         {
+        }
+
+        public Go(IDictionary<(string, string), Delegate> imports)
+            : this(new DictionaryImportResolver(imports))
+        {
+        }
+
+        public Go(IImportResolver importResolver)
+        {
+            this.importResolver = importResolver;
             this.import = new Import(this);
-            this.exitPromise = new TaskCompletionSource<int>();
+            this.jsGo = new JSObject("go", new Dictionary<string, object>()
+            {
+                {"_makeFuncWrapper", new JSFunction("_makeFuncWrapper", (object self, object[] args) => {
+                    return this.MakeFuncWrapper(args[0]);
+                })},
+                {"_pendingEvent", null},
+            });
         }
 
-        internal object LoadValue(int addr)
+        // FileSystem is the file system the Go program accesses. This must be set before the program runs.
+        public IGoFileSystem FileSystem { get; set; } = new DefaultGoFileSystem();
+
+        // Stdin is the standard input of the Go program. If this is null, the console's standard input is used.
+        // This must be set before the program runs.
+        public Stream Stdin { get; set; }
+
+        // SetStdin sets the standard input of the Go program to the UTF-8 bytes of the reader's text.
+        public void SetStdin(TextReader reader)
+        {
+            this.Stdin = new TextReaderStream(reader);
+        }
+
+        // Stdout is the standard output of the Go program. If this is null, the console's standard output is used.
+        // This must be set before the program runs.
+        public Stream Stdout { get; set; }
+
+        // Stderr is the standard error of the Go program. If this is null, the console's standard error is used.
+        // The Go runtime's diagnostic output like panics and GODEBUG traces is written here.
+        // This must be set before the program runs.
+        public Stream Stderr { get; set; }
+
+        // ConsoleOutputEncoding is the encoding of the text written to the console.
+        // The Go program always writes UTF-8. If this is not null, the output is converted into this encoding.
+        // This is not used when Stdout or Stderr is set.
+        public Encoding ConsoleOutputEncoding { get; set; }
+
+        // Env is the environment variables of the Go program. This must be set before the program runs.
+        public IDictionary<string, string> Env { get; } = new Dictionary<string, string>();
+
+        // EnableGoDebug adds the settings to the GODEBUG environment variable.
+        public void EnableGoDebug(GoDebugOptions options)
         {
-            double f = this.mem.LoadFloat64(addr);
-            if (f == 0)
+            var settings = new List<string>();
+            string current;
+            if (this.Env.TryGetValue("GODEBUG", out current) && current != "")
             {
-                return JSObject.Undefined;
+                settings.Add(current);
             }
-            if (!double.IsNaN(f))
+            if ((options & GoDebugOptions.GCTrace) != 0)
             {
-                return f;
+                settings.Add("gctrace=1");
+            }
+            if ((options & GoDebugOptions.ScavTrace) != 0)
+            {
+                settings.Add("scavtrace=1");
+            }
+            if ((options & GoDebugOptions.InitTrace) != 0)
+            {
+                settings.Add("inittrace=1");
+            }
+            if ((options & GoDebugOptions.SchedTrace) != 0)
+            {
+                settings.Add("schedtrace=1000");
             }
-            int id = (int)this.mem.LoadUint32(addr);
-            return this.values[id];
+            this.Env["GODEBUG"] = string.Join(",", settings);
         }
 
-        internal void StoreValue(int addr, object v)
+        // BrowserApis specifies what the Go program gets when it accesses a browser-only global like document,
+        // localStorage or navigator that is not set by SetGlobal.
+        public BrowserApiBehavior BrowserApis { get; set; } = BrowserApiBehavior.Undefined;
+
+        // BrowserApiShim returns the value for the browser-only global of the given name when BrowserApis is Shim.
+        // The value is converted in the same way as SetGlobal.
+        public Func<string, object> BrowserApiShim { get; set; }
+
+        // MaxMemoryBytes caps how large the memory can grow to. When the cap is hit, the Go program runs out of memory.
+        // If this is null, the memory can grow up to the module's limit. This must be set before the program runs.
+        public long? MaxMemoryBytes { get; set; }
+
+        // ThreadingModel specifies where RunAsync runs the event loop of the Go program.
+        // Callbacks from the Go program to the host are invoked on the event loop.
+        public GoThreadingModel ThreadingModel { get; set; } = GoThreadingModel.ThreadPool;
+
+        // Clock is the source of time for the Go program. This must be set before the program runs.
+        public IGoClock Clock { get; set; } = new SystemGoClock();
+
+        // SerializeHostCalls makes the instance safe to be called from multiple threads.
+        // When this is true, the event loop, exported functions returned by GetExport, and the methods accessing the memory
+        // hold a lock of the instance, so that a call from another thread waits until the Go program stops running.
+        // A callback from the Go program must not wait for another thread calling into the instance, or it deadlocks.
+        // This must be set before the program runs.
+        public bool SerializeHostCalls { get; set; }
+
+        private JSFunction MakeFuncWrapper(object id)
         {
-            const int NaNHead = 0x7FF80000;
-            double? d = ToDouble(v);
-            if (d.HasValue)
+            return new JSFunction("wrapper", (object self, object[] args) => {
+                var ev = new JSObject("event", new Dictionary<string, object>()
+                {
+                    {"id", id},
+                    {"this", self},
+                    {"args", new List<object>(args)},
+                });
+                this.jsGo.Set("_pendingEvent", ev);
+                this.Resume();
+                return ev.Get("result");
+            });
+        }
+
+        internal T ResolveImport<T>(string module, string name) where T : class
+        {
+            Delegate d;
+            if (!this.resolvedImports.TryGetValue((module, name), out d))
             {
-                if (double.IsNaN(d.Value))
+                if (this.importResolver != null)
                 {
-                    this.mem.StoreInt32(addr + 4, NaNHead);
-                    this.mem.StoreInt32(addr, 0);
-                    return;
+                    d = this.importResolver.Resolve(module, name);
                 }
-                if (d.Value == 0)
+                if (d == null)
                 {
-                    this.mem.StoreInt32(addr + 4, NaNHead);
-                    this.mem.StoreInt32(addr, 1);
-                    return;
+                    throw new NotImplementedException($"import {module}.{name} is not implemented");
                 }
-                this.mem.StoreFloat64(addr, d.Value);
-                return;
+                this.resolvedImports[(module, name)] = d;
             }
-            if (v == JSObject.Undefined)
+            var f = d as T;
+            if (f == null)
             {
-                this.mem.StoreFloat64(addr, 0);
-                return;
+                throw new InvalidCastException($"import {module}.{name} must be {typeof(T)} but {d.GetType()}");
             }
-            switch (v)
+            return f;
+        }
+
+        // Ref is a JavaScript value as syscall/js's ref: a number, or a NaN whose high bits have the type flag and whose
+        // low 32 bits are the ID of the value. 0 is undefined, and the number 0 is the ID 1.
+        private readonly struct Ref
+        {
+            private const long NaNHead = 0x7FF80000L << 32;
+
+            public readonly long Bits;
+
+            public Ref(long bits)
+            {
+                this.Bits = bits;
+            }
+
+            public static Ref FromID(int id, int typeFlag)
+            {
+                return new Ref(NaNHead | ((long)typeFlag << 32) | (uint)id);
+            }
+
+            public static Ref FromNumber(double d)
+            {
+                if (double.IsNaN(d))
+                {
+                    return FromID(0, 0);
+                }
+                if (d == 0)
+                {
+                    return FromID(1, 0);
+                }
+                return new Ref(BitConverter.DoubleToInt64Bits(d));
+            }
+
+            public bool IsUndefined
+            {
+                get { return this.Number == 0; }
+            }
+
+            public bool IsNumber
+            {
+                get { return !double.IsNaN(this.Number); }
+            }
+
+            public double Number
+            {
+                get { return BitConverter.Int64BitsToDouble(this.Bits); }
+            }
+
+            public int ID
+            {
+                get { return (int)this.Bits; }
+            }
+        }
+
+        internal object LoadValue(int addr)
+        {
+            var r = new Ref(this.mem.LoadInt64(addr));
+            if (r.IsUndefined)
+            {
+                return JSObject.Undefined;
+            }
+            if (r.IsNumber)
+            {
+                double f = r.Number;
+                // Reuse the boxes of small integers like lengths and indices.
+                if (f > 0 && f < boxedIntegers.Length && f == (int)f)
+                {
+                    return boxedIntegers[(int)f];
+                }
+                return f;
+            }
+            return this.values[r.ID];
+        }
+
+        // LoadSliceOfValues returns the values of the []ref at addr in an array from the pool. The array must be returned
+        // by ReturnSliceOfValues after the call.
+        internal object[] LoadSliceOfValues(int addr)
+        {
+            var array = (int)this.mem.LoadInt64(addr);
+            var len = (int)this.mem.LoadInt64(addr + 8);
+            object[] values = null;
+            if (len < this.valueArrayPool.Length && this.valueArrayPool[len].Count > 0)
+            {
+                values = this.valueArrayPool[len].Pop();
+            }
+            else
+            {
+                values = new object[len];
+            }
+            for (int i = 0; i < len; i++)
+            {
+                values[i] = this.LoadValue(array + i * 8);
+            }
+            return values;
+        }
+
+        internal void ReturnSliceOfValues(object[] values)
+        {
+            if (values.Length >= this.valueArrayPool.Length)
+            {
+                return;
+            }
+            Array.Clear(values, 0, values.Length);
+            this.valueArrayPool[values.Length].Push(values);
+        }
+
+        // LoadPropertyName loads a string like Mem.LoadString, but reuses the string loaded from the same bytes before,
+        // as the same property and method names are loaded by syscall/js again and again.
+        internal string LoadPropertyName(int addr)
+        {
+            var ptr = (int)this.mem.LoadInt64(addr);
+            var len = (int)this.mem.LoadInt64(addr + 8);
+            if (len > maxCachedPropertyNameLength)
+            {
+                return this.mem.LoadStringDirectly(ptr, len);
+            }
+            var bytes = this.mem.LoadSliceDirectly(ptr, len);
+            // FNV-1a
+            uint hash = 2166136261;
+            for (int i = 0; i < len; i++)
+            {
+                hash = (hash ^ bytes.Array[bytes.Offset + i]) * 16777619;
+            }
+            ref var entry = ref this.propertyNames[hash % (uint)this.propertyNames.Length];
+            if (entry.Bytes != null && entry.Bytes.Length == len)
+            {
+                bool equal = true;
+                for (int i = 0; i < len; i++)
+                {
+                    if (entry.Bytes[i] != bytes.Array[bytes.Offset + i])
+                    {
+                        equal = false;
+                        break;
+                    }
+                }
+                if (equal)
+                {
+                    return entry.Name;
+                }
+            }
+            var copied = new byte[len];
+            Array.Copy(bytes.Array, bytes.Offset, copied, 0, len);
+            entry.Bytes = copied;
+            entry.Name = Encoding.UTF8.GetString(copied);
+            return entry.Name;
+        }
+
+        private struct PropertyName
+        {
+            public byte[] Bytes;
+            public string Name;
+        }
+
+        internal void StoreValue(int addr, object v)
+        {
+            if (v is Task)
+            {
+                v = this.ToPromise((Task)v);
+            }
+            double? d = ToDouble(v);
+            if (d.HasValue)
+            {
+                this.mem.StoreInt64(addr, Ref.FromNumber(d.Value).Bits);
+                return;
+            }
+            if (v == JSObject.Undefined)
+            {
+                this.mem.StoreInt64(addr, 0);
+                return;
+            }
+            switch (v)
+            {
+            case null:
+                this.mem.StoreInt64(addr, Ref.FromID(2, 0).Bits);
+                return;
+            case true:
+                this.mem.StoreInt64(addr, Ref.FromID(3, 0).Bits);
+                return;
+            case false:
+                this.mem.StoreInt64(addr, Ref.FromID(4, 0).Bits);
+                return;
+            }
+            int id;
+            if (!this.ids.TryGetValue(v, out id))
+            {
+                if (this.idPool.Count > 0)
+                {
+                    id = this.idPool.Pop();
+                }
+                else
+                {
+                    id = this.nextValueId;
+                    this.nextValueId++;
+                }
+                this.values[id] = v;
+                this.goRefCounts[id] = 0;
+                this.ids[v] = id;
+                this.valuesCreated++;
+            }
+            this.goRefCounts[id]++;
+            int typeFlag = 1;
+            if (v is string)
+            {
+                typeFlag = 2;
+            }
+            else if (v is JSFunction)
+            {
+                typeFlag = 4;
+            }
+            this.mem.StoreInt64(addr, Ref.FromID(id, typeFlag).Bits);
+        }
+
+        internal void FinalizeRef(int id)
+        {
+            // Predefined values like the global object are never finalized.
+            if (!this.goRefCounts.ContainsKey(id))
+            {
+                return;
+            }
+            this.goRefCounts[id]--;
+            if (this.goRefCounts[id] > 0)
+            {
+                return;
+            }
+            var v = this.values[id];
+            this.values.Remove(id);
+            this.goRefCounts.Remove(id);
+            this.ids.Remove(v);
+            this.idPool.Push(id);
+            this.valuesFinalized++;
+        }
+
+        // GetValueStats returns the statistics of the host values referenced by the Go program as js.Value.
+        // This is useful to verify that a long-running program doesn't leak values.
+        public JSValueStats GetValueStats()
+        {
+            int live = this.ids == null ? 0 : this.ids.Count;
+            return new JSValueStats(live, this.valuesCreated, this.valuesFinalized);
+        }
+
+        public int Run()
+        {
+            return this.Run(new string[] { });
+        }
+
+        public int Run(string[] args)
+        {
+            return this.Run(args, CancellationToken.None);
+        }
+
+        // Run runs the Go program on the calling thread until the program exits, and returns the exit code.
+        //
+        // When the token is canceled, the event loop stops resuming the program, the program is regarded as exited,
+        // and OperationCanceledException is thrown. Note that a program busy without yielding to the event loop
+        // cannot be stopped until it yields.
+        public int Run(string[] args, CancellationToken cancellationToken)
+        {
+            this.cancellationToken = cancellationToken;
+            using (cancellationToken.Register(() => this.Post(this.Cancel)))
+            {
+                using (this.EnterHostCall())
+                {
+                    this.Start(args);
+                }
+                while (!this.exited)
+                {
+                    if (this.IsIdle())
+                    {
+                        this.DetectDeadlock();
+                        break;
+                    }
+                    var task = this.tasks.Take();
+                    using (this.EnterHostCall())
+                    {
+                        task();
+                    }
+                }
+            }
+            return this.Result();
+        }
+
+        public Task<int> RunAsync()
+        {
+            return this.RunAsync(new string[] { }, CancellationToken.None);
+        }
+
+        public Task<int> RunAsync(CancellationToken cancellationToken)
+        {
+            return this.RunAsync(new string[] { }, cancellationToken);
+        }
+
+        public Task<int> RunAsync(string[] args)
+        {
+            return this.RunAsync(args, CancellationToken.None);
+        }
+
+        // RunAsync runs the Go program according to ThreadingModel, and returns a task completed with the exit code.
+        // When the token is canceled, the task is canceled in the same way as Run.
+        public Task<int> RunAsync(string[] args, CancellationToken cancellationToken)
+        {
+            switch (this.ThreadingModel)
+            {
+            case GoThreadingModel.DedicatedThread:
+                var tcs = new TaskCompletionSource<int>(TaskCreationOptions.RunContinuationsAsynchronously);
+                var thread = new System.Threading.Thread(() => {
+                    try
+                    {
+                        tcs.SetResult(this.Run(args, cancellationToken));
+                    }
+                    catch (OperationCanceledException)
+                    {
+                        tcs.SetCanceled();
+                    }
+                    catch (Exception e)
+                    {
+                        tcs.SetException(e);
+                    }
+                });
+                thread.Name = "Go";
+                thread.IsBackground = true;
+                thread.Start();
+                return tcs.Task;
+            case GoThreadingModel.SynchronizationContext:
+                var context = System.Threading.SynchronizationContext.Current;
+                if (context == null)
+                {
+                    throw new InvalidOperationException("RunAsync with GoThreadingModel.SynchronizationContext must be called on a thread with a SynchronizationContext");
+                }
+                this.syncContext = context;
+                this.completion = new TaskCompletionSource<int>();
+                this.cancellationToken = cancellationToken;
+                var registration = cancellationToken.Register(() => this.Post(this.Cancel));
+                this.completion.Task.ContinueWith(_ => registration.Dispose());
+                context.Post(_ => {
+                    this.Step(() => this.Start(args));
+                }, null);
+                return this.completion.Task;
+            default:
+                return Task.Run(() => this.Run(args, cancellationToken), cancellationToken);
+            }
+        }
+
+        // Post posts the action to the event loop. This can be called from any thread.
+        private void Post(Action action)
+        {
+            if (this.syncContext == null)
+            {
+                this.tasks.Add(action);
+                return;
+            }
+            System.Threading.Interlocked.Increment(ref this.pendingTasks);
+            this.syncContext.Post(_ => {
+                System.Threading.Interlocked.Decrement(ref this.pendingTasks);
+                this.Step(action);
+            }, null);
+        }
+
+        // Step runs the action on the SynchronizationContext, and completes the task returned by RunAsync if the program has finished.
+        private void Step(Action action)
+        {
+            if (this.completion.Task.IsCompleted)
+            {
+                return;
+            }
+            try
+            {
+                using (this.EnterHostCall())
+                {
+                    action();
+                }
+                if (!this.exited && this.IsIdle())
+                {
+                    this.DetectDeadlock();
+                }
+                if (this.exited)
+                {
+                    this.completion.SetResult(this.Result());
+                }
+            }
+            catch (OperationCanceledException)
+            {
+                this.completion.SetCanceled();
+            }
+            catch (Exception e)
+            {
+                this.completion.SetException(e);
+            }
+        }
+
+        private bool IsIdle()
+        {
+            return this.scheduledTimeouts.Count == 0 && this.tasks.Count == 0 && this.pendingTasks == 0 && this.backgroundTasks == 0;
+        }
+
+        private void DetectDeadlock()
+        {
+            // No events can wake up the program any more. Let the Go runtime detect the deadlock.
+            this.jsGo.Set("_pendingEvent", new JSObject(new Dictionary<string, object>()
+            {
+                {"id", 0},
+            }));
+            this.Resume();
+            if (!this.exited)
+            {
+                throw new Exception("Go program is waiting for events that never happen");
+            }
+        }
+
+        // Cancel stops the program without waiting for the Go runtime, and releases the resources.
+        private void Cancel()
+        {
+            if (this.exited)
+            {
+                return;
+            }
+            foreach (var timer in this.scheduledTimeouts.Values)
+            {
+                timer.Dispose();
+            }
+            this.scheduledTimeouts.Clear();
+            this.jsFS?.CloseFiles();
+            this.exited = true;
+            this.canceled = true;
+            this.inst = null;
+            this.values = null;
+            this.goRefCounts = null;
+            this.ids = null;
+            this.idPool = null;
+        }
+
+        private int Result()
+        {
+            if (this.canceled)
+            {
+                throw new OperationCanceledException(this.cancellationToken);
+            }
+            if (this.panicOutput != null && this.exitCode != 0)
+            {
+                throw this.NewPanicException();
+            }
+            return this.exitCode;
+        }
+
+        private void Start(string[] args)
+        {
+            this.stdout = this.Stdout ?? this.OpenConsoleStream(Console.OpenStandardOutput());
+            this.stderr = this.Stderr ?? this.OpenConsoleStream(Console.OpenStandardError());
+            this.startNanoseconds = this.Clock.MonotonicNanoseconds;
+            this.lastNanoseconds = 0;
+            this.canceled = false;
+            this.mem = new Mem(this.MaxMemoryBytes.HasValue ? (int)Math.Min(this.MaxMemoryBytes.Value / Mem.PageSize, int.MaxValue) : int.MaxValue);
+{{- if .Static}}
+            // The state of the wasm module is static with -static, so only one program can run at a time.
+            lock (staticLock)
+            {
+                if (staticOwner != null && staticOwner != this && !staticOwner.exited)
+                {
+                    throw new InvalidOperationException("another Go program is running; only one program can run at a time with -static");
+                }
+                staticOwner = this;
+            }
+{{- end}}
+            this.inst = new Inst(this.mem, this.import);
+{{- if .WASI}}
+            this.wasi = new Wasi(this.mem, this.FileSystem, this.Clock, this.Stdin ?? Console.OpenStandardInput(), this.stdout, this.stderr, args.Prepend("wasi").ToArray(), this.EnvStrings(), Directory.GetCurrentDirectory(), this.ObserveStderr, this.cancellationToken);
+{{- end}}
+{{- if index .Exported "run"}}
+            this.jsFS = new JSFileSystem(this.FileSystem, this.Stdin ?? Console.OpenStandardInput(), this.stdout, this.stderr, this.ObserveStderr, this.StartBackgroundTask);
+            this.global = JSObject.NewGlobal(this.jsFS.Object, this.Post, this.ResolveMissingGlobal);
+            foreach (var kv in this.hostGlobals)
+            {
+                this.global.Set(kv.Key, this.ToJSValue(kv.Value));
+            }
+            this.values = new Dictionary<int, object>
+            {
+                {0, double.NaN},
+                {1, 0},
+                {2, null},
+                {3, true},
+                {4, false},
+                {5, this.global},
+                {6, this.jsGo},
+            };
+            this.goRefCounts = new Dictionary<int, int>();
+            this.ids = new Dictionary<object, int>();
+            this.idPool = new Stack<int>();
+            this.nextValueId = this.values.Count;
+            this.exited = false;
+
+            int offset = 4096;
+            Func<string, int> strPtr = (string str) => {
+                int ptr = offset;
+                offset += this.mem.StoreString(offset, str + '\0');
+                if (offset % 8 != 0)
+                {
+                    offset += 8 - (offset % 8);
+                }
+                return ptr;
+            };
+
+            // 'js' is requried as the first argument.
+            int argc = args.Length + 1;
+            IEnumerable<int> argvPtrs = args.Prepend("js").Select(arg => strPtr(arg)).Append(0);
+            argvPtrs = argvPtrs.Concat(this.EnvStrings().Select(env => strPtr(env))).Append(0);
+
+            int argv = offset;
+            foreach (int ptr in argvPtrs)
+            {
+                this.mem.StoreInt32(offset, ptr);
+                this.mem.StoreInt32(offset + 4, 0);
+                offset += 8;
+            }
+
+            this.CallGo(() => {{if .Static}}Inst{{else}}this.inst{{end}}.run(argc, argv));
+{{- else if index .Exported "_start"}}
+            int code = 0;
+            try
+            {
+                {{if .Static}}Inst{{else}}this.inst{{end}}._start();
+            }
+{{- if .WASI}}
+            catch (WasiExitException e)
+            {
+                code = e.Code;
+            }
+{{- end}}
+            finally
+            {
+                this.exited = true;
+{{- if .WASI}}
+                this.wasi.CloseFiles();
+{{- end}}
+            }
+            this.Exit(code);
+{{- else}}
+            throw new NotSupportedException("the module exports neither run nor _start");
+{{- end}}
+        }
+
+        // MemoryReset is raised when the Go runtime has grown the memory, or a snapshot is restored.
+        // The backing array of the memory is replaced, so a host that holds a view of the memory must re-acquire it.
+        public event EventHandler MemoryReset;
+
+        // SetGlobal sets the value as a property of the JavaScript global object,
+        // which the Go program can access via js.Global().Get(name).
+        // A delegate is converted into a function, and a Task into a promise.
+        // This must be called before the program runs.
+        public void SetGlobal(string name, object value)
+        {
+            this.hostGlobals[name] = value;
+        }
+
+        // ToPromise returns a JavaScript promise that is settled when the task completes.
+        // The Go program can await it with then, and the reactions are invoked on the event loop.
+        public object ToPromise(Task task)
+        {
+            return this.promises.GetValue(task, (Task t) => {
+                // A pending promise keeps the event loop alive.
+                System.Threading.Interlocked.Increment(ref this.backgroundTasks);
+                return JSPromise.FromTask(t, (Action action) => {
+                    this.Post(() => {
+                        System.Threading.Interlocked.Decrement(ref this.backgroundTasks);
+                        action();
+                    });
+                }, TaskResult);
+            });
+        }
+
+{{- if and (not .AOT) .Target.Trimming}}
+        // The trimmer cannot know the type of the task, so keep Task<T>.Result for all T.
+        [System.Diagnostics.CodeAnalysis.DynamicDependency(System.Diagnostics.CodeAnalysis.DynamicallyAccessedMemberTypes.PublicProperties, typeof(Task<>))]
+        [System.Diagnostics.CodeAnalysis.UnconditionalSuppressMessage("Trimming", "IL2075", Justification = "Task<T>.Result is kept by DynamicDependency")]
+{{- end}}
+        private static object TaskResult(Task task)
+        {
+{{- if .AOT}}
+            // Without reflection, only the results of the tasks of these types are known.
+            switch (task)
+            {
+            case Task<object> t:
+                return t.Result;
+            case Task<string> t:
+                return t.Result;
+            case Task<bool> t:
+                return t.Result;
+            case Task<int> t:
+                return t.Result;
+            case Task<long> t:
+                return t.Result;
+            case Task<double> t:
+                return t.Result;
+            }
+            return JSObject.Undefined;
+{{- else}}
+            // Task.Run(Action) returns Task<VoidTaskResult> whose result is meaningless.
+            var property = task.GetType().GetProperty("Result");
+            if (property == null || property.PropertyType.Name == "VoidTaskResult")
+            {
+                return JSObject.Undefined;
+            }
+            return property.GetValue(task);
+{{- end}}
+        }
+
+        // ToTask returns a task that is completed when the JavaScript promise created by the Go program is settled.
+        // If the value is not a promise, the returned task is already completed with the value.
+        public Task<object> ToTask(object promise)
+        {
+            if (promise is JSPromise)
+            {
+                return ((JSPromise)promise).ToTask();
+            }
+            return Task.FromResult(promise);
+        }
+
+{{- if and (not .AOT) .Target.Trimming}}
+        [System.Diagnostics.CodeAnalysis.UnconditionalSuppressMessage("Trimming", "IL2072", Justification = "Activator creates only value types, which don't need constructors")]
+{{- end}}
+        private object ToJSValue(object value)
+        {
+            if (value is Task)
+            {
+                return this.ToPromise((Task)value);
+            }
+            if (!(value is Delegate))
+            {
+                return value;
+            }
+{{- if .AOT}}
+            // Without reflection, only delegates that take this and the arguments as JavaScript functions are converted.
+            if (value is Func<object, object[], object>)
+            {
+                return new JSFunction("", (Func<object, object[], object>)value);
+            }
+            throw new NotSupportedException($"{value.GetType()} cannot be converted into a JavaScript function without reflection; use Func<object, object[], object>");
+{{- else}}
+            var d = (Delegate)value;
+            var parameters = d.Method.GetParameters();
+            return new JSFunction(d.Method.Name, (object self, object[] args) => {
+                var converted = new object[parameters.Length];
+                for (int i = 0; i < parameters.Length; i++)
+                {
+                    var arg = i < args.Length ? args[i] : JSObject.Undefined;
+                    var type = parameters[i].ParameterType;
+                    if (type == typeof(object))
+                    {
+                        converted[i] = arg;
+                    }
+                    else if (arg == null || arg == JSObject.Undefined)
+                    {
+                        converted[i] = type.IsValueType ? Activator.CreateInstance(type) : null;
+                    }
+                    else if (type.IsInstanceOfType(arg))
+                    {
+                        converted[i] = arg;
+                    }
+                    else
+                    {
+                        converted[i] = Convert.ChangeType(arg, type, CultureInfo.InvariantCulture);
+                    }
+                }
+                object result;
+                try
+                {
+                    result = d.DynamicInvoke(converted);
+                }
+                catch (TargetInvocationException e)
+                {
+                    throw e.InnerException;
+                }
+                if (d.Method.ReturnType == typeof(void))
+                {
+                    return JSObject.Undefined;
+                }
+                return this.ToJSValue(result);
+            });
+{{- end}}
+        }
+
+        private object ResolveMissingGlobal(string name)
+        {
+            if (!browserApiNames.Contains(name))
+            {
+                return JSObject.Undefined;
+            }
+            switch (this.BrowserApis)
+            {
+            case BrowserApiBehavior.Throw:
+                throw new NotSupportedException($"{name} is a browser API and is not available in .NET. Set it with SetGlobal or BrowserApiShim.");
+            case BrowserApiBehavior.Shim:
+                var value = this.BrowserApiShim == null ? null : this.BrowserApiShim(name);
+                if (value == null)
+                {
+                    return JSObject.Undefined;
+                }
+                value = this.ToJSValue(value);
+                this.global.Set(name, value);
+                return value;
+            }
+            return JSObject.Undefined;
+        }
+
+        // Instance returns the instance of the running program, or null if the program is not running.
+        // The exported functions are called without the lock even if SerializeHostCalls is true.
+        public Inst Instance => this.inst;
+
+        // GetExport returns the exported function of the given wasm name as Action<...> or Func<...>,
+        // or null if the function is not exported.
+        public Delegate GetExport(string name)
+        {
+            if (this.inst == null)
+            {
+                throw new InvalidOperationException(Strings.NotRunning);
+            }
+            return this.inst.GetExport(name, this.SerializeHostCalls ? this.hostLock : null);
+        }
+
+{{range $value := .Exports}}{{if $value.IsAPI}}{{$value.ImplCSharp "        "}}
+
+{{$value.AsyncCSharp "        "}}
+
+{{end}}{{end}}        // Exports calls the exported functions of the program by their original names, with the parameter names in
+        // the name section or the Go source, and the .NET types of the Go parameters if known by -src.
+        // The program must be running by Run or RunAsync.
+        public sealed class Exports
+        {
+            private readonly Go go;
+
+            // delegates is the typed delegates of the instance that the program last ran on.
+            private Delegates delegates;
+
+            public Exports(Go go)
+            {
+                if (go == null)
+                {
+                    throw new ArgumentNullException(nameof(go));
+                }
+                this.go = go;
+            }
+
+            // Delegates is the typed delegates of the exported functions of an instance, so that a call doesn't look
+            // up and cast the delegate by GetExport every time.
+            private sealed class Delegates
+            {
+                internal readonly Inst Inst;
+{{- range $value := .Exports}}{{if $value.IsAPI}}
+                internal readonly {{$value.DelegateType}} {{$value.FacadeField}};
+{{- end}}{{end}}
+
+                internal Delegates(Go go)
+                {
+                    this.Inst = go.Instance;
+{{- range $value := .Exports}}{{if $value.IsAPI}}
+                    this.{{$value.FacadeField}} = ({{$value.DelegateType}})go.GetExport({{$value.NameConst}});
+{{- end}}{{end}}
+                }
+            }
+
+            // GetDelegates returns the typed delegates of the running instance. They are resolved again when the
+            // program runs again, as the instance is new.
+            private Delegates GetDelegates()
+            {
+                var d = this.delegates;
+                if (d == null || d.Inst != this.go.Instance)
+                {
+                    d = new Delegates(this.go);
+                    this.delegates = d;
+                }
+                return d;
+            }
+{{- range $value := .Exports}}{{if $value.IsAPI}}
+
+{{$value.FacadeCSharp "            "}}
+{{- end}}{{end}}
+        }
+
+        // CallExportAsync calls the exported function on the event loop, so that the call is serialized with
+        // the events of the program, e.g. timers and callbacks. The program must be running by Run or RunAsync.
+        private Task<T> CallExportAsync<T>(Func<T> f)
+        {
+            if (this.inst == null || this.exited)
+            {
+                throw new InvalidOperationException(Strings.NotRunning);
+            }
+            var tcs = new TaskCompletionSource<T>(TaskCreationOptions.RunContinuationsAsynchronously);
+            this.Post(() => {
+                if (this.exited)
+                {
+                    tcs.SetException(new InvalidOperationException("Go program exited before the call"));
+                    return;
+                }
+                try
+                {
+                    tcs.SetResult(f());
+                }
+                catch (GoExitedException)
+                {
+                    // The exit code is already recorded.
+                    tcs.SetException(new InvalidOperationException("Go program exited during the call"));
+                }
+                catch (Exception e)
+                {
+                    tcs.SetException(e);
+                    throw;
+                }
+            });
+            return tcs.Task;
+        }
+
+        // Invoke calls the exported function of the given wasm name, and returns the result, or null if the function returns nothing.
+        // The arguments are converted into the parameter types like int or double.
+        public object Invoke(string name, params object[] args)
+        {
+{{- if .AOT}}
+            if (this.inst == null)
+            {
+                throw new InvalidOperationException(Strings.NotRunning);
+            }
+            try
+            {
+                if (this.SerializeHostCalls)
+                {
+                    lock (this.hostLock)
+                    {
+                        return this.inst.Invoke(name, args);
+                    }
+                }
+                return this.inst.Invoke(name, args);
+            }
+            catch (GoExitedException)
+            {
+                throw new InvalidOperationException($"Go program exited during the call to {name}");
+            }
+{{- else}}
+            var d = this.GetExport(name);
+            if (d == null)
+            {
+                throw new ArgumentException($"function {name} is not exported", nameof(name));
+            }
+            var parameters = d.Method.GetParameters();
+            if (args.Length != parameters.Length)
+            {
+                throw new ArgumentException($"function {name} takes {parameters.Length} arguments but {args.Length} were given", nameof(args));
+            }
+            var converted = new object[args.Length];
+            for (int i = 0; i < args.Length; i++)
+            {
+                converted[i] = Convert.ChangeType(args[i], parameters[i].ParameterType, CultureInfo.InvariantCulture);
+            }
+            try
+            {
+                return d.DynamicInvoke(converted);
+            }
+            catch (TargetInvocationException e) when (e.InnerException is GoExitedException)
+            {
+                throw new InvalidOperationException($"Go program exited during the call to {name}");
+            }
+            catch (TargetInvocationException e)
+            {
+                throw e.InnerException;
+            }
+{{- end}}
+        }
+
+        // Save writes a snapshot of the running Go program's linear memory, globals and tables to the stream.
+        // This must not be called while Go code is being executed, e.g. call this from a callback invoked on the event loop
+        // or while the program is waiting for events.
+        //
+        // JavaScript values referred from the Go program, like functions set by SetGlobal, are not included in the snapshot.
+        public void Save(Stream stream)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.inst == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                using (var writer = new BinaryWriter(stream, Encoding.UTF8, true))
+                {
+                    writer.Write(snapshotMagic);
+                    writer.Write(snapshotVersion);
+                    this.mem.Save(writer);
+                    this.inst.Save(writer);
+                }
+            }
+        }
+
+        // Restore replaces the running Go program's linear memory and globals with the snapshot written by Save.
+        // The snapshot must be taken from the same module. The same restrictions as Save apply.
+        //
+        // As JavaScript values are not included in the snapshot, restoring a snapshot is safe only when the JavaScript values
+        // the Go program refers to are still alive, e.g. restoring the snapshot to the same instance, or for WASI programs.
+        public void Restore(Stream stream)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.inst == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                using (var reader = new BinaryReader(stream, Encoding.UTF8, true))
+                {
+                    if (reader.ReadUInt32() != snapshotMagic)
+                    {
+                        throw new InvalidDataException("the stream is not a snapshot");
+                    }
+                    var version = reader.ReadInt32();
+                    if (version != snapshotVersion)
+                    {
+                        throw new InvalidDataException($"unsupported snapshot version: {version}");
+                    }
+                    // Read the whole snapshot before modifying the state, so that an invalid snapshot doesn't break the program.
+                    var bytes = this.mem.ReadSnapshot(reader);
+                    this.inst.Restore(reader);
+                    this.mem.Reset(bytes);
+                }
+                this.ResetMemoryDataView();
+            }
+        }
+
+        // ReadString returns the UTF-8 string of len bytes at ptr in the Go memory.
+        public string ReadString(int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                return this.mem.LoadStringDirectly(ptr, len);
+            }
+        }
+
+        // CopyBytesToGo copies src to the Go byte slice whose data pointer and length are ptr and len.
+        // This returns the number of copied bytes, which is the minimum of src's length and len.
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+{{- if or .Target.Span .Target.SpanCondition}}
+        public int CopyBytesToGo(int ptr, int len, ReadOnlySpan<byte> src)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                var n = Math.Min(src.Length, len);
+                src.Slice(0, n).CopyTo(this.mem.LoadSliceDirectly(ptr, n).AsSpan());
+                return n;
+            }
+        }
+{{- end}}
+{{- if .Target.SpanCondition}}
+#else
+{{- end}}
+{{- if not .Target.Span}}
+        public int CopyBytesToGo(int ptr, int len, byte[] src)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                var n = Math.Min(src.Length, len);
+                var slice = this.mem.LoadSliceDirectly(ptr, n);
+                Array.Copy(src, 0, slice.Array, slice.Offset, n);
+                return n;
+            }
+        }
+{{- end}}
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+
+        // WriteBytes copies the bytes into memory allocated by the module's allocator, and returns the pointer.
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+{{- if or .Target.Span .Target.SpanCondition}}
+        public int WriteBytes(ReadOnlySpan<byte> src)
+{{- end}}
+{{- if .Target.SpanCondition}}
+#else
+{{- end}}
+{{- if not .Target.Span}}
+        public int WriteBytes(byte[] src)
+{{- end}}
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+        {
+{{- if .Malloc}}
+            int ptr;
+            using (this.EnterHostCall())
+            {
+                if (this.inst == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                ptr = {{if .Static}}Inst{{else}}this.inst{{end}}.malloc(src.Length);
+            }
+            this.CopyBytesToGo(ptr, src.Length, src);
+            return ptr;
+{{- else}}
+            throw new NotSupportedException(Strings.NoAllocator);
+{{- end}}
+        }
+
+        // WriteString copies the string as UTF-8 into memory allocated by the module's allocator, and returns the
+        // pointer. len is set to the length in bytes.
+        public int WriteString(string str, out int len)
+        {
+{{- if .Malloc}}
+            var bytes = Encoding.UTF8.GetBytes(str);
+            len = bytes.Length;
+            return this.WriteBytes(bytes);
+{{- else}}
+            throw new NotSupportedException(Strings.NoAllocator);
+{{- end}}
+        }
+
+        // CopyBytesToDotNet copies the Go byte slice whose data pointer and length are ptr and len to dst.
+        // This returns the number of copied bytes, which is the minimum of dst's length and len.
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+{{- if or .Target.Span .Target.SpanCondition}}
+        public int CopyBytesToDotNet(Span<byte> dst, int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                var n = Math.Min(dst.Length, len);
+                this.mem.LoadSliceDirectly(ptr, n).AsSpan().CopyTo(dst);
+                return n;
+            }
+        }
+{{- end}}
+{{- if .Target.SpanCondition}}
+#else
+{{- end}}
+{{- if not .Target.Span}}
+        public int CopyBytesToDotNet(byte[] dst, int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                var n = Math.Min(dst.Length, len);
+                var slice = this.mem.LoadSliceDirectly(ptr, n);
+                Array.Copy(slice.Array, slice.Offset, dst, 0, n);
+                return n;
+            }
+        }
+{{- end}}
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+{{- if or .Target.Span .Target.SpanCondition}}
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+
+        // GetSpan returns the Go byte slice whose data pointer and length are ptr and len as a span over the memory,
+        // without copying. The span must not be used after the Go program runs again, as the memory might grow.
+        public Span<byte> GetSpan(int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                return this.mem.LoadSliceDirectly(ptr, len).AsSpan();
+            }
+        }
+
+        // GetMemory returns a view of the Go byte slice whose data pointer and length are ptr and len, without copying.
+        // Unlike a span, the view can be kept, e.g. across awaits. Accessing the view throws InvalidOperationException
+        // after the memory grows or a snapshot is restored (see MemoryReset), as the memory is then a new array.
+        public Memory<byte> GetMemory(int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                return new GoMemoryManager(this.mem, ptr, len).Memory;
+            }
+        }
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+{{- end}}
+
+        private void Exit(int code)
+        {
+            this.exitCode = code;
+            if (code != 0)
             {
-            case null:
-                this.mem.StoreInt32(addr + 4, NaNHead);
-                this.mem.StoreInt32(addr, 2);
-                return;
-            case true:
-                this.mem.StoreInt32(addr + 4, NaNHead);
-                this.mem.StoreInt32(addr, 3);
-                return;
-            case false:
-                this.mem.StoreInt32(addr + 4, NaNHead);
-                this.mem.StoreInt32(addr, 4);
-                return;
+                var bytes = Encoding.UTF8.GetBytes($"exit code: {code}\n");
+                this.stderr.Write(bytes, 0, bytes.Length);
+                this.stderr.Flush();
             }
-            int id = 0;
-            if (this.ids.ContainsKey(v))
+        }
+
+        private void Resume()
+        {
+            if (this.exited)
             {
-                id = this.ids[v];
+                throw new Exception("Go program has already exited");
             }
-            else
+{{- if index .Exported "resume"}}
+            this.CallGo(() => {{if .Static}}Inst{{else}}this.inst{{end}}.resume());
+{{- end}}
+        }
+
+        private Stream OpenConsoleStream(Stream stream)
+        {
+            if (this.ConsoleOutputEncoding == null || this.ConsoleOutputEncoding is UTF8Encoding)
             {
-                if (this.idPool.Count > 0)
-                {
-                    id = this.idPool.Pop();
-                }
-                else
-                {
-                    id = this.values.Count;
-                }
-                this.values[id] = v;
-                this.goRefCounts[id] = 0;
-                this.ids[v] = id;
+                return stream;
             }
-            this.goRefCounts[id]++;
-            int typeFlag = 1;
-            if (v is string)
+            return new TranscodingStream(stream, this.ConsoleOutputEncoding);
+        }
+
+        private string[] EnvStrings()
+        {
+            return this.Env.OrderBy(kv => kv.Key, StringComparer.Ordinal).Select(kv => $"{kv.Key}={kv.Value}").ToArray();
+        }
+
+        private void ResetMemoryDataView()
+        {
+            // Host-side accessors like ReadString and CopyBytesToGo always access the memory via Mem,
+            // so they never see a stale array. Only notify the host.
+            this.MemoryReset?.Invoke(this, EventArgs.Empty);
+        }
+
+        // GetSP returns the current stack pointer of the Go program.
+        //
+        // The Go program and the host can call each other recursively: an import like valueCall calls a host function,
+        // which might call back into the program via a js.FuncOf wrapper (Resume) or an exported function (Invoke).
+        // Go's stack might move during the nested call, so an import must reload the stack pointer with GetSP
+        // before storing its results. If the program exited during the nested call, GetSP throws GoExitedException
+        // to unwind the remaining frames up to the call into the program (CallGo).
+        internal int GetSP()
+        {
+            if (this.exited)
             {
-                typeFlag = 2;
+                throw new GoExitedException();
             }
-            // TODO: Should we use other typeFlag for other objects?
-            this.mem.StoreInt32(addr + 4, NaNHead | typeFlag);
-            this.mem.StoreInt32(addr, id);
+{{- if index .Exported "getsp"}}
+            return {{if .Static}}Inst{{else}}this.inst{{end}}.getsp();
+{{- else}}
+            throw new NotSupportedException("the module does not export getsp");
+{{- end}}
         }
 
-        public Task Run()
+        // EnterHostCall acquires the lock of the instance if SerializeHostCalls is true. Dispose the result to release it.
+        private HostCall EnterHostCall()
         {
-            return Run(new string[] { });
+            return new HostCall(this.SerializeHostCalls ? this.hostLock : null);
         }
 
-        public Task Run(string[] args)
+        private struct HostCall : IDisposable
         {
-            this.buf = new List<byte>();
-            this.stopwatch = Stopwatch.StartNew();
-            this.mem = new Mem();
-            this.inst = new Inst(this.mem, this.import);
-            this.values = new Dictionary<int, object>
+            public HostCall(object syncRoot)
             {
-                {0, double.NaN},
-                {1, 0},
-                {2, null},
-                {3, true},
-                {4, false},
-                {5, JSObject.Global},
-                {6, this},
-            };
-            this.goRefCounts = new Dictionary<int, int>();
-            this.ids = new Dictionary<object, int>();
-            this.idPool = new Stack<int>();
-            this.exited = false;
+                this.syncRoot = syncRoot;
+                if (syncRoot != null)
+                {
+                    System.Threading.Monitor.Enter(syncRoot);
+                }
+            }
 
-            int offset = 4096;
-            Func<string, int> strPtr = (string str) => {
-                int ptr = offset;
-                byte[] bytes = Encoding.UTF8.GetBytes(str + '\0');
-                this.mem.StoreBytes(offset, bytes);
-                offset += bytes.Length;
-                if (offset % 8 != 0)
+            public void Dispose()
+            {
+                if (this.syncRoot != null)
                 {
-                    offset += 8 - (offset % 8);
+                    System.Threading.Monitor.Exit(this.syncRoot);
                 }
-                return ptr;
-            };
+            }
 
-            // 'js' is requried as the first argument.
-            int argc = args.Length + 1;
-            IEnumerable<int> argvPtrs = args.Prepend("js").Select(arg => strPtr(arg)).Append(0);
-            // TODO: Add environment variables.
-            argvPtrs = argvPtrs.Append(0);
+            private object syncRoot;
+        }
 
-            int argv = offset;
-            foreach (int ptr in argvPtrs)
+        private void CallGo(Action f)
+        {
+            try
             {
-                this.mem.StoreInt32(offset, ptr);
-                this.mem.StoreInt32(offset + 4, 0);
-                offset += 8;
+                f();
             }
-
-            this.inst.run(argc, argv);
-            if (this.exited)
+            catch (GoExitedException)
             {
-                this.exitPromise.SetResult(0);
+                // The program exited in a nested call. The exit code is already recorded.
             }
-            return this.exitPromise.Task;
         }
 
-        private void Exit(int code)
+        private void DebugWrite(long fd, ArraySegment<byte> bytes)
         {
-            if (code != 0)
-            {
-                Console.Error.WriteLine($"exit code: {code}");
-            }
+            var stream = fd == 2 ? this.stderr : this.stdout;
+            stream.Write(bytes.Array, bytes.Offset, bytes.Count);
+            stream.Flush();
         }
 
-        private void Resume()
+        // ObserveStderr watches the standard error output to capture a panic message and a stack trace.
+        // bytes is a view of the memory or a buffer, so this doesn't box it as IEnumerable<byte> or copy it.
+        private void ObserveStderr(ArraySegment<byte> bytes)
         {
-            if (this.exited)
+            for (int i = 0; i < bytes.Count; i++)
             {
-                throw new Exception("Go program has already exited");
+                this.stderrBuf.Add(bytes.Array[bytes.Offset + i]);
             }
-            this.inst.resume();
-            if (this.exited)
+            int idx;
+            while ((idx = this.stderrBuf.IndexOf((byte)'\n')) >= 0)
             {
-                this.exitPromise.SetResult(0);
+                var line = Encoding.UTF8.GetString(this.stderrBuf.GetRange(0, idx).ToArray());
+                this.stderrBuf.RemoveRange(0, idx+1);
+                if (this.panicOutput == null)
+                {
+                    if (!line.StartsWith("panic: ") && !line.StartsWith("fatal error: "))
+                    {
+                        continue;
+                    }
+                    this.panicOutput = new List<string>();
+                }
+                this.panicOutput.Add(line);
             }
         }
 
-        private void DebugWrite(IEnumerable<byte> bytes)
+        private GoPanicException NewPanicException()
         {
-            this.buf.AddRange(bytes);
-            while (this.buf.Contains((byte)'\n'))
+            // The panic message continues until the first goroutine's stack trace.
+            var idx = this.panicOutput.FindIndex(line => line.StartsWith("goroutine "));
+            if (idx < 0)
             {
-                var idx = this.buf.IndexOf((byte)'\n');
-                var str = Encoding.UTF8.GetString(this.buf.GetRange(0, idx).ToArray());
-                Console.WriteLine(str);
-                this.buf.RemoveRange(0, idx+1);
+                idx = this.panicOutput.Count;
             }
+            var message = string.Join(Environment.NewLine, this.panicOutput.Take(idx)).Trim();
+            var stackTrace = string.Join(Environment.NewLine, this.panicOutput.Skip(idx)).Trim();
+            return new GoPanicException(message, stackTrace, this.exitCode);
         }
 
         private long PreciseNowInNanoseconds()
         {
-            return this.stopwatch.ElapsedTicks * nanosecPerTick;
+            // The Go runtime assumes that nanotime never goes backward.
+            var now = this.Clock.MonotonicNanoseconds - this.startNanoseconds;
+            if (now < this.lastNanoseconds)
+            {
+                return this.lastNanoseconds;
+            }
+            this.lastNanoseconds = now;
+            return now;
         }
 
         private double UnixNowInMilliseconds()
         {
-            return (DateTime.UtcNow.Subtract(new DateTime(1970, 1, 1))).TotalMilliseconds;
+            return (this.Clock.UtcNow.Subtract(new DateTime(1970, 1, 1))).TotalMilliseconds;
         }
 
         private int SetTimeout(double interval)
@@ -861,115 +3602,513 @@ namespace {{.Namespace}}
             var id = this.nextCallbackTimeoutId;
             this.nextCallbackTimeoutId++;
 
-            Timer timer = new Timer(interval);
-            timer.Elapsed += (sender, e) => {
-                this.Resume();
-                while (this.scheduledTimeouts.ContainsKey(id))
-                {
-                    // for some reason Go failed to register the timeout event, log and try again
-                    // (temporary workaround for https://github.com/golang/go/issues/28975)
+            // The clock's callback might be invoked on another thread. Post the task to the event loop.
+            var timer = this.Clock.Schedule(TimeSpan.FromMilliseconds(interval), () => {
+                this.Post(() => {
+                    if (this.exited || !this.scheduledTimeouts.ContainsKey(id))
+                    {
+                        return;
+                    }
                     this.Resume();
-                }
-            };
-            timer.AutoReset = false;
-            timer.Start();
+                    while (!this.exited && this.scheduledTimeouts.ContainsKey(id))
+                    {
+                        // for some reason Go failed to register the timeout event, log and try again
+                        // (temporary workaround for https://github.com/golang/go/issues/28975)
+                        this.Resume();
+                    }
+                });
+            });
 
             this.scheduledTimeouts[id] = timer;
 
             return id;
         }
 
+        // StartBackgroundTask runs the blocking operation on a thread pool thread,
+        // and then posts the continuation to the event loop.
+        private void StartBackgroundTask(Func<object> operation, Action<object, Exception> continuation)
+        {
+            System.Threading.Interlocked.Increment(ref this.backgroundTasks);
+            Task.Run(() => {
+                object result = null;
+                Exception error = null;
+                try
+                {
+                    result = operation();
+                }
+                catch (Exception e)
+                {
+                    error = e;
+                }
+                this.Post(() => {
+                    System.Threading.Interlocked.Decrement(ref this.backgroundTasks);
+                    if (this.exited)
+                    {
+                        return;
+                    }
+                    continuation(result, error);
+                });
+            });
+        }
+
         private void ClearTimeout(int id)
         {
             if (this.scheduledTimeouts.ContainsKey(id))
             {
-                this.scheduledTimeouts[id].Stop();
+                this.scheduledTimeouts[id].Dispose();
             }
             this.scheduledTimeouts.Remove(id);
         }
 
-        private byte[] GetRandomBytes(int length)
+        // FillRandomBytes fills the memory directly, without a temporary array.
+        private void FillRandomBytes(ArraySegment<byte> bytes)
         {
-            var bytes = new byte[length];
-            this.rngCsp.GetBytes(bytes);
-            return bytes;
+            this.rng.GetBytes(bytes.Array, bytes.Offset, bytes.Count);
         }
 
-        private static long nanosecPerTick = (1_000_000_000L) / Stopwatch.Frequency;
-
         private Import import;
-        private TaskCompletionSource<int> exitPromise;
+{{- if .Static}}
+        private static readonly object staticLock = new object();
+        private static Go staticOwner;
+{{- end}}
+        private BlockingCollection<Action> tasks = new BlockingCollection<Action>();
+        private int exitCode;
+        private List<byte> stderrBuf = new List<byte>();
+        private List<string> panicOutput;
+        private JSObject jsGo;
+{{- if index .Exported "run"}}
+        private JSObject global;
+        private JSFileSystem jsFS;
+{{- else}}
+        // The JavaScript environment is not used by the program.
+        private JSObject global = null;
+        private JSFileSystem jsFS = null;
+{{- end}}
+        private IImportResolver importResolver;
+{{- if .WASI}}
+        private Wasi wasi;
+{{- end}}
+        private Dictionary<(string, string), Delegate> resolvedImports = new Dictionary<(string, string), Delegate>();
 
-        private List<byte> buf;
-        private Stopwatch stopwatch;
+        private Stream stdout;
+        private Stream stderr;
+        private long startNanoseconds;
+        private long lastNanoseconds;
 
-        private Dictionary<int, Timer> scheduledTimeouts = new Dictionary<int, Timer>();
+        private Dictionary<int, IDisposable> scheduledTimeouts = new Dictionary<int, IDisposable>();
         private int nextCallbackTimeoutId = 1;
+        private int backgroundTasks;
+        private int pendingTasks;
+        private System.Threading.SynchronizationContext syncContext;
+        private TaskCompletionSource<int> completion;
+        private CancellationToken cancellationToken;
+        private bool canceled;
+        private static readonly HashSet<string> browserApiNames = new HashSet<string>()
+        {
+            "alert",
+            "document",
+            "fetch",
+            "history",
+            "indexedDB",
+            "localStorage",
+            "location",
+            "navigator",
+            "requestAnimationFrame",
+            "screen",
+            "sessionStorage",
+            "WebSocket",
+            "window",
+            "XMLHttpRequest",
+        };
+
+        private Dictionary<string, object> hostGlobals = new Dictionary<string, object>();
+        private ConditionalWeakTable<Task, JSPromise> promises = new ConditionalWeakTable<Task, JSPromise>();
         private Inst inst;
         private Mem mem;
         private Dictionary<int, object> values;
         private Dictionary<int, int> goRefCounts;
         private Dictionary<object, int> ids;
         private Stack<int> idPool;
+        private int nextValueId;
+        private PropertyName[] propertyNames = new PropertyName[256];
+        private const int maxCachedPropertyNameLength = 64;
+        // valueArrayPool is the pools of the arguments arrays by the lengths.
+        private Stack<object[]>[] valueArrayPool = Enumerable.Range(0, 8).Select(_ => new Stack<object[]>()).ToArray();
+        private static readonly object[] boxedIntegers = Enumerable.Range(0, 1024).Select(i => (object)(double)i).ToArray();
+        private long valuesCreated;
+        private long valuesFinalized;
         private bool exited;
-        private RNGCryptoServiceProvider rngCsp = new RNGCryptoServiceProvider();
+        private RandomNumberGenerator rng = RandomNumberGenerator.Create();
+        private object hostLock = new object();
+
+        // snapshotMagic is "G2DN" in little endian.
+        private const uint snapshotMagic = 0x4e443247;
+        private const int snapshotVersion = 1;
+    }
+{{- if or .Target.Span .Target.SpanCondition}}
+{{- if .Target.SpanCondition}}
+#if {{.Target.SpanCondition}}
+{{- end}}
+
+    // GoMemoryManager is a view of a range of the memory of a Go program, by Go.GetMemory. The view is invalidated
+    // when the memory's array is replaced.
+    sealed class GoMemoryManager : System.Buffers.MemoryManager<byte>
+    {
+        internal GoMemoryManager(Mem mem, int ptr, int len)
+        {
+            // Check the range.
+            mem.LoadSliceDirectly(ptr, len);
+            this.mem = mem;
+            this.generation = mem.Generation;
+            this.ptr = ptr;
+            this.len = len;
+        }
+
+        public override Span<byte> GetSpan()
+        {
+            return this.Segment().AsSpan();
+        }
+
+        public override System.Buffers.MemoryHandle Pin(int elementIndex = 0)
+        {
+            var segment = this.Segment();
+            return new Memory<byte>(segment.Array, segment.Offset, segment.Count).Slice(elementIndex).Pin();
+        }
+
+        public override void Unpin()
+        {
+            // The handle returned by Pin unpins the array itself.
+        }
+
+        protected override bool TryGetArray(out ArraySegment<byte> segment)
+        {
+            segment = this.Segment();
+            return true;
+        }
+
+        protected override void Dispose(bool disposing)
+        {
+        }
+
+        private ArraySegment<byte> Segment()
+        {
+            if (this.mem.Generation != this.generation)
+            {
+                throw new InvalidOperationException("the memory of the Go program has been replaced since the view was created; get a new view after MemoryReset");
+            }
+            return this.mem.LoadSliceDirectly(this.ptr, this.len);
+        }
+
+        private readonly Mem mem;
+        private readonly int generation;
+        private readonly int ptr;
+        private readonly int len;
+    }
+{{- if .Target.SpanCondition}}
+#endif
+{{- end}}
+{{- end}}
+{{- if .COM}}
+
+{{.COM}}
+{{- end}}
+{{- if .ProfileFuncs}}
+
+    // GoProfile is the execution counts of the functions, by -pgo-gen. The counts are written to the
+    // file of the environment variable GO2DOTNET_PGO, or default.pgo, when the process exits. go2dotnet -pgo reads the
+    // file. The counts are not exact when multiple programs run at the same time.
+    public static class GoProfile
+    {
+        internal static readonly long[] Funcs = new long[{{.ProfileFuncs}}];
+
+        static GoProfile()
+        {
+            AppDomain.CurrentDomain.ProcessExit += (sender, e) => Write(Environment.GetEnvironmentVariable("GO2DOTNET_PGO") ?? "default.pgo");
+        }
+
+        // Write writes the counts to the file. This is for the hosts where the process doesn't exit normally.
+        public static void Write(string path)
+        {
+            using (var writer = new StreamWriter(path))
+            {
+                Write(writer);
+            }
+        }
+
+        public static void Write(TextWriter writer)
+        {
+            writer.WriteLine("{{.ProfileMagic}} {{.Header.InputSHA256}}");
+            for (int i = 0; i < Funcs.Length; i++)
+            {
+                if (Funcs[i] == 0)
+                {
+                    continue;
+                }
+                writer.WriteLine($"func {i} {Funcs[i]}");
+            }
+        }
     }
+{{- end}}
 
-    sealed class Inst
+    // Inst is an instance of the wasm module. The public methods are the exported functions.
+    public sealed partial class Inst
     {
-        public Inst(Mem mem, IImport import)
+        internal Inst(Mem mem, IImport import)
         {
-             initializeFuncs_();
              mem_ = mem;
              import_ = import;
         }
 
 {{range $value := .Exports}}{{$value.CSharp "        "}}
 {{end}}
-{{range $value := .Funcs}}{{$value.CSharp "        " false true}}
+        // GetExport returns the exported function. If syncRoot is not null, the function holds its lock during the call.
+        internal Delegate GetExport(string name, object syncRoot)
+        {
+{{- if .Exports}}
+            switch (name)
+            {
+{{- range $value := .Exports}}
+            case {{$value.NameConst}}:
+                if (syncRoot != null)
+                {
+                    return {{$value.LockedDelegate "syncRoot"}};
+                }
+                return ({{$value.DelegateType}}){{if $.Static}}Inst{{else}}this{{end}}.{{$value.Ident}};
+{{- end}}
+            }
+{{- end}}
+            return null;
+        }
+{{- if .AOT}}
+
+        // Invoke calls the exported function with the arguments converted into the parameter types.
+        internal object Invoke(string name, object[] args)
+        {
+{{- if .Exports}}
+            switch (name)
+            {
+{{- range $value := .Exports}}
+            case {{$value.NameConst}}:
+{{$value.InvokeCSharp "                "}}
+{{- end}}
+            }
+{{- end}}
+            throw new ArgumentException($"function {name} is not exported", nameof(name));
+        }
+{{- end}}
+
+{{range $value := .InstFuncs}}{{$value.CSharp "        " false true}}
 {{end}}
 {{range $value := .Types}}{{$value.CSharp "        "}}
 {{end}}        private static readonly uint[][] table_ = {
 {{range $value := .Tables}}            new uint[] { {{- range $value2 := $value}}{{$value2}}, {{end}}},
 {{end}}        };
 
-        private void initializeFuncs_()
+{{- if .IndirectSwitch}}
+{{- range $value := .Indirect}}
+
+{{$value.SwitchCSharp "        " $.Static}}
+{{- end}}
+{{- else}}
+{{- range $value := .Indirect}}
+
+        // initializeTable{{$value.Type.Index}}_ creates the delegates at the first call_indirect of the type, so that creating an instance doesn't take time for them.
+        // The delegates are shared by all the instances, as the table is never modified.
+        private static Type{{$value.Type.Index}}[] initializeTable{{$value.Type.Index}}_()
+        {
+            return table{{$value.Type.Index}}_ = new Type{{$value.Type.Index}}[] {
+{{- range $value2 := $value.Elems}}
+                {{$value2}},
+{{- end}}
+            };
+        }
+{{- if $.IndirectCache}}
+
+{{$value.CacheLookupCSharp "        "}}
+{{- end}}
+{{- end}}
+{{range $value := .Indirect}}
+{{$value.MismatchCSharp "        "}}
+{{- end}}
+{{- end}}
+
+        // Save writes the globals and the tables. The tables are written only to detect a snapshot of a different module.
+        internal void Save(BinaryWriter writer)
+        {
+{{- range $value := .Globals}}
+            writer.Write({{if $.Static}}Inst{{else}}this{{end}}.global{{$value.Index}});
+{{- end}}
+            writer.Write(table_.Length);
+            foreach (var table in table_)
+            {
+                writer.Write(table.Length);
+                foreach (var elem in table)
+                {
+                    writer.Write(elem);
+                }
+            }
+        }
+
+        internal void Restore(BinaryReader reader)
         {
-            funcs_ = new object[] {
-{{range $value := .ImportFuncs}}                null,
-{{end}}{{range $value := .Funcs}}                (Type{{.Type.Index}})({{.Identifier}}),
-{{end}}            };
+{{- range $value := .Globals}}
+            var global{{$value.Index}} = reader.{{$value.BinaryReaderMethod}}();
+{{- end}}
+            if (reader.ReadInt32() != table_.Length)
+            {
+                throw new InvalidDataException(Strings.SnapshotMismatch);
+            }
+            foreach (var table in table_)
+            {
+                if (reader.ReadInt32() != table.Length)
+                {
+                    throw new InvalidDataException(Strings.SnapshotMismatch);
+                }
+                foreach (var elem in table)
+                {
+                    if (reader.ReadUInt32() != elem)
+                    {
+                        throw new InvalidDataException(Strings.SnapshotMismatch);
+                    }
+                }
+            }
+{{- range $value := .Globals}}
+            {{if $.Static}}Inst{{else}}this{{end}}.global{{$value.Index}} = global{{$value.Index}};
+{{- end}}
         }
 
 {{range $value := .Globals}}{{$value.CSharp "        "}}
 {{end}}
-        private object[] funcs_;
-        private Mem mem_;
-        private IImport import_;
+{{- if not .IndirectSwitch}}
+{{- range $value := .Indirect}}
+        private static Type{{$value.Type.Index}}[] table{{$value.Type.Index}}_;
+{{- end}}
+{{- end}}
+        private {{if .Static}}static {{end}}Mem mem_;
+        private {{if .Static}}static {{end}}IImport import_;
+    }
+
+    // Strings is the string constants that appear more than once in the generated code.
+    static class Strings
+    {
+        public const string NotRunning = "Go program is not running";
+        public const string SnapshotMismatch = "the snapshot was taken from a different module";
+        public const string NoAllocator = "the module does not export an allocator (malloc)";
+        public const string IndirectCallTypeMismatch = "indirect call type mismatch";
+{{- with .Strings.CSharp "        "}}
+
+        // The names of the imports and the exports.
+{{.}}
+{{- end}}
     }
 
     // The implementation is copied from the Go standard package math/bits, which is under BSD-style license.
     static class Bits
     {
+{{if or (not .Target.Unsafe) (not .Target.CopySign)}}        [StructLayout(LayoutKind.Explicit)]
+        struct SingleBits
+        {
+            [FieldOffset(0)]
+            public int Int32;
+            [FieldOffset(0)]
+            public float Single;
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static float Int32BitsToSingle(int x)
+        {
+            return new SingleBits { Int32 = x }.Single;
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int SingleToInt32Bits(float x)
+        {
+            return new SingleBits { Single = x }.Int32;
+        }
+
+{{end}}{{if not .Target.CopySign}}        public static float CopySign(float x, float y)
+        {
+            return Int32BitsToSingle((SingleToInt32Bits(x) & int.MaxValue) | (SingleToInt32Bits(y) & int.MinValue));
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static double CopySign(double x, double y)
+        {
+            return BitConverter.Int64BitsToDouble((BitConverter.DoubleToInt64Bits(x) & long.MaxValue) | (BitConverter.DoubleToInt64Bits(y) & long.MinValue));
+        }
+
+{{end}}{{if .Target.BitOperations}}        // BitOperations uses the hardware instructions like LZCNT and POPCNT where available.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int LeadingZeros(uint x)
+        {
+            return System.Numerics.BitOperations.LeadingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int LeadingZeros(ulong x)
+        {
+            return System.Numerics.BitOperations.LeadingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int TailingZeros(uint x)
+        {
+            return System.Numerics.BitOperations.TrailingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int TailingZeros(ulong x)
+        {
+            return System.Numerics.BitOperations.TrailingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int PopCount(uint x)
+        {
+            return System.Numerics.BitOperations.PopCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int PopCount(ulong x)
+        {
+            return System.Numerics.BitOperations.PopCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static uint RotateLeft(uint x, int k)
+        {
+            return System.Numerics.BitOperations.RotateLeft(x, k);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static ulong RotateLeft(ulong x, int k)
+        {
+            return System.Numerics.BitOperations.RotateLeft(x, k);
+        }
+    }
+{{else}}        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         public static int LeadingZeros(uint x)
         {
             return 32 - Len(x);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         public static int LeadingZeros(ulong x)
         {
             return 64 - Len(x);
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         public static int TailingZeros(uint x)
         {
             if (x == 0)
             {
                 return 32;
             }
-            return (int)deBruijn32tab[(x&-x)*deBruijn32>>(32-5)];
+            return (int)deBruijn32tab[(uint)((x&(uint)-(int)x)*deBruijn32)>>(32-5)];
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         public static int TailingZeros(ulong x)
         {
             if (x == 0)
@@ -979,6 +4118,38 @@ namespace {{.Namespace}}
             return (int)deBruijn64tab[(x&(ulong)(-(long)x))*deBruijn64>>(64-6)];
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int PopCount(uint x)
+        {
+            x -= (x >> 1) & 0x55555555;
+            x = (x & 0x33333333) + ((x >> 2) & 0x33333333);
+            x = (x + (x >> 4)) & 0x0f0f0f0f;
+            return (int)((x * 0x01010101) >> 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int PopCount(ulong x)
+        {
+            x -= (x >> 1) & 0x5555555555555555;
+            x = (x & 0x3333333333333333) + ((x >> 2) & 0x3333333333333333);
+            x = (x + (x >> 4)) & 0x0f0f0f0f0f0f0f0f;
+            return (int)((x * 0x0101010101010101) >> 56);
+        }
+
+        // The shift counts are masked by 31 or 63 in C#, as in wasm.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static uint RotateLeft(uint x, int k)
+        {
+            return (x << k) | (x >> (32 - k));
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static ulong RotateLeft(ulong x, int k)
+        {
+            return (x << k) | (x >> (64 - k));
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         private static int Len(uint x)
         {
             int n = 0;
@@ -995,10 +4166,11 @@ namespace {{.Namespace}}
             return n + (int)len8tab[x];
         }
 
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
         private static int Len(ulong x)
         {
             int n = 0;
-            if (x >= 1<<32)
+            if (x >= 1UL<<32)
             {
                 x >>= 32;
                 n = 32;
@@ -1051,5 +4223,24 @@ namespace {{.Namespace}}
             54, 26, 40, 15, 34, 20, 31, 10, 25, 14, 19, 9, 13, 8, 7, 6,
         };
     }
+{{end}}}
+`))
+
+// csRuntimeTmpl is a file of the runtime written to the runtime directory by -runtime-files.
+var csRuntimeTmpl = template.Must(csTmpl.New("runtime.cs").Parse(`{{template "header" .}}
+namespace {{.Namespace}}
+{
+{{.Code}}
+}
+`))
+
+// csPartialTmpl is a file that has a part of the functions when the output is split by -split.
+var csPartialTmpl = template.Must(csTmpl.New("partial.cs").Parse(`{{template "header" .}}
+namespace {{.Namespace}}
+{
+    sealed partial class Inst
+    {
+{{range $value := .Funcs}}{{$value.CSharp "        " false true}}
+{{end}}    }
 }
 `))
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// translator is the path of the go2dotnet binary that TestMain builds. The tests run it as a separate process, as
+// the translation parses the flags and writes the output with the global state.
+var translator string
+
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	dir, err := ioutil.TempDir("", "go2dotnet-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	translator = filepath.Join(dir, "go2dotnet")
+	cmd := exec.Command("go", "build", "-o", translator, ".")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "building go2dotnet failed: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
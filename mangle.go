@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxIdentifierLen is the maximum length of a C# identifier (CS0645).
+const maxIdentifierLen = 512
+
+// csharpKeywords is the reserved keywords of C#, which must be prefixed with @ to be used as identifiers.
+var csharpKeywords = map[string]bool{
+	"abstract": true, "as": true, "base": true, "bool": true, "break": true, "byte": true, "case": true, "catch": true,
+	"char": true, "checked": true, "class": true, "const": true, "continue": true, "decimal": true, "default": true,
+	"delegate": true, "do": true, "double": true, "else": true, "enum": true, "event": true, "explicit": true,
+	"extern": true, "false": true, "finally": true, "fixed": true, "float": true, "for": true, "foreach": true,
+	"goto": true, "if": true, "implicit": true, "in": true, "int": true, "interface": true, "internal": true,
+	"is": true, "lock": true, "long": true, "namespace": true, "new": true, "null": true, "object": true,
+	"operator": true, "out": true, "override": true, "params": true, "private": true, "protected": true,
+	"public": true, "readonly": true, "ref": true, "return": true, "sbyte": true, "sealed": true, "short": true,
+	"sizeof": true, "stackalloc": true, "static": true, "string": true, "struct": true, "switch": true, "this": true,
+	"throw": true, "true": true, "try": true, "typeof": true, "uint": true, "ulong": true, "unchecked": true,
+	"unsafe": true, "ushort": true, "using": true, "virtual": true, "void": true, "volatile": true, "while": true,
+}
+
+// reservedIdentifiers is the names that the generated code uses in the scopes of the functions.
+// A function of the same name would hide them or conflict with them.
+var reservedIdentifiers = map[string]bool{
+	"BitConverter":              true,
+	"Bits":                      true,
+	"Debug":                     true,
+	"GetExport":                 true,
+	"Go":                        true,
+	"IImport":                   true,
+	"Import":                    true,
+	"Inst":                      true,
+	"InvalidOperationException": true,
+	"Invoke":                    true,
+	"Math":                      true,
+	"MathF":                     true,
+	"Mem":                       true,
+	"NotImplementedException":   true,
+	"Restore":                   true,
+	"Save":                      true,
+	"System":                    true,
+	"Unsafe":                    true,
+	"go":                        true,
+}
+
+// reservedIdentifierRe matches the names of the variables, the labels, the globals and the delegate types in the
+// generated code.
+var reservedIdentifierRe = regexp.MustCompile(`^(?:local|stack|tmp|arg|label|global|Type)\d`)
+
+// identifierFromString escapes the wasm name into a C# identifier.
+//
+// ASCII letters and digits are kept, except for a leading digit. The other characters are escaped as _XX for
+// Latin-1, _uXXXX for the BMP and _UXXXXXXXX for the others, in lowercase hexadecimal. A byte that is not valid
+// UTF-8 is escaped as _xXX, so that it is distinguished from U+FFFD. The empty name is _.
+//
+// The escapes don't make the identifiers distinct by themselves, e.g. an identifier might be a keyword, reserved or
+// too long. mangler.unique is what guarantees that different functions have different identifiers.
+func identifierFromString(str string) string {
+	if str == "" {
+		return "_"
+	}
+	var ident []byte
+	for i, r := range str {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(str[i:], string(utf8.RuneError)):
+			ident = append(ident, fmt.Sprintf("_x%02x", str[i])...)
+		case '0' <= r && r <= '9' && i > 0, 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+			ident = append(ident, byte(r))
+		case r <= 0xff:
+			ident = append(ident, fmt.Sprintf("_%02x", r)...)
+		case r <= 0xffff:
+			ident = append(ident, fmt.Sprintf("_u%04x", r)...)
+		default:
+			ident = append(ident, fmt.Sprintf("_U%08x", r)...)
+		}
+	}
+	return string(ident)
+}
+
+// originalName returns the wasm name to be written in a comment. A name with characters that are not printable,
+// like a newline, is quoted.
+func originalName(name string) string {
+	for _, r := range name {
+		if !strconv.IsGraphic(r) {
+			return strconv.QuoteToGraphic(name)
+		}
+	}
+	return name
+}
+
+// mangler assigns unique C# identifiers to the wasm functions.
+type mangler struct {
+	used map[string]bool
+}
+
+// newMangler returns a mangler that doesn't assign the given names, e.g. the names of the exported methods.
+func newMangler(reserved []string) *mangler {
+	m := &mangler{
+		used: map[string]bool{},
+	}
+	for _, n := range reserved {
+		m.used[n] = true
+	}
+	return m
+}
+
+// ident returns a new C# identifier for the wasm name.
+//
+// The identifier is identifierFromString's result, prefixed with @ if it is a keyword. If the identifier is too
+// long, reserved or already assigned, it is truncated as needed and suffixed with __1, __2 and so on. As
+// identifierFromString never generates two consecutive underscores, a suffixed identifier never conflicts with
+// an unsuffixed one. The identifiers are deterministic as long as the names are passed in the same order.
+func (m *mangler) ident(name string) string {
+	id := identifierFromString(name)
+	if csharpKeywords[id] {
+		id = "@" + id
+	}
+	return m.unique(id)
+}
+
+// unique returns id as a new C# identifier, or id suffixed as ident does if id is not available.
+func (m *mangler) unique(id string) string {
+	if len(id) <= maxIdentifierLen && !reservedIdentifiers[id] && !reservedIdentifierRe.MatchString(id) && !m.used[id] {
+		m.used[id] = true
+		return id
+	}
+
+	// Leave room for the suffix.
+	if len(id) > maxIdentifierLen-16 {
+		id = id[:maxIdentifierLen-16]
+	}
+	for i := 1; ; i++ {
+		s := fmt.Sprintf("%s__%d", id, i)
+		if !m.used[s] {
+			m.used[s] = true
+			return s
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+)
+
+// TestIdentifierFromString tests that a byte that is not valid UTF-8 is escaped differently from U+FFFD.
+func TestIdentifierFromString(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		want string
+	}{
+		{"", "_"},
+		{"main.f", "main_2ef"},
+		{"0a", "_30a"},
+		{"数", "_u6570"},
+		{"\U0001f600", "_U0001f600"},
+		{"\ufffd", "_ufffd"},
+		{"\xff", "_xff"},
+		{"a\xe6\x95b", "a_xe6_x95b"},
+	} {
+		if got := identifierFromString(c.name); got != c.want {
+			t.Errorf("identifierFromString(%q): got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
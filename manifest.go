@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// The providers of an imported function in the manifest.
+const (
+	// importProviderRuntime is a function of Go's wasm_exec.js implemented by the generated runtime.
+	importProviderRuntime = "runtime"
+
+	// importProviderWASI is a WASI function implemented by the generated runtime.
+	importProviderWASI = "wasi"
+
+	// importProviderStub is a WASI function that is not implemented and returns ENOSYS.
+	importProviderStub = "stub"
+
+	// importProviderHost is a function that the host must supply via IImportResolver.
+	importProviderHost = "host"
+)
+
+// importEntry is an imported function in the manifest.
+type importEntry struct {
+	Module   string   `json:"module"`
+	Name     string   `json:"name"`
+	Params   []string `json:"params"`
+	Results  []string `json:"results"`
+	Provider string   `json:"provider"`
+
+	// Delegate is the C# delegate type the host supplies the function as.
+	Delegate string `json:"delegate"`
+}
+
+// importsManifest is the summary of the imports of the wasm module.
+type importsManifest struct {
+	Wasm    string         `json:"wasm"`
+	Imports []*importEntry `json:"imports"`
+}
+
+// importProvider returns who provides the imported function, in the same way as run chooses the body.
+func importProvider(f *Func) string {
+	if f.ModuleName == wasiModuleName {
+		if _, ok := wasiFuncBodies[f.Wasm.Name]; ok {
+			return importProviderWASI
+		}
+		if f.BodyStr != "" {
+			return importProviderStub
+		}
+		return importProviderHost
+	}
+	if f.BodyStr != "" {
+		return importProviderRuntime
+	}
+	return importProviderHost
+}
+
+// newImportsManifest returns the manifest of the imported functions.
+func newImportsManifest(wasmName string, ifs []*Func) *importsManifest {
+	m := &importsManifest{
+		Wasm:    wasmName,
+		Imports: []*importEntry{},
+	}
+	for _, f := range ifs {
+		e := &importEntry{
+			Module:   f.ModuleName,
+			Name:     f.Wasm.Name,
+			Params:   []string{},
+			Results:  []string{},
+			Provider: importProvider(f),
+			Delegate: delegateType(f.Wasm.Sig),
+		}
+		for _, t := range f.Wasm.Sig.ParamTypes {
+			e.Params = append(e.Params, t.String())
+		}
+		for _, t := range f.Wasm.Sig.ReturnTypes {
+			e.Results = append(e.Results, t.String())
+		}
+		m.Imports = append(m.Imports, e)
+	}
+	return m
+}
+
+// Count returns the number of the imports of the provider.
+func (m *importsManifest) Count(provider string) int {
+	var n int
+	for _, e := range m.Imports {
+		if e.Provider == provider {
+			n++
+		}
+	}
+	return n
+}
+
+// ByProvider returns the imports of the provider.
+func (m *importsManifest) ByProvider(provider string) []*importEntry {
+	var r []*importEntry
+	for _, e := range m.Imports {
+		if e.Provider == provider {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// Signature returns the wasm signature, e.g. "(i32, i64) -> (i32)".
+func (e *importEntry) Signature() string {
+	return fmt.Sprintf("(%s) -> (%s)", strings.Join(e.Params, ", "), strings.Join(e.Results, ", "))
+}
+
+var importsMarkdownTmpl = template.Must(template.New("imports.md").Parse(`# Imports of {{.Wasm}}
+
+{{len .Imports}} imported functions: {{.Count "runtime"}} implemented by the runtime of Go's wasm_exec.js, {{.Count "wasi"}} implemented by the WASI runtime, {{.Count "stub"}} WASI stubs that return ENOSYS, and {{.Count "host"}} to be supplied by the host.
+{{- with .ByProvider "host"}}
+
+## Supplied by the host
+
+Pass these functions to the constructor of Go, as a dictionary keyed by (module, name) or an IImportResolver. Calling a function that is not supplied throws NotImplementedException.
+
+| Module | Name | Signature | Delegate type |
+| --- | --- | --- | --- |
+{{- range .}}
+| {{.Module}} | {{.Name}} | {{.Signature}} | {{.Delegate}} |
+{{- end}}
+{{- end}}
+{{- with .ByProvider "stub"}}
+
+## Not implemented (ENOSYS)
+
+| Module | Name | Signature |
+| --- | --- | --- |
+{{- range .}}
+| {{.Module}} | {{.Name}} | {{.Signature}} |
+{{- end}}
+{{- end}}
+{{- with .ByProvider "runtime"}}
+
+## Implemented by the runtime
+
+| Module | Name | Signature |
+| --- | --- | --- |
+{{- range .}}
+| {{.Module}} | {{.Name}} | {{.Signature}} |
+{{- end}}
+{{- end}}
+{{- with .ByProvider "wasi"}}
+
+## Implemented by the WASI runtime
+
+| Module | Name | Signature |
+| --- | --- | --- |
+{{- range .}}
+| {{.Module}} | {{.Name}} | {{.Signature}} |
+{{- end}}
+{{- end}}
+`))
+
+// write writes the manifest as <name>.imports.json and <name>.imports.md to the directory.
+func (m *importsManifest) write(dir string, name string) error {
+	var j bytes.Buffer
+	enc := json.NewEncoder(&j)
+	// The delegate types have angle brackets.
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".imports.json"), j.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	var md bytes.Buffer
+	if err := importsMarkdownTmpl.Execute(&md, m); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".imports.md"), md.Bytes(), 0644); err != nil {
+		return err
+	}
+	return nil
+}
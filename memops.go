@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// memFuncBodies is the C# bodies that replace the Go runtime's functions to clear and to copy memory, by the function
+// names.
+//
+// Go's wasm backend implements them with memory.fill and memory.copy, or with loops of loads and stores in older
+// versions. Mem.Fill and Mem.Copy use Span<T>'s vectorized methods where available instead. The functions follow
+// Go's ABI: the arguments are in the stack frame at SP+8, and the callee pops the return address.
+var memFuncBodies = map[string]string{
+	// func memmove(to, from unsafe.Pointer, n uintptr)
+	"runtime.memmove": `    var sp = global0;
+    mem_.Copy((int)mem_.LoadInt64(sp + 8), (int)mem_.LoadInt64(sp + 16), (int)mem_.LoadInt64(sp + 24));
+    global0 = sp + 8;
+    return 0;`,
+
+	// func memclrNoHeapPointers(ptr unsafe.Pointer, n uintptr)
+	"runtime.memclrNoHeapPointers": `    var sp = global0;
+    mem_.Fill((int)mem_.LoadInt64(sp + 8), 0, (int)mem_.LoadInt64(sp + 16));
+    global0 = sp + 8;
+    return 0;`,
+}
+
+// memInstrBodies is the C# bodies that replace the helper functions of memory.copy and memory.fill that
+// lowerExtensions appends, by the function names. The parameters are the operands of the instructions.
+var memInstrBodies = map[string]string{
+	extensionFuncPrefix + "memory.copy": `    mem_.Copy(local0, local1, local2);`,
+	extensionFuncPrefix + "memory.fill": `    mem_.Fill(local0, (byte)local1, local2);`,
+}
+
+// memFuncBody returns the C# body that replaces the function's body, if the function is one of the Go runtime's
+// functions in memFuncBodies or one of the helper functions in memInstrBodies.
+func memFuncBody(f *Func) (string, bool) {
+	sig := f.Wasm.Sig
+	if body, ok := memInstrBodies[f.Wasm.Name]; ok {
+		if len(sig.ParamTypes) != 3 || len(sig.ReturnTypes) != 0 {
+			return "", false
+		}
+		for _, t := range sig.ParamTypes {
+			if t != wasm.ValueTypeI32 {
+				return "", false
+			}
+		}
+		return body, true
+	}
+	body, ok := memFuncBodies[f.Wasm.Name]
+	if !ok {
+		return "", false
+	}
+	// Every Go function takes PC_B and returns whether to unwind the stack.
+	if len(sig.ParamTypes) != 1 || sig.ParamTypes[0] != wasm.ValueTypeI32 || len(sig.ReturnTypes) != 1 || sig.ReturnTypes[0] != wasm.ValueTypeI32 {
+		return "", false
+	}
+	return body, true
+}
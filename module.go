@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// modInfoRe matches the main module line of the build information that the Go linker embeds, e.g.
+	// "mod\texample.com/foo\tv1.2.3\t".
+	modInfoRe = regexp.MustCompile(`(?m)^mod\t([^\t\n]+)\t([^\t\n]+)`)
+
+	// vcsRevisionRe and vcsModifiedRe match the version control settings of the build information.
+	vcsRevisionRe = regexp.MustCompile(`(?m)^build\tvcs\.revision=([0-9A-Za-z]+)$`)
+	vcsModifiedRe = regexp.MustCompile(`(?m)^build\tvcs\.modified=true$`)
+
+	// releaseVersionRe matches a release version of Go modules, e.g. "v1.2.3".
+	releaseVersionRe = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)(?:\+incompatible)?$`)
+)
+
+// goModule is the Go main module the wasm file is built from.
+type goModule struct {
+	// Path is the module path, e.g. "example.com/foo".
+	Path string
+
+	// Version is the module version, e.g. "v1.2.3", or "(devel)" or an empty string if unknown.
+	Version string
+
+	// Revision is the revision of the version control system, or an empty string if unknown.
+	// Modified reports whether the working tree had modifications.
+	Revision string
+	Modified bool
+}
+
+// moduleFromData returns the main module in the build information embedded in the data, or nil if none.
+func moduleFromData(data []Data) *goModule {
+	for _, d := range data {
+		if !bytes.Contains(d.Data, []byte("\nmod\t")) {
+			continue
+		}
+		m := modInfoRe.FindSubmatch(d.Data)
+		if m == nil {
+			continue
+		}
+		mod := &goModule{
+			Path:    string(m[1]),
+			Version: string(m[2]),
+		}
+		if m := vcsRevisionRe.FindSubmatch(d.Data); m != nil {
+			mod.Revision = string(m[1])
+			mod.Modified = vcsModifiedRe.Match(d.Data)
+		}
+		return mod
+	}
+	return nil
+}
+
+// moduleFromSource returns the main module of the Go package in the directory by go list -m, or nil if the package
+// is not in a module or the go command is not available.
+func moduleFromSource(dir string) *goModule {
+	cmd := exec.Command("go", "list", "-m", "-json")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	var m struct {
+		Path    string
+		Version string
+	}
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil
+	}
+	return &goModule{
+		Path:    m.Path,
+		Version: m.Version,
+	}
+}
+
+// NuGetVersion returns the version as a NuGet version, or an empty string if the version is unknown, e.g. "(devel)".
+func (m *goModule) NuGetVersion() string {
+	v := m.Version
+	if !strings.HasPrefix(v, "v") {
+		return ""
+	}
+	// A Go version is a semantic version prefixed with "v". NuGet accepts semantic versions.
+	v = strings.TrimPrefix(v, "v")
+	v = strings.TrimSuffix(v, "+incompatible")
+	return v
+}
+
+// AssemblyVersion returns the version as an assembly version, e.g. "1.2.3.0", or an empty string if the version is
+// not a release version. A pseudo-version or a pre-release doesn't have a meaningful assembly version.
+func (m *goModule) AssemblyVersion() string {
+	match := releaseVersionRe.FindStringSubmatch(m.Version)
+	if match == nil {
+		return ""
+	}
+	for _, n := range match[1:] {
+		// Each part of an assembly version is at most 65534.
+		if v, err := strconv.Atoi(n); err != nil || v > 65534 {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s.%s.%s.0", match[1], match[2], match[3])
+}
+
+// InformationalVersion returns the version with the revision, e.g. "1.2.3+0123456789ab".
+func (m *goModule) InformationalVersion() string {
+	v := m.NuGetVersion()
+	if v == "" {
+		v = "0.0.0-devel"
+	}
+	// A pseudo-version has the revision already.
+	rev := m.Revision
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if rev != "" && !strings.Contains(v, rev) {
+		sep := "+"
+		if strings.Contains(v, "+") {
+			sep = "."
+		}
+		v += sep + rev
+		if m.Modified {
+			v += ".modified"
+		}
+	}
+	return v
+}
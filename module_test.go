@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+)
+
+// TestInformationalVersion tests that the revision in the informational version is shortened to 12 characters, and
+// that a pseudo-version doesn't have it twice.
+func TestInformationalVersion(t *testing.T) {
+	const rev = "0123456789abcdef0123456789abcdef01234567"
+	for _, c := range []struct {
+		module goModule
+		want   string
+	}{
+		{goModule{Version: "v1.2.3", Revision: rev}, "1.2.3+0123456789ab"},
+		{goModule{Version: "v1.2.3", Revision: rev, Modified: true}, "1.2.3+0123456789ab.modified"},
+		{goModule{Version: "(devel)", Revision: rev}, "0.0.0-devel+0123456789ab"},
+		{goModule{Version: "v0.0.0-20200226200811-0123456789ab", Revision: rev}, "0.0.0-20200226200811-0123456789ab"},
+		{goModule{Version: "v1.2.3"}, "1.2.3"},
+	} {
+		if got := c.module.InformationalVersion(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.module, got, c.want)
+		}
+	}
+}
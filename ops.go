@@ -5,7 +5,6 @@ package main
 import (
 	"fmt"
 	"math"
-	"os"
 	"runtime/debug"
 	"strings"
 
@@ -193,12 +192,14 @@ func (b *BlockStack) HasIndex() bool {
 	return b.index[len(b.index)-1].Len() > 0
 }
 
-func (f *Func) bodyToCSharp() ([]string, error) {
+// bodyToCSharp returns the C# statements of the function body, and the C# types of the stack variables declared with
+// var.
+func (f *Func) bodyToCSharp() (_ []string, _ map[string]string, err error) {
+	// A valid body might still be beyond the translation, e.g. an instruction after unreachable that pops the
+	// operands nobody pushed. Report it as an error with the stack trace instead of crashing.
 	defer func() {
-		if err := recover(); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			debug.PrintStack()
-			panic(err)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: the translation failed: %v\n%s", f.Wasm.Name, r, debug.Stack())
 		}
 	}()
 
@@ -208,12 +209,14 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 
 	dis, err := disasm.NewDisassembly(f.Wasm, f.Mod)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var body []string
 	blockStack := &BlockStack{}
 	var tmpidx int
+	// callSites is the number of the call sites of call_indirect with caches, by -indirect=cache.
+	var callSites int
 
 	appendBody := func(str string, args ...interface{}) {
 		str = fmt.Sprintf(str, args...)
@@ -225,6 +228,22 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		body = append(body, indent+str)
 	}
 
+	if f.ProfileGen {
+		appendBody("GoProfile.Funcs[%d]++;", f.Index)
+	}
+
+	// varTypes is the C# types of the stack variables declared with var.
+	varTypes := map[string]string{}
+
+	// declType returns the type to declare the stack variable of the wasm type.
+	declType := func(t wasm.ValueType, idx string) string {
+		if f.Style != nil && f.Style.ExplicitTypes {
+			return wasmTypeToReturnType(t).CSharp()
+		}
+		varTypes["stack"+idx] = wasmTypeToReturnType(t).CSharp()
+		return "var"
+	}
+
 	gotoOrReturn := func(level int) string {
 		if l, _, ok := blockStack.PeepLevel(level); ok {
 			return fmt.Sprintf("goto label%d;", l)
@@ -238,7 +257,18 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		}
 	}
 
-	for _, instr := range dis.Code {
+	// addrs is the addresses of the instructions to look up the source lines by.
+	var addrs []uint64
+	if f.Lines != nil {
+		addrs, err = f.Lines.instrAddrs(f.Index-len(f.Mod.Import.Entries), f.Wasm.Body.Code, dis.Code)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var lastLine string
+
+	for i, instr := range dis.Code {
+		start := len(body)
 		switch instr.Op.Code {
 		case operators.Unreachable:
 			appendBody(`Debug.Assert(false, "not reached");`)
@@ -296,13 +326,13 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			}
 		case operators.Br:
 			if _, _, ret := blockStack.Peep(); ret != "" {
-				return nil, fmt.Errorf("br with a returning value is not implemented yet")
+				return nil, nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
 			level := instr.Immediates[0].(uint32)
 			appendBody(gotoOrReturn(int(level)))
 		case operators.BrIf:
 			if _, _, ret := blockStack.Peep(); ret != "" {
-				return nil, fmt.Errorf("br_if with a returning value is not implemented yet")
+				return nil, nil, fmt.Errorf("br_if with a returning value is not implemented yet")
 			}
 			level := instr.Immediates[0].(uint32)
 			appendBody("if (stack%s != 0)", blockStack.PopIndex())
@@ -313,17 +343,56 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			appendBody("}")
 		case operators.BrTable:
 			if _, _, ret := blockStack.Peep(); ret != "" {
-				return nil, fmt.Errorf("br_table with a returning value is not implemented yet")
+				return nil, nil, fmt.Errorf("br_table with a returning value is not implemented yet")
+			}
+			idx := blockStack.PopIndex()
+			n := int(instr.Immediates[0].(uint32))
+			def := int(instr.Immediates[n+1].(uint32))
+			levels := make([]int, n)
+			for i := range levels {
+				levels[i] = int(instr.Immediates[1+i].(uint32))
 			}
-			appendBody("switch (stack%s)", blockStack.PopIndex())
+			runs := brTableRuns(levels, def)
+			if len(runs) > 1 && 2*len(runs) <= n {
+				// A sparse table is a binary search on the runs of the indices to the same level. An index out of
+				// the table is in the last run to the default level, as br_table takes the index as unsigned.
+				var ladder func(runs []brTableRun)
+				ladder = func(runs []brTableRun) {
+					if len(runs) == 1 {
+						appendBody(gotoOrReturn(runs[0].level))
+						return
+					}
+					mid := len(runs) / 2
+					appendBody("if ((uint)stack%s < %du)", idx, runs[mid].start)
+					appendBody("{")
+					blockStack.IndentTemporarily()
+					ladder(runs[:mid])
+					blockStack.UnindentTemporarily()
+					appendBody("}")
+					ladder(runs[mid:])
+				}
+				ladder(runs)
+				break
+			}
+			appendBody("switch (stack%s)", idx)
 			appendBody("{")
-			len := int(instr.Immediates[0].(uint32))
-			for i := 0; i < len; i++ {
-				level := int(instr.Immediates[1+i].(uint32))
-				appendBody("case %d: %s", i, gotoOrReturn(int(level)))
+			// The cases to the same level share a section, and the cases to the default level are omitted. Then the
+			// C# compiler emits a jump table for the dense cases.
+			var caseLevels []int
+			cases := map[int][]string{}
+			for i, level := range levels {
+				if level == def {
+					continue
+				}
+				if _, ok := cases[level]; !ok {
+					caseLevels = append(caseLevels, level)
+				}
+				cases[level] = append(cases[level], fmt.Sprintf("case %d:", i))
+			}
+			for _, level := range caseLevels {
+				appendBody("%s %s", strings.Join(cases[level], " "), gotoOrReturn(level))
 			}
-			level := int(instr.Immediates[len+1].(uint32))
-			appendBody("default: %s", gotoOrReturn(int(level)))
+			appendBody("default: %s", gotoOrReturn(def))
 			appendBody("}")
 		case operators.Return:
 			switch len(sig.ReturnTypes) {
@@ -343,14 +412,15 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 
 			var ret string
 			if len(f.Wasm.Sig.ReturnTypes) > 0 {
-				ret = fmt.Sprintf("var stack%s = ", blockStack.PushIndex())
+				dst := blockStack.PushIndex()
+				ret = fmt.Sprintf("%s stack%s = ", declType(f.Wasm.Sig.ReturnTypes[0], dst), dst)
 			}
 
 			var imp string
 			if f.Import {
 				imp = "import_."
 			}
-			appendBody("%s%s%s(%s);", ret, imp, identifierFromString(f.Wasm.Name), strings.Join(args, ", "))
+			appendBody("%s%s%s(%s);", ret, imp, f.Identifier(), strings.Join(args, ", "))
 		case operators.CallIndirect:
 			idx := blockStack.PopIndex()
 			typeid := instr.Immediates[0].(uint32)
@@ -360,13 +430,29 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			for i := range t.Sig.ParamTypes {
 				args[len(t.Sig.ParamTypes)-i-1] = fmt.Sprintf("stack%s", blockStack.PopIndex())
 			}
+			// The delegates of the tables take the instance.
+			delegateArgs := args
+			if !f.Static {
+				delegateArgs = append([]string{"this"}, args...)
+			}
 
 			var ret string
 			if len(t.Sig.ReturnTypes) > 0 {
-				ret = fmt.Sprintf("var stack%s = ", blockStack.PushIndex())
+				dst := blockStack.PushIndex()
+				ret = fmt.Sprintf("%s stack%s = ", declType(t.Sig.ReturnTypes[0], dst), dst)
 			}
 
-			appendBody("%s((Type%d)(funcs_[table_[0][stack%s]]))(%s);", ret, typeid, idx, strings.Join(args, ", "))
+			if f.IndirectSwitch {
+				appendBody("%scallIndirect%d_(stack%s%s);", ret, typeid, idx, strings.Join(append([]string{""}, args...), ", "))
+			} else if f.IndirectCache {
+				// The callee of the last call at this call site is called without the table lookup if the index is
+				// the same.
+				site := fmt.Sprintf("site%d_%s_", callSites, f.Identifier())
+				callSites++
+				appendBody("%s(stack%[2]s == %[3]sindex ? %[3]s : lookupTable%[4]d_(stack%[2]s, ref %[3]sindex, ref %[3]s))(%[5]s);", ret, idx, site, typeid, strings.Join(delegateArgs, ", "))
+			} else {
+				appendBody("%s(table%[2]d_ ?? initializeTable%[2]d_())[stack%[3]s](%[4]s);", ret, typeid, idx, strings.Join(delegateArgs, ", "))
+			}
 
 		case operators.Drop:
 			blockStack.PopIndex()
@@ -378,7 +464,7 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 
 		case operators.GetLocal:
 			idx := blockStack.PushIndex()
-			appendBody("var stack%s = local%d;", idx, instr.Immediates[0])
+			appendBody("%s stack%s = local%d;", declType(f.localType(int(instr.Immediates[0].(uint32))), idx), idx, instr.Immediates[0])
 		case operators.SetLocal:
 			idx := blockStack.PopIndex()
 			appendBody("local%d = stack%s;", instr.Immediates[0], idx)
@@ -387,7 +473,7 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			appendBody("local%d = stack%s;", instr.Immediates[0], idx)
 		case operators.GetGlobal:
 			idx := blockStack.PushIndex()
-			appendBody("var stack%s = global%d;", idx, instr.Immediates[0])
+			appendBody("%s stack%s = global%d;", declType(f.Mod.Global.Globals[instr.Immediates[0].(uint32)].Type.Type, idx), idx, instr.Immediates[0])
 		case operators.SetGlobal:
 			idx := blockStack.PopIndex()
 			appendBody("global%d = stack%s;", instr.Immediates[0], idx)
@@ -510,7 +596,7 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			appendBody("mem_.StoreInt32(stack%s + %d, (int)stack%s);", addr, offset, idx)
 
 		case operators.CurrentMemory:
-			appendBody("int stack%s = mem_.Size;", blockStack.PushIndex())
+			appendBody("int stack%s = mem_.Pages;", blockStack.PushIndex())
 		case operators.GrowMemory:
 			delta := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
@@ -529,7 +615,11 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			} else {
 				bits := math.Float32bits(v)
 				appendBody("uint tmp%d = %d; // %f", tmpidx, bits, v)
-				appendBody("float stack%s = Unsafe.As<uint, float>(ref tmp%d);", idx, tmpidx)
+				if f.Target.Unsafe {
+					appendBody("float stack%s = Unsafe.As<uint, float>(ref tmp%d);", idx, tmpidx)
+				} else {
+					appendBody("float stack%s = Bits.Int32BitsToSingle((int)tmp%d);", idx, tmpidx)
+				}
 				tmpidx++
 			}
 		case operators.F64Const:
@@ -539,7 +629,11 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			} else {
 				bits := math.Float64bits(v)
 				appendBody("ulong tmp%d = %d; // %f", tmpidx, bits, v)
-				appendBody("double stack%s = Unsafe.As<ulong, double>(ref tmp%d);", idx, tmpidx)
+				if f.Target.Unsafe {
+					appendBody("double stack%s = Unsafe.As<ulong, double>(ref tmp%d);", idx, tmpidx)
+				} else {
+					appendBody("double stack%s = BitConverter.Int64BitsToDouble((long)tmp%d);", idx, tmpidx)
+				}
 				tmpidx++
 			}
 
@@ -719,7 +813,8 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = Bits.TailingZeros((uint)stack%[1]s);", idx)
 		case operators.I32Popcnt:
-			return nil, fmt.Errorf("I32Popcnt is not implemented")
+			idx := blockStack.PeepIndex()
+			appendBody("stack%[1]s = Bits.PopCount((uint)stack%[1]s);", idx)
 		case operators.I32Add:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
@@ -743,7 +838,8 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.I32RemS:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
-			appendBody("stack%s %%= stack%s;", dst, arg)
+			// C# throws OverflowException for MinValue % -1, while the result is 0 in Wasm.
+			appendBody("stack%s = stack%s == -1 ? 0 : stack%s %% stack%s;", dst, arg, dst, arg)
 		case operators.I32RemU:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
@@ -773,9 +869,13 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			dst := blockStack.PeepIndex()
 			appendBody("stack%[1]s = (int)((uint)stack%[1]s >> stack%[2]s);", dst, arg)
 		case operators.I32Rotl:
-			return nil, fmt.Errorf("I32Rotl is not implemented")
+			arg := blockStack.PopIndex()
+			dst := blockStack.PeepIndex()
+			appendBody("stack%[1]s = (int)Bits.RotateLeft((uint)stack%[1]s, stack%[2]s);", dst, arg)
 		case operators.I32Rotr:
-			return nil, fmt.Errorf("I32Rotr is not implemented")
+			arg := blockStack.PopIndex()
+			dst := blockStack.PeepIndex()
+			appendBody("stack%[1]s = (int)Bits.RotateLeft((uint)stack%[1]s, -stack%[2]s);", dst, arg)
 		case operators.I64Clz:
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = (long)Bits.LeadingZeros((ulong)stack%[1]s);", idx)
@@ -783,7 +883,8 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = (long)Bits.TailingZeros((ulong)stack%[1]s);", idx)
 		case operators.I64Popcnt:
-			return nil, fmt.Errorf("I64Popcnt is not implemented")
+			idx := blockStack.PeepIndex()
+			appendBody("stack%[1]s = (long)Bits.PopCount((ulong)stack%[1]s);", idx)
 		case operators.I64Add:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
@@ -807,7 +908,8 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.I64RemS:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
-			appendBody("stack%s %%= stack%s;", dst, arg)
+			// C# throws OverflowException for MinValue % -1, while the result is 0 in Wasm.
+			appendBody("stack%s = stack%s == -1 ? 0 : stack%s %% stack%s;", dst, arg, dst, arg)
 		case operators.I64RemU:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
@@ -837,9 +939,13 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			dst := blockStack.PeepIndex()
 			appendBody("stack%[1]s = (long)((ulong)stack%[1]s >> (int)stack%[2]s);", dst, arg)
 		case operators.I64Rotl:
-			return nil, fmt.Errorf("I64Rotl is not implemented")
+			arg := blockStack.PopIndex()
+			dst := blockStack.PeepIndex()
+			appendBody("stack%[1]s = (long)Bits.RotateLeft((ulong)stack%[1]s, (int)stack%[2]s);", dst, arg)
 		case operators.I64Rotr:
-			return nil, fmt.Errorf("I64Rotr is not implemented")
+			arg := blockStack.PopIndex()
+			dst := blockStack.PeepIndex()
+			appendBody("stack%[1]s = (long)Bits.RotateLeft((ulong)stack%[1]s, -(int)stack%[2]s);", dst, arg)
 		case operators.F32Abs:
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = Math.Abs(stack%[1]s);", idx)
@@ -848,18 +954,20 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			appendBody("stack%[1]s = -stack%[1]s;", idx)
 		case operators.F32Ceil:
 			idx := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.Ceil(stack%[1]s);", idx)
+			appendBody("stack%[1]s = %[2]s;", idx, f.float32Math("Ceiling", "stack"+idx))
 		case operators.F32Floor:
 			idx := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.Floor(stack%[1]s);", idx)
+			appendBody("stack%[1]s = %[2]s;", idx, f.float32Math("Floor", "stack"+idx))
 		case operators.F32Trunc:
 			idx := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.Truncate(stack%[1]s);", idx)
+			appendBody("stack%[1]s = %[2]s;", idx, f.float32Math("Truncate", "stack"+idx))
 		case operators.F32Nearest:
-			return nil, fmt.Errorf("F32Nearest is not implemented yet")
+			// Round rounds a midpoint to the even integer by default, like nearest.
+			idx := blockStack.PeepIndex()
+			appendBody("stack%[1]s = %[2]s;", idx, f.float32Math("Round", "stack"+idx))
 		case operators.F32Sqrt:
 			idx := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.Sqrt(stack%[1]s);", idx)
+			appendBody("stack%[1]s = %[2]s;", idx, f.float32Math("Sqrt", "stack"+idx))
 		case operators.F32Add:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
@@ -887,7 +995,7 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.F32Copysign:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.CopySign(stack%[1]s, stack%[2]s);", dst, arg)
+			appendBody("stack%[1]s = %[3]s.CopySign(stack%[1]s, stack%[2]s);", dst, arg, f.copySignClass(true))
 		case operators.F64Abs:
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = Math.Abs(stack%[1]s);", idx)
@@ -896,7 +1004,7 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			appendBody("stack%[1]s = -stack%[1]s;", idx)
 		case operators.F64Ceil:
 			idx := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.Ceil(stack%[1]s);", idx)
+			appendBody("stack%[1]s = Math.Ceiling(stack%[1]s);", idx)
 		case operators.F64Floor:
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = Math.Floor(stack%[1]s);", idx)
@@ -904,7 +1012,9 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = Math.Truncate(stack%[1]s);", idx)
 		case operators.F64Nearest:
-			return nil, fmt.Errorf("F64Nearest is not implemented yet")
+			// Round rounds a midpoint to the even integer by default, like nearest.
+			idx := blockStack.PeepIndex()
+			appendBody("stack%[1]s = Math.Round(stack%[1]s);", idx)
 		case operators.F64Sqrt:
 			idx := blockStack.PeepIndex()
 			appendBody("stack%[1]s = Math.Sqrt(stack%[1]s);", idx)
@@ -935,7 +1045,7 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.F64Copysign:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PeepIndex()
-			appendBody("stack%[1]s = Math.CopySign(stack%[1]s, stack%[2]s);", dst, arg)
+			appendBody("stack%[1]s = %[3]s.CopySign(stack%[1]s, stack%[2]s);", dst, arg, f.copySignClass(false))
 
 		case operators.I32WrapI64:
 			arg := blockStack.PopIndex()
@@ -944,19 +1054,19 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.I32TruncSF32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("int stack%s = (int)Math.Truncate(stack%s);", dst, arg)
+			appendBody("int stack%s = checked((int)stack%s);", dst, arg)
 		case operators.I32TruncUF32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("int stack%s = (int)((uint)Math.Truncate(stack%s));", dst, arg)
+			appendBody("int stack%s = (int)checked((uint)stack%s);", dst, arg)
 		case operators.I32TruncSF64:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("int stack%s = (int)Math.Truncate(stack%s);", dst, arg)
+			appendBody("int stack%s = checked((int)stack%s);", dst, arg)
 		case operators.I32TruncUF64:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("int stack%s = (int)((uint)Math.Truncate(stack%s));", dst, arg)
+			appendBody("int stack%s = (int)checked((uint)stack%s);", dst, arg)
 		case operators.I64ExtendSI32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
@@ -964,23 +1074,23 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.I64ExtendUI32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("long stack%s = (long)((ulong)stack%s);", dst, arg)
+			appendBody("long stack%s = (long)(uint)stack%s;", dst, arg)
 		case operators.I64TruncSF32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("long stack%s = (long)Math.Truncate(stack%s);", dst, arg)
+			appendBody("long stack%s = checked((long)stack%s);", dst, arg)
 		case operators.I64TruncUF32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("long stack%s = (long)((ulong)Math.Truncate(stack%s));", dst, arg)
+			appendBody("long stack%s = (long)checked((ulong)stack%s);", dst, arg)
 		case operators.I64TruncSF64:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("long stack%s = (long)Math.Truncate(stack%s);", dst, arg)
+			appendBody("long stack%s = checked((long)stack%s);", dst, arg)
 		case operators.I64TruncUF64:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("long stack%s = (long)((ulong)Math.Truncate(stack%s));", dst, arg)
+			appendBody("long stack%s = (long)checked((ulong)stack%s);", dst, arg)
 		case operators.F32ConvertSI32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
@@ -1016,23 +1126,41 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 		case operators.F64ConvertUI64:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
-			appendBody("double stack%s = (double)((long)stack%s);", dst, arg)
+			appendBody("double stack%s = (double)((ulong)stack%s);", dst, arg)
 		case operators.F64PromoteF32:
 			arg := blockStack.PopIndex()
 			dst := blockStack.PushIndex()
 			appendBody("double stack%s = (double)stack%s;", dst, arg)
 
 		case operators.I32ReinterpretF32:
-			return nil, fmt.Errorf("I32ReinterpretF32 is not implemented yet")
+			return nil, nil, fmt.Errorf("I32ReinterpretF32 is not implemented yet")
 		case operators.I64ReinterpretF64:
-			return nil, fmt.Errorf("I64ReinterpretF64 is not implemented yet")
+			return nil, nil, fmt.Errorf("I64ReinterpretF64 is not implemented yet")
 		case operators.F32ReinterpretI32:
-			return nil, fmt.Errorf("F32ReinterpretI32 is not implemented yet")
+			return nil, nil, fmt.Errorf("F32ReinterpretI32 is not implemented yet")
 		case operators.F64ReinterpretI64:
-			return nil, fmt.Errorf("F64ReinterpretI64 is not implemented yet")
+			return nil, nil, fmt.Errorf("F64ReinterpretI64 is not implemented yet")
 
 		default:
-			return nil, fmt.Errorf("unexpected operator: %v", instr.Op)
+			return nil, nil, fmt.Errorf("unexpected operator: %v", instr.Op)
+		}
+
+		if addrs == nil || len(body) == start {
+			continue
+		}
+		// The block structure belongs to the surrounding instructions.
+		switch instr.Op.Code {
+		case operators.Block, operators.Loop, operators.Else, operators.End:
+			continue
+		}
+		row, ok := f.Lines.lookup(addrs[i])
+		if !ok {
+			continue
+		}
+		if d := lineDirective(row); d != lastLine {
+			indent := body[start][:len(body[start])-len(strings.TrimLeft(body[start], " "))]
+			body = append(body[:start], append([]string{indent + d}, body[start:]...)...)
+			lastLine = d
 		}
 	}
 	switch len(sig.ReturnTypes) {
@@ -1050,8 +1178,164 @@ func (f *Func) bodyToCSharp() ([]string, error) {
 			appendBody(`return 0;`)
 		}
 	default:
-		return nil, fmt.Errorf("unexpected num of return types: %d", len(sig.ReturnTypes))
+		return nil, nil, fmt.Errorf("unexpected num of return types: %d", len(sig.ReturnTypes))
 	}
 
-	return body, nil
+	if f.PointerMemory {
+		if end := constMemoryEnd(dis.Code); end > 0 {
+			body = append([]string{fmt.Sprintf("    mem_.Require(%d);", end)}, body...)
+		}
+	}
+
+	return body, varTypes, nil
+}
+
+// memoryAccessSizes is the number of bytes that the load and store instructions access.
+var memoryAccessSizes = map[byte]int64{
+	operators.I32Load:    4,
+	operators.I64Load:    8,
+	operators.F32Load:    4,
+	operators.F64Load:    8,
+	operators.I32Load8s:  1,
+	operators.I32Load8u:  1,
+	operators.I32Load16s: 2,
+	operators.I32Load16u: 2,
+	operators.I64Load8s:  1,
+	operators.I64Load8u:  1,
+	operators.I64Load16s: 2,
+	operators.I64Load16u: 2,
+	operators.I64Load32s: 4,
+	operators.I64Load32u: 4,
+	operators.I32Store:   4,
+	operators.I64Store:   8,
+	operators.F32Store:   4,
+	operators.F64Store:   8,
+	operators.I32Store8:  1,
+	operators.I32Store16: 2,
+	operators.I64Store8:  1,
+	operators.I64Store16: 2,
+	operators.I64Store32: 4,
+}
+
+// constMemoryEnd returns the end of the memory that the loads and stores at constant addresses access, or 0 if there
+// are no such accesses. An address is constant if it is pushed by i32.const right before a load, or right before the
+// instruction that pushes the value of a store.
+//
+// As the memory never shrinks, checking the end once at the start of the function is enough for these accesses.
+func constMemoryEnd(code []disasm.Instr) int64 {
+	var end int64
+	for i, instr := range code {
+		size, ok := memoryAccessSizes[instr.Op.Code]
+		if !ok {
+			continue
+		}
+		j := i - 1
+		// The stores are after the loads in the opcodes.
+		if instr.Op.Code >= operators.I32Store {
+			// The value is pushed after the address.
+			if j < 0 {
+				continue
+			}
+			switch code[j].Op.Code {
+			case operators.I32Const, operators.I64Const, operators.F32Const, operators.F64Const, operators.GetLocal, operators.GetGlobal:
+			default:
+				continue
+			}
+			j--
+		}
+		if j < 0 || code[j].Op.Code != operators.I32Const {
+			continue
+		}
+		e := int64(uint32(code[j].Immediates[0].(int32))) + int64(instr.Immediates[1].(uint32)) + size
+		if e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// methodImpl returns the MethodImplOptions of the function by its number of instructions, or an empty string.
+func (f *Func) methodImpl() (string, error) {
+	if f.AggressiveInlining == 0 && f.AggressiveOptimization == 0 && !f.Hot {
+		return "", nil
+	}
+	n, err := f.numInstrs()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case n <= f.AggressiveInlining && f.AggressiveInlining > 0:
+		return "MethodImplOptions.AggressiveInlining", nil
+	case f.AggressiveOptimization > 0 && n >= f.AggressiveOptimization:
+		return "MethodImplOptions.AggressiveOptimization", nil
+	case f.Hot && f.Target.AggressiveOptimization:
+		// The hot function by -pgo is compiled with full optimization at once.
+		return "MethodImplOptions.AggressiveOptimization", nil
+	}
+	return "", nil
+}
+
+// numInstrs returns the number of the instructions of the function's body.
+func (f *Func) numInstrs() (int, error) {
+	instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+	if err != nil {
+		return 0, err
+	}
+	return len(instrs), nil
+}
+
+// localType returns the wasm type of the local variable, including the parameters.
+func (f *Func) localType(idx int) wasm.ValueType {
+	if idx < len(f.Wasm.Sig.ParamTypes) {
+		return f.Wasm.Sig.ParamTypes[idx]
+	}
+	idx -= len(f.Wasm.Sig.ParamTypes)
+	for _, e := range f.Wasm.Body.Locals {
+		if idx < int(e.Count) {
+			return e.Type
+		}
+		idx -= int(e.Count)
+	}
+	panic("not reached")
+}
+
+// float32Math returns a C# expression to call the float version of the given System.Math method.
+func (f *Func) float32Math(method string, arg string) string {
+	if f.Target.MathF {
+		return fmt.Sprintf("MathF.%s(%s)", method, arg)
+	}
+	// A float operation via double is exact for these methods.
+	return fmt.Sprintf("(float)Math.%s(%s)", method, arg)
+}
+
+// copySignClass returns the C# class that has CopySign.
+func (f *Func) copySignClass(float32 bool) string {
+	if f.Target.CopySign {
+		if float32 && f.Target.MathF {
+			return "MathF"
+		}
+		if !float32 {
+			return "Math"
+		}
+	}
+	return "Bits"
+}
+
+// brTableRun is a run of the consecutive indices of a br_table that branch to the same level.
+type brTableRun struct {
+	start int
+	level int
+}
+
+// brTableRuns returns the runs of the br_table of the levels and the default level. The last run is to the default
+// level from the end of the table.
+func brTableRuns(levels []int, def int) []brTableRun {
+	var runs []brTableRun
+	for i, level := range append(levels, def) {
+		if len(runs) > 0 && runs[len(runs)-1].level == level {
+			continue
+		}
+		runs = append(runs, brTableRun{start: i, level: level})
+	}
+	return runs
 }
@@ -0,0 +1,296 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// optimizeFuncs simplifies the instructions of the functions before they are translated into C#.
+//
+// The simplifications are local to adjacent instructions, so they never move code across blocks:
+//   - constant arithmetic is folded, e.g. i32.const 1; i32.const 2; i32.add into i32.const 3,
+//   - identity operations are removed, e.g. i32.const 0; i32.add,
+//   - stores to locals that are never read are dropped, and a constant or a local that is dropped is removed.
+func optimizeFuncs(fs []*Func) error {
+	for _, f := range fs {
+		if f.Import || f.Wasm.Body == nil {
+			continue
+		}
+		instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+		if err != nil {
+			return err
+		}
+		code, changed, err := optimizeInstrs(instrs)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if err := f.setCode(code, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// optimizeInstrs returns the simplified instructions, and whether they are changed.
+func optimizeInstrs(instrs []disasm.Instr) ([]disasm.Instr, bool, error) {
+	dropOp, err := operators.New(operators.Drop)
+	if err != nil {
+		return nil, false, err
+	}
+
+	read := map[uint32]bool{}
+	for _, instr := range instrs {
+		if instr.Op.Code == operators.GetLocal {
+			read[instr.Immediates[0].(uint32)] = true
+		}
+	}
+
+	var changed bool
+	var out []disasm.Instr
+	for _, instr := range instrs {
+		switch instr.Op.Code {
+		case operators.SetLocal:
+			if !read[instr.Immediates[0].(uint32)] {
+				instr = disasm.Instr{Op: dropOp}
+				changed = true
+			}
+		case operators.TeeLocal:
+			if !read[instr.Immediates[0].(uint32)] {
+				// The value is left on the stack.
+				changed = true
+				continue
+			}
+		}
+		out = append(out, instr)
+
+		// Simplify the tail repeatedly, as a simplification can make another one possible.
+		for {
+			n, ok := simplifyTail(out)
+			if !ok {
+				break
+			}
+			out = n
+			changed = true
+		}
+	}
+	return out, changed, nil
+}
+
+// simplifyTail simplifies the last instructions of out, and reports whether it did.
+func simplifyTail(out []disasm.Instr) ([]disasm.Instr, bool) {
+	n := len(out)
+	if n < 2 {
+		return out, false
+	}
+	last := out[n-1]
+	prev := out[n-2]
+
+	// A value that is dropped right after it is pushed.
+	if last.Op.Code == operators.Drop {
+		switch prev.Op.Code {
+		case operators.I32Const, operators.I64Const, operators.F32Const, operators.F64Const, operators.GetLocal, operators.GetGlobal:
+			return out[:n-2], true
+		}
+	}
+
+	// An unary operation on a constant.
+	if prev.Op.Code == operators.I32Const {
+		x := prev.Immediates[0].(int32)
+		switch last.Op.Code {
+		case operators.I32Eqz:
+			return append(out[:n-2], i32Const(boolToInt32(x == 0))), true
+		case operators.I64ExtendSI32:
+			return append(out[:n-2], i64Const(int64(x))), true
+		case operators.I64ExtendUI32:
+			return append(out[:n-2], i64Const(int64(uint32(x)))), true
+		}
+	}
+	if prev.Op.Code == operators.I64Const {
+		x := prev.Immediates[0].(int64)
+		switch last.Op.Code {
+		case operators.I64Eqz:
+			return append(out[:n-2], i32Const(boolToInt32(x == 0))), true
+		case operators.I32WrapI64:
+			return append(out[:n-2], i32Const(int32(x))), true
+		}
+	}
+
+	// An identity operation, whose right operand is a constant.
+	if prev.Op.Code == operators.I32Const {
+		switch y := prev.Immediates[0].(int32); last.Op.Code {
+		case operators.I32Add, operators.I32Sub, operators.I32Or, operators.I32Xor:
+			if y == 0 {
+				return out[:n-2], true
+			}
+		case operators.I32Shl, operators.I32ShrS, operators.I32ShrU:
+			if y&31 == 0 {
+				return out[:n-2], true
+			}
+		case operators.I32Mul:
+			if y == 1 {
+				return out[:n-2], true
+			}
+		case operators.I32And:
+			if y == -1 {
+				return out[:n-2], true
+			}
+		}
+	}
+	if prev.Op.Code == operators.I64Const {
+		switch y := prev.Immediates[0].(int64); last.Op.Code {
+		case operators.I64Add, operators.I64Sub, operators.I64Or, operators.I64Xor:
+			if y == 0 {
+				return out[:n-2], true
+			}
+		case operators.I64Shl, operators.I64ShrS, operators.I64ShrU:
+			if y&63 == 0 {
+				return out[:n-2], true
+			}
+		case operators.I64Mul:
+			if y == 1 {
+				return out[:n-2], true
+			}
+		case operators.I64And:
+			if y == -1 {
+				return out[:n-2], true
+			}
+		}
+	}
+
+	// A binary operation on two constants. Divisions are not folded as they can trap.
+	if n < 3 {
+		return out, false
+	}
+	first := out[n-3]
+	if first.Op.Code == operators.I32Const && prev.Op.Code == operators.I32Const {
+		if v, ok := foldI32(last.Op.Code, first.Immediates[0].(int32), prev.Immediates[0].(int32)); ok {
+			return append(out[:n-3], v), true
+		}
+	}
+	if first.Op.Code == operators.I64Const && prev.Op.Code == operators.I64Const {
+		if v, ok := foldI64(last.Op.Code, first.Immediates[0].(int64), prev.Immediates[0].(int64)); ok {
+			return append(out[:n-3], v), true
+		}
+	}
+	return out, false
+}
+
+// foldI32 returns the constant of the i32 binary operation on the constants, or false if the operation is not
+// folded.
+func foldI32(op byte, x, y int32) (disasm.Instr, bool) {
+	switch op {
+	case operators.I32Add:
+		return i32Const(x + y), true
+	case operators.I32Sub:
+		return i32Const(x - y), true
+	case operators.I32Mul:
+		return i32Const(x * y), true
+	case operators.I32And:
+		return i32Const(x & y), true
+	case operators.I32Or:
+		return i32Const(x | y), true
+	case operators.I32Xor:
+		return i32Const(x ^ y), true
+	case operators.I32Shl:
+		return i32Const(x << (uint32(y) & 31)), true
+	case operators.I32ShrS:
+		return i32Const(x >> (uint32(y) & 31)), true
+	case operators.I32ShrU:
+		return i32Const(int32(uint32(x) >> (uint32(y) & 31))), true
+	case operators.I32Eq:
+		return i32Const(boolToInt32(x == y)), true
+	case operators.I32Ne:
+		return i32Const(boolToInt32(x != y)), true
+	case operators.I32LtS:
+		return i32Const(boolToInt32(x < y)), true
+	case operators.I32LtU:
+		return i32Const(boolToInt32(uint32(x) < uint32(y))), true
+	case operators.I32GtS:
+		return i32Const(boolToInt32(x > y)), true
+	case operators.I32GtU:
+		return i32Const(boolToInt32(uint32(x) > uint32(y))), true
+	case operators.I32LeS:
+		return i32Const(boolToInt32(x <= y)), true
+	case operators.I32LeU:
+		return i32Const(boolToInt32(uint32(x) <= uint32(y))), true
+	case operators.I32GeS:
+		return i32Const(boolToInt32(x >= y)), true
+	case operators.I32GeU:
+		return i32Const(boolToInt32(uint32(x) >= uint32(y))), true
+	}
+	return disasm.Instr{}, false
+}
+
+// foldI64 returns the constant of the i64 binary operation on the constants, or false if the operation is not
+// folded.
+func foldI64(op byte, x, y int64) (disasm.Instr, bool) {
+	switch op {
+	case operators.I64Add:
+		return i64Const(x + y), true
+	case operators.I64Sub:
+		return i64Const(x - y), true
+	case operators.I64Mul:
+		return i64Const(x * y), true
+	case operators.I64And:
+		return i64Const(x & y), true
+	case operators.I64Or:
+		return i64Const(x | y), true
+	case operators.I64Xor:
+		return i64Const(x ^ y), true
+	case operators.I64Shl:
+		return i64Const(x << (uint64(y) & 63)), true
+	case operators.I64ShrS:
+		return i64Const(x >> (uint64(y) & 63)), true
+	case operators.I64ShrU:
+		return i64Const(int64(uint64(x) >> (uint64(y) & 63))), true
+	case operators.I64Eq:
+		return i32Const(boolToInt32(x == y)), true
+	case operators.I64Ne:
+		return i32Const(boolToInt32(x != y)), true
+	case operators.I64LtS:
+		return i32Const(boolToInt32(x < y)), true
+	case operators.I64LtU:
+		return i32Const(boolToInt32(uint64(x) < uint64(y))), true
+	case operators.I64GtS:
+		return i32Const(boolToInt32(x > y)), true
+	case operators.I64GtU:
+		return i32Const(boolToInt32(uint64(x) > uint64(y))), true
+	case operators.I64LeS:
+		return i32Const(boolToInt32(x <= y)), true
+	case operators.I64LeU:
+		return i32Const(boolToInt32(uint64(x) <= uint64(y))), true
+	case operators.I64GeS:
+		return i32Const(boolToInt32(x >= y)), true
+	case operators.I64GeU:
+		return i32Const(boolToInt32(uint64(x) >= uint64(y))), true
+	}
+	return disasm.Instr{}, false
+}
+
+func i32Const(v int32) disasm.Instr {
+	op, err := operators.New(operators.I32Const)
+	if err != nil {
+		panic(err)
+	}
+	return disasm.Instr{Op: op, Immediates: []interface{}{v}}
+}
+
+func i64Const(v int64) disasm.Instr {
+	op, err := operators.New(operators.I64Const)
+	if err != nil {
+		panic(err)
+	}
+	return disasm.Instr{Op: op, Immediates: []interface{}{v}}
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// funcCodeKey is the arguments of Func.CSharp to look up the code generated in advance.
+type funcCodeKey struct {
+	indent   string
+	public   bool
+	withBody bool
+}
+
+// generatedFunc is the code of a function generated by generateFuncs, and the statistics of it.
+type generatedFunc struct {
+	Code  string
+	Stats funcStats
+}
+
+// funcStats is the size of a function and how it is split, for -v.
+type funcStats struct {
+	// Ops is the number of the wasm instructions, or 0 if -max-method-ops is 0.
+	Ops int
+
+	// Lines is the number of the lines of the body before the split.
+	Lines int
+
+	// OverLimit reports whether the function exceeds -max-method-lines or -max-method-ops.
+	OverLimit bool
+
+	// Parts is the number of the methods the function is split into, or 0 if the function is not split.
+	Parts int
+}
+
+// generateFuncs generates the C# code of the functions as the template "out.cs" and "partial.cs" do, with p
+// goroutines, by -p. The functions are independent of each other once their fields are set, and the templates only
+// write the generated code in the order of the functions.
+//
+// If cache is not nil, the code is reused from the cache, or stored to the cache.
+func generateFuncs(fs []*Func, p int, cache *buildCache) error {
+	key := funcCodeKey{
+		indent:   "        ",
+		public:   false,
+		withBody: true,
+	}
+	if cache != nil {
+		if gs := cache.get(); len(gs) == len(fs) {
+			for i, f := range fs {
+				f.generated = map[funcCodeKey]string{
+					key: gs[i].Code,
+				}
+				f.stats = gs[i].Stats
+			}
+			return nil
+		}
+	}
+
+	gs := make([]generatedFunc, len(fs))
+	errs := make([]error, len(fs))
+
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				gs[i].Code, errs[i] = fs[i].CSharp(key.indent, key.public, key.withBody)
+				gs[i].Stats = fs[i].stats
+			}
+		}()
+	}
+	for i := range fs {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	// Report the error of the first function, as the templates would.
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for i, f := range fs {
+		f.generated = map[funcCodeKey]string{
+			key: gs[i].Code,
+		}
+	}
+	if cache != nil {
+		cache.put(gs)
+	}
+	return nil
+}
+
+// reportSplitFuncs writes the functions that exceed -max-method-lines or -max-method-ops, and whether they are split,
+// by -v.
+func reportSplitFuncs(w io.Writer, fs []*Func) {
+	for _, f := range fs {
+		s := f.stats
+		if !s.OverLimit {
+			continue
+		}
+		size := fmt.Sprintf("%d lines", s.Lines)
+		if s.Ops > 0 {
+			size = fmt.Sprintf("%d instructions, %d lines", s.Ops, s.Lines)
+		}
+		if s.Parts > 0 {
+			fmt.Fprintf(w, "%s (%s): split into %d methods\n", originalName(f.Wasm.Name), size, s.Parts)
+			continue
+		}
+		// e.g. a function that is one large loop.
+		fmt.Fprintf(w, "%s (%s): not split as there are no top-level statements to split at\n", originalName(f.Wasm.Name), size)
+	}
+}
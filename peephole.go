@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// literalRe matches an integer literal, e.g. "-1" or "5L".
+	literalRe = regexp.MustCompile(`^-?\d+L?$`)
+
+	// simpleExprRe matches an expression that doesn't need parentheses to be an operand, e.g. "local0" or
+	// "mem_.LoadInt32(stack0 + 8)".
+	simpleExprRe = regexp.MustCompile(`^[\w.]+(?:\([^()]*\))?$`)
+
+	// pureExprRe matches an expression of variables, literals, casts and operators without side effects.
+	// A division is excluded as it can throw.
+	pureExprRe = regexp.MustCompile(`^[\w\s+\-*&|^~!<>=()?:]*$`)
+
+	// callRe matches a method call in an expression.
+	callRe = regexp.MustCompile(`\w\s*\(`)
+
+	// wordRe matches an identifier or a keyword.
+	wordRe = regexp.MustCompile(`\b[A-Za-z_]\w*`)
+
+	// assignRe matches an assignment to the variable just before it.
+	assignRe = regexp.MustCompile(`^\s*(?:(?:[-+*/%&|^]|<<|>>)?=[^=]|\+\+|--)`)
+
+	// operandEndRe matches the end of the code before a whole argument or a whole right-hand side.
+	operandEndRe = regexp.MustCompile(`(?:\w\(|, |[^-+*/%&|^<>!=]= |^return )$`)
+)
+
+// castKeywords is the keywords that can appear in a pure expression.
+var castKeywords = map[string]bool{
+	"int": true, "long": true, "uint": true, "ulong": true, "float": true, "double": true,
+	"short": true, "ushort": true, "byte": true, "sbyte": true,
+}
+
+// statementKeywords is the keywords that can start a statement using a variable.
+var statementKeywords = map[string]bool{
+	"return": true, "if": true, "switch": true, "var": true,
+}
+
+// forwardSubstitute is a peephole optimization that replaces a variable used only once in the next statement with its
+// initializer, e.g. "var stack1 = local0; stack0 += stack1;" becomes "stack0 += local0;". This halves the lines of
+// the generated code, and makes it easier for the C# compiler and the JIT to optimize.
+//
+// The order of the evaluation is kept: an initializer with side effects, e.g. a call or a memory load, is moved only
+// if nothing with side effects is evaluated before the variable in the statement. A literal is moved only to a whole
+// argument or a whole right-hand side, so that the C# compiler never folds it into a constant expression that might
+// overflow or divide by zero.
+func forwardSubstitute(body []string) []string {
+	counts := identCounts(body)
+	var r []string
+	for _, l := range body {
+		for len(r) > 0 {
+			nl, ok := substituteDecl(r[len(r)-1], l, counts)
+			if !ok {
+				break
+			}
+			r = r[:len(r)-1]
+			l = nl
+		}
+		r = append(r, l)
+	}
+	return r
+}
+
+// substituteDecl returns the statement next with the variable declared by decl replaced with its initializer, if
+// possible.
+func substituteDecl(decl, next string, counts map[string]int) (string, bool) {
+	stmt := strings.TrimSpace(decl)
+	m := varDeclRe.FindStringSubmatch(stmt)
+	if m == nil || m[2] == "" || strings.Contains(stmt, "//") || counts[m[1]] != 2 {
+		return "", false
+	}
+	name, expr := m[1], m[2]
+	typ := strings.Fields(stmt)[0]
+
+	code := next
+	if i := strings.Index(code, "//"); i >= 0 {
+		code = code[:i]
+	}
+	var pos []int
+	for _, p := range identRe.FindAllStringIndex(code, -1) {
+		if code[p[0]:p[1]] == name {
+			pos = p
+			break
+		}
+	}
+	if pos == nil {
+		return "", false
+	}
+	prefix, suffix := code[:pos[0]], code[pos[1]:]
+	if assignRe.MatchString(suffix) || strings.HasSuffix(prefix, "ref ") || strings.HasSuffix(prefix, "out ") {
+		return "", false
+	}
+
+	switch {
+	case literalRe.MatchString(expr):
+		// The type of a declaration like "long stack0 = 1;" differs from the type of the literal.
+		switch typ {
+		case "var", "int":
+		case "long":
+			if !strings.HasSuffix(expr, "L") {
+				expr += "L"
+			}
+		default:
+			return "", false
+		}
+		if !operandEndRe.MatchString(strings.TrimLeft(prefix, " \t")) {
+			return "", false
+		}
+		if s := strings.TrimLeft(suffix, " "); !strings.HasPrefix(s, ",") && !strings.HasPrefix(s, ")") && !strings.HasPrefix(s, ";") {
+			return "", false
+		}
+	case isPureExpr(expr):
+		if !identRe.MatchString(expr) {
+			return "", false
+		}
+	default:
+		if !isPurePrefix(prefix) {
+			return "", false
+		}
+	}
+	if !literalRe.MatchString(expr) && !simpleExprRe.MatchString(expr) {
+		expr = "(" + expr + ")"
+	}
+	return next[:pos[0]] + expr + next[pos[1]:], true
+}
+
+// isPureExpr reports whether the expression has no side effects and reads only the variables of the function.
+func isPureExpr(expr string) bool {
+	if !pureExprRe.MatchString(expr) || callRe.MatchString(expr) {
+		return false
+	}
+	for _, w := range wordRe.FindAllString(expr, -1) {
+		if !castKeywords[w] && identRe.FindString(w) != w {
+			return false
+		}
+	}
+	return true
+}
+
+// isPurePrefix reports whether the code of a statement before a variable evaluates nothing that has side effects or
+// reads what an initializer with side effects might change. The arguments of a call are evaluated before the call.
+func isPurePrefix(prefix string) bool {
+	if strings.Contains(prefix, "?") || strings.Contains(prefix, "&&") || strings.Contains(prefix, "||") {
+		return false
+	}
+	// calls reports whether each open parenthesis is of a call.
+	var calls []bool
+	for i := 0; i < len(prefix); i++ {
+		switch prefix[i] {
+		case '(':
+			w := wordRe.FindAllString(strings.TrimRight(prefix[:i], " "), -1)
+			call := len(w) > 0 && strings.HasSuffix(strings.TrimRight(prefix[:i], " "), w[len(w)-1]) && !statementKeywords[w[len(w)-1]]
+			calls = append(calls, call)
+		case ')':
+			if len(calls) == 0 || calls[len(calls)-1] {
+				return false
+			}
+			calls = calls[:len(calls)-1]
+		case '[':
+			return false
+		}
+	}
+	for _, p := range wordRe.FindAllStringIndex(prefix, -1) {
+		w := prefix[p[0]:p[1]]
+		if identRe.FindString(w) == w || castKeywords[w] || statementKeywords[w] {
+			continue
+		}
+		// A method or its receiver, e.g. "mem_.StoreInt32(".
+		if rest := strings.TrimLeft(prefix[p[1]:], " "); strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, ".") {
+			continue
+		}
+		return false
+	}
+	return true
+}
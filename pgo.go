@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// profileMagic is the first word of a profile written by the code generated with -pgo-gen.
+const profileMagic = "go2dotnet-profile"
+
+// pgoInlineSize is the maximum number of instructions of a function to be inlined into the hot functions by -pgo,
+// when -inline is 0.
+const pgoInlineSize = 8
+
+// pgoSplitFactor is how many times a hot function can be larger than -max-method-lines before it is split.
+// The calls of the parts and the accesses to the state struct slow down the function.
+const pgoSplitFactor = 4
+
+// execProfile is the execution counts recorded by the code generated with -pgo-gen, and read by -pgo.
+//
+// The format is text: the first line is the magic and the SHA-256 hash of the wasm file, and each of the other lines
+// is "func <function index> <calls>".
+type execProfile struct {
+	// Funcs is the number of the calls of each function by the function index.
+	Funcs map[int]int64
+}
+
+// readProfile reads the profile for the wasm file of the SHA-256 hash.
+func readProfile(path string, inputSHA256 string) (*execProfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &execProfile{
+		Funcs: map[int]int64{},
+	}
+	s := bufio.NewScanner(f)
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		fields := strings.Fields(s.Text())
+		if lineno == 1 {
+			if len(fields) != 2 || fields[0] != profileMagic {
+				return nil, fmt.Errorf("%s is not a profile written by -pgo-gen", path)
+			}
+			if fields[1] != inputSHA256 {
+				return nil, fmt.Errorf("%s is the profile of a different wasm file", path)
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		var nums []int64
+		for _, f := range fields[1:] {
+			n, err := strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+			}
+			nums = append(nums, n)
+		}
+		switch {
+		case fields[0] == "func" && len(nums) == 2:
+			p.Funcs[int(nums[0])] += nums[1]
+		default:
+			return nil, fmt.Errorf("%s:%d: unexpected line", path, lineno)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if lineno == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return p, nil
+}
+
+// hotFuncs returns the indices of the hot functions: the functions called at least 1% as many times as the most
+// called function.
+func (p *execProfile) hotFuncs() map[int]bool {
+	var max int64
+	for _, n := range p.Funcs {
+		if n > max {
+			max = n
+		}
+	}
+	hot := map[int]bool{}
+	for idx, n := range p.Funcs {
+		if n > 0 && n*100 >= max {
+			hot[idx] = true
+		}
+	}
+	return hot
+}
@@ -0,0 +1,350 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultLangVersion    = "8.0"
+	defaultPackageVersion = "1.0.0"
+)
+
+var csprojTmpl = template.Must(template.New("csproj").Parse(`<Project Sdk="Microsoft.NET.Sdk">
+
+  <PropertyGroup>
+    <OutputType>Library</OutputType>
+{{- if .MultiTarget}}
+    <TargetFrameworks>{{.TargetFramework}}</TargetFrameworks>
+{{- else}}
+    <TargetFramework>{{.TargetFramework}}</TargetFramework>
+{{- end}}
+    <LangVersion>{{.LangVersion}}</LangVersion>
+    <RootNamespace>{{.Namespace}}</RootNamespace>
+    <AssemblyName>{{.Name}}</AssemblyName>
+{{- if .AllowUnsafeBlocks}}
+    <AllowUnsafeBlocks>true</AllowUnsafeBlocks>
+{{- end}}
+{{- if .AOT}}
+    <IsAotCompatible>true</IsAotCompatible>
+    <PublishAot>true</PublishAot>
+{{- end}}
+{{- if .Trimmable}}
+    <IsTrimmable>true</IsTrimmable>
+{{- end}}
+{{- if .COMHosting}}
+    <EnableComHosting>true</EnableComHosting>
+{{- end}}
+{{- if .COMInterop}}
+    <RegisterForComInterop>true</RegisterForComInterop>
+{{- end}}
+{{- with .Module}}
+    <Product>{{.Path}}</Product>
+{{- with .AssemblyVersion}}
+    <AssemblyVersion>{{.}}</AssemblyVersion>
+    <FileVersion>{{.}}</FileVersion>
+{{- end}}
+    <InformationalVersion>{{.InformationalVersion}}</InformationalVersion>
+    <IncludeSourceRevisionInInformationalVersion>false</IncludeSourceRevisionInInformationalVersion>
+{{- end}}
+{{- if .EmbedSources}}
+    <DebugType>portable</DebugType>
+    <EmbedAllSources>true</EmbedAllSources>
+{{- end}}
+    <Nullable>disable</Nullable>
+    <ImplicitUsings>disable</ImplicitUsings>
+  </PropertyGroup>
+{{- if .Package}}
+
+  <PropertyGroup>
+    <IsPackable>true</IsPackable>
+    <PackageId>{{.PackageID}}</PackageId>
+    <Version>{{.Version}}</Version>
+{{- if .License}}
+    <PackageLicenseExpression>{{.License}}</PackageLicenseExpression>
+{{- end}}
+    <Description>{{.Name}} translated from Go by go2dotnet</Description>
+  </PropertyGroup>
+{{- end}}
+{{- if .Subprojects}}
+
+  <ItemGroup>
+{{- range .Subprojects}}
+    <Compile Remove="{{.}}/**" />
+{{- end}}
+  </ItemGroup>
+{{- end}}
+{{- if .Resources}}
+
+  <ItemGroup>
+{{- range $name, $_ := .Resources}}
+    <EmbeddedResource Include="{{$name}}" LogicalName="{{$name}}" />
+{{- end}}
+  </ItemGroup>
+{{- end}}
+{{- if .Proto}}
+
+  <ItemGroup>
+    <FrameworkReference Include="Microsoft.AspNetCore.App" />
+    <Protobuf Include="{{.Name}}.proto" GrpcServices="Server" />
+  </ItemGroup>
+{{- end}}
+{{- if .PackageReferences}}
+
+  <ItemGroup>
+{{- range $name, $version := .PackageReferences}}
+    <PackageReference Include="{{$name}}" Version="{{$version}}" />
+{{- end}}
+  </ItemGroup>
+{{- end}}
+
+</Project>
+`))
+
+// asmdefTmpl is a Unity assembly definition, which Unity uses instead of a .csproj.
+var asmdefTmpl = template.Must(template.New("asmdef").Parse(`{
+    "name": "{{.Name}}",
+    "rootNamespace": "{{.Namespace}}",
+    "references": [],
+    "includePlatforms": [],
+    "excludePlatforms": [],
+    "allowUnsafeCode": {{.AllowUnsafeBlocks}},
+    "overrideReferences": false,
+    "precompiledReferences": [],
+    "autoReferenced": true,
+    "defineConstraints": [],
+    "versionDefines": [],
+    "noEngineReferences": true
+}
+`))
+
+// slnTmpl is a solution that contains only the generated project.
+// FAE04EC0-301F-11D3-BF4B-00C04F79EFBC is the project type of C# projects.
+var slnTmpl = template.Must(template.New("sln").Parse(`
+Microsoft Visual Studio Solution File, Format Version 12.00
+# Visual Studio Version 16
+VisualStudioVersion = 16.0.30114.105
+MinimumVisualStudioVersion = 10.0.40219.1
+Project("{FAE04EC0-301F-11D3-BF4B-00C04F79EFBC}") = "{{.Name}}", "{{.Name}}.csproj", "{{.GUID}}"
+EndProject
+Global
+	GlobalSection(SolutionConfigurationPlatforms) = preSolution
+		Debug|Any CPU = Debug|Any CPU
+		Release|Any CPU = Release|Any CPU
+	EndGlobalSection
+	GlobalSection(ProjectConfigurationPlatforms) = postSolution
+		{{.GUID}}.Debug|Any CPU.ActiveCfg = Debug|Any CPU
+		{{.GUID}}.Debug|Any CPU.Build.0 = Debug|Any CPU
+		{{.GUID}}.Release|Any CPU.ActiveCfg = Release|Any CPU
+		{{.GUID}}.Release|Any CPU.Build.0 = Release|Any CPU
+	EndGlobalSection
+EndGlobal
+`))
+
+type project struct {
+	Name            string
+	Namespace       string
+	TargetFramework string
+
+	// MultiTarget reports whether TargetFramework is multiple target frameworks separated by semicolons.
+	MultiTarget       bool
+	LangVersion       string
+	AllowUnsafeBlocks bool
+
+	// RuntimeFiles is the C# files of the runtime written to the runtime directory, keyed by the file names.
+	RuntimeFiles map[string][]byte
+
+	// Proto and GRPCService are the .proto and the C# file of the gRPC service by -grpc, or nil if unused.
+	Proto       []byte
+	GRPCService []byte
+
+	// Resources is the embedded resources of the project, keyed by the file names.
+	Resources map[string][]byte
+
+	// PackageReferences is the NuGet packages the project depends on, keyed by the package IDs.
+	PackageReferences map[string]string
+
+	// AOT reports whether the project is compatible with Native AOT.
+	AOT bool
+
+	// Trimmable reports whether the assembly is marked as trimmable. This is implied by AOT.
+	Trimmable bool
+
+	// COMHosting and COMInterop report whether the assembly is registered for COM by -com, for .NET Core and
+	// .NET Framework respectively.
+	COMHosting bool
+	COMInterop bool
+
+	// EmbedSources reports whether the C# files are embedded into the portable PDB. This is for -emit=dll, which
+	// removes the C# files after the build. This must be false with -line, as the C# compiler would embed the files
+	// that #line refers to, which might not exist.
+	EmbedSources bool
+
+	// Module is the Go main module, which is stamped into the assembly, or nil if unknown.
+	Module *goModule
+
+	// Subprojects is the directories of the subprojects, e.g. the benchmarks by -emit-bench, which are excluded from
+	// the project.
+	Subprojects []string
+
+	// Unity reports whether the project is for Unity. If true, an .asmdef is written instead of a .csproj.
+	Unity bool
+
+	// Package metadata. These are used only when Package is true.
+	Package   bool
+	PackageID string
+	Version   string
+	License   string
+}
+
+// projectName returns the name of the project for the wasm file, e.g. "helloworld" for "path/to/helloworld.wasm".
+func projectName(wasmPath string) string {
+	name := filepath.Base(wasmPath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// GUID returns a GUID of the project. The GUID is derived from the name so that the output is deterministic.
+func (p *project) GUID() string {
+	h := md5.Sum([]byte(p.Name))
+	// Set the version and the variant as a name-based UUID (version 3).
+	h[6] = (h[6] & 0x0f) | 0x30
+	h[8] = (h[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("{%x-%x-%x-%x-%x}", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16]))
+}
+
+// write writes the generated code, the parts of the code split by -split, the project file,
+// and the solution file if sln is true, to the directory.
+func (p *project) write(dir string, code []byte, parts [][]byte, sln bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, p.Name+".cs"), code, 0644); err != nil {
+		return err
+	}
+	// Remove the parts of a previous run. Otherwise, the project would compile the same functions twice.
+	stale, err := filepath.Glob(filepath.Join(dir, p.Name+".Part*.cs"))
+	if err != nil {
+		return err
+	}
+	for _, f := range stale {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	// Remove the runtime files of a previous run. Otherwise, the project would have the runtime twice.
+	for _, name := range runtimeFileNames {
+		if err := os.Remove(filepath.Join(dir, "runtime", name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if len(p.RuntimeFiles) > 0 {
+		if err := os.MkdirAll(filepath.Join(dir, "runtime"), 0755); err != nil {
+			return err
+		}
+	}
+	for name, code := range p.RuntimeFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, "runtime", name), code, 0644); err != nil {
+			return err
+		}
+	}
+	// Remove the gRPC files of a previous run. Otherwise, the project would fail to compile without the packages.
+	if p.Proto == nil {
+		for _, name := range []string{p.Name + ".proto", p.Name + ".Grpc.cs"} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else {
+		if err := ioutil.WriteFile(filepath.Join(dir, p.Name+".proto"), p.Proto, 0644); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, p.Name+".Grpc.cs"), p.GRPCService, 0644); err != nil {
+			return err
+		}
+	}
+	for name, data := range p.Resources {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	for i, part := range parts {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s.Part%d.cs", p.Name, i)), part, 0644); err != nil {
+			return err
+		}
+	}
+	if p.Unity {
+		if err := p.writeTemplate(filepath.Join(dir, p.Name+".asmdef"), asmdefTmpl); err != nil {
+			return err
+		}
+	} else {
+		if err := p.writeTemplate(filepath.Join(dir, p.Name+".csproj"), csprojTmpl); err != nil {
+			return err
+		}
+	}
+	if sln {
+		if err := p.writeTemplate(filepath.Join(dir, p.Name+".sln"), slnTmpl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *project) writeTemplate(path string, tmpl *template.Template) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, p); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pack runs dotnet pack for the project written to the directory. The .nupkg is written to the same directory.
+func (p *project) pack(dir string) error {
+	cmd := exec.Command("dotnet", "pack", filepath.Join(dir, p.Name+".csproj"), "-c", "Release", "-o", dir)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("dotnet pack failed: %v", err)
+	}
+	return nil
+}
+
+// buildDLL builds the project written to the directory dir, and copies the assembly and its portable PDB to the
+// directory out.
+//
+// The assembly is compiled from the generated C# code by dotnet build. go2dotnet doesn't emit IL by itself, so the
+// sequence points of the PDB are the C# compiler's: the statements of the C# code embedded into the PDB, or the
+// source lines of the wasm file's DWARF with -line.
+func (p *project) buildDLL(dir string, out string) error {
+	bin := filepath.Join(dir, "bin")
+	cmd := exec.Command("dotnet", "build", filepath.Join(dir, p.Name+".csproj"), "-c", "Release", "-o", bin)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("dotnet build failed: %v", err)
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{p.Name + ".dll", p.Name + ".pdb"} {
+		b, err := ioutil.ReadFile(filepath.Join(bin, name))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(out, name), b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+const promise = `    // JSPromiseRejectedException is thrown when awaiting a rejected promise.
+    public sealed class JSPromiseRejectedException : Exception
+    {
+        public JSPromiseRejectedException(object reason)
+            : base($"promise rejected: {JSObject.Stringify(reason)}")
+        {
+            this.Reason = reason;
+        }
+
+        // Reason is the value the promise was rejected with.
+        public object Reason { get; }
+    }
+
+    // JSPromise is JavaScript's Promise. Reactions are invoked asynchronously via post, which is the event loop.
+    sealed class JSPromise : JSObject
+    {
+        enum State
+        {
+            Pending,
+            Fulfilled,
+            Rejected,
+        }
+
+        public static JSFunction NewConstructor(Action<Action> post)
+        {
+            var ctor = new JSFunction("Promise", null, (object[] args) => {
+                var p = new JSPromise(post);
+                try
+                {
+                    ReflectApply(args[0], Undefined, new object[] { p.ResolveFunction(), p.RejectFunction() });
+                }
+                catch (Exception e)
+                {
+                    p.Reject(e);
+                }
+                return p;
+            }, typeof(JSPromise));
+            ctor.Set("resolve", new JSFunction("resolve", (object self, object[] args) => {
+                if (args.Length > 0 && args[0] is JSPromise)
+                {
+                    return args[0];
+                }
+                var p = new JSPromise(post);
+                p.Resolve(args.Length > 0 ? args[0] : Undefined);
+                return p;
+            }));
+            ctor.Set("reject", new JSFunction("reject", (object self, object[] args) => {
+                var p = new JSPromise(post);
+                p.Reject(args.Length > 0 ? args[0] : Undefined);
+                return p;
+            }));
+            return ctor;
+        }
+
+        // FromTask returns a promise settled when the task completes.
+        // The task's continuation might run on any thread, so the settlement is posted to the event loop.
+        // result returns the result of the completed task as a JavaScript value.
+        public static JSPromise FromTask(Task task, Action<Action> post, Func<Task, object> result)
+        {
+            var p = new JSPromise(post);
+            task.ContinueWith((Task t) => {
+                post(() => {
+                    if (t.IsFaulted)
+                    {
+                        var e = t.Exception.InnerExceptions.Count == 1 ? t.Exception.InnerException : t.Exception;
+                        p.Reject(e is JSPromiseRejectedException ? ((JSPromiseRejectedException)e).Reason : e);
+                        return;
+                    }
+                    if (t.IsCanceled)
+                    {
+                        p.Reject(new TaskCanceledException(t));
+                        return;
+                    }
+                    p.Resolve(result(t));
+                });
+            }, TaskContinuationOptions.ExecuteSynchronously);
+            return p;
+        }
+
+        public JSPromise(Action<Action> post)
+            : base("Promise")
+        {
+            this.post = post;
+            this.Set("then", new JSFunction("then", (object self, object[] args) => {
+                return this.Then(args.Length > 0 ? args[0] : null, args.Length > 1 ? args[1] : null);
+            }));
+            this.Set("catch", new JSFunction("catch", (object self, object[] args) => {
+                return this.Then(null, args.Length > 0 ? args[0] : null);
+            }));
+            this.Set("finally", new JSFunction("finally", (object self, object[] args) => {
+                var f = args.Length > 0 ? args[0] : null;
+                if (!(f is JSFunction))
+                {
+                    return this.Then(null, null);
+                }
+                return this.Then(new JSFunction("", (object self2, object[] args2) => {
+                    ReflectApply(f, Undefined, new object[] { });
+                    return args2[0];
+                }), new JSFunction("", (object self2, object[] args2) => {
+                    ReflectApply(f, Undefined, new object[] { });
+                    throw new JSPromiseRejectedException(args2[0]);
+                }));
+            }));
+        }
+
+        public void Resolve(object value)
+        {
+            if (this.state != State.Pending || this.resolving)
+            {
+                return;
+            }
+            if (value == this)
+            {
+                this.Reject(new Exception("chaining cycle detected for promise"));
+                return;
+            }
+            if (value is JSPromise)
+            {
+                this.resolving = true;
+                ((JSPromise)value).AddReaction(() => {
+                    var p = (JSPromise)value;
+                    this.resolving = false;
+                    if (p.state == State.Fulfilled)
+                    {
+                        this.Settle(State.Fulfilled, p.result);
+                    }
+                    else
+                    {
+                        this.Settle(State.Rejected, p.result);
+                    }
+                });
+                return;
+            }
+            this.Settle(State.Fulfilled, value);
+        }
+
+        public void Reject(object reason)
+        {
+            if (this.state != State.Pending || this.resolving)
+            {
+                return;
+            }
+            this.Settle(State.Rejected, reason);
+        }
+
+        // ToTask returns a task completed when the promise is settled.
+        public Task<object> ToTask()
+        {
+            // The task is completed on the event loop. Don't run the continuations there.
+            var tcs = new TaskCompletionSource<object>(TaskCreationOptions.RunContinuationsAsynchronously);
+            this.AddReaction(() => {
+                if (this.state == State.Fulfilled)
+                {
+                    tcs.SetResult(this.result);
+                }
+                else
+                {
+                    tcs.SetException(this.result as Exception ?? new JSPromiseRejectedException(this.result));
+                }
+            });
+            return tcs.Task;
+        }
+
+        private JSFunction ResolveFunction()
+        {
+            return new JSFunction("resolve", (object self, object[] args) => {
+                this.Resolve(args.Length > 0 ? args[0] : Undefined);
+                return Undefined;
+            });
+        }
+
+        private JSFunction RejectFunction()
+        {
+            return new JSFunction("reject", (object self, object[] args) => {
+                this.Reject(args.Length > 0 ? args[0] : Undefined);
+                return Undefined;
+            });
+        }
+
+        private JSPromise Then(object onFulfilled, object onRejected)
+        {
+            var next = new JSPromise(this.post);
+            this.AddReaction(() => {
+                var handler = this.state == State.Fulfilled ? onFulfilled : onRejected;
+                if (!(handler is JSFunction))
+                {
+                    if (this.state == State.Fulfilled)
+                    {
+                        next.Resolve(this.result);
+                    }
+                    else
+                    {
+                        next.Reject(this.result);
+                    }
+                    return;
+                }
+                object value;
+                try
+                {
+                    value = ReflectApply(handler, Undefined, new object[] { this.result });
+                }
+                catch (JSPromiseRejectedException e)
+                {
+                    next.Reject(e.Reason);
+                    return;
+                }
+                catch (Exception e)
+                {
+                    next.Reject(e);
+                    return;
+                }
+                next.Resolve(value);
+            });
+            return next;
+        }
+
+        private void AddReaction(Action reaction)
+        {
+            if (this.state == State.Pending)
+            {
+                this.reactions.Add(reaction);
+                return;
+            }
+            this.post(reaction);
+        }
+
+        private void Settle(State state, object result)
+        {
+            this.state = state;
+            this.result = result;
+            foreach (var reaction in this.reactions)
+            {
+                this.post(reaction);
+            }
+            this.reactions.Clear();
+        }
+
+        private Action<Action> post;
+        private State state = State.Pending;
+        private bool resolving;
+        private object result;
+        private List<Action> reactions = new List<Action>();
+    }`
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// reachableFuncs returns the functions of fs that can be called, in the same order.
+//
+// A function can be called if it is exported, in a table, or called by a function that can be called. A function in a
+// table is regarded as called by call_indirect regardless of the signature. The start function is not considered as
+// the start section is not supported.
+func reachableFuncs(fs []*Func, allfs []*Func, exports []*Export, tables [][]uint32) ([]*Func, error) {
+	reached := map[int]bool{}
+	var queue []int
+	reach := func(idx int) {
+		if reached[idx] {
+			return
+		}
+		reached[idx] = true
+		queue = append(queue, idx)
+	}
+
+	for _, e := range exports {
+		reach(e.Index)
+	}
+	for _, t := range tables {
+		for _, idx := range t {
+			reach(int(idx))
+		}
+	}
+
+	for len(queue) > 0 {
+		f := allfs[queue[0]]
+		queue = queue[1:]
+		if f.Import || f.Wasm.Body == nil {
+			continue
+		}
+		instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+		if err != nil {
+			return nil, err
+		}
+		for _, instr := range instrs {
+			if instr.Op.Code != operators.Call {
+				continue
+			}
+			reach(int(instr.Immediates[0].(uint32)))
+		}
+	}
+
+	var r []*Func
+	for _, f := range fs {
+		if reached[f.Index] {
+			r = append(r, f)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+
+using System;
+using System.Collections;
+using System.Collections.Generic;
+using System.Linq;
+using System.Reflection;
+using System.Runtime.Loader;
+using System.Threading;
+
+namespace Go2DotNet.Run
+{
+    // Program runs a Go program translated by go2dotnet, so that the assembly can be run without writing a host:
+    //
+    //     go2dotnet-run [options] MyTranslated.dll [--] [args...]
+    //
+    // The arguments after the assembly are passed to the Go program, and the exit code of the Go program is the exit
+    // code of this command.
+    class Program
+    {
+        const string usage = @"usage: go2dotnet-run [options] assembly.dll [--] [args...]
+
+options:
+  -type name     full name of the Go class, if the assembly has more than one
+  -env key=value environment variable of the Go program (repeatable)
+  -clearenv      don't pass the environment variables of this process to the Go program";
+
+        static int Main(string[] args)
+        {
+            string typeName = null;
+            var env = new List<KeyValuePair<string, string>>();
+            bool clearEnv = false;
+
+            int i = 0;
+            for (; i < args.Length && args[i].StartsWith("-"); i++)
+            {
+                switch (args[i].TrimStart('-'))
+                {
+                case "type":
+                    if (++i == args.Length)
+                    {
+                        return Usage("-type requires a value");
+                    }
+                    typeName = args[i];
+                    break;
+                case "env":
+                    if (++i == args.Length || !args[i].Contains("="))
+                    {
+                        return Usage("-env requires key=value");
+                    }
+                    int eq = args[i].IndexOf('=');
+                    env.Add(new KeyValuePair<string, string>(args[i].Substring(0, eq), args[i].Substring(eq + 1)));
+                    break;
+                case "clearenv":
+                    clearEnv = true;
+                    break;
+                case "h":
+                case "help":
+                    Console.WriteLine(usage);
+                    return 0;
+                default:
+                    return Usage($"unknown option {args[i]}");
+                }
+            }
+            if (i == args.Length)
+            {
+                return Usage("the assembly is not specified");
+            }
+            string path = System.IO.Path.GetFullPath(args[i]);
+            i++;
+            if (i < args.Length && args[i] == "--")
+            {
+                i++;
+            }
+            string[] goArgs = args.Skip(i).ToArray();
+
+            Type type;
+            try
+            {
+                type = FindGoType(LoadAssembly(path), typeName);
+            }
+            catch (Exception e)
+            {
+                Console.Error.WriteLine($"go2dotnet-run: {e.Message}");
+                return 2;
+            }
+
+            object go = Activator.CreateInstance(type);
+            var goEnv = (IDictionary<string, string>)type.GetProperty("Env").GetValue(go);
+            if (!clearEnv)
+            {
+                foreach (DictionaryEntry e in Environment.GetEnvironmentVariables())
+                {
+                    goEnv[(string)e.Key] = (string)e.Value;
+                }
+            }
+            foreach (var kv in env)
+            {
+                goEnv[kv.Key] = kv.Value;
+            }
+
+            // Ctrl+C stops the Go program in the same way as the signal stops a native Go program.
+            using (var cts = new CancellationTokenSource())
+            {
+                Console.CancelKeyPress += (sender, e) => {
+                    e.Cancel = true;
+                    cts.Cancel();
+                };
+                var run = type.GetMethod("Run", new Type[] { typeof(string[]), typeof(CancellationToken) });
+                try
+                {
+                    return (int)run.Invoke(go, new object[] { goArgs, cts.Token });
+                }
+                catch (TargetInvocationException e) when (e.InnerException is OperationCanceledException)
+                {
+                    return 130;
+                }
+                catch (TargetInvocationException e)
+                {
+                    // Rethrow the exception of the Go program as is, e.g. a trap, so that the stack trace is printed.
+                    System.Runtime.ExceptionServices.ExceptionDispatchInfo.Capture(e.InnerException).Throw();
+                    throw;
+                }
+            }
+        }
+
+        static int Usage(string message)
+        {
+            Console.Error.WriteLine($"go2dotnet-run: {message}");
+            Console.Error.WriteLine(usage);
+            return 2;
+        }
+
+        // LoadAssembly loads the assembly with its dependencies, e.g. the NuGet packages in its .deps.json.
+        static Assembly LoadAssembly(string path)
+        {
+            var resolver = new AssemblyDependencyResolver(path);
+            var context = new AssemblyLoadContext(System.IO.Path.GetFileNameWithoutExtension(path));
+            context.Resolving += (c, name) => {
+                string p = resolver.ResolveAssemblyToPath(name);
+                return p == null ? null : c.LoadFromAssemblyPath(p);
+            };
+            context.ResolvingUnmanagedDll += (assembly, name) => {
+                string p = resolver.ResolveUnmanagedDllToPath(name);
+                return p == null ? IntPtr.Zero : System.Runtime.InteropServices.NativeLibrary.Load(p);
+            };
+            return context.LoadFromAssemblyPath(path);
+        }
+
+        // FindGoType returns the class Go generated by go2dotnet in the assembly.
+        // The class is identified by its members, as each assembly has its own types.
+        static Type FindGoType(Assembly assembly, string typeName)
+        {
+            var types = assembly.GetTypes().Where(t =>
+                t.Name == "Go" && t.IsClass && (t.IsPublic || t.IsNestedPublic) &&
+                t.GetConstructor(Type.EmptyTypes) != null &&
+                t.GetProperty("Env")?.PropertyType == typeof(IDictionary<string, string>) &&
+                t.GetMethod("Run", new Type[] { typeof(string[]), typeof(CancellationToken) })?.ReturnType == typeof(int));
+            if (typeName != null)
+            {
+                types = types.Where(t => t.FullName == typeName);
+            }
+            var found = types.ToArray();
+            switch (found.Length)
+            {
+            case 0:
+                throw new Exception($"{assembly.GetName().Name} has no public Go class generated by go2dotnet");
+            case 1:
+                return found[0];
+            default:
+                throw new Exception($"{assembly.GetName().Name} has more than one Go class; specify one with -type: {string.Join(", ", found.Select(t => t.FullName))}");
+            }
+        }
+    }
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+// runtimeTypes is the C# code of the public types that don't depend on the wasm module.
+const runtimeTypes = `    // IImportResolver provides implementations of imported functions that go2dotnet doesn't implement,
+    // like functions declared by //go:wasmimport.
+    public interface IImportResolver
+    {
+        // Resolve returns an Action<...> or a Func<...> matching the import's signature, or null if not provided.
+        Delegate Resolve(string module, string name);
+    }
+
+    public sealed class DictionaryImportResolver : IImportResolver
+    {
+        public DictionaryImportResolver(IDictionary<(string, string), Delegate> imports)
+        {
+            this.imports = imports;
+        }
+
+        public Delegate Resolve(string module, string name)
+        {
+            Delegate d;
+            if (this.imports.TryGetValue((module, name), out d))
+            {
+                return d;
+            }
+            return null;
+        }
+
+        private IDictionary<(string, string), Delegate> imports;
+    }
+
+    // GoPanicException is thrown when the Go program exits due to a panic or a fatal error.
+    public sealed class GoPanicException : Exception
+    {
+        public GoPanicException(string message, string goStackTrace, int exitCode)
+            : base(message)
+        {
+            this.GoStackTrace = goStackTrace;
+            this.ExitCode = exitCode;
+        }
+
+        // GoStackTrace is the goroutine stack traces printed by the Go runtime.
+        public string GoStackTrace { get; }
+
+        public int ExitCode { get; }
+
+        public override string ToString()
+        {
+            return base.ToString() + Environment.NewLine + this.GoStackTrace;
+        }
+    }
+
+    // GoExitedException is thrown to unwind the frames when the Go program exits in a nested call.
+    sealed class GoExitedException : Exception
+    {
+        public GoExitedException()
+            : base("Go program has exited")
+        {
+        }
+    }
+
+    public sealed class JSValueStats
+    {
+        public JSValueStats(int liveCount, long createdCount, long finalizedCount)
+        {
+            this.LiveCount = liveCount;
+            this.CreatedCount = createdCount;
+            this.FinalizedCount = finalizedCount;
+        }
+
+        // LiveCount is the number of values currently referenced by the Go program.
+        public int LiveCount { get; }
+
+        // CreatedCount is the total number of values registered in the value table.
+        public long CreatedCount { get; }
+
+        // FinalizedCount is the total number of values released by finalizeRef.
+        public long FinalizedCount { get; }
+    }
+
+    // GoDebugOptions are common GODEBUG settings to diagnose the Go program.
+    [Flags]
+    public enum GoDebugOptions
+    {
+        None = 0,
+
+        // GCTrace prints a line for each garbage collection (gctrace=1).
+        GCTrace = 1 << 0,
+
+        // ScavTrace prints a summary of the scavenger's work (scavtrace=1).
+        ScavTrace = 1 << 1,
+
+        // InitTrace prints the time and the allocations of each package initialization (inittrace=1).
+        InitTrace = 1 << 2,
+
+        // SchedTrace sets schedtrace=1000, which prints the scheduler state every second on the other platforms.
+        // This prints nothing on js/wasm, as the state is printed by sysmon, which Go's runtime never starts on wasm.
+        SchedTrace = 1 << 3,
+    }
+
+    // GoThreadingModel specifies where the event loop of the Go program runs.
+    public enum GoThreadingModel
+    {
+        // ThreadPool runs the event loop on a thread pool thread.
+        ThreadPool,
+
+        // DedicatedThread runs the event loop on a new background thread.
+        DedicatedThread,
+
+        // SynchronizationContext runs the event loop on the SynchronizationContext of the thread calling RunAsync, e.g. a UI thread.
+        SynchronizationContext,
+    }
+
+    // BrowserApiBehavior specifies what the Go program gets when it accesses a browser API like document.
+    public enum BrowserApiBehavior
+    {
+        // Undefined returns undefined as Node.js does.
+        Undefined,
+
+        // Throw throws NotSupportedException.
+        Throw,
+
+        // Shim returns the value from Go.BrowserApiShim.
+        Shim,
+    }`
+
+// runtimeFileNames is the names of the files written to the runtime directory by -runtime-files.
+var runtimeFileNames = []string{"Runtime.cs", "JS.cs", "FileSystem.cs", "Clock.cs", "Promise.cs", "Wasi.cs", "Blazor.cs"}
+
+// runtimeFiles returns the C# code of the runtime files keyed by the file names.
+// WASI and Blazor are included only when wasiCode and blazorCode are not empty.
+func runtimeFiles(wasiCode, blazorCode string) map[string]string {
+	files := map[string]string{
+		"Runtime.cs":    runtimeTypes,
+		"JS.cs":         js,
+		"FileSystem.cs": fileSystem,
+		"Clock.cs":      clock,
+		"Promise.cs":    promise,
+	}
+	if wasiCode != "" {
+		files["Wasi.cs"] = wasiCode
+	}
+	if blazorCode != "" {
+		files["Blazor.cs"] = blazorCode
+	}
+	return files
+}
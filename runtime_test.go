@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runtimeTestNamespace is the namespace of the code generated for the runtime tests.
+const runtimeTestNamespace = "Go2DotNet.RuntimeTest"
+
+// runtimeRun translates testdata/runtime/<name>.wasm, and runs the C# program with the generated code. This returns
+// the standard output of the program.
+func runtimeRun(t *testing.T, name string, program string) string {
+	if testing.Short() {
+		t.Skip("skipping in short mode, as the program is built with the .NET SDK")
+	}
+	if _, err := exec.LookPath("dotnet"); err != nil {
+		t.Skip("dotnet is not in PATH")
+	}
+
+	dir, err := ioutil.TempDir("", "go2dotnet-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wasmPath, err := filepath.Abs(filepath.Join("testdata", "runtime", name+".wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(translator, "-wasm", wasmPath, "-namespace", runtimeTestNamespace, "-out", filepath.Join(dir, "gen")).CombinedOutput(); err != nil {
+		t.Fatalf("translation failed: %v\n%s", err, out)
+	}
+
+	runner := &subproject{
+		Name:            "Runner",
+		Project:         filepath.Join("gen", name),
+		TargetFramework: "net8.0",
+		Exe:             true,
+		Files: map[string][]byte{
+			"Program.cs": []byte(program),
+		},
+	}
+	if err := runner.write(dir); err != nil {
+		t.Fatal(err)
+	}
+	csproj := filepath.Join(dir, runner.Name, runner.Name+".csproj")
+	if out, err := exec.Command("dotnet", "build", "-c", "Release", csproj).CombinedOutput(); err != nil {
+		t.Fatalf("dotnet build failed: %v\n%s", err, out)
+	}
+	out, err := exec.Command("dotnet", "run", "-c", "Release", "--no-build", "--project", csproj).Output()
+	if err != nil {
+		var stderr []byte
+		if e, ok := err.(*exec.ExitError); ok {
+			stderr = e.Stderr
+		}
+		t.Fatalf("dotnet run failed: %v\n%s%s", err, out, stderr)
+	}
+	return string(out)
+}
+
+// TestReentrant tests the calls from the Go program to the host and back to the Go program. The program calls the
+// host function f by valueCall, and f calls an exported function of the program.
+func TestReentrant(t *testing.T) {
+	got := runtimeRun(t, "reentrant", `using System;
+using Go2DotNet.RuntimeTest;
+
+public static class Program
+{
+    public static void Main()
+    {
+        // move moves the stack pointer. valueCall must store the result at the new stack pointer.
+        var nested = new Go();
+        nested.SetGlobal("f", new Func<object>(() => nested.Invoke("move")));
+        Console.WriteLine($"nested: {nested.Run()}");
+
+        // exit exits the program. valueCall must not return to the program, which would exit with the result of f.
+        var exited = new Go();
+        exited.SetGlobal("f", new Func<object>(() => {
+            exited.Invoke("exit");
+            return 1;
+        }));
+        Console.WriteLine($"exit in nested call: {exited.Run()}");
+
+        // The exported functions cannot be called after the program exits.
+        try
+        {
+            exited.Invoke("move");
+            Console.WriteLine("call after exit: no exception");
+        }
+        catch (InvalidOperationException e)
+        {
+            Console.WriteLine($"call after exit: {e.Message}");
+        }
+    }
+}
+`)
+	want := `nested: 7
+exit in nested call: 3
+call after exit: Go program is not running
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestIsolation tests that the programs in different instances don't share the JavaScript values. Each program sets
+// Object.marker of its global object, and exits with 1 if the marker has already been set.
+func TestIsolation(t *testing.T) {
+	got := runtimeRun(t, "isolation", `using System;
+using System.Threading.Tasks;
+using Go2DotNet.RuntimeTest;
+
+public static class Program
+{
+    public static void Main()
+    {
+        Console.WriteLine($"first: {new Go().Run()}");
+        Console.WriteLine($"second: {new Go().Run()}");
+
+        var results = Task.WhenAll(new Go().RunAsync(), new Go().RunAsync()).Result;
+        Console.WriteLine($"concurrent: {results[0]} {results[1]}");
+    }
+}
+`)
+	want := `first: 0
+second: 0
+concurrent: 0 0
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestNames tests the exports whose names are not C# identifiers as they are. The methods of IGoApp have the mangled
+// names, Invoke takes the original names, and a method of Exports doesn't collide with its field go.
+func TestNames(t *testing.T) {
+	got := runtimeRun(t, "names", `using System;
+using System.Threading.Tasks;
+using Go2DotNet.RuntimeTest;
+
+public static class Program
+{
+    public static void Main()
+    {
+        var go = new Go();
+        go.SetGlobal("f", new Func<object>(() => {
+            var app = (IGoApp)go;
+            Console.WriteLine($"{app.@lock()} {app.@class()} {app.my_2dfunc()} {app.Invoke__1()} {app.GetExport__1()} {app._u6570()} {app.lockAsync()} {app.go__1()}");
+            Console.WriteLine($"{go.Invoke("lock")} {go.Invoke("my-func")} {go.Invoke("数")} {go.Invoke("lockAsync")} {new Go.Exports(go).go_()}");
+            // The Task-returning wrappers would wait for this call, so they are only referred to.
+            Func<Task<int>> async = app.lockAsync__1;
+            async = app.lockAsyncAsync;
+            return 1;
+        }));
+        Console.WriteLine($"exit: {go.Run()}");
+    }
+}
+`)
+	want := `0 1 2 3 4 5 6 7
+0 2 5 6 7
+exit: 1
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
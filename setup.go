@@ -0,0 +1,393 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// translation is a wasm module prepared for generating the C# code: the types, the functions and the exports with
+// their identifiers, and the memory, the globals, the tables and the data segments.
+type translation struct {
+	Types []*Type
+
+	// Imports is the imported functions. Funcs is the defined functions that can be called, and AllFuncs is the
+	// function index space, i.e. the imported functions and all the defined functions.
+	Imports  []*Func
+	Funcs    []*Func
+	AllFuncs []*Func
+
+	Exports  []*Export
+	Exported map[string]bool
+	UsesWASI bool
+	Strings  *stringConsts
+
+	// ProfileFuncs is the number of the functions counted by -pgo-gen, including the imported functions, or 0.
+	ProfileFuncs int
+
+	// Malloc reports whether the module exports an allocator.
+	Malloc bool
+
+	InitPageNum int
+	MaxPageNum  int
+	Globals     []*Global
+	Tables      [][]uint32
+	Indirect    []*IndirectTable
+	Data        []Data
+}
+
+// newTranslation prepares the decoded module for generating the C# code with the flags. lines is the line table by
+// -line, or nil, and inputSHA256 is the hash of the wasm file that a profile by -pgo must match.
+//
+// The sections other than the types, the functions and the code are optional, and a missing one is the same as an
+// empty one. run and Fuzz share this, so that a fuzzer reaches the same code as the command.
+func newTranslation(mod *wasm.Module, tgt *target, style *codeStyle, pointerMemory bool, lines *lineTable, inputSHA256 string) (*translation, error) {
+	t := &translation{}
+
+	if mod.Types != nil {
+		for i, e := range mod.Types.Entries {
+			e := e
+			t.Types = append(t.Types, &Type{
+				Sig:    &e,
+				Index:  i,
+				Static: *flagStatic,
+			})
+		}
+	}
+
+	if mod.Import != nil {
+		for i, e := range mod.Import.Entries {
+			fi, ok := e.Type.(wasm.FuncImport)
+			if !ok {
+				return nil, fmt.Errorf("import %s.%s: importing a memory, a table or a global is not supported", e.ModuleName, e.FieldName)
+			}
+			if int(fi.Type) >= len(t.Types) {
+				return nil, fmt.Errorf("import %s.%s: type %d is out of range", e.ModuleName, e.FieldName, fi.Type)
+			}
+			name := e.FieldName
+			body := importFuncBodies[name]
+			if e.ModuleName == wasiModuleName {
+				body = wasiFuncBody(name, t.Types[fi.Type].Sig)
+				t.UsesWASI = true
+			}
+			t.Imports = append(t.Imports, &Func{
+				Type: t.Types[fi.Type],
+				Wasm: wasm.Function{
+					Sig:  t.Types[fi.Type].Sig,
+					Name: name,
+				},
+				Index:      i,
+				Import:     true,
+				ModuleName: e.ModuleName,
+				BodyStr:    body,
+			})
+		}
+	}
+
+	// There is a bug that signature and body are shifted (go-interpreter/wagon#190).
+	var names wasm.NameMap
+	var localNames map[uint32]wasm.NameMap
+	if c := mod.Custom(wasm.CustomSectionName); c != nil {
+		var nsec wasm.NameSection
+		if err := nsec.UnmarshalWASM(bytes.NewReader(c.Data)); err != nil {
+			return nil, err
+		}
+		if len(nsec.Types[wasm.NameFunction]) > 0 {
+			sub, err := nsec.Decode(wasm.NameFunction)
+			if err != nil {
+				return nil, err
+			}
+			names = sub.(*wasm.FunctionNames).Names
+		}
+		if len(nsec.Types[wasm.NameLocal]) > 0 {
+			sub, err := nsec.Decode(wasm.NameLocal)
+			if err != nil {
+				return nil, err
+			}
+			localNames = sub.(*wasm.LocalNames).Funcs
+		}
+	}
+	var fs []*Func
+	if mod.Function != nil {
+		if mod.Code == nil || len(mod.Code.Bodies) != len(mod.Function.Types) {
+			return nil, fmt.Errorf("the number of the function bodies doesn't match the number of the functions %d", len(mod.Function.Types))
+		}
+		for i, ti := range mod.Function.Types {
+			if int(ti) >= len(t.Types) {
+				return nil, fmt.Errorf("function %d: type %d is out of range", i, ti)
+			}
+			idx := i + len(t.Imports)
+			body := mod.Code.Bodies[i]
+			fs = append(fs, &Func{
+				Type: t.Types[ti],
+				Wasm: wasm.Function{
+					Sig:  t.Types[ti].Sig,
+					Body: &body,
+					Name: names[uint32(idx)],
+				},
+				Index: idx,
+			})
+		}
+	}
+	for _, f := range fs {
+		if body, ok := memFuncBody(f); ok {
+			f.BodyStr = body
+		}
+	}
+	allfs := append(t.Imports, fs...)
+	t.AllFuncs = allfs
+
+	t.Exported = map[string]bool{}
+	if mod.Export != nil {
+		// The exports are in the order in the wasm file, so that the output is stable.
+		for _, n := range mod.Export.Names {
+			e := mod.Export.Entries[n]
+			switch e.Kind {
+			case wasm.ExternalFunction:
+				if int(e.Index) >= len(allfs) {
+					return nil, fmt.Errorf("export %s: function %d is out of range", e.FieldStr, e.Index)
+				}
+				t.Exported[e.FieldStr] = true
+				t.Exports = append(t.Exports, &Export{
+					Index: int(e.Index),
+					Name:  e.FieldStr,
+				})
+			case wasm.ExternalMemory:
+				// Ignore
+			default:
+				return nil, fmt.Errorf("export type %d is not implemented", e.Kind)
+			}
+		}
+	}
+	exports := t.Exports
+
+	if *flagSrc != "" {
+		goFuncs, err := loadGoFuncs(*flagSrc)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range exports {
+			fn, ok := goFuncs[e.Name]
+			if !ok {
+				continue
+			}
+			e.Doc = fn.Doc
+			e.GoParams = fn.Params
+			e.GoResults = fn.Results
+		}
+	}
+
+	// The exports are named first, so that they keep their names unless they are keywords or not identifiers. Run and
+	// RunAsync are the methods of IGoApp, and CallExportAsync is the method of Go that the Task-returning wrappers call.
+	m := newMangler([]string{"Run", "RunAsync", "CallExportAsync"})
+	for _, e := range exports {
+		e.Ident = m.ident(e.Name)
+	}
+	for _, e := range exports {
+		e.AsyncIdent = m.unique(e.BaseIdent() + "Async")
+	}
+	for i, f := range allfs {
+		// A function without a name in the name section is named after its index.
+		if f.Wasm.Name == "" {
+			f.Ident = m.unique(fmt.Sprintf("func%d", i))
+			continue
+		}
+		f.Ident = m.ident(f.Wasm.Name)
+	}
+	for _, e := range exports {
+		e.Funcs = allfs
+		if ns, ok := localNames[uint32(e.Index)]; ok {
+			// The parameters are the first locals.
+			for i := range allfs[e.Index].Wasm.Sig.ParamTypes {
+				e.ParamNames = append(e.ParamNames, ns[uint32(i)])
+			}
+		}
+	}
+	for _, f := range t.Imports {
+		f.Mod = mod
+		f.Funcs = allfs
+		f.Types = t.Types
+		f.Target = tgt
+		f.Style = style
+	}
+	for _, f := range fs {
+		f.Mod = mod
+		f.Funcs = allfs
+		f.Types = t.Types
+		f.Target = tgt
+		f.Style = style
+		f.Static = *flagStatic
+		f.Lines = lines
+		f.IndirectSwitch = *flagIndirect == "switch"
+		f.IndirectCache = *flagIndirect == "cache"
+		f.PointerMemory = pointerMemory
+		f.AggressiveInlining = *flagAggrInl
+		f.AggressiveOptimization = *flagAggrOpt
+		f.MaxMethodLines = *flagMaxLines
+		f.MaxMethodOps = *flagMaxOps
+	}
+	var hot map[int]bool
+	if *flagPGO != "" {
+		prof, err := readProfile(*flagPGO, inputSHA256)
+		if err != nil {
+			return nil, err
+		}
+		hot = prof.hotFuncs()
+		for _, f := range fs {
+			f.Hot = hot[f.Index]
+		}
+	}
+	inline := *flagInline
+	if hot != nil && inline == 0 && lines == nil {
+		inline = pgoInlineSize
+	}
+	if inline > 0 {
+		if err := inlineFuncs(allfs, inline, hot); err != nil {
+			return nil, err
+		}
+	}
+	if lines == nil {
+		// The line table is by the offsets of the original instructions.
+		if err := optimizeFuncs(fs); err != nil {
+			return nil, err
+		}
+		if err := promoteSpills(fs); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range exports {
+		e.Static = *flagStatic
+	}
+
+	// The string constants are numbered in the order of the imports and then of the export names, so that the output
+	// is stable.
+	t.Strings = newStringConsts()
+	for _, f := range t.Imports {
+		f.Strings = t.Strings
+		if f.BodyStr == "" {
+			t.Strings.add(f.ModuleName)
+			t.Strings.add(f.Wasm.Name)
+		}
+	}
+	for _, n := range sortedExportNames(exports) {
+		t.Strings.add(n)
+	}
+	for _, e := range exports {
+		e.Strings = t.Strings
+	}
+
+	if *flagPGOGen {
+		for _, f := range allfs {
+			f.ProfileGen = true
+		}
+		t.ProfileFuncs = len(allfs)
+	}
+
+	// An allocator is exported by some toolchains like TinyGo. This is used to pass strings from the host.
+	for _, e := range exports {
+		if e.Name != "malloc" {
+			continue
+		}
+		sig := allfs[e.Index].Wasm.Sig
+		if len(sig.ParamTypes) == 1 && sig.ParamTypes[0] == wasm.ValueTypeI32 && len(sig.ReturnTypes) == 1 && sig.ReturnTypes[0] == wasm.ValueTypeI32 {
+			t.Malloc = true
+		}
+	}
+	for _, e := range exports {
+		e.Malloc = t.Malloc
+	}
+
+	// A .NET array is indexed by int, so the memory cannot exceed 2 GiB regardless of the module's limit. A module
+	// without a memory has an empty one.
+	t.MaxPageNum = (1<<31 - 1) / (64 * 1024)
+	if mod.Memory != nil && len(mod.Memory.Entries) > 0 {
+		lim := mod.Memory.Entries[0].Limits
+		t.InitPageNum = int(lim.Initial)
+		if lim.Flags&1 != 0 && int(lim.Maximum) < t.MaxPageNum {
+			t.MaxPageNum = int(lim.Maximum)
+		}
+	}
+
+	if mod.Global != nil {
+		for i, e := range mod.Global.Globals {
+			// TODO: Consider mutability.
+			v, err := evalInitExpr(mod, e.Init)
+			if err != nil {
+				return nil, fmt.Errorf("global %d: %v", i, err)
+			}
+			init, err := csConstant(v, e.Type.Type)
+			if err != nil {
+				return nil, fmt.Errorf("global %d: %v", i, err)
+			}
+			t.Globals = append(t.Globals, &Global{
+				Type:   e.Type.Type,
+				Index:  i,
+				Init:   init,
+				Static: *flagStatic,
+			})
+		}
+	}
+
+	if mod.Start != nil {
+		return nil, fmt.Errorf("start section must be nil but not")
+	}
+
+	if mod.Table != nil {
+		t.Tables = make([][]uint32, len(mod.Table.Entries))
+	}
+	if mod.Elements != nil {
+		for i, e := range mod.Elements.Entries {
+			if int(e.Index) >= len(t.Tables) {
+				return nil, fmt.Errorf("element segment %d: table %d is out of range", i, e.Index)
+			}
+			v, err := evalInitExpr(mod, e.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("element segment %d: %v", i, err)
+			}
+			offset, ok := v.(int32)
+			if !ok || offset < 0 {
+				return nil, fmt.Errorf("element segment %d: invalid offset %v", i, v)
+			}
+			if diff := int(offset) + int(len(e.Elems)) - int(len(t.Tables[e.Index])); diff > 0 {
+				t.Tables[e.Index] = append(t.Tables[e.Index], make([]uint32, diff)...)
+			}
+			copy(t.Tables[e.Index][offset:], e.Elems)
+		}
+	}
+
+	// Only the functions that can be called are translated.
+	fs, err := reachableFuncs(fs, allfs, exports, t.Tables)
+	if err != nil {
+		return nil, err
+	}
+	t.Funcs = fs
+
+	if len(t.Tables) > 0 {
+		t.Indirect, err = indirectTables(fs, allfs, t.Types, t.Tables[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if mod.Data != nil {
+		for i, e := range mod.Data.Entries {
+			offset, err := evalInitExpr(mod, e.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("data segment %d: %v", i, err)
+			}
+			o, ok := offset.(int32)
+			if !ok {
+				return nil, fmt.Errorf("data segment %d: invalid offset %v", i, offset)
+			}
+			t.Data = append(t.Data, Data{
+				Offset: int(o),
+				Data:   e.Data,
+			})
+		}
+	}
+
+	return t, nil
+}
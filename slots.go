@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reuseStackSlots makes the stack variables of the same type share a variable if their lifetimes don't overlap, and
+// returns the declarations of the shared variables, the body and the types of the renamed variables declared with var.
+//
+// The translation declares a new variable for every value pushed to the stack, which exceeds the number of the locals
+// that RyuJIT can track in a large function. The lifetime of a variable is from the first line to the last line it
+// appears in. This is conservative even with goto: as wasm values cannot flow into a block nor across a back edge of a
+// loop, a value used after a label is always defined after the label, or before the block that the label ends.
+//
+// The variables are renamed to stack0, stack1 and so on by the shared variables. A variable that shares nothing is
+// still declared where it was, and the shared variables are declared at the top of the function like the locals.
+func reuseStackSlots(body []string, varTypes map[string]string) ([]string, []string, map[string]string) {
+	type variable struct {
+		typ   string
+		start int
+		end   int
+		slot  int
+	}
+	vars := map[string]*variable{}
+	var names []string
+	for i, l := range body {
+		m := varDeclRe.FindStringSubmatch(strings.TrimSpace(l))
+		if m == nil || !strings.HasPrefix(m[1], "stack") {
+			continue
+		}
+		typ := strings.Fields(strings.TrimSpace(l))[0]
+		if typ == "var" {
+			t, ok := varTypes[m[1]]
+			if !ok {
+				// The type is unknown. Don't share the variable.
+				t = "var " + m[1]
+			}
+			typ = t
+		}
+		vars[m[1]] = &variable{
+			typ:   typ,
+			start: i,
+			end:   i,
+		}
+		names = append(names, m[1])
+	}
+	for i, l := range body {
+		if j := strings.Index(l, "//"); j >= 0 {
+			l = l[:j]
+		}
+		for _, id := range identRe.FindAllString(l, -1) {
+			v, ok := vars[id]
+			if !ok {
+				continue
+			}
+			if i < v.start {
+				v.start = i
+			}
+			if i > v.end {
+				v.end = i
+			}
+		}
+	}
+
+	// Assign the variables to the slots in the order of the declarations, which is the order of the lifetimes'
+	// starts. A slot is not reused at the line its last variable ends, so that a statement never writes a variable
+	// that it reads as another value.
+	type slot struct {
+		typ  string
+		end  int
+		vars []string
+	}
+	var slots []*slot
+	for _, n := range names {
+		v := vars[n]
+		found := false
+		for i, s := range slots {
+			if s.typ != v.typ || s.end >= v.start {
+				continue
+			}
+			s.end = v.end
+			s.vars = append(s.vars, n)
+			v.slot = i
+			found = true
+			break
+		}
+		if !found {
+			v.slot = len(slots)
+			slots = append(slots, &slot{
+				typ:  v.typ,
+				end:  v.end,
+				vars: []string{n},
+			})
+		}
+	}
+
+	var decls []string
+	for i, s := range slots {
+		if len(s.vars) > 1 {
+			decls = append(decls, fmt.Sprintf("%s stack%d = 0;", s.typ, i))
+		}
+	}
+
+	newVarTypes := map[string]string{}
+	for n, t := range varTypes {
+		if v, ok := vars[n]; ok && len(slots[v.slot].vars) == 1 {
+			newVarTypes[fmt.Sprintf("stack%d", v.slot)] = t
+		}
+	}
+
+	var r []string
+	for _, l := range body {
+		stmt := strings.TrimSpace(l)
+		if m := varDeclRe.FindStringSubmatch(stmt); m != nil {
+			if v, ok := vars[m[1]]; ok && len(slots[v.slot].vars) > 1 {
+				if m[2] == "" {
+					continue
+				}
+				// Remove the type to make the declaration an assignment.
+				indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+				l = indent + strings.SplitN(stmt, " ", 2)[1]
+			}
+		}
+		l = identRe.ReplaceAllStringFunc(l, func(id string) string {
+			if v, ok := vars[id]; ok {
+				return fmt.Sprintf("stack%d", v.slot)
+			}
+			return id
+		})
+		r = append(r, l)
+	}
+	return decls, r, newVarTypes
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+
+using System;
+using System.Collections.Generic;
+using System.ComponentModel;
+using System.Diagnostics;
+using System.IO;
+using System.Linq;
+using System.Security.Cryptography;
+using System.Text;
+using System.Threading;
+using Microsoft.CodeAnalysis;
+using Microsoft.CodeAnalysis.Diagnostics;
+using Microsoft.CodeAnalysis.Text;
+
+namespace Go2DotNet.SourceGenerator
+{
+    // Go2DotNetGenerator translates the wasm files in AdditionalFiles into C# at compile time.
+    //
+    // The translator is written in Go and cannot be loaded into the compiler, so the generator runs the go2dotnet
+    // command for each wasm file. The pipeline is incremental: the command runs again only when the content of the
+    // wasm file or the options change.
+    [Generator(LanguageNames.CSharp)]
+    public sealed class Go2DotNetGenerator : IIncrementalGenerator
+    {
+        private static readonly DiagnosticDescriptor NoNamespace = new DiagnosticDescriptor(
+            "GO2DOTNET001",
+            "No namespace",
+            "{0} has no Go2DotNetNamespace metadata",
+            "Go2DotNet",
+            DiagnosticSeverity.Error,
+            isEnabledByDefault: true);
+
+        private static readonly DiagnosticDescriptor TranslationFailed = new DiagnosticDescriptor(
+            "GO2DOTNET002",
+            "Translation failed",
+            "go2dotnet failed to translate {0}: {1}",
+            "Go2DotNet",
+            DiagnosticSeverity.Error,
+            isEnabledByDefault: true);
+
+        // targets is the target frameworks that go2dotnet supports. The other target frameworks are translated for
+        // netcoreapp3.1, which is go2dotnet's default.
+        private static readonly HashSet<string> targets = new HashSet<string>()
+        {
+            "netstandard2.0",
+            "netstandard2.1",
+            "net48",
+            "netcoreapp3.1",
+            "net6.0",
+            "net8.0",
+        };
+
+        public void Initialize(IncrementalGeneratorInitializationContext context)
+        {
+            var translations = context.AdditionalTextsProvider
+                .Where(file => file.Path.EndsWith(".wasm", StringComparison.OrdinalIgnoreCase))
+                .Combine(context.AnalyzerConfigOptionsProvider)
+                .Select((pair, cancellationToken) => Translation.Create(pair.Left, pair.Right, cancellationToken));
+            context.RegisterSourceOutput(translations, (spc, translation) => translation.Execute(spc));
+        }
+
+        // Translation is the input of a translation. This is compared by the values, so that the pipeline skips the
+        // translation when nothing has changed.
+        private sealed class Translation : IEquatable<Translation>
+        {
+            public string Path { get; private set; }
+            public string Namespace { get; private set; }
+            public string Command { get; private set; }
+            public string Flags { get; private set; }
+            public string Target { get; private set; }
+
+            // Checksum is the SHA-256 of the wasm file, or the error message if the file cannot be read.
+            public string Checksum { get; private set; }
+
+            public static Translation Create(AdditionalText file, AnalyzerConfigOptionsProvider options, CancellationToken cancellationToken)
+            {
+                var t = new Translation();
+                t.Path = file.Path;
+
+                string value;
+                if (options.GetOptions(file).TryGetValue("build_metadata.AdditionalFiles.Go2DotNetNamespace", out value))
+                {
+                    t.Namespace = value;
+                }
+                if (!options.GlobalOptions.TryGetValue("build_property.Go2DotNetCommand", out value) || value == "")
+                {
+                    value = "go2dotnet";
+                }
+                t.Command = value;
+                if (options.GlobalOptions.TryGetValue("build_property.Go2DotNetFlags", out value))
+                {
+                    t.Flags = value;
+                }
+                if (options.GlobalOptions.TryGetValue("build_property.TargetFramework", out value) && targets.Contains(value))
+                {
+                    t.Target = value;
+                }
+
+                // AdditionalText reads a file as text, so read the binary file directly.
+                cancellationToken.ThrowIfCancellationRequested();
+                try
+                {
+                    using (var sha = SHA256.Create())
+                    {
+                        t.Checksum = BitConverter.ToString(sha.ComputeHash(File.ReadAllBytes(file.Path)));
+                    }
+                }
+                catch (IOException e)
+                {
+                    t.Checksum = e.Message;
+                }
+                catch (UnauthorizedAccessException e)
+                {
+                    t.Checksum = e.Message;
+                }
+                return t;
+            }
+
+            public void Execute(SourceProductionContext context)
+            {
+                if (string.IsNullOrEmpty(this.Namespace))
+                {
+                    context.ReportDiagnostic(Diagnostic.Create(NoNamespace, Location.None, this.Path));
+                    return;
+                }
+
+                var args = new List<string> { "-wasm", this.Path, "-namespace", this.Namespace };
+                if (this.Target != null)
+                {
+                    args.Add("-target");
+                    args.Add(this.Target);
+                }
+                if (!string.IsNullOrEmpty(this.Flags))
+                {
+                    args.AddRange(this.Flags.Split(new[] { ' ', '\t' }, StringSplitOptions.RemoveEmptyEntries));
+                }
+
+                var info = new ProcessStartInfo(this.Command, string.Join(" ", args.Select(QuoteArgument)))
+                {
+                    UseShellExecute = false,
+                    CreateNoWindow = true,
+                    RedirectStandardOutput = true,
+                    RedirectStandardError = true,
+                    StandardOutputEncoding = Encoding.UTF8,
+                    WorkingDirectory = System.IO.Path.GetDirectoryName(this.Path),
+                };
+                string stdout;
+                string stderr;
+                int exitCode;
+                try
+                {
+                    using (var process = Process.Start(info))
+                    using (context.CancellationToken.Register(() => KillProcess(process)))
+                    {
+                        // Read the standard error on another thread, so that neither of the pipes blocks the process.
+                        var stderrTask = process.StandardError.ReadToEndAsync();
+                        stdout = process.StandardOutput.ReadToEnd();
+                        stderr = stderrTask.Result;
+                        process.WaitForExit();
+                        exitCode = process.ExitCode;
+                    }
+                }
+                catch (Win32Exception e)
+                {
+                    context.ReportDiagnostic(Diagnostic.Create(TranslationFailed, Location.None, this.Path, $"{this.Command}: {e.Message}"));
+                    return;
+                }
+                context.CancellationToken.ThrowIfCancellationRequested();
+                if (exitCode != 0)
+                {
+                    context.ReportDiagnostic(Diagnostic.Create(TranslationFailed, Location.None, this.Path, stderr.Trim()));
+                    return;
+                }
+
+                context.AddSource(HintName(this.Namespace, this.Path), SourceText.From(stdout, Encoding.UTF8));
+            }
+
+            public bool Equals(Translation other)
+            {
+                return other != null &&
+                    this.Path == other.Path &&
+                    this.Namespace == other.Namespace &&
+                    this.Command == other.Command &&
+                    this.Flags == other.Flags &&
+                    this.Target == other.Target &&
+                    this.Checksum == other.Checksum;
+            }
+
+            public override bool Equals(object obj)
+            {
+                return this.Equals(obj as Translation);
+            }
+
+            public override int GetHashCode()
+            {
+                return (this.Path ?? "").GetHashCode() ^ (this.Checksum ?? "").GetHashCode();
+            }
+
+            private static void KillProcess(Process process)
+            {
+                try
+                {
+                    process.Kill();
+                }
+                catch (InvalidOperationException)
+                {
+                    // The process has already exited.
+                }
+            }
+
+            // HintName returns the name of the generated file, which must be unique in the compilation.
+            private static string HintName(string ns, string path)
+            {
+                var name = $"{ns}.{System.IO.Path.GetFileNameWithoutExtension(path)}.g.cs";
+                var sb = new StringBuilder();
+                foreach (var c in name)
+                {
+                    sb.Append(char.IsLetterOrDigit(c) || c == '.' || c == '_' || c == '-' ? c : '_');
+                }
+                return sb.ToString();
+            }
+
+            private static string QuoteArgument(string arg)
+            {
+                if (arg.Length > 0 && arg.IndexOfAny(new[] { ' ', '\t', '"' }) < 0)
+                {
+                    return arg;
+                }
+                return "\"" + arg.Replace("\\\"", "\\\\\"").Replace("\"", "\\\"") + "\"";
+            }
+        }
+    }
+}
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// spGlobal is the index of the global of Go's stack pointer SP.
+const spGlobal = 0
+
+// spillSlot is a value stored at an offset from SP.
+type spillSlot struct {
+	// store is the index of the store instruction.
+	store int
+
+	typ    wasm.ValueType
+	offset uint32
+	width  uint32
+}
+
+// promoteSpills forwards the values stored to Go's stack frames to the loads of them.
+//
+// Go's wasm backend keeps the values in the stack frame in the linear memory at the offsets from SP, e.g. it stores a
+// register by global.get 0; local.get 1; i64.store offset=8, and loads it again later. When a load follows a store of
+// the same slot, and nothing between them can change the memory or SP, the value is kept in a new local by the store
+// and the load becomes a local.get of it. The stores are kept, as the callees and the resumed functions read the
+// frames.
+//
+// Only the code between the control flow instructions, calls and the other stores is analyzed, so that the values
+// are valid on every path. A store is matched only if the value is pushed by one instruction, as Go's backend does.
+func promoteSpills(fs []*Func) error {
+	getLocal, err := operators.New(operators.GetLocal)
+	if err != nil {
+		return err
+	}
+	teeLocal, err := operators.New(operators.TeeLocal)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		if f.Import || f.Wasm.Body == nil {
+			continue
+		}
+		instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+		if err != nil {
+			return err
+		}
+
+		// forwards is the store of the value of each load, by the index of the global.get of the load.
+		forwards := map[int]int{}
+		var slots []spillSlot
+		for i := 0; i < len(instrs); i++ {
+			instr := instrs[i]
+			if instr.Op.Code == operators.GetGlobal && instr.Immediates[0].(uint32) == spGlobal && i+1 < len(instrs) {
+				if typ, width, ok := spillLoad(instrs[i+1].Op.Code); ok {
+					offset := instrs[i+1].Immediates[1].(uint32)
+					for _, s := range slots {
+						if s.offset == offset && s.typ == typ && s.width == width {
+							forwards[i] = s.store
+							break
+						}
+					}
+					i++
+					continue
+				}
+				if i+2 < len(instrs) && isSinglePush(instrs[i+1]) {
+					if typ, width, ok := spillStore(instrs[i+2].Op.Code); ok {
+						offset := instrs[i+2].Immediates[1].(uint32)
+						slots = removeOverlappingSlots(slots, offset, width)
+						slots = append(slots, spillSlot{
+							store:  i + 2,
+							typ:    typ,
+							offset: offset,
+							width:  width,
+						})
+						i += 2
+						continue
+					}
+				}
+			}
+			if !keepsSpills(instr) {
+				slots = nil
+			}
+		}
+		if len(forwards) == 0 {
+			continue
+		}
+
+		numLocals := uint32(len(f.Wasm.Sig.ParamTypes))
+		for _, e := range f.Wasm.Body.Locals {
+			numLocals += e.Count
+		}
+		var newLocals []wasm.LocalEntry
+		// locals is the new local that each forwarded store tees the value into.
+		locals := map[int]uint32{}
+		for _, store := range forwards {
+			if _, ok := locals[store]; ok {
+				continue
+			}
+			typ, _, _ := spillStore(instrs[store].Op.Code)
+			locals[store] = numLocals
+			newLocals = append(newLocals, wasm.LocalEntry{Count: 1, Type: typ})
+			numLocals++
+		}
+
+		var code []disasm.Instr
+		for i := 0; i < len(instrs); i++ {
+			if store, ok := forwards[i]; ok {
+				code = append(code, disasm.Instr{Op: getLocal, Immediates: []interface{}{locals[store]}})
+				// Skip the load.
+				i++
+				continue
+			}
+			if l, ok := locals[i]; ok {
+				code = append(code, disasm.Instr{Op: teeLocal, Immediates: []interface{}{l}})
+			}
+			code = append(code, instrs[i])
+		}
+		if err := f.setCode(code, newLocals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOverlappingSlots removes the slots that overlap the bytes of the width at the offset.
+func removeOverlappingSlots(slots []spillSlot, offset, width uint32) []spillSlot {
+	var r []spillSlot
+	for _, s := range slots {
+		if s.offset < offset+width && offset < s.offset+s.width {
+			continue
+		}
+		r = append(r, s)
+	}
+	return r
+}
+
+// spillLoad returns the type and the width of the value loaded by the instruction, if the value can be forwarded
+// from a store.
+func spillLoad(op byte) (wasm.ValueType, uint32, bool) {
+	switch op {
+	case operators.I32Load:
+		return wasm.ValueTypeI32, 4, true
+	case operators.I64Load:
+		return wasm.ValueTypeI64, 8, true
+	case operators.F32Load:
+		return wasm.ValueTypeF32, 4, true
+	case operators.F64Load:
+		return wasm.ValueTypeF64, 8, true
+	}
+	return 0, 0, false
+}
+
+// spillStore returns the type and the width of the value stored by the instruction, if the value can be forwarded
+// to a load.
+func spillStore(op byte) (wasm.ValueType, uint32, bool) {
+	switch op {
+	case operators.I32Store:
+		return wasm.ValueTypeI32, 4, true
+	case operators.I64Store:
+		return wasm.ValueTypeI64, 8, true
+	case operators.F32Store:
+		return wasm.ValueTypeF32, 4, true
+	case operators.F64Store:
+		return wasm.ValueTypeF64, 8, true
+	}
+	return 0, 0, false
+}
+
+// isSinglePush reports whether the instruction pushes a value without popping any.
+func isSinglePush(instr disasm.Instr) bool {
+	switch instr.Op.Code {
+	case operators.GetLocal, operators.GetGlobal, operators.I32Const, operators.I64Const, operators.F32Const, operators.F64Const:
+		return true
+	}
+	return false
+}
+
+// keepsSpills reports whether the values stored to the stack frame are still valid after the instruction.
+// The instruction must neither change the memory or SP, nor be reachable from other code, like the end of a block.
+func keepsSpills(instr disasm.Instr) bool {
+	switch instr.Op.Code {
+	case operators.Nop, operators.Block, operators.If, operators.BrIf, operators.Drop, operators.Select,
+		operators.GetLocal, operators.SetLocal, operators.TeeLocal, operators.GetGlobal, operators.CurrentMemory,
+		operators.I32Const, operators.I64Const, operators.F32Const, operators.F64Const:
+		return true
+	case operators.SetGlobal:
+		return instr.Immediates[0].(uint32) != spGlobal
+	}
+	switch {
+	case operators.I32Load <= instr.Op.Code && instr.Op.Code <= operators.I64Load32u:
+		return true
+	case operators.I32Eqz <= instr.Op.Code && instr.Op.Code <= operators.F64ReinterpretI64:
+		// The numeric instructions.
+		return true
+	}
+	return false
+}
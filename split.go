@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// returnRe matches a return statement, e.g. "return stack0;".
+	returnRe = regexp.MustCompile(`\breturn(?: ([^;]+))?;`)
+
+	// localDeclRe matches a declaration of a local variable at the top of a function, e.g. "int local1 = 0;".
+	localDeclRe = regexp.MustCompile(`^(\w+) ((?:stack|local|tmp)\w*) = 0;$`)
+)
+
+// splitFunc is a function split into continuation methods by splitBody.
+type splitFunc struct {
+	// Body is the body of the function, which calls the parts in turn.
+	Body []string
+
+	// Methods is the C# code of the parts and the state struct, which follows the function.
+	Methods []string
+
+	// Parts is the number of the parts.
+	Parts int
+}
+
+// splitBody splits the body of the function into parts of about maxLines lines each, by -max-method-lines.
+//
+// The body is split only at the statements at the top level, so that a part has whole if-else and switch statements.
+// As the blocks of wasm are structured, a goto to a label in another part can only be at the top level. Such a goto
+// becomes a return of the label's number, and the function calls the part that has the label, which starts with a
+// switch to jump to the label. The variables are moved to a struct passed to the parts by reference.
+//
+// splitBody returns nil if the body cannot be split, e.g. the type of a variable is unknown.
+func (f *Func) splitBody(locals []string, body []string, varTypes map[string]string, maxLines int) *splitFunc {
+	type field struct {
+		typ  string
+		name string
+	}
+	var fields []field
+	isField := map[string]bool{}
+	addField := func(typ, name string) {
+		if isField[name] {
+			return
+		}
+		isField[name] = true
+		fields = append(fields, field{typ: typ, name: name})
+	}
+	for i, t := range f.Wasm.Sig.ParamTypes {
+		addField(wasmTypeToReturnType(t).CSharp(), fmt.Sprintf("local%d", i))
+	}
+	for _, l := range locals {
+		m := localDeclRe.FindStringSubmatch(l)
+		if m == nil {
+			return nil
+		}
+		addField(m[1], m[2])
+	}
+	for _, l := range body {
+		m := varDeclRe.FindStringSubmatch(strings.TrimSpace(l))
+		if m == nil {
+			continue
+		}
+		typ := strings.Fields(strings.TrimSpace(l))[0]
+		if typ == "var" {
+			t, ok := varTypes[m[1]]
+			if !ok {
+				return nil
+			}
+			typ = t
+		}
+		addField(typ, m[1])
+	}
+
+	// Split the body into the parts at the top level.
+	var parts [][]string
+	var part []string
+	depth := 0
+	maxLabel := -1
+	labelPart := map[string]int{}
+	var prev string
+	for _, l := range body {
+		stmt := strings.TrimSpace(l)
+		// Split only after a whole statement. An else belongs to the if-statement before it.
+		if depth == 0 && len(part) >= maxLines && (strings.HasSuffix(prev, ";") || prev == "}") && stmt != "{" && !strings.HasPrefix(stmt, "else") {
+			parts = append(parts, part)
+			part = nil
+		}
+		prev = stmt
+		if m := labelRe.FindStringSubmatch(stmt); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil
+			}
+			if n > maxLabel {
+				maxLabel = n
+			}
+			if depth == 0 {
+				labelPart["label"+m[1]] = len(parts)
+			}
+		}
+		switch stmt {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+		part = append(part, l)
+	}
+	if part != nil {
+		parts = append(parts, part)
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+
+	// startLabel returns the number to start the part from the first statement.
+	startLabel := func(i int) int {
+		return maxLabel + 1 + i
+	}
+
+	stateType := f.Identifier() + "_state"
+	retField := "ret_"
+	var static string
+	if f.Static {
+		static = "static "
+	}
+
+	// entries is the labels of each part that are jumped to from other parts.
+	entries := make([]map[string]bool, len(parts))
+	for i := range entries {
+		entries[i] = map[string]bool{}
+	}
+	var methods [][]string
+	for i, p := range parts {
+		var lines []string
+		for _, l := range p {
+			stmt := strings.TrimSpace(l)
+			indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+			if m := varDeclRe.FindStringSubmatch(stmt); m != nil && isField[m[1]] {
+				if m[2] == "" {
+					continue
+				}
+				// Remove the type to make the declaration an assignment to the field.
+				l = indent + strings.SplitN(stmt, " ", 2)[1]
+			}
+			l = returnRe.ReplaceAllStringFunc(l, func(s string) string {
+				m := returnRe.FindStringSubmatch(s)
+				if m[1] == "" {
+					return "return -1;"
+				}
+				return fmt.Sprintf("s.%s = %s; return -1;", retField, m[1])
+			})
+			l = gotoRe.ReplaceAllStringFunc(l, func(s string) string {
+				label := gotoRe.FindStringSubmatch(s)[1]
+				j, ok := labelPart[label]
+				if !ok || j == i {
+					return s
+				}
+				entries[j][label] = true
+				return fmt.Sprintf("return %s;", strings.TrimPrefix(label, "label"))
+			})
+			l = identRe.ReplaceAllStringFunc(l, func(id string) string {
+				if isField[id] {
+					return "s." + id
+				}
+				return id
+			})
+			lines = append(lines, l)
+		}
+		// The reachability is determined before the statements are rewritten, as a rewritten return is not a single
+		// jump. The end is unreachable also when the part ends with an if-else or a switch whose blocks all jump.
+		if isEndReachable(p) {
+			if i < len(parts)-1 {
+				lines = append(lines, fmt.Sprintf("    return %d;", startLabel(i+1)))
+			} else {
+				lines = append(lines, "    return -1;")
+			}
+		}
+		methods = append(methods, lines)
+	}
+
+	// Add the switches to jump to the entries.
+	var r []string
+	for i, m := range methods {
+		var labels []string
+		for l := range entries[i] {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		var head []string
+		if len(labels) > 0 {
+			head = append(head, "    switch (entry)", "    {")
+			for _, l := range labels {
+				head = append(head, fmt.Sprintf("    case %s: goto %s;", strings.TrimPrefix(l, "label"), l))
+			}
+			head = append(head, "    }")
+		}
+		// The labels jumped to only from other parts are now referenced only by the switch.
+		m = removeUnreferencedLabels(append(head, m...))
+		r = append(r, fmt.Sprintf("// OriginalName: %s (part %d)\nprivate %sint %s_part%d(ref %s s, int entry)\n{\n%s}",
+			originalName(f.Wasm.Name), i, static, f.Identifier(), i, stateType, joinLines(m)))
+	}
+
+	var st []string
+	st = append(st, fmt.Sprintf("// %s is the variables of %s shared by the parts.", stateType, f.Identifier()))
+	st = append(st, fmt.Sprintf("private struct %s", stateType), "{")
+	for _, fd := range fields {
+		st = append(st, fmt.Sprintf("    public %s %s;", fd.typ, fd.name))
+	}
+	if len(f.Wasm.Sig.ReturnTypes) > 0 {
+		st = append(st, fmt.Sprintf("    public %s %s;", wasmTypeToReturnType(f.Wasm.Sig.ReturnTypes[0]).CSharp(), retField))
+	}
+	st = append(st, "}")
+	r = append(r, strings.Join(st, "\n"))
+
+	// The function calls the parts until a part returns.
+	var b []string
+	b = append(b, fmt.Sprintf("    var s = new %s();", stateType))
+	for i := range f.Wasm.Sig.ParamTypes {
+		b = append(b, fmt.Sprintf("    s.local%[1]d = local%[1]d;", i))
+	}
+	b = append(b, fmt.Sprintf("    int next = %s_part0(ref s, -1);", f.Identifier()))
+	b = append(b, "    while (next >= 0)", "    {", "        switch (next)", "        {")
+	for i := range parts {
+		var labels []string
+		for l := range entries[i] {
+			labels = append(labels, strings.TrimPrefix(l, "label"))
+		}
+		sort.Strings(labels)
+		if i > 0 {
+			labels = append(labels, strconv.Itoa(startLabel(i)))
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		for _, l := range labels {
+			b = append(b, fmt.Sprintf("        case %s:", l))
+		}
+		b = append(b, fmt.Sprintf("            next = %s_part%d(ref s, next);", f.Identifier(), i), "            break;")
+	}
+	b = append(b, "        default:", "            throw new InvalidOperationException($\"invalid label {next}\");")
+	b = append(b, "        }", "    }")
+	if len(f.Wasm.Sig.ReturnTypes) > 0 {
+		b = append(b, fmt.Sprintf("    return s.%s;", retField))
+	}
+
+	return &splitFunc{
+		Body:    b,
+		Methods: r,
+		Parts:   len(parts),
+	}
+}
+
+// joinLines joins the lines with a newline at the end of each line.
+func joinLines(lines []string) string {
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(l)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
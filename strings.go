@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// stringConsts is the string literals of the generated code that depend on the module, i.e. the names of the imports
+// and the exports. Each name appears in several places, e.g. in the export's method of Go, in Exports and in the
+// switch of GetExport, so the literals are deduplicated into the constants of the Strings class.
+//
+// The constants are added in run() before the code is generated, as the functions might be generated in parallel.
+type stringConsts struct {
+	indices map[string]int
+	values  []string
+}
+
+func newStringConsts() *stringConsts {
+	return &stringConsts{
+		indices: map[string]int{},
+	}
+}
+
+// add adds the literal as a constant unless it is already added.
+func (s *stringConsts) add(v string) {
+	if _, ok := s.indices[v]; ok {
+		return
+	}
+	s.indices[v] = len(s.values)
+	s.values = append(s.values, v)
+}
+
+// Ref returns the C# expression that refers to the constant of the literal.
+func (s *stringConsts) Ref(v string) (string, error) {
+	i, ok := s.indices[v]
+	if !ok {
+		return "", fmt.Errorf("the string constant %q is not added", v)
+	}
+	return fmt.Sprintf("Strings.S%d", i), nil
+}
+
+// CSharp returns the C# declarations of the constants.
+func (s *stringConsts) CSharp(indent string) string {
+	var lines []string
+	for i, v := range s.values {
+		lines = append(lines, fmt.Sprintf("%spublic const string S%d = %s;", indent, i, csString(v)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sortedExportNames returns the names of the exports in sorted order.
+func sortedExportNames(exports []*Export) []string {
+	var names []string
+	for _, e := range exports {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// csString returns the C# literal of the string. Unlike %q, this never uses \x, which takes up to 4 hex digits in C#.
+func csString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&b, `\u%04x\u%04x`, r1, r2)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
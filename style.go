@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// codeStyle is the style of the generated code, so that the code matches the .editorconfig of the consuming project.
+type codeStyle struct {
+	// FileScopedNamespace reports whether the namespace is declared as a file-scoped namespace (C# 10).
+	FileScopedNamespace bool
+
+	// ExplicitTypes reports whether variables in the functions are declared with explicit types instead of var.
+	ExplicitTypes bool
+
+	// SameLineBraces reports whether opening braces are put at the end of the previous lines (K&R style)
+	// instead of on new lines (Allman style).
+	SameLineBraces bool
+
+	// Indent is the string for one level of indentation.
+	Indent string
+}
+
+// newCodeStyle returns the style specified by the flag values.
+func newCodeStyle(namespace, types, braces, indent string) (*codeStyle, error) {
+	s := &codeStyle{
+		Indent: "    ",
+	}
+
+	switch namespace {
+	case "block":
+	case "file":
+		s.FileScopedNamespace = true
+	default:
+		return nil, fmt.Errorf("unknown -style-namespace value %q", namespace)
+	}
+
+	switch types {
+	case "var":
+	case "explicit":
+		s.ExplicitTypes = true
+	default:
+		return nil, fmt.Errorf("unknown -style-types value %q", types)
+	}
+
+	switch braces {
+	case "newline":
+	case "sameline":
+		s.SameLineBraces = true
+	default:
+		return nil, fmt.Errorf("unknown -style-braces value %q", braces)
+	}
+
+	if indent == "tab" {
+		s.Indent = "\t"
+	} else {
+		n, err := strconv.Atoi(indent)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("-style-indent must be a positive number or tab but %q", indent)
+		}
+		s.Indent = strings.Repeat(" ", n)
+	}
+
+	return s, nil
+}
+
+// apply rewrites the generated code, which is in the default style, in the style.
+func (s *codeStyle) apply(code []byte) []byte {
+	lines := strings.Split(string(code), "\n")
+	if s.FileScopedNamespace {
+		lines = fileScopedNamespace(lines)
+	}
+	if s.SameLineBraces {
+		lines = sameLineBraces(lines)
+	}
+	if s.Indent != "    " {
+		for i, l := range lines {
+			trimmed := strings.TrimLeft(l, " ")
+			n := len(l) - len(trimmed)
+			lines[i] = strings.Repeat(s.Indent, n/4) + strings.Repeat(" ", n%4) + trimmed
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// fileScopedNamespace converts the block-scoped namespace into a file-scoped namespace, and unindents the declarations in it.
+func fileScopedNamespace(lines []string) []string {
+	begin := -1
+	for i, l := range lines {
+		if strings.HasPrefix(l, "namespace ") && i+1 < len(lines) && lines[i+1] == "{" {
+			begin = i
+			break
+		}
+	}
+	end := -1
+	for i := len(lines) - 1; i > begin; i-- {
+		if lines[i] == "}" {
+			end = i
+			break
+		}
+	}
+	if begin < 0 || end < 0 {
+		return lines
+	}
+
+	r := append([]string{}, lines[:begin]...)
+	r = append(r, lines[begin]+";", "")
+	for _, l := range lines[begin+2 : end] {
+		r = append(r, strings.TrimPrefix(l, "    "))
+	}
+	return append(r, lines[end+1:]...)
+}
+
+// sameLineBraces moves the opening braces on their own lines to the end of the previous lines,
+// and joins "else", "catch" and "finally" with the preceding closing braces.
+func sameLineBraces(lines []string) []string {
+	var r []string
+	for _, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if len(r) > 0 {
+			prev := r[len(r)-1]
+			prevTrimmed := strings.TrimSpace(prev)
+			// A brace cannot follow a comment or a preprocessor directive, and a label must be followed by a statement.
+			joinable := prevTrimmed != "" && !strings.Contains(prev, "//") && !strings.HasPrefix(prevTrimmed, "#") && !strings.HasSuffix(prevTrimmed, ":;")
+			if trimmed == "{" && joinable {
+				r[len(r)-1] = prev + " {"
+				continue
+			}
+			if prevTrimmed == "}" && (trimmed == "else" || strings.HasPrefix(trimmed, "catch") || trimmed == "finally") {
+				r[len(r)-1] = prev + " " + trimmed
+				continue
+			}
+		}
+		r = append(r, l)
+	}
+	return r
+}
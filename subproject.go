@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// subproject is a project that refers to the generated project, e.g. the benchmarks by -emit-bench. This is written
+// to a subdirectory of -out, which the generated project excludes.
+type subproject struct {
+	// Name is the name of the project, which is also the directory name.
+	Name string
+
+	// Project is the name of the generated project the project refers to.
+	Project string
+
+	// TargetFramework is the target framework of the project, which must be able to run an executable.
+	TargetFramework string
+
+	// Exe reports whether the project is an executable. Test reports whether the project is a test project.
+	Exe  bool
+	Test bool
+
+	// PackageReferences is the NuGet packages the project depends on, keyed by the package IDs.
+	PackageReferences map[string]string
+
+	// Files is the files of the project, keyed by the file names. The files other than C# files are copied to the
+	// output directory.
+	Files map[string][]byte
+}
+
+// exportCall is an exported function to call from a subproject.
+type exportCall struct {
+	Export *Export
+
+	// Params, Args and Values are the parameters of the calling methods, the arguments to pass them to the
+	// exported function, and the default values of them.
+	Params []string
+	Args   []string
+	Values []string
+
+	// ReturnType is the C# return type, and DelegateType is the delegate type of the function.
+	ReturnType   string
+	DelegateType string
+}
+
+// selectExports returns the exported functions to call by the flag. names is a comma-separated list of the export
+// names, or "all" for all the exported functions of the API.
+func selectExports(exports []*Export, names string, flagName string) ([]*exportCall, error) {
+	selected := map[string]bool{}
+	if names != "all" {
+		for _, n := range strings.Split(names, ",") {
+			selected[strings.TrimSpace(n)] = true
+		}
+	}
+
+	var r []*exportCall
+	for _, e := range exports {
+		if !e.IsAPI() {
+			continue
+		}
+		if names != "all" && !selected[e.Name] {
+			continue
+		}
+		delete(selected, e.Name)
+
+		retType, params, args, err := e.signature()
+		if err != nil {
+			return nil, err
+		}
+		c := &exportCall{
+			Export:       e,
+			Params:       params,
+			Args:         args,
+			ReturnType:   retType.CSharp(),
+			DelegateType: e.DelegateType(),
+		}
+		for _, t := range e.Funcs[e.Index].Wasm.Sig.ParamTypes {
+			// The types of the arguments must match the parameters exactly.
+			switch wasmTypeToReturnType(t) {
+			case ReturnTypeI32:
+				c.Values = append(c.Values, "0")
+			case ReturnTypeI64:
+				c.Values = append(c.Values, "0L")
+			case ReturnTypeF32:
+				c.Values = append(c.Values, "0f")
+			case ReturnTypeF64:
+				c.Values = append(c.Values, "0d")
+			}
+		}
+		r = append(r, c)
+	}
+	for n := range selected {
+		return nil, fmt.Errorf("%s: %q is not an exported function", flagName, n)
+	}
+	if len(r) == 0 {
+		return nil, fmt.Errorf("%s: no exported functions", flagName)
+	}
+	return r, nil
+}
+
+// subprojectSuffixes is the suffixes of the names of the subprojects.
+var subprojectSuffixes = []string{".Benchmarks", ".Tests"}
+
+var subprojectTmpl = template.Must(template.New("subproject").Parse(`<Project Sdk="Microsoft.NET.Sdk">
+
+  <PropertyGroup>
+{{- if .Exe}}
+    <OutputType>Exe</OutputType>
+{{- end}}
+    <TargetFramework>{{.TargetFramework}}</TargetFramework>
+{{- if .Test}}
+    <IsPackable>false</IsPackable>
+    <IsTestProject>true</IsTestProject>
+{{- end}}
+    <Nullable>disable</Nullable>
+    <ImplicitUsings>disable</ImplicitUsings>
+  </PropertyGroup>
+
+  <ItemGroup>
+    <ProjectReference Include="../{{.Project}}.csproj" />
+{{- range $name, $version := .PackageReferences}}
+    <PackageReference Include="{{$name}}" Version="{{$version}}" />
+{{- end}}
+  </ItemGroup>
+{{- if .DataFiles}}
+
+  <ItemGroup>
+{{- range .DataFiles}}
+    <None Include="{{.}}" CopyToOutputDirectory="PreserveNewest" />
+{{- end}}
+  </ItemGroup>
+{{- end}}
+
+</Project>
+`))
+
+// runnableTargetFramework returns the target framework of a subproject for the generated project's.
+// A .NET Standard project cannot be run, so the subproject targets the latest .NET instead.
+// For multiple target frameworks, the subproject targets the last one.
+func runnableTargetFramework(tgt *target) string {
+	if tgt.Frameworks != nil {
+		return runnableTargetFramework(targets[tgt.Frameworks[len(tgt.Frameworks)-1]])
+	}
+	if strings.HasPrefix(tgt.Name, "netstandard") {
+		return "net8.0"
+	}
+	return tgt.Name
+}
+
+// DataFiles returns the names of the files other than C# files in order.
+func (s *subproject) DataFiles() []string {
+	var names []string
+	for name := range s.Files {
+		if filepath.Ext(name) != ".cs" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// write writes the project to the directory of the project in dir.
+func (s *subproject) write(dir string) error {
+	dir = filepath.Join(dir, s.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, s.Name+".csproj"))
+	if err != nil {
+		return err
+	}
+	if err := subprojectTmpl.Execute(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	for name, data := range s.Files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subprojectCode returns the C# file of a subproject with the code in the namespace.
+func subprojectCode(header *fileHeader, namespace string, code string, style *codeStyle) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := csRuntimeTmpl.Execute(&buf, &runtimeData{
+		Header:    header,
+		Namespace: namespace,
+		Code:      code,
+		// A subproject targets a runnable .NET, whose C# version is the latest.
+		CSharp8: true,
+	}); err != nil {
+		return nil, err
+	}
+	return style.apply(buf.Bytes()), nil
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// IndirectTable is a table of delegates of a type for call_indirect.
+// The elements are the functions in the wasm table, and the functions of other signatures are replaced with
+// the function that throws an exception so that call_indirect doesn't need casts or null checks.
+type IndirectTable struct {
+	Type *Type
+
+	// Funcs is the C# expressions of the elements.
+	Funcs []string
+}
+
+// MismatchCSharp returns the C# function that is called for an element of a different signature.
+func (t *IndirectTable) MismatchCSharp(indent string) (string, error) {
+	var retType ReturnType
+	switch ts := t.Type.Sig.ReturnTypes; len(ts) {
+	case 0:
+		retType = ReturnTypeVoid
+	case 1:
+		retType = wasmTypeToReturnType(ts[0])
+	default:
+		return "", fmt.Errorf("the number of return values must be 0 or 1 but %d", len(ts))
+	}
+
+	var args []string
+	for i, t := range t.Type.Sig.ParamTypes {
+		args = append(args, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).CSharp(), i))
+	}
+
+	return fmt.Sprintf(`%sprivate static %s Type%dMismatch_(%s) => throw new InvalidOperationException(Strings.IndirectCallTypeMismatch);`, indent, retType.CSharp(), t.Type.Index, strings.Join(args, ", ")), nil
+}
+
+// Elems returns the C# expressions of the elements of the delegate table. Unless the functions are static, each
+// element is a lambda that calls the function of the instance given as the first argument. The lambdas capture
+// nothing, so the table can be shared by the instances.
+func (t *IndirectTable) Elems() []string {
+	if t.Type.Static {
+		return t.Funcs
+	}
+	params := []string{"self"}
+	var args []string
+	for i := range t.Type.Sig.ParamTypes {
+		params = append(params, fmt.Sprintf("arg%d", i))
+		args = append(args, fmt.Sprintf("arg%d", i))
+	}
+	var elems []string
+	for _, f := range t.Funcs {
+		if f != t.mismatch() {
+			f = "self." + f
+		}
+		elems = append(elems, fmt.Sprintf("(%s) => %s(%s)", strings.Join(params, ", "), f, strings.Join(args, ", ")))
+	}
+	return elems
+}
+
+// mismatch returns the name of the function that is called for an element of a different signature.
+func (t *IndirectTable) mismatch() string {
+	return fmt.Sprintf("Type%dMismatch_", t.Type.Index)
+}
+
+// SwitchCSharp returns the C# method that dispatches call_indirect by a switch on the table index, by
+// -indirect=switch. The functions are called directly without delegates, so the JIT compiler can inline them.
+func (t *IndirectTable) SwitchCSharp(indent string, static bool) (string, error) {
+	var retType ReturnType
+	switch ts := t.Type.Sig.ReturnTypes; len(ts) {
+	case 0:
+		retType = ReturnTypeVoid
+	case 1:
+		retType = wasmTypeToReturnType(ts[0])
+	default:
+		return "", fmt.Errorf("the number of return values must be 0 or 1 but %d", len(ts))
+	}
+
+	params := []string{"int index"}
+	var args []string
+	for i, t := range t.Type.Sig.ParamTypes {
+		params = append(params, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).CSharp(), i))
+		args = append(args, fmt.Sprintf("arg%d", i))
+	}
+
+	var s string
+	if static {
+		s = "static "
+	}
+	lines := []string{
+		fmt.Sprintf("private %s%s callIndirect%d_(%s)", s, retType.CSharp(), t.Type.Index, strings.Join(params, ", ")),
+		"{",
+		"    switch (index)",
+		"    {",
+	}
+	for i, f := range t.Funcs {
+		if f == t.mismatch() {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("    case %d:", i))
+		if retType == ReturnTypeVoid {
+			lines = append(lines, fmt.Sprintf("        %s(%s);", f, strings.Join(args, ", ")), "        return;")
+		} else {
+			lines = append(lines, fmt.Sprintf("        return %s(%s);", f, strings.Join(args, ", ")))
+		}
+	}
+	lines = append(lines,
+		"    default:",
+		"        throw new InvalidOperationException(Strings.IndirectCallTypeMismatch);",
+		"    }",
+		"}")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// CacheLookupCSharp returns the C# method that looks up the table for a call site and caches the element at the call
+// site, by -indirect=cache.
+func (t *IndirectTable) CacheLookupCSharp(indent string) string {
+	lines := []string{
+		fmt.Sprintf("private static Type%[1]d lookupTable%[1]d_(int index, ref int cachedIndex, ref Type%[1]d cached)", t.Type.Index),
+		"{",
+		fmt.Sprintf("    var f = (table%[1]d_ ?? initializeTable%[1]d_())[index];", t.Type.Index),
+		"    cachedIndex = index;",
+		"    cached = f;",
+		"    return f;",
+		"}",
+	}
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// callSiteRe matches a call_indirect with the cache of the call site, and captures the type index and the name of the
+// cache.
+var callSiteRe = regexp.MustCompile(`lookupTable(\d+)_\([^,]+, ref (site\d+_\w+_)index, ref `)
+
+// callSiteFields returns the declarations of the fields that cache the callees of the call sites in the body, by
+// -indirect=cache.
+//
+// Go's wasm module runs on one thread at a time, so the index and the delegate of a call site are not updated
+// atomically.
+func callSiteFields(body []string, static bool) []string {
+	var s string
+	if static {
+		s = "static "
+	}
+	var fields []string
+	for _, l := range body {
+		for _, m := range callSiteRe.FindAllStringSubmatch(l, -1) {
+			fields = append(fields,
+				fmt.Sprintf("private %sint %sindex = -1;", s, m[2]),
+				fmt.Sprintf("private %sType%s %s;", s, m[1], m[2]))
+		}
+	}
+	return fields
+}
+
+// indirectTables returns the tables for the types used by call_indirect in the functions.
+func indirectTables(funcs []*Func, allfs []*Func, types []*Type, table []uint32) ([]*IndirectTable, error) {
+	used := map[uint32]bool{}
+	for _, f := range funcs {
+		instrs, err := disasm.Disassemble(f.Wasm.Body.Code)
+		if err != nil {
+			return nil, err
+		}
+		for _, instr := range instrs {
+			if instr.Op.Code != operators.CallIndirect {
+				continue
+			}
+			used[instr.Immediates[0].(uint32)] = true
+		}
+	}
+
+	var tables []*IndirectTable
+	for _, t := range types {
+		if !used[uint32(t.Index)] {
+			continue
+		}
+		it := &IndirectTable{
+			Type: t,
+		}
+		for _, idx := range table {
+			f := allfs[idx]
+			if !sameSig(f.Wasm.Sig, t.Sig) {
+				it.Funcs = append(it.Funcs, it.mismatch())
+				continue
+			}
+			if f.Import {
+				it.Funcs = append(it.Funcs, "import_."+f.Identifier())
+				continue
+			}
+			it.Funcs = append(it.Funcs, f.Identifier())
+		}
+		tables = append(tables, it)
+	}
+	return tables, nil
+}
+
+// sameSig reports whether the two signatures are the same. call_indirect compares signatures structurally.
+func sameSig(a, b *wasm.FunctionSig) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) || len(a.ReturnTypes) != len(b.ReturnTypes) {
+		return false
+	}
+	for i := range a.ParamTypes {
+		if a.ParamTypes[i] != b.ParamTypes[i] {
+			return false
+		}
+	}
+	for i := range a.ReturnTypes {
+		if a.ReturnTypes[i] != b.ReturnTypes[i] {
+			return false
+		}
+	}
+	return true
+}
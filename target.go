@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultTarget = "netcoreapp3.1"
+
+// target represents a target framework and the APIs available there.
+type target struct {
+	// Name is the target framework moniker used in the .csproj, or the monikers separated by semicolons for
+	// multiple target frameworks.
+	Name string
+
+	// Frameworks is the target frameworks if there are multiple ones, or nil otherwise.
+	Frameworks []string
+
+	// Unsafe reports whether System.Runtime.CompilerServices.Unsafe is available without packages.
+	Unsafe bool
+
+	// Span reports whether Span<T> is available without packages.
+	Span bool
+
+	// SpanCondition is the condition of #if under which Span<T> is available, if Span<T> is available in some of
+	// the multiple target frameworks but not in the others. Otherwise, this is an empty string.
+	SpanCondition string
+
+	// MathF reports whether System.MathF is available.
+	MathF bool
+
+	// CopySign reports whether Math.CopySign is available.
+	CopySign bool
+
+	// AOT reports whether Native AOT is available.
+	AOT bool
+
+	// Trimming reports whether the attributes for the IL trimmer in System.Diagnostics.CodeAnalysis are available.
+	Trimming bool
+
+	// COMHosting reports whether a COM server can be built with EnableComHosting (.NET Core 3.0 or later).
+	COMHosting bool
+
+	// AggressiveOptimization reports whether MethodImplOptions.AggressiveOptimization is available (.NET Core 3.0 or
+	// later).
+	AggressiveOptimization bool
+
+	// BitOperations reports whether System.Numerics.BitOperations is available (.NET Core 3.0 or later).
+	BitOperations bool
+
+	// COMInterop reports whether the assembly can be registered for COM with RegisterForComInterop (.NET Framework).
+	COMInterop bool
+}
+
+var targets = map[string]*target{
+	"netstandard2.0": {
+		Name: "netstandard2.0",
+	},
+	"netstandard2.1": {
+		Name:  "netstandard2.1",
+		Span:  true,
+		MathF: true,
+	},
+	"net48": {
+		Name:       "net48",
+		COMInterop: true,
+	},
+	"netcoreapp3.1": {
+		Name:                   "netcoreapp3.1",
+		Unsafe:                 true,
+		Span:                   true,
+		MathF:                  true,
+		CopySign:               true,
+		COMHosting:             true,
+		AggressiveOptimization: true,
+		BitOperations:          true,
+	},
+	"net6.0": {
+		Name:                   "net6.0",
+		Unsafe:                 true,
+		Span:                   true,
+		MathF:                  true,
+		CopySign:               true,
+		Trimming:               true,
+		COMHosting:             true,
+		AggressiveOptimization: true,
+		BitOperations:          true,
+	},
+	"net8.0": {
+		Name:                   "net8.0",
+		Unsafe:                 true,
+		Span:                   true,
+		MathF:                  true,
+		CopySign:               true,
+		AOT:                    true,
+		Trimming:               true,
+		COMHosting:             true,
+		AggressiveOptimization: true,
+		BitOperations:          true,
+	},
+}
+
+// spanCondition is the condition of #if under which Span<T> is available without packages.
+const spanCondition = "NETSTANDARD2_1_OR_GREATER || NETCOREAPP"
+
+// lookupTarget returns the target of the name. The name can be multiple target frameworks separated by semicolons,
+// e.g. "net48;net8.0". Then the APIs are the ones available in all of them, except that Span<T> is selected by #if.
+func lookupTarget(name string) (*target, error) {
+	if strings.Contains(name, ";") {
+		return lookupTargets(strings.Split(name, ";"))
+	}
+	t, ok := targets[name]
+	if !ok {
+		var names []string
+		for n := range targets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown target %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// lookupTargets returns the target of the multiple target frameworks.
+func lookupTargets(names []string) (*target, error) {
+	var ts []*target
+	var frameworks []string
+	seen := map[string]bool{}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		t, err := lookupTarget(n)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+		frameworks = append(frameworks, n)
+	}
+	switch len(ts) {
+	case 0:
+		return nil, fmt.Errorf("no target frameworks are given")
+	case 1:
+		return ts[0], nil
+	}
+
+	t := &target{
+		Name:       strings.Join(frameworks, ";"),
+		Frameworks: frameworks,
+		Unsafe:     true,
+		Span:       true,
+		MathF:      true,
+		CopySign:   true,
+		AOT:        true,
+		Trimming:   true,
+		COMHosting: true,
+		COMInterop: true,
+
+		AggressiveOptimization: true,
+		BitOperations:          true,
+	}
+	var span bool
+	for _, t2 := range ts {
+		t.Unsafe = t.Unsafe && t2.Unsafe
+		t.Span = t.Span && t2.Span
+		t.MathF = t.MathF && t2.MathF
+		t.CopySign = t.CopySign && t2.CopySign
+		t.AOT = t.AOT && t2.AOT
+		t.Trimming = t.Trimming && t2.Trimming
+		t.COMHosting = t.COMHosting && t2.COMHosting
+		t.COMInterop = t.COMInterop && t2.COMInterop
+		t.AggressiveOptimization = t.AggressiveOptimization && t2.AggressiveOptimization
+		t.BitOperations = t.BitOperations && t2.BitOperations
+		span = span || t2.Span
+	}
+	if span && !t.Span {
+		t.SpanCondition = spanCondition
+	}
+	return t, nil
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// codeData is the data of the template "out.cs", which generates the main C# file.
+type codeData struct {
+	// Header is the license header and the provenance in the comment at the top of the file.
+	Header *fileHeader
+
+	// Namespace is the namespace specified by -namespace.
+	Namespace string
+
+	// ImportFuncs is the functions imported by the wasm module.
+	ImportFuncs []*Func
+
+	// Funcs is the functions defined in the wasm module.
+	Funcs []*Func
+
+	// InstFuncs is the functions written in the main file. This is empty when the functions are split by -split.
+	InstFuncs []*Func
+
+	// Exports is the exported functions.
+	Exports []*Export
+
+	// Strings is the string constants of the names of the imports and the exports.
+	Strings *stringConsts
+
+	// Globals is the global variables.
+	Globals []*Global
+
+	// Types is the function types.
+	Types []*Type
+
+	// Tables is the function indices in the tables.
+	Tables [][]uint32
+
+	// Indirect is the tables of delegates for call_indirect.
+	Indirect []*IndirectTable
+
+	// InitPageNum and MaxPageNum are the initial and the maximum numbers of memory pages.
+	InitPageNum int
+	MaxPageNum  int
+
+	// Data is the data segments.
+	Data []Data
+
+	// Malloc reports whether the wasm module exports malloc.
+	Malloc bool
+
+	// Exported is the names of the exported functions.
+	Exported map[string]bool
+
+	// Target is the target framework.
+	Target *target
+
+	// UnsafeMem reports whether the memory is accessed with Unsafe, by -memory=unsafe.
+	UnsafeMem bool
+
+	// PointerMem reports whether the memory is accessed by pointers to the pinned bytes without bounds checks, by
+	// -unsafe-memory.
+	PointerMem bool
+
+	// DataMode is the value of -data, and DataResource is the name of the embedded resource for -data=resource.
+	DataMode     string
+	DataResource string
+
+	// Compressed is the data segments concatenated and compressed by -compress, or nil if they are not compressed.
+	Compressed *Data
+
+	// AOT reports whether the code must not use reflection, by -aot.
+	AOT bool
+
+	// Static reports whether the state and the functions of the wasm module are static, by -static.
+	Static bool
+
+	// COM is C# code of the COM-visible facade by -com, or an empty string if unused.
+	COM string
+
+	// Runtime is C# code of the public types of the runtime.
+	Runtime string
+
+	// RuntimeFiles reports whether Runtime, JS, FS, Clock, Promise, WASI and Blazor are written to separate files
+	// by -runtime-files instead of this file.
+	RuntimeFiles bool
+
+	// JS, FS, Clock, Promise, WASI and Blazor are C# code of the runtime. WASI and Blazor are empty if unused.
+	JS      string
+	FS      string
+	Clock   string
+	Promise string
+	WASI    string
+	Blazor  string
+
+	// Module is the Go main module the wasm file is built from, or nil if unknown.
+	Module *goModule
+
+	// CSharp8 reports whether the code can use C# 8 features, i.e. -csharp-version is not 7.3.
+	CSharp8 bool
+
+	// IndirectSwitch reports whether call_indirect is dispatched by switch methods instead of delegate tables, by
+	// -indirect=switch.
+	IndirectSwitch bool
+
+	// IndirectCache reports whether each call site of call_indirect caches the last callee, by -indirect=cache.
+	IndirectCache bool
+
+	// ProfileFuncs is the number of the functions counted by -pgo-gen, including the imported functions, or 0.
+	ProfileFuncs int
+}
+
+// DataEnd returns the end offset of the data segments.
+func (c *codeData) DataEnd() int {
+	var end int
+	for _, d := range c.Data {
+		if e := d.Offset + len(d.Data); e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// DataImage reports whether the data segments are decoded into an image shared by the memories. Uncompressed
+// segments by -data=span are copied from the static data to each memory directly.
+func (c *codeData) DataImage() bool {
+	return len(c.Data) > 0 && !(c.DataMode == "span" && c.Compressed == nil)
+}
+
+// ProfileMagic returns the first word of a profile by -pgo-gen.
+func (c *codeData) ProfileMagic() string {
+	return profileMagic
+}
+
+// partialData is the data of the template "partial.cs", which generates a file of the functions split by -split.
+type partialData struct {
+	// Header is the license header and the provenance in the comment at the top of the file.
+	Header *fileHeader
+
+	// Namespace is the namespace specified by -namespace.
+	Namespace string
+
+	// Funcs is the functions in the file.
+	Funcs []*Func
+
+	// CSharp8 reports whether the code can use C# 8 features, i.e. -csharp-version is not 7.3.
+	CSharp8 bool
+}
+
+// runtimeData is the data of the template "runtime.cs", which generates a file of the runtime by -runtime-files.
+type runtimeData struct {
+	// Header is the license header and the provenance in the comment at the top of the file.
+	Header *fileHeader
+
+	// Namespace is the namespace specified by -namespace.
+	Namespace string
+
+	// Code is C# code of the runtime in the file.
+	Code string
+
+	// CSharp8 reports whether the code can use C# 8 features, i.e. -csharp-version is not 7.3.
+	CSharp8 bool
+}
+
+// funcData is the data of the template "func", which generates a C# method of a wasm function.
+type funcData struct {
+	// OriginalName is the name of the function in the wasm module.
+	OriginalName string
+
+	// Name is the C# identifier of the function.
+	Name string
+
+	// Index is the index of the function in the wasm module.
+	Index int
+
+	// ReturnType is the C# return type, and Args is the C# parameter list.
+	ReturnType string
+	Args       string
+
+	// Locals and Body are the lines of the local variable declarations and the body.
+	Locals []string
+	Body   []string
+
+	// Public reports whether the method is public.
+	Public bool
+
+	// Static reports whether the method is static, by -static.
+	Static bool
+
+	// WithBody reports whether the body is generated. If false, only the signature is generated.
+	WithBody bool
+
+	// MethodImpl is the MethodImplOptions of the method, e.g. "MethodImplOptions.AggressiveInlining", or an empty
+	// string.
+	MethodImpl string
+}
+
+// loadTemplates replaces the templates with the files in the directory dir.
+//
+// A file replaces the template of the same name: "out.cs", "partial.cs", "runtime.cs" or "func". A file can also
+// redefine a template with {{define}}, e.g. {{define "header"}} for the header of the C# files. The data of the
+// templates are codeData, partialData, runtimeData and funcData respectively.
+func loadTemplates(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no template files in %s", dir)
+	}
+
+	var funcFiles []string
+	var csFiles []string
+	for _, f := range files {
+		if filepath.Base(f) == "func" {
+			funcFiles = append(funcFiles, f)
+			continue
+		}
+		csFiles = append(csFiles, f)
+	}
+
+	if len(csFiles) > 0 {
+		t, err := csTmpl.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.ParseFiles(csFiles...); err != nil {
+			return err
+		}
+		csTmpl = t
+		csPartialTmpl = t.Lookup("partial.cs")
+		csRuntimeTmpl = t.Lookup("runtime.cs")
+	}
+	if len(funcFiles) > 0 {
+		t, err := funcTmpl.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.ParseFiles(funcFiles...); err != nil {
+			return err
+		}
+		funcTmpl = t
+	}
+	return nil
+}
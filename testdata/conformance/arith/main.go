@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// arith prints the results of the integer operations, e.g. the division, the shifts, the conversions and math/bits,
+// of the edge values.
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+var i32s = []int32{0, 1, -1, 7, -7, 123456789, math.MaxInt32, math.MinInt32}
+
+var i64s = []int64{0, 1, -1, 7, -7, 1234567890123, math.MaxInt64, math.MinInt64}
+
+func main() {
+	for _, x := range i32s {
+		for _, y := range i32s {
+			fmt.Println(x+y, x-y, x*y, x&y, x|y, x^y, x<<uint(y&31), x>>uint(y&31), uint32(x)>>uint(y&31))
+			if y != 0 && !(x == math.MinInt32 && y == -1) {
+				fmt.Println(x/y, x%y, uint32(x)/uint32(y), uint32(x)%uint32(y))
+			}
+			fmt.Println(x < y, x <= y, uint32(x) < uint32(y), uint32(x) >= uint32(y))
+		}
+		fmt.Println(int64(x), uint64(uint32(x)), int8(x), uint16(x))
+		fmt.Println(bits.LeadingZeros32(uint32(x)), bits.TrailingZeros32(uint32(x)), bits.OnesCount32(uint32(x)), bits.RotateLeft32(uint32(x), 5), bits.ReverseBytes32(uint32(x)))
+	}
+	for _, x := range i64s {
+		for _, y := range i64s {
+			fmt.Println(x+y, x-y, x*y, x&y, x|y, x^y, x<<uint(y&63), x>>uint(y&63), uint64(x)>>uint(y&63))
+			if y != 0 && !(x == math.MinInt64 && y == -1) {
+				fmt.Println(x/y, x%y, uint64(x)/uint64(y), uint64(x)%uint64(y))
+			}
+			hi, lo := bits.Mul64(uint64(x), uint64(y))
+			fmt.Println(hi, lo)
+		}
+		fmt.Println(int32(x), uint32(x), bits.Len64(uint64(x)), bits.OnesCount64(uint64(x)), bits.RotateLeft64(uint64(x), -7))
+	}
+}
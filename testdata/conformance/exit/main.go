@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// exit exits with the code 3 by os.Exit, which skips the deferred calls.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	defer fmt.Println("never printed")
+	fmt.Println("before exit")
+	os.Exit(3)
+}
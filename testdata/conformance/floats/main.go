@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// floats prints the results of the floating-point operations and the conversions between the integers and the
+// floating-point numbers.
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var f64s = []float64{0, math.Copysign(0, -1), 1, -1.5, 0.1, 2.5, 3.5, -2.5, 1e300, -1e-300, 123456.789, math.Inf(1), math.Inf(-1), math.NaN()}
+
+func main() {
+	for _, x := range f64s {
+		for _, y := range f64s {
+			fmt.Println(x+y, x-y, x*y, x/y, x < y, x == y, math.Min(x, y), math.Max(x, y), math.Copysign(x, y))
+		}
+		fmt.Println(math.Sqrt(x), math.Floor(x), math.Ceil(x), math.Trunc(x), math.RoundToEven(x), math.Abs(x), -x)
+		fmt.Println(float32(x), math.Float64bits(x), math.Float32bits(float32(x)))
+		fmt.Println(strconv.FormatFloat(x, 'g', -1, 64), strconv.FormatFloat(x, 'e', 5, 32))
+		if !math.IsNaN(x) && math.Abs(x) < 1e18 {
+			fmt.Println(int64(x), int32(x), uint8(int64(x)))
+		}
+		if x >= 0 && x < 1e18 {
+			fmt.Println(uint64(x), uint32(x))
+		}
+	}
+	for _, i := range []int64{0, -1, 1 << 53, 1<<53 + 1, math.MaxInt64, math.MinInt64} {
+		fmt.Println(float64(i), float32(i), float64(uint64(i)), float32(uint64(i)))
+	}
+	fmt.Println(math.Sin(1), math.Exp(2), math.Log(10), math.Pow(2, 0.5), math.Mod(7.5, 2))
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// goroutines prints the results of goroutines that communicate by channels, select and sync, and of timers.
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+func main() {
+	ch := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		sum := 0
+		for v := range ch {
+			sum += v
+		}
+		fmt.Println("sum", sum)
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		ch <- i
+	}
+	close(ch)
+	<-done
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	count := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mu.Lock()
+				count++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	fmt.Println("count", count)
+
+	// The ticks are counted without a deadline, as a loaded machine might deliver fewer ticks in any given time. The
+	// timeout races with the ticks, so only that it fires is printed, not when.
+	timeout := time.After(time.Millisecond)
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	ticks := 0
+	timedOut := false
+	for ticks < 5 {
+		select {
+		case <-tick.C:
+			ticks++
+		case <-timeout:
+			timedOut = true
+		}
+	}
+	if !timedOut {
+		<-timeout
+	}
+	fmt.Println("ticks", ticks)
+	fmt.Println("timeout")
+}
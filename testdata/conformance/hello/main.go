@@ -0,0 +1,10 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+	fmt.Printf("%d %s %v\n", 42, "go2dotnet", []int{1, 2, 3})
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// interfaces prints the results of the method calls by interfaces, closures and reflection, which are indirect calls.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type shape interface {
+	area() float64
+	name() string
+}
+
+type rect struct{ w, h float64 }
+
+func (r rect) area() float64 { return r.w * r.h }
+func (r rect) name() string  { return "rect" }
+
+type circle struct{ r float64 }
+
+func (c *circle) area() float64 { return 3 * c.r * c.r }
+func (c *circle) name() string  { return "circle" }
+
+type myError struct{ code int }
+
+func (e *myError) Error() string { return fmt.Sprintf("error %d", e.code) }
+
+func find(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return fmt.Errorf("wrapped: %w", &myError{code})
+}
+
+func main() {
+	shapes := []shape{rect{2, 3}, &circle{2}, rect{1, 1}}
+	for _, s := range shapes {
+		fmt.Println(s.name(), s.area())
+	}
+
+	var fs []func(int) int
+	for i := 0; i < 5; i++ {
+		i := i
+		fs = append(fs, func(x int) int { return x*i + i })
+	}
+	for _, f := range fs {
+		fmt.Print(f(10), " ")
+	}
+	fmt.Println()
+
+	var e *myError
+	fmt.Println(find(0), errors.As(find(42), &e), e.code)
+
+	v := reflect.ValueOf(rect{4, 5})
+	fmt.Println(v.Type(), v.NumField(), v.Field(0).Float(), v.MethodByName("String").IsValid())
+	fmt.Printf("%+v %#v\n", rect{4, 5}, &circle{1})
+}
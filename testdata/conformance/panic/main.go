@@ -0,0 +1,11 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// panic prints a line and panics, which exits with the code 2.
+package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("before panic")
+	panic("unrecovered")
+}
@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// recover prints the values recovered from panics, e.g. by the runtime errors, and the order of deferred calls.
+package main
+
+import "fmt"
+
+func try(name string, f func()) {
+	defer func() {
+		fmt.Println(name, "recovered:", recover())
+	}()
+	f()
+}
+
+func main() {
+	try("panic", func() { panic("boom") })
+	try("index", func() {
+		var xs []int
+		i := 3
+		_ = xs[i]
+	})
+	try("nil map", func() {
+		var m map[string]int
+		m["x"] = 1
+	})
+	try("divide", func() {
+		x := 0
+		fmt.Println(1 / x)
+	})
+	try("nil pointer", func() {
+		var p *struct{ x int }
+		fmt.Println(p.x)
+	})
+	try("type assertion", func() {
+		var v interface{} = "s"
+		_ = v.(int)
+	})
+	for i := 0; i < 3; i++ {
+		defer fmt.Println("deferred", i)
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// strings prints the results of the operations of strings, slices, maps and sorting.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+func main() {
+	s := "Hello, 世界! こんにちは"
+	fmt.Println(len(s), utf8.RuneCountInString(s), strings.ToUpper(s), strings.Fields(s))
+	for i, r := range s {
+		fmt.Print(i, ":", string(r), " ")
+	}
+	fmt.Println()
+
+	var b strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "%d,", i*i)
+	}
+	fmt.Println(len(b.String()), strings.Count(b.String(), "1"), strings.Index(b.String(), "998001"))
+
+	m := map[string]int{}
+	for _, w := range strings.Fields("the quick brown fox jumps over the lazy dog the end") {
+		m[w]++
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Println(k, m[k])
+	}
+
+	xs := make([]int, 0)
+	for i := 0; i < 100000; i++ {
+		xs = append(xs, (i*7919)%100003)
+	}
+	sort.Ints(xs)
+	fmt.Println(xs[:5], xs[len(xs)-5:])
+	ys := make([]byte, 1<<16)
+	copy(ys[1:], ys[:len(ys)-1])
+	for i := range ys {
+		ys[i] = byte(i)
+	}
+	copy(ys[3:], ys)
+	fmt.Println(ys[:8], ys[len(ys)-4:])
+}
@@ -0,0 +1,529 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build ignore
+// +build ignore
+
+// gen writes the wasm files of the golden tests to testdata/golden, and the wasm files of the runtime tests to
+// testdata/runtime. Run this in the repository root:
+//
+//	go run testdata/gen.go
+//
+// Each module has the shape of a wasm file by Go's js/wasm port, i.e. the imports of wasm_exec.js, the exports run,
+// resume and getsp, the stack pointer as the global 0, and a data segment, but the functions are small enough to
+// review the generated code.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// goImports is the functions that wasm_exec.js provides. Every import takes the stack pointer.
+var goImports = []string{
+	"runtime.wasmExit", "runtime.wasmWrite", "runtime.resetMemoryDataView", "runtime.nanotime1",
+	"runtime.walltime1", "runtime.scheduleTimeoutEvent", "runtime.clearTimeoutEvent", "runtime.getRandomData",
+	"syscall/js.finalizeRef", "syscall/js.stringVal", "syscall/js.valueGet", "syscall/js.valueSet",
+	"syscall/js.valueDelete", "syscall/js.valueIndex", "syscall/js.valueSetIndex", "syscall/js.valueCall",
+	"syscall/js.valueInvoke", "syscall/js.valueNew", "syscall/js.valueLength", "syscall/js.valuePrepareString",
+	"syscall/js.valueLoadString", "syscall/js.valueInstanceOf", "syscall/js.copyBytesToGo", "syscall/js.copyBytesToJS",
+	"debug",
+}
+
+// The indices of the types.
+const (
+	typeI32       = iota // (i32) -> ()
+	typeI32I32           // (i32, i32) -> ()
+	typeVoid             // () -> ()
+	typeRetI32           // () -> i32
+	typeI32I32Ret        // (i32, i32) -> i32
+	typeI32Ret           // (i32) -> i32
+	typeI32x4Ret         // (i32, i32, i32, i32) -> i32
+)
+
+var types = []wasm.FunctionSig{
+	typeI32:       {Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+	typeI32I32:    {Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}},
+	typeVoid:      {Form: 0x60},
+	typeRetI32:    {Form: 0x60, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+	typeI32I32Ret: {Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+	typeI32Ret:    {Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+	typeI32x4Ret:  {Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+}
+
+// function is a defined function. export is the export name, if any.
+type function struct {
+	name   string
+	typ    uint32
+	locals []wasm.LocalEntry
+	body   []byte
+	export string
+}
+
+// module is the parts of a module that differ between the wasm files.
+type module struct {
+	funcs   []function
+	globals []wasm.GlobalEntry
+	data    string
+}
+
+// The indices of the defined functions in the function index space.
+var (
+	funcRun    = uint32(len(goImports))
+	funcResume = funcRun + 1
+	funcGetSP  = funcRun + 2
+)
+
+// globalRef is the ref of the global object in syscall/js, i.e. a NaN-boxed ID 5.
+const globalRef = 0x7ff8000000000005
+
+// baseFuncs returns the defined functions that every golden module has. The function 4 is replaced by each module.
+func baseFuncs() []function {
+	return []function{
+		// run(argc, argv) exits with the code 2048.
+		{name: "_rt0_wasm_js", typ: typeI32I32, body: code(i32Const(2048), call(0)), export: "run"},
+		{name: "wasm_pc_f_loop", typ: typeVoid, export: "resume"},
+		{name: "runtime.getsp", typ: typeRetI32, body: code(i32Const(4096)), export: "getsp"},
+		{name: "main.add", typ: typeI32I32Ret, body: code(getLocal(0), getLocal(1), op(operators.I32Add)), export: "add"},
+		{name: "unused.fn", typ: typeVoid, body: code(i32Const(1), i32Const(2), op(operators.I32Add), op(operators.Drop))},
+		{name: "runtime.alloc", typ: typeI32Ret, body: code(getLocal(0)), export: "malloc"},
+	}
+}
+
+// baseGlobals is the globals that every module has: the stack pointer and a mutable i64.
+var baseGlobals = []wasm.GlobalEntry{
+	{Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true}, Init: code(i32Const(65536), op(operators.End))},
+	{Type: wasm.GlobalVar{Type: wasm.ValueTypeI64, Mutable: true}, Init: code(i64Const(7), op(operators.End))},
+}
+
+func main() {
+	golden := filepath.Join("testdata", "golden")
+	rt := filepath.Join("testdata", "runtime")
+
+	// basic is the functions above.
+	write(golden, "basic", module{funcs: baseFuncs(), globals: baseGlobals, data: "hello, world\n"})
+
+	// indirect(i) calls the i-th function of the table, which has resume and getsp, with the type () -> i32.
+	fs := baseFuncs()
+	fs[4] = function{name: "unused.fn", typ: typeI32Ret, body: code(getLocal(0), callIndirect(typeRetI32)), export: "indirect"}
+	write(golden, "indirect", module{funcs: fs, globals: baseGlobals, data: "hello, world\n"})
+
+	// globals has the globals of each type with the edge values.
+	gs := append(append([]wasm.GlobalEntry{}, baseGlobals...),
+		wasm.GlobalEntry{Type: wasm.GlobalVar{Type: wasm.ValueTypeF32, Mutable: true}, Init: code(f32Bits(0x3fc00000), op(operators.End))}, // 1.5
+		wasm.GlobalEntry{Type: wasm.GlobalVar{Type: wasm.ValueTypeF32}, Init: code(f32Bits(0x7fc00001), op(operators.End))},                // NaN with a payload
+		wasm.GlobalEntry{Type: wasm.GlobalVar{Type: wasm.ValueTypeF64}, Init: code(f64Bits(0x8000000000000000), op(operators.End))},        // -0
+		wasm.GlobalEntry{Type: wasm.GlobalVar{Type: wasm.ValueTypeF64}, Init: code(f64Bits(0x7ff0000000000000), op(operators.End))},        // +Inf
+		wasm.GlobalEntry{Type: wasm.GlobalVar{Type: wasm.ValueTypeF64}, Init: code(f64Bits(0x3fb999999999999a), op(operators.End))},        // 0.1
+		wasm.GlobalEntry{Type: wasm.GlobalVar{Type: wasm.ValueTypeI64}, Init: code(i64Const(-1), op(operators.End))},
+	)
+	write(golden, "globals", module{funcs: baseFuncs(), globals: gs, data: "hello, world\n"})
+
+	// strings has count(s, n, m), which takes a Go string as a pointer and a length. strings.flags gives the Go source
+	// in testdata/golden/strings by -src.
+	fs = baseFuncs()
+	fs[4] = function{name: "main.count", typ: typeI32x4Ret, body: code(getLocal(1), getLocal(2), op(operators.I32Add)), export: "count"}
+	write(golden, "strings", module{funcs: fs, globals: baseGlobals, data: "hello, world\n"})
+
+	// peephole has the code that forwardSubstitute used to turn into a self-comparison and a self-assignment, which the
+	// C# compiler warns about: isnan(i) converts i to a double x and checks x != x like Go's math.IsNaN, and swap(a, b)
+	// writes a back to itself after swapping.
+	fs = baseFuncs()
+	isnan := code(getLocal(0), op(operators.F64ConvertSI32), setLocal(1), getLocal(1), getLocal(1), op(operators.F64Ne))
+	fs[4] = function{name: "main.isnan", typ: typeI32Ret, locals: []wasm.LocalEntry{{Count: 1, Type: wasm.ValueTypeF64}}, body: isnan, export: "isnan"}
+	fs = append(fs, function{name: "main.swap", typ: typeI32I32Ret, body: code(getLocal(0), getLocal(1), setLocal(0), setLocal(1), getLocal(0), setLocal(0), getLocal(1)), export: "swap"})
+	write(golden, "peephole", module{funcs: fs, globals: baseGlobals, data: "hello, world\n"})
+
+	write(rt, "reentrant", reentrant())
+	write(rt, "isolation", isolation())
+
+	// bare has only a function.
+	writeBare(golden, "bare")
+
+	writeNumeric(filepath.Join("testdata", "verify"), "numeric")
+	writeBrTable(filepath.Join("testdata", "verify"), "brtable")
+	writeExtensions(filepath.Join("testdata", "verify"), "extensions")
+	writeBrTable(golden, "brtable")
+
+	// names is also a golden file to review the identifiers.
+	write(golden, "names", names())
+	write(rt, "names", names())
+}
+
+// runtimeData is the data segment of the runtime tests, and the constants are the addresses of the strings in it.
+const (
+	runtimeData = "fObjectmarker"
+	strF        = 1024 // "f"
+	strObject   = 1025 // "Object"
+	strMarker   = 1031 // "marker"
+)
+
+// runtimeSP is the initial stack pointer of the runtime tests.
+const runtimeSP = 8192
+
+// runtimeFuncs returns run with the body, resume and getsp, which returns the global 0.
+func runtimeFuncs(run []byte) []function {
+	return []function{
+		{name: "_rt0_wasm_js", typ: typeI32I32, body: code(i32Const(runtimeSP), setGlobal(0), run), export: "run"},
+		{name: "wasm_pc_f_loop", typ: typeVoid, export: "resume"},
+		{name: "runtime.getsp", typ: typeRetI32, body: code(getGlobal(0)), export: "getsp"},
+	}
+}
+
+// reentrant calls the JavaScript function f of the global object by valueCall, and exits with the result if the call
+// succeeds, or with 100 otherwise. The result is read at the stack pointer after the call, as the host can call the
+// exported functions in f:
+//
+//   - move() moves the stack pointer and returns 7, as if Go's stack were moved during the nested call.
+//   - exit() exits the program with the code 3.
+func reentrant() module {
+	fs := append(runtimeFuncs(callF()),
+		function{name: "main.move", typ: typeRetI32, body: code(i32Const(runtimeSP/2), setGlobal(0), i32Const(7)), export: "move"},
+		function{name: "main.exit", typ: typeVoid, body: code(
+			getGlobal(0), i32Const(3), memOp(operators.I32Store, 2, 8),
+			getGlobal(0), call(importIndex("runtime.wasmExit")),
+		), export: "exit"},
+	)
+	return module{funcs: fs, globals: baseGlobals, data: runtimeData}
+}
+
+// callF returns the body of run that calls the JavaScript function f of the global object, and exits with the result
+// if the call succeeds, or with 100 otherwise.
+func callF() []byte {
+	return code(
+		// f(): the receiver, the method name and no arguments.
+		storeI64At(8, i64Const(globalRef)),
+		storeI64At(16, i64Const(strF)),
+		storeI64At(24, i64Const(1)),
+		storeI64At(32, i64Const(0)),
+		storeI64At(40, i64Const(0)),
+		storeI64At(48, i64Const(0)),
+		getGlobal(0), call(importIndex("syscall/js.valueCall")),
+		// The exit code is ok ? int32(result) : 100.
+		getGlobal(0),
+		getGlobal(0), memOp(operators.F64Load, 3, 56), op(operators.I32TruncSF64),
+		i32Const(100),
+		getGlobal(0), memOp(operators.I32Load8u, 0, 64),
+		op(operators.Select),
+		memOp(operators.I32Store, 2, 8),
+		getGlobal(0), call(importIndex("runtime.wasmExit")),
+	)
+}
+
+// names has the exports whose names are not C# identifiers as they are: keywords, a name with a hyphen, the names of
+// the members of Inst, a non-Latin-1 name, a name that is the Task-returning wrapper of another export, and the name
+// of the field of Exports. Each
+// export returns its position in the list. run calls f as reentrant does, so that the host calls the exports.
+func names() module {
+	fs := runtimeFuncs(callF())
+	for i, n := range []string{"lock", "class", "my-func", "Invoke", "GetExport", "数", "lockAsync", "go"} {
+		fs = append(fs, function{name: "main.export" + strconv.Itoa(i), typ: typeRetI32, body: code(i32Const(int32(i))), export: n})
+	}
+	return module{funcs: fs, globals: baseGlobals, data: runtimeData}
+}
+
+// isolation gets Object.marker of the global object, sets it to 1, and exits with 0 if it was undefined, or with 1
+// otherwise. A program in another instance must not see the marker.
+func isolation() module {
+	run := code(
+		// Object
+		storeI64At(8, i64Const(globalRef)),
+		storeI64At(16, i64Const(strObject)),
+		storeI64At(24, i64Const(6)),
+		getGlobal(0), call(importIndex("syscall/js.valueGet")),
+		// Object.marker
+		storeI64At(8, code(getGlobal(0), memOp(operators.I64Load, 3, 32))),
+		storeI64At(16, i64Const(strMarker)),
+		storeI64At(24, i64Const(6)),
+		getGlobal(0), call(importIndex("syscall/js.valueGet")),
+		// The exit code is whether the marker is not undefined.
+		getGlobal(0),
+		getGlobal(0), memOp(operators.I64Load, 3, 32), i64Const(0), op(operators.I64Ne),
+		memOp(operators.I32Store, 2, 64),
+		// Object.marker = 1, whose ref is the bits of the float64.
+		storeI64At(32, i64Const(0x3ff0000000000000)),
+		getGlobal(0), call(importIndex("syscall/js.valueSet")),
+		getGlobal(0),
+		getGlobal(0), memOp(operators.I32Load, 2, 64),
+		memOp(operators.I32Store, 2, 8),
+		getGlobal(0), call(importIndex("runtime.wasmExit")),
+	)
+	return module{funcs: runtimeFuncs(run), globals: baseGlobals, data: runtimeData}
+}
+
+// write writes the module to <name>.wasm in dir.
+func write(dir, name string, mod module) {
+	imports := &wasm.SectionImports{}
+	for _, n := range goImports {
+		imports.Entries = append(imports.Entries, wasm.ImportEntry{ModuleName: "go", FieldName: n, Type: wasm.FuncImport{Type: typeI32}})
+	}
+
+	fsec := &wasm.SectionFunctions{}
+	csec := &wasm.SectionCode{}
+	exports := &wasm.SectionExports{Entries: map[string]wasm.ExportEntry{
+		"mem": {FieldStr: "mem", Kind: wasm.ExternalMemory, Index: 0},
+	}}
+	var names bytes.Buffer
+	leb128.WriteVarUint32(&names, uint32(len(mod.funcs)))
+	for i, f := range mod.funcs {
+		idx := funcRun + uint32(i)
+		fsec.Types = append(fsec.Types, f.typ)
+		csec.Bodies = append(csec.Bodies, wasm.FunctionBody{Locals: f.locals, Code: f.body})
+		if f.export != "" {
+			exports.Entries[f.export] = wasm.ExportEntry{FieldStr: f.export, Kind: wasm.ExternalFunction, Index: idx}
+		}
+		leb128.WriteVarUint32(&names, idx)
+		leb128.WriteVarUint32(&names, uint32(len(f.name)))
+		names.WriteString(f.name)
+	}
+	// The name section has only the function names (subsection 1).
+	var nameSec bytes.Buffer
+	nameSec.WriteByte(1)
+	leb128.WriteVarUint32(&nameSec, uint32(names.Len()))
+	nameSec.Write(names.Bytes())
+
+	encode(dir, name,
+		&wasm.SectionTypes{Entries: types},
+		imports,
+		fsec,
+		&wasm.SectionTables{Entries: []wasm.Table{{ElementType: wasm.ElemTypeAnyFunc, Limits: wasm.ResizableLimits{Initial: 2}}}},
+		&wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}},
+		&wasm.SectionGlobals{Globals: mod.globals},
+		exports,
+		&wasm.SectionElements{Entries: []wasm.ElementSegment{{Offset: code(i32Const(0), op(operators.End)), Elems: []uint32{funcResume, funcGetSP}}}},
+		csec,
+		&wasm.SectionData{Entries: []wasm.DataSegment{{Offset: code(i32Const(1024), op(operators.End)), Data: []byte(mod.data)}}},
+		&wasm.SectionCustom{Name: "name", Data: nameSec.Bytes()},
+	)
+}
+
+// writeBare writes a module that has only a function, without the imports, the table, the memory, the globals or
+// the exports, which are all optional.
+func writeBare(dir, name string) {
+	encode(dir, name,
+		&wasm.SectionTypes{Entries: types},
+		&wasm.SectionFunctions{Types: []uint32{typeI32I32Ret}},
+		&wasm.SectionCode{Bodies: []wasm.FunctionBody{{Code: code(getLocal(0), getLocal(1), op(operators.I32Add))}}},
+	)
+}
+
+// numericOps is the instructions that numeric exports, each of which is exported by its name.
+var numericOps = []byte{
+	operators.I32Shl, operators.I32ShrS, operators.I32ShrU, operators.I32Rotl, operators.I32Rotr,
+	operators.I64Shl, operators.I64ShrS, operators.I64ShrU, operators.I64Rotl, operators.I64Rotr,
+	operators.I32DivS, operators.I32RemS, operators.I64DivS, operators.I64RemS,
+	operators.F32Min, operators.F32Max, operators.F64Min, operators.F64Max,
+	operators.I32TruncSF32, operators.I32TruncUF32, operators.I32TruncSF64, operators.I32TruncUF64,
+	operators.I64TruncSF32, operators.I64TruncUF32, operators.I64TruncSF64, operators.I64TruncUF64,
+	operators.F32ConvertUI64, operators.F64ConvertUI64,
+}
+
+// writeNumeric writes a module for verify, which exports a function for each of numericOps that applies the
+// instruction to the parameters. Like a module written in the text format, the module has no imports, table or
+// memory.
+func writeNumeric(dir, name string) {
+	tsec := &wasm.SectionTypes{}
+	fsec := &wasm.SectionFunctions{}
+	csec := &wasm.SectionCode{}
+	exports := &wasm.SectionExports{Entries: map[string]wasm.ExportEntry{}}
+	for i, o := range numericOps {
+		op, err := operators.New(o)
+		if err != nil {
+			panic(err)
+		}
+		fsec.Types = append(fsec.Types, uint32(len(tsec.Entries)))
+		tsec.Entries = append(tsec.Entries, wasm.FunctionSig{Form: 0x60, ParamTypes: op.Args, ReturnTypes: []wasm.ValueType{op.Returns}})
+		var body []byte
+		for j := range op.Args {
+			body = append(body, getLocal(uint32(j))...)
+		}
+		csec.Bodies = append(csec.Bodies, wasm.FunctionBody{Code: append(body, o)})
+		exports.Entries[op.Name] = wasm.ExportEntry{FieldStr: op.Name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+	encode(dir, name, tsec, fsec, exports, csec)
+}
+
+// extensionOps is the instructions of the sign extension and the non-trapping float-to-int conversion proposals that
+// extensions exports. The instructions with the prefix 0xfc have the instruction number after it.
+var extensionOps = []struct {
+	name     string
+	code     []byte
+	from, to wasm.ValueType
+}{
+	{"i32.extend8_s", []byte{0xc0}, wasm.ValueTypeI32, wasm.ValueTypeI32},
+	{"i32.extend16_s", []byte{0xc1}, wasm.ValueTypeI32, wasm.ValueTypeI32},
+	{"i64.extend8_s", []byte{0xc2}, wasm.ValueTypeI64, wasm.ValueTypeI64},
+	{"i64.extend16_s", []byte{0xc3}, wasm.ValueTypeI64, wasm.ValueTypeI64},
+	{"i64.extend32_s", []byte{0xc4}, wasm.ValueTypeI64, wasm.ValueTypeI64},
+	{"i32.trunc_sat_f32_s", []byte{0xfc, 0}, wasm.ValueTypeF32, wasm.ValueTypeI32},
+	{"i32.trunc_sat_f32_u", []byte{0xfc, 1}, wasm.ValueTypeF32, wasm.ValueTypeI32},
+	{"i32.trunc_sat_f64_s", []byte{0xfc, 2}, wasm.ValueTypeF64, wasm.ValueTypeI32},
+	{"i32.trunc_sat_f64_u", []byte{0xfc, 3}, wasm.ValueTypeF64, wasm.ValueTypeI32},
+	{"i64.trunc_sat_f32_s", []byte{0xfc, 4}, wasm.ValueTypeF32, wasm.ValueTypeI64},
+	{"i64.trunc_sat_f32_u", []byte{0xfc, 5}, wasm.ValueTypeF32, wasm.ValueTypeI64},
+	{"i64.trunc_sat_f64_s", []byte{0xfc, 6}, wasm.ValueTypeF64, wasm.ValueTypeI64},
+	{"i64.trunc_sat_f64_u", []byte{0xfc, 7}, wasm.ValueTypeF64, wasm.ValueTypeI64},
+}
+
+// writeExtensions writes a module for verify, which exports a function for each of extensionOps that applies the
+// instruction to the parameter. go2dotnet lowers the instructions to MVP ones, so both the interpreter and the
+// translated code run the lowered ones. The module has no memory, like numeric.
+func writeExtensions(dir, name string) {
+	tsec := &wasm.SectionTypes{}
+	fsec := &wasm.SectionFunctions{}
+	csec := &wasm.SectionCode{}
+	exports := &wasm.SectionExports{Entries: map[string]wasm.ExportEntry{}}
+	for i, o := range extensionOps {
+		fsec.Types = append(fsec.Types, uint32(len(tsec.Entries)))
+		tsec.Entries = append(tsec.Entries, wasm.FunctionSig{Form: 0x60, ParamTypes: []wasm.ValueType{o.from}, ReturnTypes: []wasm.ValueType{o.to}})
+		csec.Bodies = append(csec.Bodies, wasm.FunctionBody{Code: code(getLocal(0), o.code)})
+		exports.Entries[o.name] = wasm.ExportEntry{FieldStr: o.name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+	encode(dir, name, tsec, fsec, exports, csec)
+}
+
+// writeBrTable writes a module for verify and the golden files, which exports the functions with a sparse br_table
+// and a dense one. Each function returns the level that the br_table branches to, and the functions with the suffix
+// _small pass the parameter modulo 25 or 8 so that most of the calls don't branch to the default.
+func writeBrTable(dir, name string) {
+	// The sparse table has 20 indices, which mostly branch to the default.
+	sparse := make([]uint32, 20)
+	sparse[3] = 1
+	sparse[10] = 2
+	sparse[11] = 2
+	body := func(table []uint32, def uint32) []byte {
+		return code(
+			block(), block(), block(), getLocal(0), brTable(table, def), op(operators.End),
+			i32Const(100), op(operators.Return), op(operators.End),
+			i32Const(101), op(operators.Return), op(operators.End),
+			i32Const(102),
+		)
+	}
+	remU := func(f uint32, n int32) []byte {
+		return code(getLocal(0), i32Const(n), op(operators.I32RemU), call(f))
+	}
+	fns := []struct {
+		name string
+		code []byte
+	}{
+		{"sparse", body(sparse, 0)},
+		{"dense", body([]uint32{1, 2, 1, 0}, 2)},
+		{"sparse_small", remU(0, 25)},
+		{"dense_small", remU(1, 8)},
+	}
+	fsec := &wasm.SectionFunctions{}
+	csec := &wasm.SectionCode{}
+	exports := &wasm.SectionExports{Entries: map[string]wasm.ExportEntry{}}
+	for i, f := range fns {
+		fsec.Types = append(fsec.Types, typeI32Ret)
+		csec.Bodies = append(csec.Bodies, wasm.FunctionBody{Code: f.code})
+		exports.Entries[f.name] = wasm.ExportEntry{FieldStr: f.name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+	encode(dir, name, &wasm.SectionTypes{Entries: types}, fsec, exports, csec)
+}
+
+// encode writes the module of the sections to <name>.wasm in dir.
+func encode(dir, name string, sections ...wasm.Section) {
+	m := &wasm.Module{Sections: sections}
+	var buf bytes.Buffer
+	if err := wasm.EncodeModule(&buf, m); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".wasm"), buf.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func importIndex(name string) uint32 {
+	for i, n := range goImports {
+		if n == name {
+			return uint32(i)
+		}
+	}
+	panic("unknown import: " + name)
+}
+
+func code(instrs ...[]byte) []byte {
+	var b []byte
+	for _, i := range instrs {
+		b = append(b, i...)
+	}
+	return b
+}
+
+func op(o byte) []byte {
+	return []byte{o}
+}
+
+func getLocal(i uint32) []byte {
+	return leb128.AppendUleb128([]byte{operators.GetLocal}, uint64(i))
+}
+
+func getGlobal(i uint32) []byte {
+	return leb128.AppendUleb128([]byte{operators.GetGlobal}, uint64(i))
+}
+
+func setLocal(i uint32) []byte {
+	return leb128.AppendUleb128([]byte{operators.SetLocal}, uint64(i))
+}
+
+func setGlobal(i uint32) []byte {
+	return leb128.AppendUleb128([]byte{operators.SetGlobal}, uint64(i))
+}
+
+// block returns a block without a result.
+func block() []byte {
+	return []byte{operators.Block, byte(wasm.BlockTypeEmpty)}
+}
+
+func brTable(levels []uint32, def uint32) []byte {
+	b := leb128.AppendUleb128([]byte{operators.BrTable}, uint64(len(levels)))
+	for _, l := range levels {
+		b = leb128.AppendUleb128(b, uint64(l))
+	}
+	return leb128.AppendUleb128(b, uint64(def))
+}
+
+func call(f uint32) []byte {
+	return leb128.AppendUleb128([]byte{operators.Call}, uint64(f))
+}
+
+func callIndirect(typ uint32) []byte {
+	// The table index is always 0.
+	return append(leb128.AppendUleb128([]byte{operators.CallIndirect}, uint64(typ)), 0)
+}
+
+// memOp returns a load or a store with the alignment as a power of 2 and the offset.
+func memOp(o byte, align, offset uint32) []byte {
+	return leb128.AppendUleb128(leb128.AppendUleb128([]byte{o}, uint64(align)), uint64(offset))
+}
+
+// storeI64At stores the i64 value at the stack pointer + offset.
+func storeI64At(offset uint32, value []byte) []byte {
+	return code(getGlobal(0), value, memOp(operators.I64Store, 3, offset))
+}
+
+func i32Const(v int32) []byte {
+	return leb128.AppendSleb128([]byte{operators.I32Const}, int64(v))
+}
+
+func i64Const(v int64) []byte {
+	return leb128.AppendSleb128([]byte{operators.I64Const}, v)
+}
+
+func f32Bits(bits uint32) []byte {
+	return []byte{operators.F32Const, byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24)}
+}
+
+func f64Bits(bits uint64) []byte {
+	b := []byte{operators.F64Const}
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(bits>>(8*i)))
+	}
+	return b
+}
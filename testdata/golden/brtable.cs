@@ -0,0 +1,3693 @@
+// Code generated by go2dotnet. DO NOT EDIT.
+//
+// go2dotnet version: (golden)
+// Input SHA-256:     91e41f6c27a5810fe795b576414399beed65f2e4df6b37ea0b8e90a68bee146d
+// Command line:      go2dotnet -wasm brtable.wasm -namespace Go2DotNet.Golden
+
+#nullable disable
+
+using System;
+using System.Collections.Concurrent;
+using System.Collections.Generic;
+using System.Diagnostics;
+using System.Dynamic;
+using System.Globalization;
+using System.IO;
+using System.Linq;
+using System.Reflection;
+using System.Runtime.CompilerServices;
+using System.Runtime.InteropServices;
+using System.Security.Cryptography;
+using System.Text;
+using System.Threading.Tasks;
+using System.Timers;
+
+using CancellationToken = System.Threading.CancellationToken;
+
+namespace Go2DotNet.Golden
+{
+    sealed class Mem
+    {
+        internal const int PageSize = 64 * 1024;
+
+        // maxPages is the maximum number of pages the memory can grow to.
+        public Mem(int maxPages)
+        {
+            this.maxPages = Math.Min(maxPages, 32767);
+            this.bytes = new byte[0 * PageSize];
+        }
+
+        internal int Size
+        {
+            get
+            {
+                return this.bytes.Length;
+            }
+        }
+
+        internal int Pages
+        {
+            get
+            {
+                return this.Size / PageSize;
+            }
+        }
+
+        // Grow grows the memory by delta pages, and returns the previous number of pages.
+        // This returns -1 when the memory cannot grow, so that the Go runtime reports out of memory.
+        internal int Grow(int delta)
+        {
+            var prevPages = this.Pages;
+            if (delta < 0 || (long)prevPages + delta > this.maxPages)
+            {
+                return -1;
+            }
+            try
+            {
+                Array.Resize(ref this.bytes, (prevPages + delta) * PageSize);
+            }
+            catch (OutOfMemoryException)
+            {
+                return -1;
+            }
+            this.Generation++;
+            return prevPages;
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal sbyte LoadInt8(int addr)
+        {
+            return (sbyte)this.bytes[addr];
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal byte LoadUint8(int addr)
+        {
+            return this.bytes[addr];
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal short LoadInt16(int addr)
+        {
+            return (short)((ushort)this.bytes[addr] | (ushort)(this.bytes[addr+1]) << 8);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal ushort LoadUint16(int addr)
+        {
+            return (ushort)((ushort)this.bytes[addr] | (ushort)(this.bytes[addr+1]) << 8);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal int LoadInt32(int addr)
+        {
+            return (int)((uint)this.bytes[addr] |
+                (uint)(this.bytes[addr+1]) << 8 |
+                (uint)(this.bytes[addr+2]) << 16 |
+                (uint)(this.bytes[addr+3]) << 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal uint LoadUint32(int addr)
+        {
+            return (uint)((uint)this.bytes[addr] |
+                (uint)(this.bytes[addr+1]) << 8 |
+                (uint)(this.bytes[addr+2]) << 16 |
+                (uint)(this.bytes[addr+3]) << 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal long LoadInt64(int addr)
+        {
+            return (long)((ulong)this.bytes[addr] |
+                (ulong)(this.bytes[addr+1]) << 8 |
+                (ulong)(this.bytes[addr+2]) << 16 |
+                (ulong)(this.bytes[addr+3]) << 24 |
+                (ulong)(this.bytes[addr+4]) << 32 |
+                (ulong)(this.bytes[addr+5]) << 40 |
+                (ulong)(this.bytes[addr+6]) << 48 |
+                (ulong)(this.bytes[addr+7]) << 56);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal float LoadFloat32(int addr)
+        {
+            int bits = LoadInt32(addr);
+            return Unsafe.As<int, float>(ref bits);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal double LoadFloat64(int addr)
+        {
+            long bits = LoadInt64(addr);
+            return Unsafe.As<long, double>(ref bits);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt8(int addr, sbyte val)
+        {
+            this.bytes[addr] = (byte)val;
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt16(int addr, short val)
+        {
+            this.bytes[addr] = (byte)val;
+            this.bytes[addr+1] = (byte)(val >> 8);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt32(int addr, int val)
+        {
+            this.bytes[addr] = (byte)val;
+            this.bytes[addr+1] = (byte)(val >> 8);
+            this.bytes[addr+2] = (byte)(val >> 16);
+            this.bytes[addr+3] = (byte)(val >> 24);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreInt64(int addr, long val)
+        {
+            this.bytes[addr] = (byte)val;
+            this.bytes[addr+1] = (byte)(val >> 8);
+            this.bytes[addr+2] = (byte)(val >> 16);
+            this.bytes[addr+3] = (byte)(val >> 24);
+            this.bytes[addr+4] = (byte)(val >> 32);
+            this.bytes[addr+5] = (byte)(val >> 40);
+            this.bytes[addr+6] = (byte)(val >> 48);
+            this.bytes[addr+7] = (byte)(val >> 56);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreFloat32(int addr, float val)
+        {
+            this.StoreInt32(addr, Unsafe.As<float, int>(ref val));
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void StoreFloat64(int addr, double val)
+        {
+            this.StoreInt64(addr, Unsafe.As<double, long>(ref val));
+        }
+
+        internal void StoreBytes(int addr, byte[] bytes)
+        {
+            for (int i = 0; i < bytes.Length; i++)
+            {
+                this.bytes[addr+i] = bytes[i];
+            }
+        }
+
+        // Fill sets n bytes at addr to val, like memory.fill.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void Fill(int addr, byte val, int n)
+        {
+            this.bytes.AsSpan(addr, n).Fill(val);
+        }
+
+        // Copy copies n bytes from src to dst, like memory.copy. The ranges can overlap.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        internal void Copy(int dst, int src, int n)
+        {
+            this.bytes.AsSpan(src, n).CopyTo(this.bytes.AsSpan(dst, n));
+        }
+
+        internal ArraySegment<byte> LoadSlice(int addr)
+        {
+            var array = this.LoadInt64(addr);
+            var len = this.LoadInt64(addr + 8);
+            return new ArraySegment<byte>(this.bytes, (int)array, (int)len);
+        }
+
+        internal ArraySegment<byte> LoadSliceDirectly(long array, int len)
+        {
+            return new ArraySegment<byte>(this.bytes, (int)array, len);
+        }
+
+        internal string LoadString(int addr)
+        {
+            var saddr = this.LoadInt64(addr);
+            var len = this.LoadInt64(addr + 8);
+            return this.LoadStringDirectly(saddr, (int)len);
+        }
+
+        internal string LoadStringDirectly(long addr, int len)
+        {
+            return Encoding.UTF8.GetString(this.bytes, (int)addr, len);
+        }
+
+        internal int StoreString(int addr, string str)
+        {
+            var bytes = Encoding.UTF8.GetBytes(str);
+            this.StoreBytes(addr, bytes);
+            return bytes.Length;
+        }
+
+        internal void Save(BinaryWriter writer)
+        {
+            writer.Write(this.Pages);
+            writer.Write(this.bytes);
+        }
+
+        // ReadSnapshot reads the memory written by Save. The memory is not modified until Reset is called.
+        internal byte[] ReadSnapshot(BinaryReader reader)
+        {
+            int pages = reader.ReadInt32();
+            if (pages < 0 || pages > this.maxPages)
+            {
+                throw new InvalidDataException($"the snapshot has {pages} pages but the memory can have at most {this.maxPages} pages");
+            }
+            var bytes = reader.ReadBytes(pages * PageSize);
+            if (bytes.Length != pages * PageSize)
+            {
+                throw new EndOfStreamException();
+            }
+            return bytes;
+        }
+
+        internal void Reset(byte[] bytes)
+        {
+            this.bytes = bytes;
+            this.Generation++;
+        }
+
+        // Generation is incremented whenever the bytes are replaced, which invalidates the views of the memory.
+        internal int Generation { get; private set; }
+
+        private byte[] bytes;
+        private int maxPages;
+    }
+
+    // IImportResolver provides implementations of imported functions that go2dotnet doesn't implement,
+    // like functions declared by //go:wasmimport.
+    public interface IImportResolver
+    {
+        // Resolve returns an Action<...> or a Func<...> matching the import's signature, or null if not provided.
+        Delegate Resolve(string module, string name);
+    }
+
+    public sealed class DictionaryImportResolver : IImportResolver
+    {
+        public DictionaryImportResolver(IDictionary<(string, string), Delegate> imports)
+        {
+            this.imports = imports;
+        }
+
+        public Delegate Resolve(string module, string name)
+        {
+            Delegate d;
+            if (this.imports.TryGetValue((module, name), out d))
+            {
+                return d;
+            }
+            return null;
+        }
+
+        private IDictionary<(string, string), Delegate> imports;
+    }
+
+    // GoPanicException is thrown when the Go program exits due to a panic or a fatal error.
+    public sealed class GoPanicException : Exception
+    {
+        public GoPanicException(string message, string goStackTrace, int exitCode)
+            : base(message)
+        {
+            this.GoStackTrace = goStackTrace;
+            this.ExitCode = exitCode;
+        }
+
+        // GoStackTrace is the goroutine stack traces printed by the Go runtime.
+        public string GoStackTrace { get; }
+
+        public int ExitCode { get; }
+
+        public override string ToString()
+        {
+            return base.ToString() + Environment.NewLine + this.GoStackTrace;
+        }
+    }
+
+    // GoExitedException is thrown to unwind the frames when the Go program exits in a nested call.
+    sealed class GoExitedException : Exception
+    {
+        public GoExitedException()
+            : base("Go program has exited")
+        {
+        }
+    }
+
+    public sealed class JSValueStats
+    {
+        public JSValueStats(int liveCount, long createdCount, long finalizedCount)
+        {
+            this.LiveCount = liveCount;
+            this.CreatedCount = createdCount;
+            this.FinalizedCount = finalizedCount;
+        }
+
+        // LiveCount is the number of values currently referenced by the Go program.
+        public int LiveCount { get; }
+
+        // CreatedCount is the total number of values registered in the value table.
+        public long CreatedCount { get; }
+
+        // FinalizedCount is the total number of values released by finalizeRef.
+        public long FinalizedCount { get; }
+    }
+
+    // GoDebugOptions are common GODEBUG settings to diagnose the Go program.
+    [Flags]
+    public enum GoDebugOptions
+    {
+        None = 0,
+
+        // GCTrace prints a line for each garbage collection (gctrace=1).
+        GCTrace = 1 << 0,
+
+        // ScavTrace prints a summary of the scavenger's work (scavtrace=1).
+        ScavTrace = 1 << 1,
+
+        // InitTrace prints the time and the allocations of each package initialization (inittrace=1).
+        InitTrace = 1 << 2,
+
+        // SchedTrace sets schedtrace=1000, which prints the scheduler state every second on the other platforms.
+        // This prints nothing on js/wasm, as the state is printed by sysmon, which Go's runtime never starts on wasm.
+        SchedTrace = 1 << 3,
+    }
+
+    // GoThreadingModel specifies where the event loop of the Go program runs.
+    public enum GoThreadingModel
+    {
+        // ThreadPool runs the event loop on a thread pool thread.
+        ThreadPool,
+
+        // DedicatedThread runs the event loop on a new background thread.
+        DedicatedThread,
+
+        // SynchronizationContext runs the event loop on the SynchronizationContext of the thread calling RunAsync, e.g. a UI thread.
+        SynchronizationContext,
+    }
+
+    // BrowserApiBehavior specifies what the Go program gets when it accesses a browser API like document.
+    public enum BrowserApiBehavior
+    {
+        // Undefined returns undefined as Node.js does.
+        Undefined,
+
+        // Throw throws NotSupportedException.
+        Throw,
+
+        // Shim returns the value from Go.BrowserApiShim.
+        Shim,
+    }
+
+    internal interface IImport
+    {
+    }
+
+    class JSObject
+    {
+        public static readonly JSObject Undefined = new JSObject("undefined");
+
+        // NewGlobal creates a global object with the given fs module.
+        // post is used to invoke promise reactions asynchronously.
+        // fallback is used to resolve properties that don't exist.
+        public static JSObject NewGlobal(JSObject fs, Action<Action> post, Func<string, object> fallback)
+        {
+            // The constructors are created per global so that a Go program mutating them doesn't affect
+            // other instances.
+            var objectConstructor = new JSFunction("Object", null, (object[] args) => {
+                if (args.Length > 0 && args[0] != null && args[0] != Undefined)
+                {
+                    return args[0];
+                }
+                return new JSObject(new Dictionary<string, object>());
+            }, typeof(JSObject));
+            objectConstructor.Set("keys", new JSFunction("keys", (object self, object[] args) => {
+                return Keys(args[0]).Cast<object>().ToList();
+            }));
+            var arrayConstructor = new JSFunction("Array", null, (object[] args) => {
+                if (args.Length == 1 && !(args[0] is string))
+                {
+                    return Enumerable.Repeat((object)Undefined, (int)ToNumber(args[0])).ToList();
+                }
+                return new List<object>(args);
+            }, typeof(List<object>));
+            var uint8ArrayConstructor = new JSFunction("Uint8Array", null, (object[] args) => {
+                if (args.Length == 0)
+                {
+                    return new byte[0];
+                }
+                return new byte[(int)ToNumber(args[0])];
+            }, typeof(byte[]));
+
+            JSObject process = new JSObject("process", new Dictionary<string, object>()
+            {
+                {"pid", -1},
+                {"ppid", -1},
+                {"cwd", new JSFunction("cwd", (object self, object[] args) => {
+                    return Directory.GetCurrentDirectory();
+                })},
+            });
+            foreach (var name in new string[] { "getuid", "getgid", "geteuid", "getegid" })
+            {
+                process.Set(name, new JSFunction(name, (object self, object[] args) => {
+                    return -1;
+                }));
+            }
+
+            return new JSGlobal(fallback, new Dictionary<string, object>()
+            {
+                {"Object", objectConstructor},
+                {"Array", arrayConstructor},
+                {"process", process},
+                {"fs", fs},
+                {"Uint8Array", uint8ArrayConstructor},
+                {"Promise", JSPromise.NewConstructor(post)},
+            });
+        }
+
+        public static double ToNumber(object value)
+        {
+            if (value == null)
+            {
+                return 0;
+            }
+            if (value == Undefined)
+            {
+                return double.NaN;
+            }
+            if (value is bool)
+            {
+                return (bool)value ? 1 : 0;
+            }
+            if (value is string)
+            {
+                return StringToNumber((string)value);
+            }
+            if (value is IConvertible)
+            {
+                return Convert.ToDouble(value, CultureInfo.InvariantCulture);
+            }
+            return double.NaN;
+        }
+
+        // StringToNumber converts the string to a number in the same way as JavaScript's Number(str),
+        // regardless of the current culture.
+        public static double StringToNumber(string str)
+        {
+            str = str.Trim();
+            if (str == "")
+            {
+                return 0;
+            }
+            switch (str)
+            {
+            case "Infinity":
+            case "+Infinity":
+                return double.PositiveInfinity;
+            case "-Infinity":
+                return double.NegativeInfinity;
+            }
+            if (str.Length > 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X'))
+            {
+                ulong hex;
+                if (ulong.TryParse(str.Substring(2), NumberStyles.AllowHexSpecifier, CultureInfo.InvariantCulture, out hex))
+                {
+                    return hex;
+                }
+                return double.NaN;
+            }
+            double d;
+            if (str.All(c => ('0' <= c && c <= '9') || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-') &&
+                double.TryParse(str, NumberStyles.Float, CultureInfo.InvariantCulture, out d))
+            {
+                return d;
+            }
+            return double.NaN;
+        }
+
+        // NumberToString converts the number to a string in the same way as JavaScript's Number.prototype.toString,
+        // regardless of the current culture.
+        public static string NumberToString(double d)
+        {
+            if (double.IsNaN(d))
+            {
+                return "NaN";
+            }
+            if (double.IsPositiveInfinity(d))
+            {
+                return "Infinity";
+            }
+            if (double.IsNegativeInfinity(d))
+            {
+                return "-Infinity";
+            }
+            if (d == 0)
+            {
+                // This includes -0.
+                return "0";
+            }
+
+            // Get the shortest digits that round-trip, and the position of the decimal point.
+            var sign = d < 0 ? "-" : "";
+            var r = Math.Abs(d).ToString("R", CultureInfo.InvariantCulture);
+            var exp = 0;
+            var idx = r.IndexOfAny(new char[] { 'E', 'e' });
+            if (idx >= 0)
+            {
+                exp = int.Parse(r.Substring(idx + 1), NumberStyles.AllowLeadingSign, CultureInfo.InvariantCulture);
+                r = r.Substring(0, idx);
+            }
+            var point = r.IndexOf('.');
+            var n = (point >= 0 ? point : r.Length) + exp;
+            var digits = r.Replace(".", "");
+            var leadingZeros = digits.Length - digits.TrimStart('0').Length;
+            digits = digits.Substring(leadingZeros).TrimEnd('0');
+            n -= leadingZeros;
+            var k = digits.Length;
+
+            if (k <= n && n <= 21)
+            {
+                return sign + digits + new string('0', n - k);
+            }
+            if (0 < n && n <= 21)
+            {
+                return sign + digits.Substring(0, n) + "." + digits.Substring(n);
+            }
+            if (-6 < n && n <= 0)
+            {
+                return sign + "0." + new string('0', -n) + digits;
+            }
+            var e = n - 1;
+            var expStr = (e < 0 ? "e-" : "e+") + Math.Abs(e).ToString(CultureInfo.InvariantCulture);
+            if (k == 1)
+            {
+                return sign + digits + expStr;
+            }
+            return sign + digits.Substring(0, 1) + "." + digits.Substring(1) + expStr;
+        }
+
+        // Stringify converts the value to a string in the same way as JavaScript's String(value).
+        public static string Stringify(object value)
+        {
+            if (value == null)
+            {
+                return "null";
+            }
+            if (value is string)
+            {
+                return (string)value;
+            }
+            if (value is bool)
+            {
+                return (bool)value ? "true" : "false";
+            }
+            if (value is byte[])
+            {
+                return string.Join(",", ((byte[])value).Select(b => b.ToString(CultureInfo.InvariantCulture)));
+            }
+            if (value is List<object>)
+            {
+                return string.Join(",", ((List<object>)value).Select(v => (v == null || v == Undefined) ? "" : Stringify(v)));
+            }
+            if (value is JSObject)
+            {
+                return value.ToString();
+            }
+            if (value is Exception)
+            {
+                return "Error: " + ((Exception)value).Message;
+            }
+            if (!(value is IConvertible))
+            {
+                return "[object Object]";
+            }
+            return NumberToString(ToNumber(value));
+        }
+
+        public static object ReflectGet(object target, string key)
+        {
+            if (target == Undefined || target == null)
+            {
+                throw new Exception($"cannot read property {key} of {Stringify(target)}");
+            }
+            if (target is JSObject)
+            {
+                return ((JSObject)target).Get(key);
+            }
+            if (target is IDictionary<string, object>)
+            {
+                object value;
+                if (((IDictionary<string, object>)target).TryGetValue(key, out value))
+                {
+                    return value;
+                }
+                return Undefined;
+            }
+            if (key == "length")
+            {
+                if (target is string)
+                {
+                    return ((string)target).Length;
+                }
+                if (target is byte[])
+                {
+                    return ((byte[])target).Length;
+                }
+                if (target is List<object>)
+                {
+                    return ((List<object>)target).Count;
+                }
+            }
+            if (target is Exception && key == "message")
+            {
+                return ((Exception)target).Message;
+            }
+            throw new Exception($"{target}.{key} not found");
+        }
+
+        public static void ReflectSet(object target, string key, object value)
+        {
+            if (target is JSObject && target != Undefined)
+            {
+                ((JSObject)target).Set(key, value);
+                return;
+            }
+            if (target is IDictionary<string, object>)
+            {
+                ((IDictionary<string, object>)target)[key] = value;
+                return;
+            }
+            throw new Exception($"cannot set {key} on {Stringify(target)}");
+        }
+
+        public static void ReflectDelete(object target, string key)
+        {
+            if (target is JSObject && target != Undefined)
+            {
+                ((JSObject)target).Delete(key);
+                return;
+            }
+            if (target is IDictionary<string, object>)
+            {
+                ((IDictionary<string, object>)target).Remove(key);
+                return;
+            }
+            throw new Exception($"cannot delete {key} from {Stringify(target)}");
+        }
+
+        public static object ReflectGetIndex(object target, long index)
+        {
+            if (target is List<object>)
+            {
+                var list = (List<object>)target;
+                if (index < 0 || index >= list.Count)
+                {
+                    return Undefined;
+                }
+                return list[(int)index];
+            }
+            if (target is byte[])
+            {
+                var bytes = (byte[])target;
+                if (index < 0 || index >= bytes.Length)
+                {
+                    return Undefined;
+                }
+                return bytes[index];
+            }
+            return ReflectGet(target, index.ToString(CultureInfo.InvariantCulture));
+        }
+
+        public static void ReflectSetIndex(object target, long index, object value)
+        {
+            if (target is List<object>)
+            {
+                var list = (List<object>)target;
+                while (list.Count <= index)
+                {
+                    list.Add(Undefined);
+                }
+                list[(int)index] = value;
+                return;
+            }
+            if (target is byte[])
+            {
+                var bytes = (byte[])target;
+                if (0 <= index && index < bytes.Length)
+                {
+                    bytes[index] = (byte)ToNumber(value);
+                }
+                return;
+            }
+            ReflectSet(target, index.ToString(CultureInfo.InvariantCulture), value);
+        }
+
+        public static object ReflectApply(object target, object thisArgument, object[] args)
+        {
+            if (target is JSFunction)
+            {
+                return ((JSFunction)target).Invoke(thisArgument, args);
+            }
+            throw new Exception($"{Stringify(target)} is not a function");
+        }
+
+        public static object ReflectConstruct(object target, object[] args)
+        {
+            if (target is JSFunction)
+            {
+                return ((JSFunction)target).Construct(args);
+            }
+            throw new Exception($"{Stringify(target)} is not a constructor");
+        }
+
+        // Keys returns the property names of the object in the same way as JavaScript's Object.keys.
+        public static IEnumerable<string> Keys(object target)
+        {
+            if (target is JSObject && target != Undefined)
+            {
+                return ((JSObject)target).values.Keys.ToList();
+            }
+            if (target is IDictionary<string, object>)
+            {
+                return ((IDictionary<string, object>)target).Keys.ToList();
+            }
+            if (target is List<object>)
+            {
+                return Enumerable.Range(0, ((List<object>)target).Count).Select(i => i.ToString(CultureInfo.InvariantCulture)).ToList();
+            }
+            if (target is byte[])
+            {
+                return Enumerable.Range(0, ((byte[])target).Length).Select(i => i.ToString(CultureInfo.InvariantCulture)).ToList();
+            }
+            if (target == null || target == Undefined)
+            {
+                throw new Exception($"cannot convert {Stringify(target)} to object");
+            }
+            return Enumerable.Empty<string>();
+        }
+
+        // ToDynamic converts the JavaScript value into a .NET object graph.
+        // Objects are converted into ExpandoObject, arrays into List<object> and undefined into null.
+        public static object ToDynamic(object value)
+        {
+            return ToDynamic(value, new Dictionary<object, object>());
+        }
+
+        private static object ToDynamic(object value, Dictionary<object, object> converted)
+        {
+            if (value == Undefined)
+            {
+                return null;
+            }
+            if (value == null || value is JSFunction)
+            {
+                return value;
+            }
+            if (converted.ContainsKey(value))
+            {
+                return converted[value];
+            }
+            if (value is JSObject || value is IDictionary<string, object>)
+            {
+                var obj = new ExpandoObject();
+                converted[value] = obj;
+                var dict = (IDictionary<string, object>)obj;
+                foreach (var key in Keys(value))
+                {
+                    dict[key] = ToDynamic(ReflectGet(value, key), converted);
+                }
+                return obj;
+            }
+            if (value is List<object>)
+            {
+                var list = new List<object>();
+                converted[value] = list;
+                foreach (var v in (List<object>)value)
+                {
+                    list.Add(ToDynamic(v, converted));
+                }
+                return list;
+            }
+            return value;
+        }
+
+        public static double Length(object target)
+        {
+            return ToNumber(ReflectGet(target, "length"));
+        }
+
+        public static bool InstanceOf(object value, object type)
+        {
+            if (type is JSFunction)
+            {
+                return ((JSFunction)type).IsInstance(value);
+            }
+            throw new Exception($"right-hand side of instanceof is not callable: {Stringify(type)}");
+        }
+
+        public JSObject(Dictionary<string, object> values)
+            : this("(JSObject)", values)
+        {
+        }
+
+        public JSObject(string name)
+            : this(name, new Dictionary<string, object>())
+        {
+        }
+
+        public JSObject(string name, Dictionary<string, object> values)
+        {
+            this.name = name;
+            this.values = values;
+        }
+
+        // Get returns the property value, or undefined if the property doesn't exist as JavaScript does.
+        public virtual object Get(string key)
+        {
+            object value;
+            if (this.values.TryGetValue(key, out value))
+            {
+                return value;
+            }
+            return Undefined;
+        }
+
+        public bool Has(string key)
+        {
+            return this.values.ContainsKey(key);
+        }
+
+        public void Set(string key, object value)
+        {
+            this.values[key] = value;
+        }
+
+        public void Delete(string key)
+        {
+            this.values.Remove(key);
+        }
+
+        public override string ToString()
+        {
+            return this.name;
+        }
+
+        private Dictionary<string, object> values;
+        private string name;
+    }
+
+    sealed class JSGlobal : JSObject
+    {
+        public JSGlobal(Func<string, object> fallback, Dictionary<string, object> values)
+            : base("global", values)
+        {
+            this.fallback = fallback;
+        }
+
+        public override object Get(string key)
+        {
+            if (this.Has(key) || this.fallback == null)
+            {
+                return base.Get(key);
+            }
+            return this.fallback(key);
+        }
+
+        private Func<string, object> fallback;
+    }
+
+    class JSFunction : JSObject
+    {
+        public JSFunction(string name, Func<object, object[], object> invoke)
+            : this(name, invoke, null, null)
+        {
+        }
+
+        public JSFunction(string name, Func<object, object[], object> invoke, Func<object[], object> construct, Type instanceType)
+            : base(name)
+        {
+            this.invoke = invoke;
+            this.construct = construct;
+            this.instanceType = instanceType;
+        }
+
+        public object Invoke(object thisArgument, object[] args)
+        {
+            if (this.invoke == null)
+            {
+                throw new Exception($"{this} is not a function");
+            }
+            return this.invoke(thisArgument, args);
+        }
+
+        public object Construct(object[] args)
+        {
+            if (this.construct == null)
+            {
+                throw new Exception($"{this} is not a constructor");
+            }
+            return this.construct(args);
+        }
+
+        public bool IsInstance(object value)
+        {
+            if (this.instanceType == null || value == null || value == Undefined)
+            {
+                return false;
+            }
+            return this.instanceType.IsInstanceOfType(value);
+        }
+
+        private Func<object, object[], object> invoke;
+        private Func<object[], object> construct;
+        private Type instanceType;
+    }
+
+    // GoFileInfo describes a file or a directory in IGoFileSystem.
+    public sealed class GoFileInfo
+    {
+        public GoFileInfo(bool isDirectory, long size, DateTime modTimeUtc)
+        {
+            this.IsDirectory = isDirectory;
+            this.Size = size;
+            this.ModTimeUtc = modTimeUtc;
+        }
+
+        public bool IsDirectory { get; }
+        public long Size { get; }
+        public DateTime ModTimeUtc { get; }
+    }
+
+    // IGoFileSystem is the file system the Go program accesses via the os package.
+    // Implement this to give the program a zip archive, embedded resources or an in-memory fake instead of the real disk.
+    public interface IGoFileSystem
+    {
+        // Open opens the file at path. This throws FileNotFoundException when the file doesn't exist.
+        Stream Open(string path, FileMode mode, FileAccess access);
+
+        // Read reads bytes from the stream returned by Open. If position is not null, the bytes are read at the position.
+        int Read(Stream stream, byte[] buffer, int offset, int count, long? position);
+
+        // Write writes bytes to the stream returned by Open. If position is not null, the bytes are written at the position.
+        int Write(Stream stream, byte[] buffer, int offset, int count, long? position);
+
+        // Stat returns the information of the file at path, or null when the file doesn't exist.
+        GoFileInfo Stat(string path);
+
+        // ReadDir returns the names of the entries in the directory at path.
+        string[] ReadDir(string path);
+    }
+
+    // DefaultGoFileSystem is an IGoFileSystem backed by the real file system.
+    public class DefaultGoFileSystem : IGoFileSystem
+    {
+        public virtual Stream Open(string path, FileMode mode, FileAccess access)
+        {
+            return new FileStream(path, mode, access);
+        }
+
+        public virtual int Read(Stream stream, byte[] buffer, int offset, int count, long? position)
+        {
+            if (!position.HasValue)
+            {
+                return stream.Read(buffer, offset, count);
+            }
+            var current = stream.Position;
+            try
+            {
+                stream.Position = position.Value;
+                return stream.Read(buffer, offset, count);
+            }
+            finally
+            {
+                stream.Position = current;
+            }
+        }
+
+        public virtual int Write(Stream stream, byte[] buffer, int offset, int count, long? position)
+        {
+            if (!position.HasValue)
+            {
+                stream.Write(buffer, offset, count);
+                stream.Flush();
+                return count;
+            }
+            var current = stream.Position;
+            try
+            {
+                stream.Position = position.Value;
+                stream.Write(buffer, offset, count);
+                stream.Flush();
+                return count;
+            }
+            finally
+            {
+                stream.Position = current;
+            }
+        }
+
+        public virtual GoFileInfo Stat(string path)
+        {
+            if (Directory.Exists(path))
+            {
+                return new GoFileInfo(true, 0, Directory.GetLastWriteTimeUtc(path));
+            }
+            if (File.Exists(path))
+            {
+                var info = new FileInfo(path);
+                return new GoFileInfo(false, info.Length, info.LastWriteTimeUtc);
+            }
+            return null;
+        }
+
+        public virtual string[] ReadDir(string path)
+        {
+            return Directory.EnumerateFileSystemEntries(path).Select(p => Path.GetFileName(p)).ToArray();
+        }
+    }
+
+    // TextReaderStream is a read-only stream of the UTF-8 bytes of the text from a TextReader.
+    sealed class TextReaderStream : Stream
+    {
+        public TextReaderStream(TextReader reader)
+        {
+            this.reader = reader;
+        }
+
+        public override bool CanRead => true;
+        public override bool CanSeek => false;
+        public override bool CanWrite => false;
+        public override long Length => throw new NotSupportedException();
+
+        public override long Position
+        {
+            get => throw new NotSupportedException();
+            set => throw new NotSupportedException();
+        }
+
+        public override int Read(byte[] buffer, int offset, int count)
+        {
+            if (this.pending.Length == this.pendingOffset)
+            {
+                // Read a line at a time so that an interactive program gets the input as soon as possible.
+                var line = this.reader.ReadLine();
+                if (line == null)
+                {
+                    return 0;
+                }
+                this.pending = Encoding.UTF8.GetBytes(line + "\n");
+                this.pendingOffset = 0;
+            }
+            var n = Math.Min(count, this.pending.Length - this.pendingOffset);
+            Array.Copy(this.pending, this.pendingOffset, buffer, offset, n);
+            this.pendingOffset += n;
+            return n;
+        }
+
+        public override void Flush()
+        {
+        }
+
+        public override long Seek(long offset, SeekOrigin origin)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void SetLength(long value)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void Write(byte[] buffer, int offset, int count)
+        {
+            throw new NotSupportedException();
+        }
+
+        private TextReader reader;
+        private byte[] pending = new byte[0];
+        private int pendingOffset;
+    }
+
+    // TranscodingStream is a write-only stream that converts UTF-8 bytes into another encoding.
+    sealed class TranscodingStream : Stream
+    {
+        public TranscodingStream(Stream stream, Encoding encoding)
+        {
+            this.stream = stream;
+            this.encoding = encoding;
+        }
+
+        public override bool CanRead => false;
+        public override bool CanSeek => false;
+        public override bool CanWrite => true;
+        public override long Length => throw new NotSupportedException();
+
+        public override long Position
+        {
+            get => throw new NotSupportedException();
+            set => throw new NotSupportedException();
+        }
+
+        public override int Read(byte[] buffer, int offset, int count)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void Flush()
+        {
+            this.stream.Flush();
+        }
+
+        public override long Seek(long offset, SeekOrigin origin)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void SetLength(long value)
+        {
+            throw new NotSupportedException();
+        }
+
+        public override void Write(byte[] buffer, int offset, int count)
+        {
+            // The decoder keeps an incomplete UTF-8 sequence at the end until the next write.
+            var chars = new char[this.decoder.GetCharCount(buffer, offset, count)];
+            var n = this.decoder.GetChars(buffer, offset, count, chars, 0);
+            var bytes = this.encoding.GetBytes(chars, 0, n);
+            this.stream.Write(bytes, 0, bytes.Length);
+        }
+
+        private Stream stream;
+        private Encoding encoding;
+        private Decoder decoder = new UTF8Encoding(false).GetDecoder();
+    }
+
+    sealed class JSErrnoException : Exception
+    {
+        public JSErrnoException(string code)
+            : base(code)
+        {
+            this.Code = code;
+        }
+
+        public string Code { get; }
+    }
+
+    // JSFileSystem is Node.js's fs module for syscall/js, backed by IGoFileSystem.
+    sealed class JSFileSystem
+    {
+        // The values of Node.js's fs.constants on Linux.
+        const int OWronly = 1;
+        const int ORdwr = 2;
+        const int OCreat = 64;
+        const int OExcl = 128;
+        const int OTrunc = 512;
+        const int OAppend = 1024;
+        const int ODirectory = 65536;
+
+        const int SIfchr = 0x2000;
+        const int SIfdir = 0x4000;
+        const int SIfreg = 0x8000;
+
+        public JSFileSystem(IGoFileSystem fs, Stream stdin, Stream stdout, Stream stderr, Action<ArraySegment<byte>> stderrObserver, Action<Func<object>, Action<object, Exception>> startBackgroundTask)
+        {
+            this.fs = fs;
+            this.stderrObserver = stderrObserver;
+            this.startBackgroundTask = startBackgroundTask;
+            this.files[0] = stdin;
+            this.files[1] = stdout;
+            this.files[2] = stderr;
+
+            var values = new Dictionary<string, object>()
+            {
+                {"constants", new JSObject(new Dictionary<string, object>()
+                    {
+                        {"O_WRONLY", OWronly},
+                        {"O_RDWR", ORdwr},
+                        {"O_CREAT", OCreat},
+                        {"O_TRUNC", OTrunc},
+                        {"O_APPEND", OAppend},
+                        {"O_EXCL", OExcl},
+                        {"O_DIRECTORY", ODirectory},
+                    })},
+                {"writeSync", new JSFunction("writeSync", (object self, object[] args) => {
+                    var buf = (byte[])args[1];
+                    return this.Write((int)JSObject.ToNumber(args[0]), buf, 0, buf.Length, null);
+                })},
+                {"write", AsyncFunction("write", (object[] args) => {
+                    return this.Write((int)JSObject.ToNumber(args[0]), (byte[])args[1], (int)JSObject.ToNumber(args[2]), (int)JSObject.ToNumber(args[3]), ToPosition(args[4]));
+                })},
+                {"read", new JSFunction("read", (object self, object[] args) => {
+                    if ((int)JSObject.ToNumber(args[0]) == 0 && this.startBackgroundTask != null)
+                    {
+                        return this.ReadStdin((byte[])args[1], (int)JSObject.ToNumber(args[2]), (int)JSObject.ToNumber(args[3]), args[5]);
+                    }
+                    return JSObject.ReflectApply(this.read, self, args);
+                })},
+                {"open", AsyncFunction("open", (object[] args) => {
+                    return this.Open(JSObject.Stringify(args[0]), (int)JSObject.ToNumber(args[1]));
+                })},
+                {"close", AsyncFunction("close", (object[] args) => {
+                    this.Close((int)JSObject.ToNumber(args[0]));
+                    return null;
+                })},
+                {"fsync", AsyncFunction("fsync", (object[] args) => {
+                    this.GetStream((int)JSObject.ToNumber(args[0])).Flush();
+                    return null;
+                })},
+                {"fstat", AsyncFunction("fstat", (object[] args) => {
+                    return this.Fstat((int)JSObject.ToNumber(args[0]));
+                })},
+                {"stat", AsyncFunction("stat", (object[] args) => {
+                    return this.Stat(JSObject.Stringify(args[0]));
+                })},
+                {"lstat", AsyncFunction("lstat", (object[] args) => {
+                    return this.Stat(JSObject.Stringify(args[0]));
+                })},
+                {"readdir", AsyncFunction("readdir", (object[] args) => {
+                    return this.fs.ReadDir(JSObject.Stringify(args[0])).Cast<object>().ToList();
+                })},
+            };
+            foreach (var name in new string[] { "chmod", "chown", "fchmod", "fchown", "ftruncate", "lchown", "link", "mkdir", "readlink", "rename", "rmdir", "symlink", "truncate", "unlink", "utimes" })
+            {
+                values[name] = AsyncFunction(name, (object[] args) => {
+                    throw new JSErrnoException("ENOSYS");
+                });
+            }
+            this.read = AsyncFunction("read", (object[] args) => {
+                return this.Read((int)JSObject.ToNumber(args[0]), (byte[])args[1], (int)JSObject.ToNumber(args[2]), (int)JSObject.ToNumber(args[3]), ToPosition(args[4]));
+            });
+            this.Object = new JSObject("fs", values);
+        }
+
+        public JSObject Object { get; }
+
+        // CloseFiles closes all the files opened by the Go program.
+        public void CloseFiles()
+        {
+            foreach (var fd in this.files.Keys.Where(fd => fd > 2).ToList())
+            {
+                this.files[fd].Dispose();
+                this.files.Remove(fd);
+            }
+            this.paths.Clear();
+            this.dirs.Clear();
+            this.appends.Clear();
+        }
+
+        private static JSFunction AsyncFunction(string name, Func<object[], object> f)
+        {
+            // Node.js's asynchronous API takes a callback as the last argument.
+            // The callback is invoked synchronously, which syscall/js allows.
+            return new JSFunction(name, (object self, object[] args) => {
+                var callback = args[args.Length - 1];
+                object result;
+                try
+                {
+                    result = f(args.Take(args.Length - 1).ToArray());
+                }
+                catch (Exception e)
+                {
+                    JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { ToJSError(e) });
+                    return JSObject.Undefined;
+                }
+                JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { null, result });
+                return JSObject.Undefined;
+            });
+        }
+
+        private static JSObject ToJSError(Exception e)
+        {
+            string code;
+            switch (e)
+            {
+            case JSErrnoException errno:
+                code = errno.Code;
+                break;
+            case FileNotFoundException _:
+            case DirectoryNotFoundException _:
+                code = "ENOENT";
+                break;
+            case UnauthorizedAccessException _:
+                code = "EACCES";
+                break;
+            case NotSupportedException _:
+                code = "ENOSYS";
+                break;
+            default:
+                code = "EIO";
+                break;
+            }
+            return new JSObject("Error", new Dictionary<string, object>()
+            {
+                {"message", e.Message},
+                {"code", code},
+            });
+        }
+
+        private static long? ToPosition(object value)
+        {
+            if (value == null || value == JSObject.Undefined)
+            {
+                return null;
+            }
+            return (long)JSObject.ToNumber(value);
+        }
+
+        private Stream GetStream(int fd)
+        {
+            if (!this.files.ContainsKey(fd))
+            {
+                throw new JSErrnoException(this.dirs.ContainsKey(fd) ? "EISDIR" : "EBADF");
+            }
+            return this.files[fd];
+        }
+
+        private int Read(int fd, byte[] buffer, int offset, int length, long? position)
+        {
+            var stream = this.GetStream(fd);
+            if (fd <= 2)
+            {
+                return stream.Read(buffer, offset, length);
+            }
+            return this.fs.Read(stream, buffer, offset, length, position);
+        }
+
+        private object ReadStdin(byte[] buffer, int offset, int length, object callback)
+        {
+            // Reading the standard input might block for a long time. Read it on another thread
+            // so that other goroutines and timers keep running, and then call back on the event loop.
+            this.startBackgroundTask(() => {
+                return this.files[0].Read(buffer, offset, length);
+            }, (object n, Exception e) => {
+                if (e != null)
+                {
+                    JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { ToJSError(e) });
+                    return;
+                }
+                JSObject.ReflectApply(callback, JSObject.Undefined, new object[] { null, n });
+            });
+            return JSObject.Undefined;
+        }
+
+        private int Write(int fd, byte[] buffer, int offset, int length, long? position)
+        {
+            var stream = this.GetStream(fd);
+            if (fd <= 2)
+            {
+                stream.Write(buffer, offset, length);
+                stream.Flush();
+                if (fd == 2 && this.stderrObserver != null)
+                {
+                    this.stderrObserver(new ArraySegment<byte>(buffer, offset, length));
+                }
+                return length;
+            }
+            if (this.appends.Contains(fd))
+            {
+                stream.Seek(0, SeekOrigin.End);
+            }
+            return this.fs.Write(stream, buffer, offset, length, position);
+        }
+
+        private int Open(string path, int flags)
+        {
+            var info = this.fs.Stat(path);
+            if (info != null && info.IsDirectory)
+            {
+                if ((flags & (OWronly | ORdwr)) != 0)
+                {
+                    throw new JSErrnoException("EISDIR");
+                }
+                this.dirs[this.nextFd] = path;
+                return this.nextFd++;
+            }
+            if ((flags & ODirectory) != 0)
+            {
+                throw new JSErrnoException(info == null ? "ENOENT" : "ENOTDIR");
+            }
+
+            FileMode mode = FileMode.Open;
+            if ((flags & OCreat) != 0)
+            {
+                if ((flags & OExcl) != 0)
+                {
+                    if (info != null)
+                    {
+                        throw new JSErrnoException("EEXIST");
+                    }
+                    mode = FileMode.CreateNew;
+                }
+                else if ((flags & OTrunc) != 0)
+                {
+                    mode = FileMode.Create;
+                }
+                else
+                {
+                    mode = FileMode.OpenOrCreate;
+                }
+            }
+            else if ((flags & OTrunc) != 0)
+            {
+                mode = FileMode.Truncate;
+            }
+            else if (info == null)
+            {
+                throw new JSErrnoException("ENOENT");
+            }
+
+            FileAccess access = FileAccess.Read;
+            if ((flags & ORdwr) != 0)
+            {
+                access = FileAccess.ReadWrite;
+            }
+            else if ((flags & OWronly) != 0)
+            {
+                access = FileAccess.Write;
+            }
+
+            this.files[this.nextFd] = this.fs.Open(path, mode, access);
+            this.paths[this.nextFd] = path;
+            if ((flags & OAppend) != 0)
+            {
+                this.appends.Add(this.nextFd);
+            }
+            return this.nextFd++;
+        }
+
+        private void Close(int fd)
+        {
+            if (this.dirs.Remove(fd))
+            {
+                return;
+            }
+            var stream = this.GetStream(fd);
+            if (fd > 2)
+            {
+                stream.Dispose();
+            }
+            this.files.Remove(fd);
+            this.paths.Remove(fd);
+            this.appends.Remove(fd);
+        }
+
+        private JSObject Fstat(int fd)
+        {
+            if (this.dirs.ContainsKey(fd))
+            {
+                return this.Stat(this.dirs[fd]);
+            }
+            this.GetStream(fd);
+            if (this.paths.ContainsKey(fd))
+            {
+                return this.Stat(this.paths[fd]);
+            }
+            return NewStats(SIfchr | 0x1b6, 0, DateTime.UtcNow);
+        }
+
+        private JSObject Stat(string path)
+        {
+            var info = this.fs.Stat(path);
+            if (info == null)
+            {
+                throw new JSErrnoException("ENOENT");
+            }
+            if (info.IsDirectory)
+            {
+                return NewStats(SIfdir | 0x1ed, 0, info.ModTimeUtc);
+            }
+            return NewStats(SIfreg | 0x1a4, info.Size, info.ModTimeUtc);
+        }
+
+        private static JSObject NewStats(int mode, long size, DateTime modTimeUtc)
+        {
+            var ms = (modTimeUtc - new DateTime(1970, 1, 1, 0, 0, 0, DateTimeKind.Utc)).TotalMilliseconds;
+            return new JSObject("Stats", new Dictionary<string, object>()
+            {
+                {"dev", 0},
+                {"ino", 0},
+                {"mode", mode},
+                {"nlink", 1},
+                {"uid", 0},
+                {"gid", 0},
+                {"rdev", 0},
+                {"size", size},
+                {"blksize", 4096},
+                {"blocks", (size + 511) / 512},
+                {"atimeMs", ms},
+                {"mtimeMs", ms},
+                {"ctimeMs", ms},
+                {"isDirectory", new JSFunction("isDirectory", (object self, object[] args) => {
+                    return (mode & SIfdir) != 0;
+                })},
+            });
+        }
+
+        private IGoFileSystem fs;
+        private Action<ArraySegment<byte>> stderrObserver;
+        private Action<Func<object>, Action<object, Exception>> startBackgroundTask;
+        private JSFunction read;
+        private Dictionary<int, Stream> files = new Dictionary<int, Stream>();
+        private Dictionary<int, string> paths = new Dictionary<int, string>();
+        private Dictionary<int, string> dirs = new Dictionary<int, string>();
+        private HashSet<int> appends = new HashSet<int>();
+        private int nextFd = 3;
+    }
+
+    // IGoClock is the source of time for the Go program.
+    // Implement this to freeze or fast-forward time in tests.
+    public interface IGoClock
+    {
+        // UtcNow is the current wall clock time.
+        DateTime UtcNow { get; }
+
+        // MonotonicNanoseconds is the current time of a monotonic clock in nanoseconds.
+        long MonotonicNanoseconds { get; }
+
+        // Schedule invokes the callback after the delay. The callback may be invoked on any thread.
+        // Disposing the returned object cancels the callback.
+        IDisposable Schedule(TimeSpan delay, Action callback);
+    }
+
+    // SystemGoClock is an IGoClock backed by the system clock and timers.
+    public sealed class SystemGoClock : IGoClock
+    {
+        public DateTime UtcNow
+        {
+            get
+            {
+                return DateTime.UtcNow;
+            }
+        }
+
+        public long MonotonicNanoseconds
+        {
+            get
+            {
+                // Scale the ticks without losing precision even when the frequency doesn't divide 10^9,
+                // and without overflowing.
+                var ticks = this.stopwatch.ElapsedTicks;
+                var freq = Stopwatch.Frequency;
+                return ticks / freq * 1_000_000_000L + ticks % freq * 1_000_000_000L / freq;
+            }
+        }
+
+        public IDisposable Schedule(TimeSpan delay, Action callback)
+        {
+            Timer timer = new Timer(Math.Max(delay.TotalMilliseconds, 1));
+            timer.Elapsed += (sender, e) => {
+                callback();
+            };
+            timer.AutoReset = false;
+            timer.Start();
+            return timer;
+        }
+
+        private Stopwatch stopwatch = Stopwatch.StartNew();
+    }
+
+    // ManualGoClock is an IGoClock whose time advances only when Advance is called.
+    public sealed class ManualGoClock : IGoClock
+    {
+        public ManualGoClock()
+            : this(new DateTime(2000, 1, 1, 0, 0, 0, DateTimeKind.Utc))
+        {
+        }
+
+        public ManualGoClock(DateTime utcNow)
+        {
+            this.utcNow = utcNow;
+        }
+
+        public DateTime UtcNow
+        {
+            get
+            {
+                lock (this.timers)
+                {
+                    return this.utcNow;
+                }
+            }
+        }
+
+        public long MonotonicNanoseconds
+        {
+            get
+            {
+                lock (this.timers)
+                {
+                    return this.elapsed.Ticks * 100;
+                }
+            }
+        }
+
+        public IDisposable Schedule(TimeSpan delay, Action callback)
+        {
+            lock (this.timers)
+            {
+                var timer = new ManualTimer(this, this.elapsed + delay, callback);
+                this.timers.Add(timer);
+                return timer;
+            }
+        }
+
+        // Advance moves the time forward, and invokes the callbacks whose time has come in order.
+        public void Advance(TimeSpan delta)
+        {
+            TimeSpan end;
+            lock (this.timers)
+            {
+                end = this.elapsed + delta;
+            }
+            while (true)
+            {
+                ManualTimer next;
+                lock (this.timers)
+                {
+                    next = this.timers.Where(t => t.Due <= end).OrderBy(t => t.Due).FirstOrDefault();
+                    if (next == null)
+                    {
+                        this.utcNow += end - this.elapsed;
+                        this.elapsed = end;
+                        return;
+                    }
+                    this.timers.Remove(next);
+                    if (next.Due > this.elapsed)
+                    {
+                        this.utcNow += next.Due - this.elapsed;
+                        this.elapsed = next.Due;
+                    }
+                }
+                next.Callback();
+            }
+        }
+
+        sealed class ManualTimer : IDisposable
+        {
+            public ManualTimer(ManualGoClock clock, TimeSpan due, Action callback)
+            {
+                this.clock = clock;
+                this.Due = due;
+                this.Callback = callback;
+            }
+
+            public TimeSpan Due { get; }
+            public Action Callback { get; }
+
+            public void Dispose()
+            {
+                lock (this.clock.timers)
+                {
+                    this.clock.timers.Remove(this);
+                }
+            }
+
+            private ManualGoClock clock;
+        }
+
+        private DateTime utcNow;
+        private TimeSpan elapsed;
+        private List<ManualTimer> timers = new List<ManualTimer>();
+    }
+
+    // JSPromiseRejectedException is thrown when awaiting a rejected promise.
+    public sealed class JSPromiseRejectedException : Exception
+    {
+        public JSPromiseRejectedException(object reason)
+            : base($"promise rejected: {JSObject.Stringify(reason)}")
+        {
+            this.Reason = reason;
+        }
+
+        // Reason is the value the promise was rejected with.
+        public object Reason { get; }
+    }
+
+    // JSPromise is JavaScript's Promise. Reactions are invoked asynchronously via post, which is the event loop.
+    sealed class JSPromise : JSObject
+    {
+        enum State
+        {
+            Pending,
+            Fulfilled,
+            Rejected,
+        }
+
+        public static JSFunction NewConstructor(Action<Action> post)
+        {
+            var ctor = new JSFunction("Promise", null, (object[] args) => {
+                var p = new JSPromise(post);
+                try
+                {
+                    ReflectApply(args[0], Undefined, new object[] { p.ResolveFunction(), p.RejectFunction() });
+                }
+                catch (Exception e)
+                {
+                    p.Reject(e);
+                }
+                return p;
+            }, typeof(JSPromise));
+            ctor.Set("resolve", new JSFunction("resolve", (object self, object[] args) => {
+                if (args.Length > 0 && args[0] is JSPromise)
+                {
+                    return args[0];
+                }
+                var p = new JSPromise(post);
+                p.Resolve(args.Length > 0 ? args[0] : Undefined);
+                return p;
+            }));
+            ctor.Set("reject", new JSFunction("reject", (object self, object[] args) => {
+                var p = new JSPromise(post);
+                p.Reject(args.Length > 0 ? args[0] : Undefined);
+                return p;
+            }));
+            return ctor;
+        }
+
+        // FromTask returns a promise settled when the task completes.
+        // The task's continuation might run on any thread, so the settlement is posted to the event loop.
+        // result returns the result of the completed task as a JavaScript value.
+        public static JSPromise FromTask(Task task, Action<Action> post, Func<Task, object> result)
+        {
+            var p = new JSPromise(post);
+            task.ContinueWith((Task t) => {
+                post(() => {
+                    if (t.IsFaulted)
+                    {
+                        var e = t.Exception.InnerExceptions.Count == 1 ? t.Exception.InnerException : t.Exception;
+                        p.Reject(e is JSPromiseRejectedException ? ((JSPromiseRejectedException)e).Reason : e);
+                        return;
+                    }
+                    if (t.IsCanceled)
+                    {
+                        p.Reject(new TaskCanceledException(t));
+                        return;
+                    }
+                    p.Resolve(result(t));
+                });
+            }, TaskContinuationOptions.ExecuteSynchronously);
+            return p;
+        }
+
+        public JSPromise(Action<Action> post)
+            : base("Promise")
+        {
+            this.post = post;
+            this.Set("then", new JSFunction("then", (object self, object[] args) => {
+                return this.Then(args.Length > 0 ? args[0] : null, args.Length > 1 ? args[1] : null);
+            }));
+            this.Set("catch", new JSFunction("catch", (object self, object[] args) => {
+                return this.Then(null, args.Length > 0 ? args[0] : null);
+            }));
+            this.Set("finally", new JSFunction("finally", (object self, object[] args) => {
+                var f = args.Length > 0 ? args[0] : null;
+                if (!(f is JSFunction))
+                {
+                    return this.Then(null, null);
+                }
+                return this.Then(new JSFunction("", (object self2, object[] args2) => {
+                    ReflectApply(f, Undefined, new object[] { });
+                    return args2[0];
+                }), new JSFunction("", (object self2, object[] args2) => {
+                    ReflectApply(f, Undefined, new object[] { });
+                    throw new JSPromiseRejectedException(args2[0]);
+                }));
+            }));
+        }
+
+        public void Resolve(object value)
+        {
+            if (this.state != State.Pending || this.resolving)
+            {
+                return;
+            }
+            if (value == this)
+            {
+                this.Reject(new Exception("chaining cycle detected for promise"));
+                return;
+            }
+            if (value is JSPromise)
+            {
+                this.resolving = true;
+                ((JSPromise)value).AddReaction(() => {
+                    var p = (JSPromise)value;
+                    this.resolving = false;
+                    if (p.state == State.Fulfilled)
+                    {
+                        this.Settle(State.Fulfilled, p.result);
+                    }
+                    else
+                    {
+                        this.Settle(State.Rejected, p.result);
+                    }
+                });
+                return;
+            }
+            this.Settle(State.Fulfilled, value);
+        }
+
+        public void Reject(object reason)
+        {
+            if (this.state != State.Pending || this.resolving)
+            {
+                return;
+            }
+            this.Settle(State.Rejected, reason);
+        }
+
+        // ToTask returns a task completed when the promise is settled.
+        public Task<object> ToTask()
+        {
+            // The task is completed on the event loop. Don't run the continuations there.
+            var tcs = new TaskCompletionSource<object>(TaskCreationOptions.RunContinuationsAsynchronously);
+            this.AddReaction(() => {
+                if (this.state == State.Fulfilled)
+                {
+                    tcs.SetResult(this.result);
+                }
+                else
+                {
+                    tcs.SetException(this.result as Exception ?? new JSPromiseRejectedException(this.result));
+                }
+            });
+            return tcs.Task;
+        }
+
+        private JSFunction ResolveFunction()
+        {
+            return new JSFunction("resolve", (object self, object[] args) => {
+                this.Resolve(args.Length > 0 ? args[0] : Undefined);
+                return Undefined;
+            });
+        }
+
+        private JSFunction RejectFunction()
+        {
+            return new JSFunction("reject", (object self, object[] args) => {
+                this.Reject(args.Length > 0 ? args[0] : Undefined);
+                return Undefined;
+            });
+        }
+
+        private JSPromise Then(object onFulfilled, object onRejected)
+        {
+            var next = new JSPromise(this.post);
+            this.AddReaction(() => {
+                var handler = this.state == State.Fulfilled ? onFulfilled : onRejected;
+                if (!(handler is JSFunction))
+                {
+                    if (this.state == State.Fulfilled)
+                    {
+                        next.Resolve(this.result);
+                    }
+                    else
+                    {
+                        next.Reject(this.result);
+                    }
+                    return;
+                }
+                object value;
+                try
+                {
+                    value = ReflectApply(handler, Undefined, new object[] { this.result });
+                }
+                catch (JSPromiseRejectedException e)
+                {
+                    next.Reject(e.Reason);
+                    return;
+                }
+                catch (Exception e)
+                {
+                    next.Reject(e);
+                    return;
+                }
+                next.Resolve(value);
+            });
+            return next;
+        }
+
+        private void AddReaction(Action reaction)
+        {
+            if (this.state == State.Pending)
+            {
+                this.reactions.Add(reaction);
+                return;
+            }
+            this.post(reaction);
+        }
+
+        private void Settle(State state, object result)
+        {
+            this.state = state;
+            this.result = result;
+            foreach (var reaction in this.reactions)
+            {
+                this.post(reaction);
+            }
+            this.reactions.Clear();
+        }
+
+        private Action<Action> post;
+        private State state = State.Pending;
+        private bool resolving;
+        private object result;
+        private List<Action> reactions = new List<Action>();
+    }
+
+    // IGoApp is the API of the Go program: running the program and calling the exported functions.
+    // This is implemented by Go, and can be mocked in tests of the code using the program.
+    public interface IGoApp
+    {
+        int Run();
+        int Run(string[] args);
+        int Run(string[] args, CancellationToken cancellationToken);
+        Task<int> RunAsync();
+        Task<int> RunAsync(CancellationToken cancellationToken);
+        Task<int> RunAsync(string[] args);
+        Task<int> RunAsync(string[] args, CancellationToken cancellationToken);
+        int sparse(int arg0);
+        Task<int> sparseAsync(int arg0);
+        int dense(int arg0);
+        Task<int> denseAsync(int arg0);
+        int sparse_5fsmall(int arg0);
+        Task<int> sparse_5fsmallAsync(int arg0);
+        int dense_5fsmall(int arg0);
+        Task<int> dense_5fsmallAsync(int arg0);
+    }
+
+    public class Go : IGoApp
+    {
+        class Import : IImport
+        {
+            internal Import(Go go)
+            {
+                this.go = go;
+            }
+
+            private Go go;
+        }
+
+        private static double? ToDouble(object value)
+        {
+            if (value == null)
+            {
+                return null;
+            }
+
+            switch (Type.GetTypeCode(value.GetType()))
+            {
+            case TypeCode.SByte:
+                return (double)(sbyte)value;
+            case TypeCode.Byte:
+                return (double)(byte)value;
+            case TypeCode.Int16:
+                return (double)(short)value;
+            case TypeCode.UInt16:
+                return (double)(ushort)value;
+            case TypeCode.Int32:
+                return (double)(int)value;
+            case TypeCode.UInt32:
+                return (double)(uint)value;
+            case TypeCode.Int64:
+                return (double)(long)value;
+            case TypeCode.UInt64:
+                return (double)(ulong)value;
+            case TypeCode.Single:
+                return (double)(float)value;
+            case TypeCode.Double:
+                return (double)(double)value;
+            case TypeCode.Decimal:
+                return (double)(decimal)value;
+            }
+            return null;
+        }
+
+        public Go()
+            : this((IImportResolver)null)
+        {
+        }
+
+        public Go(IDictionary<(string, string), Delegate> imports)
+            : this(new DictionaryImportResolver(imports))
+        {
+        }
+
+        public Go(IImportResolver importResolver)
+        {
+            this.importResolver = importResolver;
+            this.import = new Import(this);
+            this.jsGo = new JSObject("go", new Dictionary<string, object>()
+            {
+                {"_makeFuncWrapper", new JSFunction("_makeFuncWrapper", (object self, object[] args) => {
+                    return this.MakeFuncWrapper(args[0]);
+                })},
+                {"_pendingEvent", null},
+            });
+        }
+
+        // FileSystem is the file system the Go program accesses. This must be set before the program runs.
+        public IGoFileSystem FileSystem { get; set; } = new DefaultGoFileSystem();
+
+        // Stdin is the standard input of the Go program. If this is null, the console's standard input is used.
+        // This must be set before the program runs.
+        public Stream Stdin { get; set; }
+
+        // SetStdin sets the standard input of the Go program to the UTF-8 bytes of the reader's text.
+        public void SetStdin(TextReader reader)
+        {
+            this.Stdin = new TextReaderStream(reader);
+        }
+
+        // Stdout is the standard output of the Go program. If this is null, the console's standard output is used.
+        // This must be set before the program runs.
+        public Stream Stdout { get; set; }
+
+        // Stderr is the standard error of the Go program. If this is null, the console's standard error is used.
+        // The Go runtime's diagnostic output like panics and GODEBUG traces is written here.
+        // This must be set before the program runs.
+        public Stream Stderr { get; set; }
+
+        // ConsoleOutputEncoding is the encoding of the text written to the console.
+        // The Go program always writes UTF-8. If this is not null, the output is converted into this encoding.
+        // This is not used when Stdout or Stderr is set.
+        public Encoding ConsoleOutputEncoding { get; set; }
+
+        // Env is the environment variables of the Go program. This must be set before the program runs.
+        public IDictionary<string, string> Env { get; } = new Dictionary<string, string>();
+
+        // EnableGoDebug adds the settings to the GODEBUG environment variable.
+        public void EnableGoDebug(GoDebugOptions options)
+        {
+            var settings = new List<string>();
+            string current;
+            if (this.Env.TryGetValue("GODEBUG", out current) && current != "")
+            {
+                settings.Add(current);
+            }
+            if ((options & GoDebugOptions.GCTrace) != 0)
+            {
+                settings.Add("gctrace=1");
+            }
+            if ((options & GoDebugOptions.ScavTrace) != 0)
+            {
+                settings.Add("scavtrace=1");
+            }
+            if ((options & GoDebugOptions.InitTrace) != 0)
+            {
+                settings.Add("inittrace=1");
+            }
+            if ((options & GoDebugOptions.SchedTrace) != 0)
+            {
+                settings.Add("schedtrace=1000");
+            }
+            this.Env["GODEBUG"] = string.Join(",", settings);
+        }
+
+        // BrowserApis specifies what the Go program gets when it accesses a browser-only global like document,
+        // localStorage or navigator that is not set by SetGlobal.
+        public BrowserApiBehavior BrowserApis { get; set; } = BrowserApiBehavior.Undefined;
+
+        // BrowserApiShim returns the value for the browser-only global of the given name when BrowserApis is Shim.
+        // The value is converted in the same way as SetGlobal.
+        public Func<string, object> BrowserApiShim { get; set; }
+
+        // MaxMemoryBytes caps how large the memory can grow to. When the cap is hit, the Go program runs out of memory.
+        // If this is null, the memory can grow up to the module's limit. This must be set before the program runs.
+        public long? MaxMemoryBytes { get; set; }
+
+        // ThreadingModel specifies where RunAsync runs the event loop of the Go program.
+        // Callbacks from the Go program to the host are invoked on the event loop.
+        public GoThreadingModel ThreadingModel { get; set; } = GoThreadingModel.ThreadPool;
+
+        // Clock is the source of time for the Go program. This must be set before the program runs.
+        public IGoClock Clock { get; set; } = new SystemGoClock();
+
+        // SerializeHostCalls makes the instance safe to be called from multiple threads.
+        // When this is true, the event loop, exported functions returned by GetExport, and the methods accessing the memory
+        // hold a lock of the instance, so that a call from another thread waits until the Go program stops running.
+        // A callback from the Go program must not wait for another thread calling into the instance, or it deadlocks.
+        // This must be set before the program runs.
+        public bool SerializeHostCalls { get; set; }
+
+        private JSFunction MakeFuncWrapper(object id)
+        {
+            return new JSFunction("wrapper", (object self, object[] args) => {
+                var ev = new JSObject("event", new Dictionary<string, object>()
+                {
+                    {"id", id},
+                    {"this", self},
+                    {"args", new List<object>(args)},
+                });
+                this.jsGo.Set("_pendingEvent", ev);
+                this.Resume();
+                return ev.Get("result");
+            });
+        }
+
+        internal T ResolveImport<T>(string module, string name) where T : class
+        {
+            Delegate d;
+            if (!this.resolvedImports.TryGetValue((module, name), out d))
+            {
+                if (this.importResolver != null)
+                {
+                    d = this.importResolver.Resolve(module, name);
+                }
+                if (d == null)
+                {
+                    throw new NotImplementedException($"import {module}.{name} is not implemented");
+                }
+                this.resolvedImports[(module, name)] = d;
+            }
+            var f = d as T;
+            if (f == null)
+            {
+                throw new InvalidCastException($"import {module}.{name} must be {typeof(T)} but {d.GetType()}");
+            }
+            return f;
+        }
+
+        // Ref is a JavaScript value as syscall/js's ref: a number, or a NaN whose high bits have the type flag and whose
+        // low 32 bits are the ID of the value. 0 is undefined, and the number 0 is the ID 1.
+        private readonly struct Ref
+        {
+            private const long NaNHead = 0x7FF80000L << 32;
+
+            public readonly long Bits;
+
+            public Ref(long bits)
+            {
+                this.Bits = bits;
+            }
+
+            public static Ref FromID(int id, int typeFlag)
+            {
+                return new Ref(NaNHead | ((long)typeFlag << 32) | (uint)id);
+            }
+
+            public static Ref FromNumber(double d)
+            {
+                if (double.IsNaN(d))
+                {
+                    return FromID(0, 0);
+                }
+                if (d == 0)
+                {
+                    return FromID(1, 0);
+                }
+                return new Ref(BitConverter.DoubleToInt64Bits(d));
+            }
+
+            public bool IsUndefined
+            {
+                get { return this.Number == 0; }
+            }
+
+            public bool IsNumber
+            {
+                get { return !double.IsNaN(this.Number); }
+            }
+
+            public double Number
+            {
+                get { return BitConverter.Int64BitsToDouble(this.Bits); }
+            }
+
+            public int ID
+            {
+                get { return (int)this.Bits; }
+            }
+        }
+
+        internal object LoadValue(int addr)
+        {
+            var r = new Ref(this.mem.LoadInt64(addr));
+            if (r.IsUndefined)
+            {
+                return JSObject.Undefined;
+            }
+            if (r.IsNumber)
+            {
+                double f = r.Number;
+                // Reuse the boxes of small integers like lengths and indices.
+                if (f > 0 && f < boxedIntegers.Length && f == (int)f)
+                {
+                    return boxedIntegers[(int)f];
+                }
+                return f;
+            }
+            return this.values[r.ID];
+        }
+
+        // LoadSliceOfValues returns the values of the []ref at addr in an array from the pool. The array must be returned
+        // by ReturnSliceOfValues after the call.
+        internal object[] LoadSliceOfValues(int addr)
+        {
+            var array = (int)this.mem.LoadInt64(addr);
+            var len = (int)this.mem.LoadInt64(addr + 8);
+            object[] values = null;
+            if (len < this.valueArrayPool.Length && this.valueArrayPool[len].Count > 0)
+            {
+                values = this.valueArrayPool[len].Pop();
+            }
+            else
+            {
+                values = new object[len];
+            }
+            for (int i = 0; i < len; i++)
+            {
+                values[i] = this.LoadValue(array + i * 8);
+            }
+            return values;
+        }
+
+        internal void ReturnSliceOfValues(object[] values)
+        {
+            if (values.Length >= this.valueArrayPool.Length)
+            {
+                return;
+            }
+            Array.Clear(values, 0, values.Length);
+            this.valueArrayPool[values.Length].Push(values);
+        }
+
+        // LoadPropertyName loads a string like Mem.LoadString, but reuses the string loaded from the same bytes before,
+        // as the same property and method names are loaded by syscall/js again and again.
+        internal string LoadPropertyName(int addr)
+        {
+            var ptr = (int)this.mem.LoadInt64(addr);
+            var len = (int)this.mem.LoadInt64(addr + 8);
+            if (len > maxCachedPropertyNameLength)
+            {
+                return this.mem.LoadStringDirectly(ptr, len);
+            }
+            var bytes = this.mem.LoadSliceDirectly(ptr, len);
+            // FNV-1a
+            uint hash = 2166136261;
+            for (int i = 0; i < len; i++)
+            {
+                hash = (hash ^ bytes.Array[bytes.Offset + i]) * 16777619;
+            }
+            ref var entry = ref this.propertyNames[hash % (uint)this.propertyNames.Length];
+            if (entry.Bytes != null && entry.Bytes.Length == len)
+            {
+                bool equal = true;
+                for (int i = 0; i < len; i++)
+                {
+                    if (entry.Bytes[i] != bytes.Array[bytes.Offset + i])
+                    {
+                        equal = false;
+                        break;
+                    }
+                }
+                if (equal)
+                {
+                    return entry.Name;
+                }
+            }
+            var copied = new byte[len];
+            Array.Copy(bytes.Array, bytes.Offset, copied, 0, len);
+            entry.Bytes = copied;
+            entry.Name = Encoding.UTF8.GetString(copied);
+            return entry.Name;
+        }
+
+        private struct PropertyName
+        {
+            public byte[] Bytes;
+            public string Name;
+        }
+
+        internal void StoreValue(int addr, object v)
+        {
+            if (v is Task)
+            {
+                v = this.ToPromise((Task)v);
+            }
+            double? d = ToDouble(v);
+            if (d.HasValue)
+            {
+                this.mem.StoreInt64(addr, Ref.FromNumber(d.Value).Bits);
+                return;
+            }
+            if (v == JSObject.Undefined)
+            {
+                this.mem.StoreInt64(addr, 0);
+                return;
+            }
+            switch (v)
+            {
+            case null:
+                this.mem.StoreInt64(addr, Ref.FromID(2, 0).Bits);
+                return;
+            case true:
+                this.mem.StoreInt64(addr, Ref.FromID(3, 0).Bits);
+                return;
+            case false:
+                this.mem.StoreInt64(addr, Ref.FromID(4, 0).Bits);
+                return;
+            }
+            int id;
+            if (!this.ids.TryGetValue(v, out id))
+            {
+                if (this.idPool.Count > 0)
+                {
+                    id = this.idPool.Pop();
+                }
+                else
+                {
+                    id = this.nextValueId;
+                    this.nextValueId++;
+                }
+                this.values[id] = v;
+                this.goRefCounts[id] = 0;
+                this.ids[v] = id;
+                this.valuesCreated++;
+            }
+            this.goRefCounts[id]++;
+            int typeFlag = 1;
+            if (v is string)
+            {
+                typeFlag = 2;
+            }
+            else if (v is JSFunction)
+            {
+                typeFlag = 4;
+            }
+            this.mem.StoreInt64(addr, Ref.FromID(id, typeFlag).Bits);
+        }
+
+        internal void FinalizeRef(int id)
+        {
+            // Predefined values like the global object are never finalized.
+            if (!this.goRefCounts.ContainsKey(id))
+            {
+                return;
+            }
+            this.goRefCounts[id]--;
+            if (this.goRefCounts[id] > 0)
+            {
+                return;
+            }
+            var v = this.values[id];
+            this.values.Remove(id);
+            this.goRefCounts.Remove(id);
+            this.ids.Remove(v);
+            this.idPool.Push(id);
+            this.valuesFinalized++;
+        }
+
+        // GetValueStats returns the statistics of the host values referenced by the Go program as js.Value.
+        // This is useful to verify that a long-running program doesn't leak values.
+        public JSValueStats GetValueStats()
+        {
+            int live = this.ids == null ? 0 : this.ids.Count;
+            return new JSValueStats(live, this.valuesCreated, this.valuesFinalized);
+        }
+
+        public int Run()
+        {
+            return this.Run(new string[] { });
+        }
+
+        public int Run(string[] args)
+        {
+            return this.Run(args, CancellationToken.None);
+        }
+
+        // Run runs the Go program on the calling thread until the program exits, and returns the exit code.
+        //
+        // When the token is canceled, the event loop stops resuming the program, the program is regarded as exited,
+        // and OperationCanceledException is thrown. Note that a program busy without yielding to the event loop
+        // cannot be stopped until it yields.
+        public int Run(string[] args, CancellationToken cancellationToken)
+        {
+            this.cancellationToken = cancellationToken;
+            using (cancellationToken.Register(() => this.Post(this.Cancel)))
+            {
+                using (this.EnterHostCall())
+                {
+                    this.Start(args);
+                }
+                while (!this.exited)
+                {
+                    if (this.IsIdle())
+                    {
+                        this.DetectDeadlock();
+                        break;
+                    }
+                    var task = this.tasks.Take();
+                    using (this.EnterHostCall())
+                    {
+                        task();
+                    }
+                }
+            }
+            return this.Result();
+        }
+
+        public Task<int> RunAsync()
+        {
+            return this.RunAsync(new string[] { }, CancellationToken.None);
+        }
+
+        public Task<int> RunAsync(CancellationToken cancellationToken)
+        {
+            return this.RunAsync(new string[] { }, cancellationToken);
+        }
+
+        public Task<int> RunAsync(string[] args)
+        {
+            return this.RunAsync(args, CancellationToken.None);
+        }
+
+        // RunAsync runs the Go program according to ThreadingModel, and returns a task completed with the exit code.
+        // When the token is canceled, the task is canceled in the same way as Run.
+        public Task<int> RunAsync(string[] args, CancellationToken cancellationToken)
+        {
+            switch (this.ThreadingModel)
+            {
+            case GoThreadingModel.DedicatedThread:
+                var tcs = new TaskCompletionSource<int>(TaskCreationOptions.RunContinuationsAsynchronously);
+                var thread = new System.Threading.Thread(() => {
+                    try
+                    {
+                        tcs.SetResult(this.Run(args, cancellationToken));
+                    }
+                    catch (OperationCanceledException)
+                    {
+                        tcs.SetCanceled();
+                    }
+                    catch (Exception e)
+                    {
+                        tcs.SetException(e);
+                    }
+                });
+                thread.Name = "Go";
+                thread.IsBackground = true;
+                thread.Start();
+                return tcs.Task;
+            case GoThreadingModel.SynchronizationContext:
+                var context = System.Threading.SynchronizationContext.Current;
+                if (context == null)
+                {
+                    throw new InvalidOperationException("RunAsync with GoThreadingModel.SynchronizationContext must be called on a thread with a SynchronizationContext");
+                }
+                this.syncContext = context;
+                this.completion = new TaskCompletionSource<int>();
+                this.cancellationToken = cancellationToken;
+                var registration = cancellationToken.Register(() => this.Post(this.Cancel));
+                this.completion.Task.ContinueWith(_ => registration.Dispose());
+                context.Post(_ => {
+                    this.Step(() => this.Start(args));
+                }, null);
+                return this.completion.Task;
+            default:
+                return Task.Run(() => this.Run(args, cancellationToken), cancellationToken);
+            }
+        }
+
+        // Post posts the action to the event loop. This can be called from any thread.
+        private void Post(Action action)
+        {
+            if (this.syncContext == null)
+            {
+                this.tasks.Add(action);
+                return;
+            }
+            System.Threading.Interlocked.Increment(ref this.pendingTasks);
+            this.syncContext.Post(_ => {
+                System.Threading.Interlocked.Decrement(ref this.pendingTasks);
+                this.Step(action);
+            }, null);
+        }
+
+        // Step runs the action on the SynchronizationContext, and completes the task returned by RunAsync if the program has finished.
+        private void Step(Action action)
+        {
+            if (this.completion.Task.IsCompleted)
+            {
+                return;
+            }
+            try
+            {
+                using (this.EnterHostCall())
+                {
+                    action();
+                }
+                if (!this.exited && this.IsIdle())
+                {
+                    this.DetectDeadlock();
+                }
+                if (this.exited)
+                {
+                    this.completion.SetResult(this.Result());
+                }
+            }
+            catch (OperationCanceledException)
+            {
+                this.completion.SetCanceled();
+            }
+            catch (Exception e)
+            {
+                this.completion.SetException(e);
+            }
+        }
+
+        private bool IsIdle()
+        {
+            return this.scheduledTimeouts.Count == 0 && this.tasks.Count == 0 && this.pendingTasks == 0 && this.backgroundTasks == 0;
+        }
+
+        private void DetectDeadlock()
+        {
+            // No events can wake up the program any more. Let the Go runtime detect the deadlock.
+            this.jsGo.Set("_pendingEvent", new JSObject(new Dictionary<string, object>()
+            {
+                {"id", 0},
+            }));
+            this.Resume();
+            if (!this.exited)
+            {
+                throw new Exception("Go program is waiting for events that never happen");
+            }
+        }
+
+        // Cancel stops the program without waiting for the Go runtime, and releases the resources.
+        private void Cancel()
+        {
+            if (this.exited)
+            {
+                return;
+            }
+            foreach (var timer in this.scheduledTimeouts.Values)
+            {
+                timer.Dispose();
+            }
+            this.scheduledTimeouts.Clear();
+            this.jsFS?.CloseFiles();
+            this.exited = true;
+            this.canceled = true;
+            this.inst = null;
+            this.values = null;
+            this.goRefCounts = null;
+            this.ids = null;
+            this.idPool = null;
+        }
+
+        private int Result()
+        {
+            if (this.canceled)
+            {
+                throw new OperationCanceledException(this.cancellationToken);
+            }
+            if (this.panicOutput != null && this.exitCode != 0)
+            {
+                throw this.NewPanicException();
+            }
+            return this.exitCode;
+        }
+
+        private void Start(string[] args)
+        {
+            this.stdout = this.Stdout ?? this.OpenConsoleStream(Console.OpenStandardOutput());
+            this.stderr = this.Stderr ?? this.OpenConsoleStream(Console.OpenStandardError());
+            this.startNanoseconds = this.Clock.MonotonicNanoseconds;
+            this.lastNanoseconds = 0;
+            this.canceled = false;
+            this.mem = new Mem(this.MaxMemoryBytes.HasValue ? (int)Math.Min(this.MaxMemoryBytes.Value / Mem.PageSize, int.MaxValue) : int.MaxValue);
+            this.inst = new Inst(this.mem, this.import);
+            throw new NotSupportedException("the module exports neither run nor _start");
+        }
+
+        // MemoryReset is raised when the Go runtime has grown the memory, or a snapshot is restored.
+        // The backing array of the memory is replaced, so a host that holds a view of the memory must re-acquire it.
+        public event EventHandler MemoryReset;
+
+        // SetGlobal sets the value as a property of the JavaScript global object,
+        // which the Go program can access via js.Global().Get(name).
+        // A delegate is converted into a function, and a Task into a promise.
+        // This must be called before the program runs.
+        public void SetGlobal(string name, object value)
+        {
+            this.hostGlobals[name] = value;
+        }
+
+        // ToPromise returns a JavaScript promise that is settled when the task completes.
+        // The Go program can await it with then, and the reactions are invoked on the event loop.
+        public object ToPromise(Task task)
+        {
+            return this.promises.GetValue(task, (Task t) => {
+                // A pending promise keeps the event loop alive.
+                System.Threading.Interlocked.Increment(ref this.backgroundTasks);
+                return JSPromise.FromTask(t, (Action action) => {
+                    this.Post(() => {
+                        System.Threading.Interlocked.Decrement(ref this.backgroundTasks);
+                        action();
+                    });
+                }, TaskResult);
+            });
+        }
+        private static object TaskResult(Task task)
+        {
+            // Task.Run(Action) returns Task<VoidTaskResult> whose result is meaningless.
+            var property = task.GetType().GetProperty("Result");
+            if (property == null || property.PropertyType.Name == "VoidTaskResult")
+            {
+                return JSObject.Undefined;
+            }
+            return property.GetValue(task);
+        }
+
+        // ToTask returns a task that is completed when the JavaScript promise created by the Go program is settled.
+        // If the value is not a promise, the returned task is already completed with the value.
+        public Task<object> ToTask(object promise)
+        {
+            if (promise is JSPromise)
+            {
+                return ((JSPromise)promise).ToTask();
+            }
+            return Task.FromResult(promise);
+        }
+        private object ToJSValue(object value)
+        {
+            if (value is Task)
+            {
+                return this.ToPromise((Task)value);
+            }
+            if (!(value is Delegate))
+            {
+                return value;
+            }
+            var d = (Delegate)value;
+            var parameters = d.Method.GetParameters();
+            return new JSFunction(d.Method.Name, (object self, object[] args) => {
+                var converted = new object[parameters.Length];
+                for (int i = 0; i < parameters.Length; i++)
+                {
+                    var arg = i < args.Length ? args[i] : JSObject.Undefined;
+                    var type = parameters[i].ParameterType;
+                    if (type == typeof(object))
+                    {
+                        converted[i] = arg;
+                    }
+                    else if (arg == null || arg == JSObject.Undefined)
+                    {
+                        converted[i] = type.IsValueType ? Activator.CreateInstance(type) : null;
+                    }
+                    else if (type.IsInstanceOfType(arg))
+                    {
+                        converted[i] = arg;
+                    }
+                    else
+                    {
+                        converted[i] = Convert.ChangeType(arg, type, CultureInfo.InvariantCulture);
+                    }
+                }
+                object result;
+                try
+                {
+                    result = d.DynamicInvoke(converted);
+                }
+                catch (TargetInvocationException e)
+                {
+                    throw e.InnerException;
+                }
+                if (d.Method.ReturnType == typeof(void))
+                {
+                    return JSObject.Undefined;
+                }
+                return this.ToJSValue(result);
+            });
+        }
+
+        private object ResolveMissingGlobal(string name)
+        {
+            if (!browserApiNames.Contains(name))
+            {
+                return JSObject.Undefined;
+            }
+            switch (this.BrowserApis)
+            {
+            case BrowserApiBehavior.Throw:
+                throw new NotSupportedException($"{name} is a browser API and is not available in .NET. Set it with SetGlobal or BrowserApiShim.");
+            case BrowserApiBehavior.Shim:
+                var value = this.BrowserApiShim == null ? null : this.BrowserApiShim(name);
+                if (value == null)
+                {
+                    return JSObject.Undefined;
+                }
+                value = this.ToJSValue(value);
+                this.global.Set(name, value);
+                return value;
+            }
+            return JSObject.Undefined;
+        }
+
+        // Instance returns the instance of the running program, or null if the program is not running.
+        // The exported functions are called without the lock even if SerializeHostCalls is true.
+        public Inst Instance => this.inst;
+
+        // GetExport returns the exported function of the given wasm name as Action<...> or Func<...>,
+        // or null if the function is not exported.
+        public Delegate GetExport(string name)
+        {
+            if (this.inst == null)
+            {
+                throw new InvalidOperationException(Strings.NotRunning);
+            }
+            return this.inst.GetExport(name, this.SerializeHostCalls ? this.hostLock : null);
+        }
+
+        int IGoApp.sparse(int arg0)
+        {
+            return ((Func<int, int>)this.GetExport(Strings.S2))(arg0);
+        }
+
+        // sparseAsync calls sparse on the event loop of the Go program, and returns a task completed when the function returns.
+        public Task<int> sparseAsync(int arg0)
+        {
+            return this.CallExportAsync(() => this.inst.sparse(arg0));
+        }
+
+        int IGoApp.dense(int arg0)
+        {
+            return ((Func<int, int>)this.GetExport(Strings.S0))(arg0);
+        }
+
+        // denseAsync calls dense on the event loop of the Go program, and returns a task completed when the function returns.
+        public Task<int> denseAsync(int arg0)
+        {
+            return this.CallExportAsync(() => this.inst.dense(arg0));
+        }
+
+        int IGoApp.sparse_5fsmall(int arg0)
+        {
+            return ((Func<int, int>)this.GetExport(Strings.S3))(arg0);
+        }
+
+        // sparse_5fsmallAsync calls sparse_5fsmall on the event loop of the Go program, and returns a task completed when the function returns.
+        public Task<int> sparse_5fsmallAsync(int arg0)
+        {
+            return this.CallExportAsync(() => this.inst.sparse_5fsmall(arg0));
+        }
+
+        int IGoApp.dense_5fsmall(int arg0)
+        {
+            return ((Func<int, int>)this.GetExport(Strings.S1))(arg0);
+        }
+
+        // dense_5fsmallAsync calls dense_5fsmall on the event loop of the Go program, and returns a task completed when the function returns.
+        public Task<int> dense_5fsmallAsync(int arg0)
+        {
+            return this.CallExportAsync(() => this.inst.dense_5fsmall(arg0));
+        }
+
+        // Exports calls the exported functions of the program by their original names, with the parameter names in
+        // the name section or the Go source, and the .NET types of the Go parameters if known by -src.
+        // The program must be running by Run or RunAsync.
+        public sealed class Exports
+        {
+            private readonly Go go;
+
+            // delegates is the typed delegates of the instance that the program last ran on.
+            private Delegates delegates;
+
+            public Exports(Go go)
+            {
+                if (go == null)
+                {
+                    throw new ArgumentNullException(nameof(go));
+                }
+                this.go = go;
+            }
+
+            // Delegates is the typed delegates of the exported functions of an instance, so that a call doesn't look
+            // up and cast the delegate by GetExport every time.
+            private sealed class Delegates
+            {
+                internal readonly Inst Inst;
+                internal readonly Func<int, int> sparse_;
+                internal readonly Func<int, int> dense_;
+                internal readonly Func<int, int> sparse_5fsmall_;
+                internal readonly Func<int, int> dense_5fsmall_;
+
+                internal Delegates(Go go)
+                {
+                    this.Inst = go.Instance;
+                    this.sparse_ = (Func<int, int>)go.GetExport(Strings.S2);
+                    this.dense_ = (Func<int, int>)go.GetExport(Strings.S0);
+                    this.sparse_5fsmall_ = (Func<int, int>)go.GetExport(Strings.S3);
+                    this.dense_5fsmall_ = (Func<int, int>)go.GetExport(Strings.S1);
+                }
+            }
+
+            // GetDelegates returns the typed delegates of the running instance. They are resolved again when the
+            // program runs again, as the instance is new.
+            private Delegates GetDelegates()
+            {
+                var d = this.delegates;
+                if (d == null || d.Inst != this.go.Instance)
+                {
+                    d = new Delegates(this.go);
+                    this.delegates = d;
+                }
+                return d;
+            }
+
+            public int sparse(int arg0)
+            {
+                return this.GetDelegates().sparse_(arg0);
+            }
+
+            public int dense(int arg0)
+            {
+                return this.GetDelegates().dense_(arg0);
+            }
+
+            public int sparse_5fsmall(int arg0)
+            {
+                return this.GetDelegates().sparse_5fsmall_(arg0);
+            }
+
+            public int dense_5fsmall(int arg0)
+            {
+                return this.GetDelegates().dense_5fsmall_(arg0);
+            }
+        }
+
+        // CallExportAsync calls the exported function on the event loop, so that the call is serialized with
+        // the events of the program, e.g. timers and callbacks. The program must be running by Run or RunAsync.
+        private Task<T> CallExportAsync<T>(Func<T> f)
+        {
+            if (this.inst == null || this.exited)
+            {
+                throw new InvalidOperationException(Strings.NotRunning);
+            }
+            var tcs = new TaskCompletionSource<T>(TaskCreationOptions.RunContinuationsAsynchronously);
+            this.Post(() => {
+                if (this.exited)
+                {
+                    tcs.SetException(new InvalidOperationException("Go program exited before the call"));
+                    return;
+                }
+                try
+                {
+                    tcs.SetResult(f());
+                }
+                catch (GoExitedException)
+                {
+                    // The exit code is already recorded.
+                    tcs.SetException(new InvalidOperationException("Go program exited during the call"));
+                }
+                catch (Exception e)
+                {
+                    tcs.SetException(e);
+                    throw;
+                }
+            });
+            return tcs.Task;
+        }
+
+        // Invoke calls the exported function of the given wasm name, and returns the result, or null if the function returns nothing.
+        // The arguments are converted into the parameter types like int or double.
+        public object Invoke(string name, params object[] args)
+        {
+            var d = this.GetExport(name);
+            if (d == null)
+            {
+                throw new ArgumentException($"function {name} is not exported", nameof(name));
+            }
+            var parameters = d.Method.GetParameters();
+            if (args.Length != parameters.Length)
+            {
+                throw new ArgumentException($"function {name} takes {parameters.Length} arguments but {args.Length} were given", nameof(args));
+            }
+            var converted = new object[args.Length];
+            for (int i = 0; i < args.Length; i++)
+            {
+                converted[i] = Convert.ChangeType(args[i], parameters[i].ParameterType, CultureInfo.InvariantCulture);
+            }
+            try
+            {
+                return d.DynamicInvoke(converted);
+            }
+            catch (TargetInvocationException e) when (e.InnerException is GoExitedException)
+            {
+                throw new InvalidOperationException($"Go program exited during the call to {name}");
+            }
+            catch (TargetInvocationException e)
+            {
+                throw e.InnerException;
+            }
+        }
+
+        // Save writes a snapshot of the running Go program's linear memory, globals and tables to the stream.
+        // This must not be called while Go code is being executed, e.g. call this from a callback invoked on the event loop
+        // or while the program is waiting for events.
+        //
+        // JavaScript values referred from the Go program, like functions set by SetGlobal, are not included in the snapshot.
+        public void Save(Stream stream)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.inst == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                using (var writer = new BinaryWriter(stream, Encoding.UTF8, true))
+                {
+                    writer.Write(snapshotMagic);
+                    writer.Write(snapshotVersion);
+                    this.mem.Save(writer);
+                    this.inst.Save(writer);
+                }
+            }
+        }
+
+        // Restore replaces the running Go program's linear memory and globals with the snapshot written by Save.
+        // The snapshot must be taken from the same module. The same restrictions as Save apply.
+        //
+        // As JavaScript values are not included in the snapshot, restoring a snapshot is safe only when the JavaScript values
+        // the Go program refers to are still alive, e.g. restoring the snapshot to the same instance, or for WASI programs.
+        public void Restore(Stream stream)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.inst == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                using (var reader = new BinaryReader(stream, Encoding.UTF8, true))
+                {
+                    if (reader.ReadUInt32() != snapshotMagic)
+                    {
+                        throw new InvalidDataException("the stream is not a snapshot");
+                    }
+                    var version = reader.ReadInt32();
+                    if (version != snapshotVersion)
+                    {
+                        throw new InvalidDataException($"unsupported snapshot version: {version}");
+                    }
+                    // Read the whole snapshot before modifying the state, so that an invalid snapshot doesn't break the program.
+                    var bytes = this.mem.ReadSnapshot(reader);
+                    this.inst.Restore(reader);
+                    this.mem.Reset(bytes);
+                }
+                this.ResetMemoryDataView();
+            }
+        }
+
+        // ReadString returns the UTF-8 string of len bytes at ptr in the Go memory.
+        public string ReadString(int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                return this.mem.LoadStringDirectly(ptr, len);
+            }
+        }
+
+        // CopyBytesToGo copies src to the Go byte slice whose data pointer and length are ptr and len.
+        // This returns the number of copied bytes, which is the minimum of src's length and len.
+        public int CopyBytesToGo(int ptr, int len, ReadOnlySpan<byte> src)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                var n = Math.Min(src.Length, len);
+                src.Slice(0, n).CopyTo(this.mem.LoadSliceDirectly(ptr, n).AsSpan());
+                return n;
+            }
+        }
+
+        // WriteBytes copies the bytes into memory allocated by the module's allocator, and returns the pointer.
+        public int WriteBytes(ReadOnlySpan<byte> src)
+        {
+            throw new NotSupportedException(Strings.NoAllocator);
+        }
+
+        // WriteString copies the string as UTF-8 into memory allocated by the module's allocator, and returns the
+        // pointer. len is set to the length in bytes.
+        public int WriteString(string str, out int len)
+        {
+            throw new NotSupportedException(Strings.NoAllocator);
+        }
+
+        // CopyBytesToDotNet copies the Go byte slice whose data pointer and length are ptr and len to dst.
+        // This returns the number of copied bytes, which is the minimum of dst's length and len.
+        public int CopyBytesToDotNet(Span<byte> dst, int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                var n = Math.Min(dst.Length, len);
+                this.mem.LoadSliceDirectly(ptr, n).AsSpan().CopyTo(dst);
+                return n;
+            }
+        }
+
+        // GetSpan returns the Go byte slice whose data pointer and length are ptr and len as a span over the memory,
+        // without copying. The span must not be used after the Go program runs again, as the memory might grow.
+        public Span<byte> GetSpan(int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                return this.mem.LoadSliceDirectly(ptr, len).AsSpan();
+            }
+        }
+
+        // GetMemory returns a view of the Go byte slice whose data pointer and length are ptr and len, without copying.
+        // Unlike a span, the view can be kept, e.g. across awaits. Accessing the view throws InvalidOperationException
+        // after the memory grows or a snapshot is restored (see MemoryReset), as the memory is then a new array.
+        public Memory<byte> GetMemory(int ptr, int len)
+        {
+            using (this.EnterHostCall())
+            {
+                if (this.mem == null)
+                {
+                    throw new InvalidOperationException(Strings.NotRunning);
+                }
+                return new GoMemoryManager(this.mem, ptr, len).Memory;
+            }
+        }
+
+        private void Exit(int code)
+        {
+            this.exitCode = code;
+            if (code != 0)
+            {
+                var bytes = Encoding.UTF8.GetBytes($"exit code: {code}\n");
+                this.stderr.Write(bytes, 0, bytes.Length);
+                this.stderr.Flush();
+            }
+        }
+
+        private void Resume()
+        {
+            if (this.exited)
+            {
+                throw new Exception("Go program has already exited");
+            }
+        }
+
+        private Stream OpenConsoleStream(Stream stream)
+        {
+            if (this.ConsoleOutputEncoding == null || this.ConsoleOutputEncoding is UTF8Encoding)
+            {
+                return stream;
+            }
+            return new TranscodingStream(stream, this.ConsoleOutputEncoding);
+        }
+
+        private string[] EnvStrings()
+        {
+            return this.Env.OrderBy(kv => kv.Key, StringComparer.Ordinal).Select(kv => $"{kv.Key}={kv.Value}").ToArray();
+        }
+
+        private void ResetMemoryDataView()
+        {
+            // Host-side accessors like ReadString and CopyBytesToGo always access the memory via Mem,
+            // so they never see a stale array. Only notify the host.
+            this.MemoryReset?.Invoke(this, EventArgs.Empty);
+        }
+
+        // GetSP returns the current stack pointer of the Go program.
+        //
+        // The Go program and the host can call each other recursively: an import like valueCall calls a host function,
+        // which might call back into the program via a js.FuncOf wrapper (Resume) or an exported function (Invoke).
+        // Go's stack might move during the nested call, so an import must reload the stack pointer with GetSP
+        // before storing its results. If the program exited during the nested call, GetSP throws GoExitedException
+        // to unwind the remaining frames up to the call into the program (CallGo).
+        internal int GetSP()
+        {
+            if (this.exited)
+            {
+                throw new GoExitedException();
+            }
+            throw new NotSupportedException("the module does not export getsp");
+        }
+
+        // EnterHostCall acquires the lock of the instance if SerializeHostCalls is true. Dispose the result to release it.
+        private HostCall EnterHostCall()
+        {
+            return new HostCall(this.SerializeHostCalls ? this.hostLock : null);
+        }
+
+        private struct HostCall : IDisposable
+        {
+            public HostCall(object syncRoot)
+            {
+                this.syncRoot = syncRoot;
+                if (syncRoot != null)
+                {
+                    System.Threading.Monitor.Enter(syncRoot);
+                }
+            }
+
+            public void Dispose()
+            {
+                if (this.syncRoot != null)
+                {
+                    System.Threading.Monitor.Exit(this.syncRoot);
+                }
+            }
+
+            private object syncRoot;
+        }
+
+        private void CallGo(Action f)
+        {
+            try
+            {
+                f();
+            }
+            catch (GoExitedException)
+            {
+                // The program exited in a nested call. The exit code is already recorded.
+            }
+        }
+
+        private void DebugWrite(long fd, ArraySegment<byte> bytes)
+        {
+            var stream = fd == 2 ? this.stderr : this.stdout;
+            stream.Write(bytes.Array, bytes.Offset, bytes.Count);
+            stream.Flush();
+        }
+
+        // ObserveStderr watches the standard error output to capture a panic message and a stack trace.
+        // bytes is a view of the memory or a buffer, so this doesn't box it as IEnumerable<byte> or copy it.
+        private void ObserveStderr(ArraySegment<byte> bytes)
+        {
+            for (int i = 0; i < bytes.Count; i++)
+            {
+                this.stderrBuf.Add(bytes.Array[bytes.Offset + i]);
+            }
+            int idx;
+            while ((idx = this.stderrBuf.IndexOf((byte)'\n')) >= 0)
+            {
+                var line = Encoding.UTF8.GetString(this.stderrBuf.GetRange(0, idx).ToArray());
+                this.stderrBuf.RemoveRange(0, idx+1);
+                if (this.panicOutput == null)
+                {
+                    if (!line.StartsWith("panic: ") && !line.StartsWith("fatal error: "))
+                    {
+                        continue;
+                    }
+                    this.panicOutput = new List<string>();
+                }
+                this.panicOutput.Add(line);
+            }
+        }
+
+        private GoPanicException NewPanicException()
+        {
+            // The panic message continues until the first goroutine's stack trace.
+            var idx = this.panicOutput.FindIndex(line => line.StartsWith("goroutine "));
+            if (idx < 0)
+            {
+                idx = this.panicOutput.Count;
+            }
+            var message = string.Join(Environment.NewLine, this.panicOutput.Take(idx)).Trim();
+            var stackTrace = string.Join(Environment.NewLine, this.panicOutput.Skip(idx)).Trim();
+            return new GoPanicException(message, stackTrace, this.exitCode);
+        }
+
+        private long PreciseNowInNanoseconds()
+        {
+            // The Go runtime assumes that nanotime never goes backward.
+            var now = this.Clock.MonotonicNanoseconds - this.startNanoseconds;
+            if (now < this.lastNanoseconds)
+            {
+                return this.lastNanoseconds;
+            }
+            this.lastNanoseconds = now;
+            return now;
+        }
+
+        private double UnixNowInMilliseconds()
+        {
+            return (this.Clock.UtcNow.Subtract(new DateTime(1970, 1, 1))).TotalMilliseconds;
+        }
+
+        private int SetTimeout(double interval)
+        {
+            var id = this.nextCallbackTimeoutId;
+            this.nextCallbackTimeoutId++;
+
+            // The clock's callback might be invoked on another thread. Post the task to the event loop.
+            var timer = this.Clock.Schedule(TimeSpan.FromMilliseconds(interval), () => {
+                this.Post(() => {
+                    if (this.exited || !this.scheduledTimeouts.ContainsKey(id))
+                    {
+                        return;
+                    }
+                    this.Resume();
+                    while (!this.exited && this.scheduledTimeouts.ContainsKey(id))
+                    {
+                        // for some reason Go failed to register the timeout event, log and try again
+                        // (temporary workaround for https://github.com/golang/go/issues/28975)
+                        this.Resume();
+                    }
+                });
+            });
+
+            this.scheduledTimeouts[id] = timer;
+
+            return id;
+        }
+
+        // StartBackgroundTask runs the blocking operation on a thread pool thread,
+        // and then posts the continuation to the event loop.
+        private void StartBackgroundTask(Func<object> operation, Action<object, Exception> continuation)
+        {
+            System.Threading.Interlocked.Increment(ref this.backgroundTasks);
+            Task.Run(() => {
+                object result = null;
+                Exception error = null;
+                try
+                {
+                    result = operation();
+                }
+                catch (Exception e)
+                {
+                    error = e;
+                }
+                this.Post(() => {
+                    System.Threading.Interlocked.Decrement(ref this.backgroundTasks);
+                    if (this.exited)
+                    {
+                        return;
+                    }
+                    continuation(result, error);
+                });
+            });
+        }
+
+        private void ClearTimeout(int id)
+        {
+            if (this.scheduledTimeouts.ContainsKey(id))
+            {
+                this.scheduledTimeouts[id].Dispose();
+            }
+            this.scheduledTimeouts.Remove(id);
+        }
+
+        // FillRandomBytes fills the memory directly, without a temporary array.
+        private void FillRandomBytes(ArraySegment<byte> bytes)
+        {
+            this.rng.GetBytes(bytes.Array, bytes.Offset, bytes.Count);
+        }
+
+        private Import import;
+        private BlockingCollection<Action> tasks = new BlockingCollection<Action>();
+        private int exitCode;
+        private List<byte> stderrBuf = new List<byte>();
+        private List<string> panicOutput;
+        private JSObject jsGo;
+        // The JavaScript environment is not used by the program.
+        private JSObject global = null;
+        private JSFileSystem jsFS = null;
+        private IImportResolver importResolver;
+        private Dictionary<(string, string), Delegate> resolvedImports = new Dictionary<(string, string), Delegate>();
+
+        private Stream stdout;
+        private Stream stderr;
+        private long startNanoseconds;
+        private long lastNanoseconds;
+
+        private Dictionary<int, IDisposable> scheduledTimeouts = new Dictionary<int, IDisposable>();
+        private int nextCallbackTimeoutId = 1;
+        private int backgroundTasks;
+        private int pendingTasks;
+        private System.Threading.SynchronizationContext syncContext;
+        private TaskCompletionSource<int> completion;
+        private CancellationToken cancellationToken;
+        private bool canceled;
+        private static readonly HashSet<string> browserApiNames = new HashSet<string>()
+        {
+            "alert",
+            "document",
+            "fetch",
+            "history",
+            "indexedDB",
+            "localStorage",
+            "location",
+            "navigator",
+            "requestAnimationFrame",
+            "screen",
+            "sessionStorage",
+            "WebSocket",
+            "window",
+            "XMLHttpRequest",
+        };
+
+        private Dictionary<string, object> hostGlobals = new Dictionary<string, object>();
+        private ConditionalWeakTable<Task, JSPromise> promises = new ConditionalWeakTable<Task, JSPromise>();
+        private Inst inst;
+        private Mem mem;
+        private Dictionary<int, object> values;
+        private Dictionary<int, int> goRefCounts;
+        private Dictionary<object, int> ids;
+        private Stack<int> idPool;
+        private int nextValueId;
+        private PropertyName[] propertyNames = new PropertyName[256];
+        private const int maxCachedPropertyNameLength = 64;
+        // valueArrayPool is the pools of the arguments arrays by the lengths.
+        private Stack<object[]>[] valueArrayPool = Enumerable.Range(0, 8).Select(_ => new Stack<object[]>()).ToArray();
+        private static readonly object[] boxedIntegers = Enumerable.Range(0, 1024).Select(i => (object)(double)i).ToArray();
+        private long valuesCreated;
+        private long valuesFinalized;
+        private bool exited;
+        private RandomNumberGenerator rng = RandomNumberGenerator.Create();
+        private object hostLock = new object();
+
+        // snapshotMagic is "G2DN" in little endian.
+        private const uint snapshotMagic = 0x4e443247;
+        private const int snapshotVersion = 1;
+    }
+
+    // GoMemoryManager is a view of a range of the memory of a Go program, by Go.GetMemory. The view is invalidated
+    // when the memory's array is replaced.
+    sealed class GoMemoryManager : System.Buffers.MemoryManager<byte>
+    {
+        internal GoMemoryManager(Mem mem, int ptr, int len)
+        {
+            // Check the range.
+            mem.LoadSliceDirectly(ptr, len);
+            this.mem = mem;
+            this.generation = mem.Generation;
+            this.ptr = ptr;
+            this.len = len;
+        }
+
+        public override Span<byte> GetSpan()
+        {
+            return this.Segment().AsSpan();
+        }
+
+        public override System.Buffers.MemoryHandle Pin(int elementIndex = 0)
+        {
+            var segment = this.Segment();
+            return new Memory<byte>(segment.Array, segment.Offset, segment.Count).Slice(elementIndex).Pin();
+        }
+
+        public override void Unpin()
+        {
+            // The handle returned by Pin unpins the array itself.
+        }
+
+        protected override bool TryGetArray(out ArraySegment<byte> segment)
+        {
+            segment = this.Segment();
+            return true;
+        }
+
+        protected override void Dispose(bool disposing)
+        {
+        }
+
+        private ArraySegment<byte> Segment()
+        {
+            if (this.mem.Generation != this.generation)
+            {
+                throw new InvalidOperationException("the memory of the Go program has been replaced since the view was created; get a new view after MemoryReset");
+            }
+            return this.mem.LoadSliceDirectly(this.ptr, this.len);
+        }
+
+        private readonly Mem mem;
+        private readonly int generation;
+        private readonly int ptr;
+        private readonly int len;
+    }
+
+    // Inst is an instance of the wasm module. The public methods are the exported functions.
+    public sealed partial class Inst
+    {
+        internal Inst(Mem mem, IImport import)
+        {
+             mem_ = mem;
+             import_ = import;
+        }
+
+        public int sparse(int arg0)
+        {
+            return func0(arg0);
+        }
+        
+        public int dense(int arg0)
+        {
+            return func1(arg0);
+        }
+        
+        public int sparse_5fsmall(int arg0)
+        {
+            return func2(arg0);
+        }
+        
+        public int dense_5fsmall(int arg0)
+        {
+            return func3(arg0);
+        }
+        
+
+        // GetExport returns the exported function. If syncRoot is not null, the function holds its lock during the call.
+        internal Delegate GetExport(string name, object syncRoot)
+        {
+            switch (name)
+            {
+            case Strings.S2:
+                if (syncRoot != null)
+                {
+                    return (Func<int, int>)((int arg0) => { lock (syncRoot) { return this.sparse(arg0); } });
+                }
+                return (Func<int, int>)this.sparse;
+            case Strings.S0:
+                if (syncRoot != null)
+                {
+                    return (Func<int, int>)((int arg0) => { lock (syncRoot) { return this.dense(arg0); } });
+                }
+                return (Func<int, int>)this.dense;
+            case Strings.S3:
+                if (syncRoot != null)
+                {
+                    return (Func<int, int>)((int arg0) => { lock (syncRoot) { return this.sparse_5fsmall(arg0); } });
+                }
+                return (Func<int, int>)this.sparse_5fsmall;
+            case Strings.S1:
+                if (syncRoot != null)
+                {
+                    return (Func<int, int>)((int arg0) => { lock (syncRoot) { return this.dense_5fsmall(arg0); } });
+                }
+                return (Func<int, int>)this.dense_5fsmall;
+            }
+            return null;
+        }
+
+        // OriginalName: 
+        // Index:        0
+        private int func0(int local0)
+        {
+            var stack0 = local0;
+            if ((uint)stack0 < 4u)
+            {
+                if ((uint)stack0 < 3u)
+                {
+                    goto label2;
+                }
+                goto label1;
+            }
+            if ((uint)stack0 < 10u)
+            {
+                goto label2;
+            }
+            if ((uint)stack0 < 12u)
+            {
+                goto label0;
+            }
+            goto label2;
+        label2:;
+            return 100;
+        label1:;
+            return 101;
+        label0:;
+            return 102;
+        }
+
+        // OriginalName: 
+        // Index:        1
+        private int func1(int local0)
+        {
+            switch (local0)
+            {
+            case 0: case 2: goto label1;
+            case 3: goto label2;
+            default: goto label0;
+            }
+        label2:;
+            return 100;
+        label1:;
+            return 101;
+        label0:;
+            return 102;
+        }
+
+        // OriginalName: 
+        // Index:        2
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        private int func2(int local0)
+        {
+            var stack0 = local0;
+            int stack1 = 25;
+            stack0 = (int)((uint)stack0 % (uint)stack1);
+            return func0(stack0);
+        }
+
+        // OriginalName: 
+        // Index:        3
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        private int func3(int local0)
+        {
+            var stack0 = local0;
+            int stack1 = 8;
+            stack0 = (int)((uint)stack0 % (uint)stack1);
+            return func1(stack0);
+        }
+
+
+        private delegate void Type0(Inst self, int arg0);
+        private delegate void Type1(Inst self, int arg0, int arg1);
+        private delegate void Type2(Inst self);
+        private delegate int Type3(Inst self);
+        private delegate int Type4(Inst self, int arg0, int arg1);
+        private delegate int Type5(Inst self, int arg0);
+        private delegate int Type6(Inst self, int arg0, int arg1, int arg2, int arg3);
+        private static readonly uint[][] table_ = {
+        };
+
+
+        // Save writes the globals and the tables. The tables are written only to detect a snapshot of a different module.
+        internal void Save(BinaryWriter writer)
+        {
+            writer.Write(table_.Length);
+            foreach (var table in table_)
+            {
+                writer.Write(table.Length);
+                foreach (var elem in table)
+                {
+                    writer.Write(elem);
+                }
+            }
+        }
+
+        internal void Restore(BinaryReader reader)
+        {
+            if (reader.ReadInt32() != table_.Length)
+            {
+                throw new InvalidDataException(Strings.SnapshotMismatch);
+            }
+            foreach (var table in table_)
+            {
+                if (reader.ReadInt32() != table.Length)
+                {
+                    throw new InvalidDataException(Strings.SnapshotMismatch);
+                }
+                foreach (var elem in table)
+                {
+                    if (reader.ReadUInt32() != elem)
+                    {
+                        throw new InvalidDataException(Strings.SnapshotMismatch);
+                    }
+                }
+            }
+        }
+
+
+        private Mem mem_;
+        private IImport import_;
+    }
+
+    // Strings is the string constants that appear more than once in the generated code.
+    static class Strings
+    {
+        public const string NotRunning = "Go program is not running";
+        public const string SnapshotMismatch = "the snapshot was taken from a different module";
+        public const string NoAllocator = "the module does not export an allocator (malloc)";
+        public const string IndirectCallTypeMismatch = "indirect call type mismatch";
+
+        // The names of the imports and the exports.
+        public const string S0 = "dense";
+        public const string S1 = "dense_small";
+        public const string S2 = "sparse";
+        public const string S3 = "sparse_small";
+    }
+
+    // The implementation is copied from the Go standard package math/bits, which is under BSD-style license.
+    static class Bits
+    {
+        // BitOperations uses the hardware instructions like LZCNT and POPCNT where available.
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int LeadingZeros(uint x)
+        {
+            return System.Numerics.BitOperations.LeadingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int LeadingZeros(ulong x)
+        {
+            return System.Numerics.BitOperations.LeadingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int TailingZeros(uint x)
+        {
+            return System.Numerics.BitOperations.TrailingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int TailingZeros(ulong x)
+        {
+            return System.Numerics.BitOperations.TrailingZeroCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int PopCount(uint x)
+        {
+            return System.Numerics.BitOperations.PopCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static int PopCount(ulong x)
+        {
+            return System.Numerics.BitOperations.PopCount(x);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static uint RotateLeft(uint x, int k)
+        {
+            return System.Numerics.BitOperations.RotateLeft(x, k);
+        }
+
+        [MethodImpl(MethodImplOptions.AggressiveInlining)]
+        public static ulong RotateLeft(ulong x, int k)
+        {
+            return System.Numerics.BitOperations.RotateLeft(x, k);
+        }
+    }
+}
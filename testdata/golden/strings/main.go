@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+// count returns the length of s in bytes plus n.
+//
+//go:wasmexport count
+func count(s string, n int32, _ int32) int32 {
+	return int32(len(s)) + n
+}
+
+func main() {}
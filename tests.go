@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// testPackageVersions is the versions of the NuGet packages the test project refers to with -emit-tests.
+var testPackageVersions = map[string]string{
+	"Microsoft.NET.Test.Sdk":    "17.9.0",
+	"xunit":                     "2.6.6",
+	"xunit.runner.visualstudio": "2.5.6",
+}
+
+var testsTmpl = template.Must(template.New("tests").Parse(`    using Xunit;
+
+    // GoTests has a test for each exported function, which runs the program, calls the function and asserts that
+    // it doesn't trap. These are the starting points of characterization tests: replace the arguments with
+    // meaningful ones, and assert the results.
+    //
+    // The program must keep running after the main function returns, e.g. by select {}, so that the exported
+    // functions can be called.
+    public class GoTests : IDisposable
+    {
+        private readonly {{.Prefix}}Go go = new {{.Prefix}}Go();
+        private readonly System.Threading.CancellationTokenSource cts = new System.Threading.CancellationTokenSource();
+        private readonly Task<int> task;
+
+        public GoTests()
+        {
+            this.task = this.go.RunAsync(new string[0], this.cts.Token);
+            System.Threading.SpinWait.SpinUntil(() => this.go.Instance != null || this.task.IsCompleted);
+        }
+
+        public void Dispose()
+        {
+            // Stop the program, which might wait for events forever.
+            this.cts.Cancel();
+        }
+{{- range .Exports}}
+
+        [Fact]
+        public async Task {{.Export.BaseIdent}}_DoesNotTrap()
+        {
+            Assert.False(this.task.IsCompleted, "Go program exited before the call");
+            var exception = await Record.ExceptionAsync(() => (({{$.Prefix}}IGoApp)this.go).{{.Export.AsyncIdent}}({{range $i, $v := .Values}}{{if $i}}, {{end}}{{$v}}{{end}}));
+            Assert.Null(exception);
+        }
+{{- end}}
+    }`))
+
+// testsCode returns the C# code of the tests of the exported functions, which is put in the namespace. prefix is
+// the prefix of the generated types, e.g. the outer class by -outer.
+func testsCode(prefix string, exports []*exportCall) (string, error) {
+	var buf bytes.Buffer
+	if err := testsTmpl.Execute(&buf, struct {
+		Prefix  string
+		Exports []*exportCall
+	}{
+		Prefix:  prefix,
+		Exports: exports,
+	}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
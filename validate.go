@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+const (
+	// wasmPageSize is the size of a page of the linear memory.
+	wasmPageSize = 65536
+
+	// maxTableSize is the maximum number of the entries of a table, which is the limit of the web browsers.
+	maxTableSize = 10000000
+)
+
+// importStubBody is the body that an imported function is validated with. unreachable makes any signature valid.
+var importStubBody = wasm.FunctionBody{Code: []byte{operators.Unreachable}}
+
+// decodeModule decodes the wasm file. The decoder might panic on a malformed file, e.g. by an index out of range, so
+// the panic is reported as an error.
+func decodeModule(wasmBytes []byte) (mod *wasm.Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			mod, err = nil, fmt.Errorf("decoding the wasm file failed: %v", r)
+		}
+	}()
+	mod, err = wasm.DecodeModule(bytes.NewReader(wasmBytes))
+	if err != nil {
+		return nil, err
+	}
+	if err := lowerExtensions(mod); err != nil {
+		return nil, err
+	}
+	return mod, nil
+}
+
+// validateModule validates the bodies of the functions structurally, e.g. the types of the operands, the nesting of
+// the blocks and the indices of the locals, the globals and the functions, so that a malformed wasm file is reported
+// as an error instead of being translated into broken C# code. -trusted skips this for a wasm file that go build has
+// just produced.
+func validateModule(mod *wasm.Module) (err error) {
+	// The validator might panic on a malformed body, e.g. with a block type out of range.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	var types []wasm.FunctionSig
+	if mod.Types != nil {
+		types = mod.Types.Entries
+	}
+	for i, t := range types {
+		if len(t.ReturnTypes) > 1 {
+			return fmt.Errorf("type %d: multiple return values are not supported", i)
+		}
+		for _, vs := range [][]wasm.ValueType{t.ParamTypes, t.ReturnTypes} {
+			for _, v := range vs {
+				if err := validateValueType(v); err != nil {
+					return fmt.Errorf("type %d: %v", i, err)
+				}
+			}
+		}
+	}
+	funcType := func(t uint32) (*wasm.FunctionSig, error) {
+		if int(t) >= len(types) {
+			return nil, fmt.Errorf("the type index %d is out of range", t)
+		}
+		return &types[t], nil
+	}
+
+	// The validator refers to the index spaces, which DecodeModule doesn't populate. Populate them in a copy, as the
+	// imports are not resolved.
+	m := *mod
+	m.FunctionIndexSpace = nil
+	m.GlobalIndexSpace = nil
+	if mod.Import != nil {
+		for _, e := range mod.Import.Entries {
+			switch t := e.Type.(type) {
+			case wasm.FuncImport:
+				sig, err := funcType(t.Type)
+				if err != nil {
+					return fmt.Errorf("import %s.%s: %v", e.ModuleName, e.FieldName, err)
+				}
+				m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+					Sig:  sig,
+					Body: &importStubBody,
+					Name: e.FieldName,
+				})
+			case wasm.GlobalVarImport:
+				if err := validateValueType(t.Type.Type); err != nil {
+					return fmt.Errorf("import %s.%s: %v", e.ModuleName, e.FieldName, err)
+				}
+				m.GlobalIndexSpace = append(m.GlobalIndexSpace, wasm.GlobalEntry{Type: t.Type})
+			}
+		}
+	}
+	if mod.Function != nil {
+		if mod.Code == nil || len(mod.Code.Bodies) != len(mod.Function.Types) {
+			return fmt.Errorf("the number of the function bodies doesn't match the number of the functions %d", len(mod.Function.Types))
+		}
+		for i, t := range mod.Function.Types {
+			sig, err := funcType(t)
+			if err != nil {
+				return fmt.Errorf("function %d: %v", i, err)
+			}
+			for _, l := range mod.Code.Bodies[i].Locals {
+				if err := validateValueType(l.Type); err != nil {
+					return fmt.Errorf("function %d: %v", i, err)
+				}
+			}
+			m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+				Sig:  sig,
+				Body: &mod.Code.Bodies[i],
+			})
+		}
+	}
+	if mod.Global != nil {
+		for i, g := range mod.Global.Globals {
+			if err := validateValueType(g.Type.Type); err != nil {
+				return fmt.Errorf("global %d: %v", i, err)
+			}
+		}
+		m.GlobalIndexSpace = append(m.GlobalIndexSpace, mod.Global.Globals...)
+	}
+
+	// The indices out of the bodies are referred to by the translation as they are.
+	funcs := uint32(len(m.FunctionIndexSpace))
+	if mod.Export != nil {
+		for _, e := range mod.Export.Entries {
+			if e.Kind == wasm.ExternalFunction && e.Index >= funcs {
+				return fmt.Errorf("export %s: the function index %d is out of range", e.FieldStr, e.Index)
+			}
+		}
+	}
+	if mod.Elements != nil {
+		for i, e := range mod.Elements.Entries {
+			if mod.Table == nil || int(e.Index) >= len(mod.Table.Entries) {
+				return fmt.Errorf("element segment %d: the table index %d is out of range", i, e.Index)
+			}
+			// The segment must fit in the initial table, so that a huge offset doesn't make the table huge.
+			size := uint64(mod.Table.Entries[e.Index].Limits.Initial)
+			if size > maxTableSize {
+				return fmt.Errorf("element segment %d: the table size %d is too large", i, size)
+			}
+			if end, err := segmentEnd(mod, e.Offset, len(e.Elems)); err != nil {
+				return fmt.Errorf("element segment %d: %v", i, err)
+			} else if end > size {
+				return fmt.Errorf("element segment %d: the end %d is out of the table of %d entries", i, end, size)
+			}
+			for _, f := range e.Elems {
+				if f >= funcs {
+					return fmt.Errorf("element segment %d: the function index %d is out of range", i, f)
+				}
+			}
+		}
+	}
+	if mod.Data != nil {
+		for i, d := range mod.Data.Entries {
+			if mod.Memory == nil || int(d.Index) >= len(mod.Memory.Entries) {
+				return fmt.Errorf("data segment %d: the memory index %d is out of range", i, d.Index)
+			}
+			size := uint64(mod.Memory.Entries[d.Index].Limits.Initial) * wasmPageSize
+			if end, err := segmentEnd(mod, d.Offset, len(d.Data)); err != nil {
+				return fmt.Errorf("data segment %d: %v", i, err)
+			} else if end > size {
+				return fmt.Errorf("data segment %d: the end %d is out of the memory of %d bytes", i, end, size)
+			}
+		}
+	}
+	return validate.VerifyModule(&m)
+}
+
+// segmentEnd returns the end of an element or a data segment, i.e. the offset by the initializer expression plus the
+// length.
+func segmentEnd(mod *wasm.Module, offset []byte, length int) (uint64, error) {
+	v, err := evalInitExpr(mod, offset)
+	if err != nil {
+		return 0, err
+	}
+	o, ok := v.(int32)
+	if !ok {
+		return 0, fmt.Errorf("invalid offset %v", v)
+	}
+	return uint64(uint32(o)) + uint64(length), nil
+}
+
+// validateValueType returns an error if t is not one of the value types that the translation supports.
+func validateValueType(t wasm.ValueType) error {
+	switch t {
+	case wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64:
+		return nil
+	}
+	return fmt.Errorf("invalid value type 0x%02x", byte(t))
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+)
+
+// TestValidateSegments validates the modules that Fuzz found, whose segments are out of the table or the memory.
+// A segment must be rejected before the translation, which would grow the table to the end of the segment.
+func TestValidateSegments(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		wasm []byte
+		ok   bool
+	}{
+		{
+			// A table of 1 entry, and an element segment at 0x7ffffff0, which made the table 8 GiB.
+			name: "element",
+			wasm: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+				0x03, 0x02, 0x01, 0x00,
+				0x04, 0x04, 0x01, 0x70, 0x00, 0x01,
+				0x09, 0x0b, 0x01, 0x00, 0x41, 0xf0, 0xff, 0xff, 0xff, 0x07, 0x0b, 0x01, 0x00,
+				0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b,
+			},
+		},
+		{
+			// The same module with an element segment at 0.
+			name: "element-ok",
+			wasm: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+				0x03, 0x02, 0x01, 0x00,
+				0x04, 0x04, 0x01, 0x70, 0x00, 0x01,
+				0x09, 0x07, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x01, 0x00,
+				0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b,
+			},
+			ok: true,
+		},
+		{
+			// A memory of 1 page, and a data segment of 2 bytes at 65535.
+			name: "data",
+			wasm: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+				0x03, 0x02, 0x01, 0x00,
+				0x05, 0x03, 0x01, 0x00, 0x01,
+				0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b,
+				0x0b, 0x0a, 0x01, 0x00, 0x41, 0xff, 0xff, 0x03, 0x0b, 0x02, 0x01, 0x02,
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			mod, err := decodeModule(c.wasm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validateModule(mod)
+			if c.ok && err != nil {
+				t.Errorf("validateModule: %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Errorf("validateModule: got nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestVerify runs the verify subcommand on the wasm files in testdata/verify: numeric.wasm exports the numeric
+// instructions that the reference interpreter replaces, e.g. the shifts with counts of 32 and 64, and brtable.wasm
+// exports the functions with a sparse and a dense br_table, and extensions.wasm exports the instructions of the sign
+// extension and the non-trapping float-to-int conversion proposals, which are lowered to MVP instructions.
+func TestVerify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode, as the translated code is built with the .NET SDK")
+	}
+	if _, err := exec.LookPath("dotnet"); err != nil {
+		t.Skip("dotnet is not in PATH")
+	}
+
+	for _, name := range []string{"numeric", "brtable", "extensions"} {
+		t.Run(name, func(t *testing.T) {
+			out, err := exec.Command(translator, "verify", "-n", "50", filepath.Join("testdata", "verify", name+".wasm")).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+		})
+	}
+}
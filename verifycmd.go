@@ -0,0 +1,817 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	wexec "github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	"github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// verifyNamespace is the namespace of the code generated by the verify subcommand.
+const verifyNamespace = "Go2DotNet.Verify"
+
+var verifyRunnerTmpl = template.Must(template.New("runner").Parse(`// Code generated by go2dotnet verify. DO NOT EDIT.
+
+using System;
+using System.Linq;
+using System.Reflection;
+
+namespace {{.Namespace}}.Runner
+{
+    public static class Program
+    {
+        private const BindingFlags Flags = BindingFlags.Public | BindingFlags.NonPublic | BindingFlags.Instance;
+
+        public static int Main(string[] args)
+        {
+            // The memory and the constructor of Inst are internal, and Go starts the program, which the exported
+            // functions are called without. The imports are null, so calling an imported function traps.
+            var asm = typeof({{.Namespace}}.Inst).Assembly;
+            var memType = asm.GetType("{{.Namespace}}.Mem", true);
+            var mem = Activator.CreateInstance(memType, Flags, null, new object[] { {{.MaxPages}} }, null);
+            var ctor = typeof({{.Namespace}}.Inst).GetConstructors(Flags).Single(c => c.GetParameters().Length == 2);
+            var inst = ctor.Invoke(new object[] { mem, null });
+            var getExport = typeof({{.Namespace}}.Inst).GetMethod("GetExport", Flags);
+{{range .Calls}}            Call(getExport, inst, {{.Name}}, "{{.Sig}}", new ulong[] { {{.Args}} });
+{{end}}            return 0;
+        }
+
+        // Call calls the exported function, and prints the bits of the result, "-" for no result or "trap". sig is
+        // the characters of the parameter types and the result type, e.g. "ii:l".
+        private static void Call(MethodInfo getExport, object inst, string name, string sig, ulong[] args)
+        {
+            var types = sig.Split(':');
+            var f = (Delegate)getExport.Invoke(inst, new object[] { name, null });
+            var values = new object[args.Length];
+            for (int i = 0; i < args.Length; i++)
+            {
+                values[i] = FromBits(types[0][i], args[i]);
+            }
+            object result;
+            try
+            {
+                result = f.DynamicInvoke(values);
+            }
+            catch (TargetInvocationException)
+            {
+                Console.WriteLine("trap");
+                return;
+            }
+            if (types[1] == "")
+            {
+                Console.WriteLine("-");
+                return;
+            }
+            Console.WriteLine(ToBits(types[1][0], result));
+        }
+
+        private static object FromBits(char t, ulong bits)
+        {
+            switch (t)
+            {
+            case 'i':
+                return (int)(uint)bits;
+            case 'l':
+                return (long)bits;
+            case 'f':
+                return BitConverter.Int32BitsToSingle((int)(uint)bits);
+            case 'd':
+                return BitConverter.Int64BitsToDouble((long)bits);
+            }
+            throw new ArgumentException(t.ToString());
+        }
+
+        private static string ToBits(char t, object v)
+        {
+            switch (t)
+            {
+            case 'i':
+                return ((uint)(int)v).ToString("x8");
+            case 'l':
+                return ((ulong)(long)v).ToString("x16");
+            case 'f':
+                return ((uint)BitConverter.SingleToInt32Bits((float)v)).ToString("x8");
+            case 'd':
+                return ((ulong)BitConverter.DoubleToInt64Bits((double)v)).ToString("x16");
+            }
+            throw new ArgumentException(t.ToString());
+        }
+    }
+}
+`))
+
+// verifyEdges is the values of each type that the argument vectors are likely to have, as the translation of an
+// instruction often differs from the interpreter at them, e.g. at the overflow of a division or the conversion of
+// NaN. The values are the bits of the values.
+var verifyEdges = map[wasm.ValueType][]uint64{
+	wasm.ValueTypeI32: {0, 1, 2, 31, 32, 0x7f, 0x80, 0xff, 0x7fffffff, 0x80000000, 0xffffffff},
+	wasm.ValueTypeI64: {0, 1, 2, 63, 64, 0xffffffff, 0x100000000, 0x7fffffffffffffff, 0x8000000000000000, 0xffffffffffffffff},
+	wasm.ValueTypeF32: {
+		0, 0x80000000, 0x3f800000, 0xbf800000, 0x3f000000, 0x3fc00000, 0x7f7fffff, 0x00000001,
+		0x7f800000, 0xff800000, 0x7fc00000, 0xffc00000, 0x7fa00001,
+		0x4f000000, 0xcf000000, 0x4f800000, 0x5f000000, 0xdf000000,
+	},
+	wasm.ValueTypeF64: {
+		0, 0x8000000000000000, 0x3ff0000000000000, 0xbff0000000000000, 0x3fe0000000000000, 0x3ff8000000000000,
+		0x7fefffffffffffff, 0x0000000000000001, 0x7ff0000000000000, 0xfff0000000000000,
+		0x7ff8000000000000, 0xfff8000000000000, 0x7ff4000000000001,
+		0x41e0000000000000, 0xc1e0000000000000, 0x41f0000000000000, 0x43e0000000000000, 0xc3e0000000000000,
+	},
+}
+
+// verifyCall is a call of an exported function with an argument vector.
+type verifyCall struct {
+	Export string
+	Sig    *wasm.FunctionSig
+	Index  uint32
+	Args   []uint64
+}
+
+// runVerify runs the verify subcommand with the arguments after "verify".
+//
+// The subcommand calls the exported functions of the wasm file with generated argument vectors in wagon's
+// interpreter, and in the C# code translated with the translation flags after "--". The bits of the results must be
+// the same, and a call must trap in both or in neither. The interpreter is the oracle for the translation of the
+// numeric instructions, which a Go program rarely reaches with the edge values.
+//
+// The exported functions are called without the Go runtime, so they should be plain wasm functions, e.g. a module
+// written in the text format. The calls share the state of an instance, i.e. the memory and the globals, in order.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: go2dotnet verify [flags] file.wasm [-- translation flags]\n")
+		fs.PrintDefaults()
+	}
+	exports := fs.String("export", "", "Comma-separated exported functions to call (all the exported functions by default)")
+	n := fs.Int("n", 100, "Number of argument vectors for each exported function")
+	seed := fs.Int64("seed", 1, "Seed of the argument vectors")
+	exactNaN := fs.Bool("exact-nan", false, "Compare the payloads of NaN results, which wasm doesn't specify for the arithmetic instructions")
+	keep := fs.String("keep", "", "Directory to keep the projects in, instead of a temporary directory")
+	verbose := fs.Bool("v", false, "Print the arguments and the results of all the calls")
+
+	var transFlags []string
+	for i, a := range args {
+		if a == "--" {
+			args, transFlags = args[:i], args[i+1:]
+			break
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *n <= 0 {
+		return fmt.Errorf("verify: -n must be positive but %d", *n)
+	}
+	for _, f := range transFlags {
+		name := strings.SplitN(strings.TrimLeft(f, "-"), "=", 2)[0]
+		for _, r := range benchReservedFlags {
+			if strings.HasPrefix(f, "-") && name == r {
+				return fmt.Errorf("verify: -%s cannot be given as a translation flag", r)
+			}
+		}
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("verify: one wasm file must be given but %q", fs.Args())
+	}
+	wasmPath, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	wasmBytes, err := ioutil.ReadFile(wasmPath)
+	if err != nil {
+		return err
+	}
+
+	mod, err := verifyReadModule(wasmBytes)
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+	calls, err := verifyCalls(mod, *exports, *n, *seed)
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+
+	want, err := verifyInterpret(mod, calls)
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+
+	dir := *keep
+	if dir == "" {
+		d, err := ioutil.TempDir("", "go2dotnet-verify")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(d)
+		dir = d
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	got, err := verifyDotNet(mod, calls, wasmPath, dir, transFlags)
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+	if len(got) != len(calls) {
+		return fmt.Errorf("verify: the runner printed %d results, want %d", len(got), len(calls))
+	}
+
+	var failed int
+	for i, c := range calls {
+		ok := verifySame(want[i], got[i], c.Sig, *exactNaN)
+		if ok && !*verbose {
+			continue
+		}
+		status := "ok  "
+		if !ok {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %s(%s): %s, want %s\n", status, c.Export, verifyFormatArgs(c), got[i], want[i])
+	}
+	if failed > 0 {
+		return fmt.Errorf("verify: %d of %d calls differ", failed, len(calls))
+	}
+	fmt.Printf("ok   %d calls\n", len(calls))
+	return nil
+}
+
+// verifyReadModule reads the wasm file for the interpreter. Each imported function is resolved to a function that
+// traps, as the C# code is called without the imports. An imported global is zero.
+func verifyReadModule(wasmBytes []byte) (*wasm.Module, error) {
+	decoded, err := decodeModule(wasmBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateModule(decoded); err != nil {
+		return nil, fmt.Errorf("the wasm file is invalid (%v)", err)
+	}
+
+	stubs := map[string]*wasm.Module{}
+	if decoded.Import != nil {
+		for _, e := range decoded.Import.Entries {
+			m, ok := stubs[e.ModuleName]
+			if !ok {
+				m = &wasm.Module{
+					Types:  &wasm.SectionTypes{},
+					Export: &wasm.SectionExports{Entries: map[string]wasm.ExportEntry{}},
+				}
+				stubs[e.ModuleName] = m
+			}
+			switch t := e.Type.(type) {
+			case wasm.FuncImport:
+				sig := decoded.Types.Entries[t.Type]
+				m.Export.Entries[e.FieldName] = wasm.ExportEntry{
+					FieldStr: e.FieldName,
+					Kind:     wasm.ExternalFunction,
+					Index:    uint32(len(m.FunctionIndexSpace)),
+				}
+				m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+					Sig:  &sig,
+					Body: &importStubBody,
+					Name: e.FieldName,
+				})
+			case wasm.GlobalVarImport:
+				m.Export.Entries[e.FieldName] = wasm.ExportEntry{
+					FieldStr: e.FieldName,
+					Kind:     wasm.ExternalGlobal,
+					Index:    uint32(len(m.GlobalIndexSpace)),
+				}
+				m.GlobalIndexSpace = append(m.GlobalIndexSpace, wasm.GlobalEntry{
+					Type: t.Type,
+					Init: verifyZeroInit(t.Type.Type),
+				})
+			default:
+				return nil, fmt.Errorf("import %s.%s: importing a memory or a table is not supported", e.ModuleName, e.FieldName)
+			}
+		}
+	}
+
+	// The interpreter reads the module lowered by decodeModule, as it doesn't support the extensions either.
+	var lowered bytes.Buffer
+	if err := wasm.EncodeModule(&lowered, decoded); err != nil {
+		return nil, err
+	}
+	return wasm.ReadModule(&lowered, func(name string) (*wasm.Module, error) {
+		m, ok := stubs[name]
+		if !ok {
+			return nil, fmt.Errorf("module %s is not imported", name)
+		}
+		return m, nil
+	})
+}
+
+// verifyZeroInit returns the initializer expression of zero of the type.
+func verifyZeroInit(t wasm.ValueType) []byte {
+	switch t {
+	case wasm.ValueTypeI32:
+		return []byte{operators.I32Const, 0, operators.End}
+	case wasm.ValueTypeI64:
+		return []byte{operators.I64Const, 0, operators.End}
+	case wasm.ValueTypeF32:
+		return []byte{operators.F32Const, 0, 0, 0, 0, operators.End}
+	default:
+		return []byte{operators.F64Const, 0, 0, 0, 0, 0, 0, 0, 0, operators.End}
+	}
+}
+
+// verifyCalls returns the calls of the exported functions. names is a comma-separated list of the export names, or
+// empty for all the exported functions.
+//
+// The first vector of each function is all zeros. Each argument of the others is an edge value or random bits at
+// even odds.
+func verifyCalls(mod *wasm.Module, names string, n int, seed int64) ([]*verifyCall, error) {
+	if mod.Export == nil {
+		return nil, fmt.Errorf("the wasm file has no exports")
+	}
+	var selected []string
+	if names == "" {
+		for _, name := range mod.Export.Names {
+			if mod.Export.Entries[name].Kind == wasm.ExternalFunction {
+				selected = append(selected, name)
+			}
+		}
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			e, ok := mod.Export.Entries[name]
+			if !ok || e.Kind != wasm.ExternalFunction {
+				return nil, fmt.Errorf("-export: exported function %s is not found", name)
+			}
+			selected = append(selected, name)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("the wasm file exports no functions")
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	var calls []*verifyCall
+	for _, name := range selected {
+		idx := mod.Export.Entries[name].Index
+		sig := mod.GetFunction(int(idx)).Sig
+		for i := 0; i < n; i++ {
+			args := make([]uint64, len(sig.ParamTypes))
+			if i > 0 {
+				for j, t := range sig.ParamTypes {
+					args[j] = verifyArg(r, t)
+				}
+			}
+			calls = append(calls, &verifyCall{
+				Export: name,
+				Sig:    sig,
+				Index:  idx,
+				Args:   args,
+			})
+		}
+	}
+	return calls, nil
+}
+
+// verifyArg returns the bits of a random argument of the type.
+func verifyArg(r *rand.Rand, t wasm.ValueType) uint64 {
+	if edges := verifyEdges[t]; r.Intn(2) == 0 {
+		v := edges[r.Intn(len(edges))]
+		// Negate the edge value sometimes, which is the sign bit for the floating-point numbers.
+		if r.Intn(4) == 0 {
+			switch t {
+			case wasm.ValueTypeI32:
+				v = uint64(uint32(-int32(v)))
+			case wasm.ValueTypeI64:
+				v = uint64(-int64(v))
+			case wasm.ValueTypeF32:
+				v ^= 1 << 31
+			case wasm.ValueTypeF64:
+				v ^= 1 << 63
+			}
+		}
+		return v
+	}
+	switch t {
+	case wasm.ValueTypeI32:
+		return uint64(r.Uint32())
+	case wasm.ValueTypeI64:
+		return r.Uint64()
+	case wasm.ValueTypeF32:
+		// Random bits are mostly huge or tiny numbers, so a number in a moderate range is chosen as often.
+		if r.Intn(2) == 0 {
+			return uint64(math.Float32bits(float32(r.NormFloat64() * 1e4)))
+		}
+		return uint64(r.Uint32())
+	default:
+		if r.Intn(2) == 0 {
+			return math.Float64bits(r.NormFloat64() * 1e9)
+		}
+		return r.Uint64()
+	}
+}
+
+// verifyInterpret calls the functions in the interpreter, and returns the results in the format of the runner. The
+// instructions that the interpreter doesn't execute as the spec does are replaced by verifySpecFuncs.
+func verifyInterpret(mod *wasm.Module, calls []*verifyCall) ([]string, error) {
+	if err := verifyReplaceSpecOps(mod); err != nil {
+		return nil, err
+	}
+	vm, err := wexec.NewVM(mod)
+	if err != nil {
+		return nil, fmt.Errorf("the interpreter failed to instantiate the module: %v", err)
+	}
+	// A trap is a panic without this.
+	vm.RecoverPanic = true
+
+	var results []string
+	for _, c := range calls {
+		v, err := vm.ExecCode(int64(c.Index), c.Args...)
+		if err != nil {
+			results = append(results, "trap")
+			continue
+		}
+		switch v := v.(type) {
+		case nil:
+			results = append(results, "-")
+		case uint32:
+			results = append(results, fmt.Sprintf("%08x", v))
+		case uint64:
+			results = append(results, fmt.Sprintf("%016x", v))
+		case float32:
+			results = append(results, fmt.Sprintf("%08x", math.Float32bits(v)))
+		case float64:
+			results = append(results, fmt.Sprintf("%016x", math.Float64bits(v)))
+		default:
+			return nil, fmt.Errorf("unexpected result %T of %s", v, c.Export)
+		}
+	}
+	return results, nil
+}
+
+// verifySpecFuncs is the host functions that replace the instructions that wagon's interpreter doesn't execute as the
+// spec does: it doesn't mask the shift counts, returns a NaN with a payload for min and max, and doesn't trap at the
+// overflow of a signed division or of a truncation. The parameters and the results are the bits of the values.
+var verifySpecFuncs = map[byte]interface{}{
+	operators.I32Shl:  func(p *wexec.Process, x, y uint32) uint32 { return x << (y & 31) },
+	operators.I32ShrS: func(p *wexec.Process, x, y uint32) uint32 { return uint32(int32(x) >> (y & 31)) },
+	operators.I32ShrU: func(p *wexec.Process, x, y uint32) uint32 { return x >> (y & 31) },
+	operators.I64Shl:  func(p *wexec.Process, x, y uint64) uint64 { return x << (y & 63) },
+	operators.I64ShrS: func(p *wexec.Process, x, y uint64) uint64 { return uint64(int64(x) >> (y & 63)) },
+	operators.I64ShrU: func(p *wexec.Process, x, y uint64) uint64 { return x >> (y & 63) },
+	operators.I32DivS: func(p *wexec.Process, x, y uint32) uint32 {
+		if int32(x) == math.MinInt32 && int32(y) == -1 {
+			panic("integer overflow")
+		}
+		return uint32(int32(x) / int32(y))
+	},
+	operators.I64DivS: func(p *wexec.Process, x, y uint64) uint64 {
+		if int64(x) == math.MinInt64 && int64(y) == -1 {
+			panic("integer overflow")
+		}
+		return uint64(int64(x) / int64(y))
+	},
+	operators.I64RemS: func(p *wexec.Process, x, y uint64) uint64 {
+		return uint64(int64(x) % int64(y))
+	},
+	operators.F32Min: func(p *wexec.Process, x, y uint32) uint32 {
+		return verifyFloat32Op(x, y, math.Min)
+	},
+	operators.F32Max: func(p *wexec.Process, x, y uint32) uint32 {
+		return verifyFloat32Op(x, y, math.Max)
+	},
+	operators.F64Min: func(p *wexec.Process, x, y uint64) uint64 {
+		return verifyFloat64Op(x, y, math.Min)
+	},
+	operators.F64Max: func(p *wexec.Process, x, y uint64) uint64 {
+		return verifyFloat64Op(x, y, math.Max)
+	},
+	operators.I32TruncSF32: func(p *wexec.Process, x uint32) uint32 {
+		return uint32(int32(verifyTrunc(float64(math.Float32frombits(x)), -1<<31, 1<<31)))
+	},
+	operators.I32TruncUF32: func(p *wexec.Process, x uint32) uint32 {
+		return uint32(verifyTrunc(float64(math.Float32frombits(x)), 0, 1<<32))
+	},
+	operators.I32TruncSF64: func(p *wexec.Process, x uint64) uint32 {
+		return uint32(int32(verifyTrunc(math.Float64frombits(x), -1<<31, 1<<31)))
+	},
+	operators.I32TruncUF64: func(p *wexec.Process, x uint64) uint32 {
+		return uint32(verifyTrunc(math.Float64frombits(x), 0, 1<<32))
+	},
+	operators.I64TruncSF32: func(p *wexec.Process, x uint32) uint64 {
+		return uint64(int64(verifyTrunc(float64(math.Float32frombits(x)), -1<<63, 1<<63)))
+	},
+	operators.I64TruncUF32: func(p *wexec.Process, x uint32) uint64 {
+		return uint64(verifyTrunc(float64(math.Float32frombits(x)), 0, 1<<64))
+	},
+	operators.I64TruncSF64: func(p *wexec.Process, x uint64) uint64 {
+		return uint64(int64(verifyTrunc(math.Float64frombits(x), -1<<63, 1<<63)))
+	},
+	operators.I64TruncUF64: func(p *wexec.Process, x uint64) uint64 {
+		return uint64(verifyTrunc(math.Float64frombits(x), 0, 1<<64))
+	},
+}
+
+// verifyFloat32Op applies the min or max of float64 to the bits of float32. A NaN operand results in the canonical
+// NaN.
+func verifyFloat32Op(x, y uint32, op func(float64, float64) float64) uint32 {
+	fx, fy := float64(math.Float32frombits(x)), float64(math.Float32frombits(y))
+	if math.IsNaN(fx) || math.IsNaN(fy) {
+		return 0x7fc00000
+	}
+	return math.Float32bits(float32(op(fx, fy)))
+}
+
+// verifyFloat64Op applies the min or max to the bits of float64. A NaN operand results in the canonical NaN.
+func verifyFloat64Op(x, y uint64, op func(float64, float64) float64) uint64 {
+	fx, fy := math.Float64frombits(x), math.Float64frombits(y)
+	if math.IsNaN(fx) || math.IsNaN(fy) {
+		return 0x7ff8000000000000
+	}
+	return math.Float64bits(op(fx, fy))
+}
+
+// verifyTrunc returns x truncated toward zero, which must be at least min and less than max. Otherwise, or if x is
+// NaN, the truncation traps.
+func verifyTrunc(x, min, max float64) float64 {
+	t := math.Trunc(x)
+	if !(t >= min && t < max) {
+		panic("invalid conversion to integer")
+	}
+	return t
+}
+
+// verifyReplaceSpecOps replaces the instructions in verifySpecFuncs with calls of the host functions, which are
+// appended to the function index space of the module.
+func verifyReplaceSpecOps(mod *wasm.Module) error {
+	// The host functions are appended in the order of the opcodes, so that the indices are stable.
+	var codes []byte
+	for code := range verifySpecFuncs {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	indices := map[byte]uint32{}
+	for _, code := range codes {
+		fn := verifySpecFuncs[code]
+		op, err := operators.New(code)
+		if err != nil {
+			return err
+		}
+		indices[code] = uint32(len(mod.FunctionIndexSpace))
+		mod.FunctionIndexSpace = append(mod.FunctionIndexSpace, wasm.Function{
+			Sig: &wasm.FunctionSig{
+				Form:        0x60,
+				ParamTypes:  op.Args,
+				ReturnTypes: []wasm.ValueType{op.Returns},
+			},
+			Host: reflect.ValueOf(fn),
+			Name: op.Name,
+		})
+	}
+	if mod.Function == nil {
+		return nil
+	}
+
+	// wagon's disassembler looks up the signature of a callee in Function.Types by the index in the function index
+	// space, without subtracting the imported functions, so the types are listed again in the order of the function
+	// index space, including the host functions.
+	var ftypes []uint32
+	for _, fn := range mod.FunctionIndexSpace {
+		ftypes = append(ftypes, uint32(len(mod.Types.Entries)))
+		mod.Types.Entries = append(mod.Types.Entries, *fn.Sig)
+	}
+	mod.Function.Types = ftypes
+
+	for i := range mod.FunctionIndexSpace {
+		fn := &mod.FunctionIndexSpace[i]
+		if fn.IsHost() || fn.Body == nil {
+			continue
+		}
+		code, err := verifyReplaceOps(fn.Body.Code, indices)
+		if err != nil {
+			return fmt.Errorf("function %d: %v", i, err)
+		}
+		// The body might be shared with the code section, so it is copied.
+		body := *fn.Body
+		body.Code = code
+		fn.Body = &body
+	}
+	return nil
+}
+
+// verifyReplaceOps returns the code with each instruction in indices replaced by a call of the function.
+func verifyReplaceOps(code []byte, indices map[byte]uint32) ([]byte, error) {
+	var out bytes.Buffer
+	r := bytes.NewReader(code)
+	for r.Len() > 0 {
+		start := len(code) - r.Len()
+		op, _ := r.ReadByte()
+		if idx, ok := indices[op]; ok {
+			out.WriteByte(operators.Call)
+			leb128.WriteVarUint32(&out, idx)
+			continue
+		}
+		if err := verifySkipImmediates(r, op); err != nil {
+			return nil, err
+		}
+		out.Write(code[start : len(code)-r.Len()])
+	}
+	return out.Bytes(), nil
+}
+
+// verifySkipImmediates skips the immediates of the instruction op.
+func verifySkipImmediates(r *bytes.Reader, op byte) error {
+	var n int
+	switch {
+	case op == operators.Block || op == operators.Loop || op == operators.If:
+		_, err := r.ReadByte()
+		return err
+	case op == operators.BrTable:
+		c, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return err
+		}
+		n = int(c) + 1
+	case op == operators.Br || op == operators.BrIf || op == operators.Call ||
+		operators.GetLocal <= op && op <= operators.SetGlobal ||
+		op == operators.I32Const || op == operators.I64Const:
+		n = 1
+	case op == operators.CallIndirect:
+		if _, err := leb128.ReadVarUint32(r); err != nil {
+			return err
+		}
+		_, err := r.ReadByte()
+		return err
+	case operators.I32Load <= op && op <= operators.I64Store32:
+		n = 2
+	case op == operators.CurrentMemory || op == operators.GrowMemory:
+		_, err := r.ReadByte()
+		return err
+	case op == operators.F32Const:
+		_, err := r.Seek(4, io.SeekCurrent)
+		return err
+	case op == operators.F64Const:
+		_, err := r.Seek(8, io.SeekCurrent)
+		return err
+	case op >= 0xc0:
+		return fmt.Errorf("instruction 0x%02x is not supported", op)
+	}
+	// An LEB128 number ends at a byte without the continuation bit.
+	for ; n > 0; n-- {
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// verifyDotNet translates the wasm file to the directory dir, calls the functions in the runner, and returns the
+// results the runner printed.
+func verifyDotNet(mod *wasm.Module, calls []*verifyCall, wasmPath, dir string, transFlags []string) ([]string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	genDir := filepath.Join(dir, "gen")
+	trans := exec.Command(self, append(transFlags, "-trusted", "-wasm", wasmPath, "-namespace", verifyNamespace, "-out", genDir)...)
+	if out, err := trans.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("translation failed: %v\n%s", err, out)
+	}
+
+	type runnerCall struct {
+		Name string
+		Sig  string
+		Args string
+	}
+	var rcs []runnerCall
+	for _, c := range calls {
+		var args []string
+		for _, a := range c.Args {
+			args = append(args, fmt.Sprintf("0x%x", a))
+		}
+		rcs = append(rcs, runnerCall{
+			Name: csString(c.Export),
+			Sig:  verifySigString(c.Sig),
+			Args: strings.Join(args, ", "),
+		})
+	}
+	// The translation grows the memory up to the maximum, or as much as a 32-bit address can reach.
+	maxPages := uint32(65536)
+	if mod.Memory != nil && len(mod.Memory.Entries) > 0 && mod.Memory.Entries[0].Limits.Flags&1 != 0 {
+		maxPages = mod.Memory.Entries[0].Limits.Maximum
+	}
+
+	var code bytes.Buffer
+	if err := verifyRunnerTmpl.Execute(&code, struct {
+		Namespace string
+		MaxPages  uint32
+		Calls     []runnerCall
+	}{
+		Namespace: verifyNamespace,
+		MaxPages:  maxPages,
+		Calls:     rcs,
+	}); err != nil {
+		return nil, err
+	}
+	runner := &subproject{
+		Name:            "Runner",
+		Project:         filepath.Join("gen", projectName(wasmPath)),
+		TargetFramework: "net8.0",
+		Exe:             true,
+		Files: map[string][]byte{
+			"Program.cs": code.Bytes(),
+		},
+	}
+	if err := runner.write(dir); err != nil {
+		return nil, err
+	}
+	csproj := filepath.Join(dir, runner.Name, runner.Name+".csproj")
+	if out, err := exec.Command("dotnet", "build", "-c", "Release", csproj).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("dotnet build failed: %v\n%s", err, out)
+	}
+	out, err := exec.Command("dotnet", "run", "-c", "Release", "--no-build", "--project", csproj).Output()
+	if err != nil {
+		return nil, fmt.Errorf("dotnet run failed: %v", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// verifySigString returns the signature in the format of the runner, e.g. "ii:l".
+func verifySigString(sig *wasm.FunctionSig) string {
+	chars := map[wasm.ValueType]byte{
+		wasm.ValueTypeI32: 'i',
+		wasm.ValueTypeI64: 'l',
+		wasm.ValueTypeF32: 'f',
+		wasm.ValueTypeF64: 'd',
+	}
+	var b strings.Builder
+	for _, t := range sig.ParamTypes {
+		b.WriteByte(chars[t])
+	}
+	b.WriteByte(':')
+	for _, t := range sig.ReturnTypes {
+		b.WriteByte(chars[t])
+	}
+	return b.String()
+}
+
+// verifySame reports whether the results are the same. Unless exactNaN is true, any NaNs are the same.
+func verifySame(want, got string, sig *wasm.FunctionSig, exactNaN bool) bool {
+	if want == got {
+		return true
+	}
+	if exactNaN || len(sig.ReturnTypes) == 0 {
+		return false
+	}
+	var w, g uint64
+	if _, err := fmt.Sscanf(want, "%x", &w); err != nil {
+		return false
+	}
+	if _, err := fmt.Sscanf(got, "%x", &g); err != nil {
+		return false
+	}
+	switch sig.ReturnTypes[0] {
+	case wasm.ValueTypeF32:
+		return math.IsNaN(float64(math.Float32frombits(uint32(w)))) && math.IsNaN(float64(math.Float32frombits(uint32(g))))
+	case wasm.ValueTypeF64:
+		return math.IsNaN(math.Float64frombits(w)) && math.IsNaN(math.Float64frombits(g))
+	}
+	return false
+}
+
+// verifyFormatArgs formats the arguments of the call for a message. A floating-point number is formatted with its
+// bits, as NaN's payload matters.
+func verifyFormatArgs(c *verifyCall) string {
+	var args []string
+	for i, a := range c.Args {
+		switch c.Sig.ParamTypes[i] {
+		case wasm.ValueTypeI32:
+			args = append(args, fmt.Sprint(int32(a)))
+		case wasm.ValueTypeI64:
+			args = append(args, fmt.Sprint(int64(a)))
+		case wasm.ValueTypeF32:
+			args = append(args, fmt.Sprintf("%v (0x%08x)", math.Float32frombits(uint32(a)), a))
+		case wasm.ValueTypeF64:
+			args = append(args, fmt.Sprintf("%v (0x%016x)", math.Float64frombits(a), a))
+		}
+	}
+	return strings.Join(args, ", ")
+}
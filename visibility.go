@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// identifierRe matches a C# identifier that consists of ASCII characters.
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// publicTypeRe matches the declarations of the public types at the top level of the namespace.
+var publicTypeRe = regexp.MustCompile(`(?m)^    public ((?:sealed |static |abstract |partial )*(?:class|struct|interface|enum|delegate) )`)
+
+// makeInternal makes the public types in the generated code internal, so that the code can be embedded
+// into another assembly without exposing the types.
+func makeInternal(code []byte) []byte {
+	return publicTypeRe.ReplaceAll(code, []byte("    internal $1"))
+}
+
+// defaultAccessTypeRe matches the declarations of the types without access modifiers at the top level of the namespace.
+var defaultAccessTypeRe = regexp.MustCompile(`(?m)^    ((?:sealed |static |abstract |partial )*(?:class|struct|interface|enum) )`)
+
+// nestInClass puts all the types of the namespace in the generated code into the static class outer.
+// The class is partial so that the code split by -split is nested into the same class.
+func nestInClass(code []byte, outer string, visibility string) []byte {
+	// Nested types without access modifiers are private, while top-level ones are internal.
+	code = defaultAccessTypeRe.ReplaceAll(code, []byte("    internal $1"))
+
+	lines := bytes.Split(code, []byte("\n"))
+
+	begin := -1
+	for i, l := range lines {
+		if bytes.HasPrefix(l, []byte("namespace ")) && i+1 < len(lines) && string(lines[i+1]) == "{" {
+			begin = i + 2
+			break
+		}
+	}
+	end := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		if string(lines[i]) == "}" {
+			end = i
+			break
+		}
+	}
+	if begin < 0 || end < begin {
+		return code
+	}
+
+	var buf bytes.Buffer
+	for _, l := range lines[:begin] {
+		buf.Write(l)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("    " + visibility + " static partial class " + outer + "\n")
+	buf.WriteString("    {\n")
+	for _, l := range lines[begin:end] {
+		if len(l) > 0 {
+			buf.WriteString("    ")
+		}
+		buf.Write(l)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("    }\n")
+	buf.Write(bytes.Join(lines[end:], []byte("\n")))
+	return buf.Bytes()
+}
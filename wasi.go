@@ -0,0 +1,693 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+const wasiModuleName = "wasi_snapshot_preview1"
+
+var wasiFuncBodies = map[string]string{
+	// args_get(argv *i32, argv_buf *u8) errno
+	"args_get": `    return go.wasi.ArgsGet(local0, local1);`,
+
+	// args_sizes_get(argc *i32, argv_buf_size *i32) errno
+	"args_sizes_get": `    return go.wasi.ArgsSizesGet(local0, local1);`,
+
+	// environ_get(environ *i32, environ_buf *u8) errno
+	"environ_get": `    return go.wasi.EnvironGet(local0, local1);`,
+
+	// environ_sizes_get(environc *i32, environ_buf_size *i32) errno
+	"environ_sizes_get": `    return go.wasi.EnvironSizesGet(local0, local1);`,
+
+	// clock_res_get(id clockid, resolution *timestamp) errno
+	"clock_res_get": `    return go.wasi.ClockResGet(local0, local1);`,
+
+	// clock_time_get(id clockid, precision timestamp, time *timestamp) errno
+	"clock_time_get": `    return go.wasi.ClockTimeGet(local0, local1, local2);`,
+
+	// fd_close(fd fd) errno
+	"fd_close": `    return go.wasi.FdClose(local0);`,
+
+	// fd_fdstat_get(fd fd, stat *fdstat) errno
+	"fd_fdstat_get": `    return go.wasi.FdFdstatGet(local0, local1);`,
+
+	// fd_fdstat_set_flags(fd fd, flags fdflags) errno
+	"fd_fdstat_set_flags": `    return go.wasi.FdFdstatSetFlags(local0, local1);`,
+
+	// fd_filestat_get(fd fd, buf *filestat) errno
+	"fd_filestat_get": `    return go.wasi.FdFilestatGet(local0, local1);`,
+
+	// fd_prestat_get(fd fd, buf *prestat) errno
+	"fd_prestat_get": `    return go.wasi.FdPrestatGet(local0, local1);`,
+
+	// fd_prestat_dir_name(fd fd, path *u8, path_len size) errno
+	"fd_prestat_dir_name": `    return go.wasi.FdPrestatDirName(local0, local1, local2);`,
+
+	// fd_read(fd fd, iovs *iovec, iovs_len size, nread *size) errno
+	"fd_read": `    return go.wasi.FdRead(local0, local1, local2, local3);`,
+
+	// fd_seek(fd fd, offset filedelta, whence whence, newoffset *filesize) errno
+	"fd_seek": `    return go.wasi.FdSeek(local0, local1, local2, local3);`,
+
+	// fd_sync(fd fd) errno
+	"fd_sync": `    return go.wasi.FdSync(local0);`,
+
+	// fd_write(fd fd, iovs *ciovec, iovs_len size, nwritten *size) errno
+	"fd_write": `    return go.wasi.FdWrite(local0, local1, local2, local3);`,
+
+	// path_filestat_get(fd fd, flags lookupflags, path *u8, path_len size, buf *filestat) errno
+	"path_filestat_get": `    return go.wasi.PathFilestatGet(local0, local1, local2, local3, local4);`,
+
+	// path_open(fd fd, dirflags lookupflags, path *u8, path_len size, oflags oflags,
+	//           fs_rights_base rights, fs_rights_inheriting rights, fdflags fdflags, fd *fd) errno
+	"path_open": `    return go.wasi.PathOpen(local0, local1, local2, local3, local4, local5, local6, local7, local8);`,
+
+	// poll_oneoff(in *subscription, out *event, nsubscriptions size, nevents *size) errno
+	"poll_oneoff": `    return go.wasi.PollOneoff(local0, local1, local2, local3);`,
+
+	// proc_exit(rval exitcode)
+	"proc_exit": `    go.wasi.ProcExit(local0);`,
+
+	// random_get(buf *u8, buf_len size) errno
+	"random_get": `    return go.wasi.RandomGet(local0, local1);`,
+
+	// sched_yield() errno
+	"sched_yield": `    return 0;`,
+}
+
+// wasiFuncBody returns the C# body of the WASI function.
+// Functions not implemented yet return ENOSYS so that the program can handle the error.
+func wasiFuncBody(name string, sig *wasm.FunctionSig) string {
+	if body, ok := wasiFuncBodies[name]; ok {
+		return body
+	}
+	if len(sig.ReturnTypes) == 1 && sig.ReturnTypes[0] == wasm.ValueTypeI32 {
+		return `    return 52; // ENOSYS`
+	}
+	return ""
+}
+
+const wasi = `    sealed class WasiExitException : Exception
+    {
+        public WasiExitException(int code)
+            : base($"exit code: {code}")
+        {
+            this.Code = code;
+        }
+
+        public int Code { get; }
+    }
+
+    // Wasi is an implementation of WASI preview1 backed by IGoFileSystem and the console.
+    sealed class Wasi
+    {
+        const int ErrnoSuccess = 0;
+        const int ErrnoAcces = 2;
+        const int ErrnoBadf = 8;
+        const int ErrnoExist = 20;
+        const int ErrnoInval = 28;
+        const int ErrnoIo = 29;
+        const int ErrnoIsdir = 31;
+        const int ErrnoNoent = 44;
+        const int ErrnoNosys = 52;
+        const int ErrnoNotdir = 54;
+        const int ErrnoSpipe = 70;
+
+        const byte FiletypeCharacterDevice = 2;
+        const byte FiletypeDirectory = 3;
+        const byte FiletypeRegularFile = 4;
+
+        const long RightsFdRead = 1L << 1;
+        const long RightsFdWrite = 1L << 6;
+
+        public Wasi(Mem mem, IGoFileSystem fs, IGoClock clock, Stream stdin, Stream stdout, Stream stderr, string[] args, string[] env, string preopenDir, Action<ArraySegment<byte>> stderrObserver, System.Threading.CancellationToken cancellationToken)
+        {
+            this.mem = mem;
+            this.fs = fs;
+            this.clock = clock;
+            this.stderrObserver = stderrObserver;
+            this.cancellationToken = cancellationToken;
+            this.args = args;
+            this.env = env;
+            this.files[0] = stdin;
+            this.files[1] = stdout;
+            this.files[2] = stderr;
+            this.root = Path.GetFullPath(preopenDir);
+            this.dirs[3] = this.root;
+            this.startNanoseconds = clock.MonotonicNanoseconds;
+            this.nextFd = 4;
+        }
+
+        public int ArgsGet(int argv, int argvBuf)
+        {
+            return this.StoreStrings(this.args, argv, argvBuf);
+        }
+
+        public int ArgsSizesGet(int argc, int argvBufSize)
+        {
+            return this.StoreStringSizes(this.args, argc, argvBufSize);
+        }
+
+        public int EnvironGet(int environ, int environBuf)
+        {
+            return this.StoreStrings(this.env, environ, environBuf);
+        }
+
+        public int EnvironSizesGet(int environc, int environBufSize)
+        {
+            return this.StoreStringSizes(this.env, environc, environBufSize);
+        }
+
+        public int ClockResGet(int id, int resolution)
+        {
+            switch (id)
+            {
+            case 0:
+                this.mem.StoreInt64(resolution, 100);
+                return ErrnoSuccess;
+            case 1:
+                this.mem.StoreInt64(resolution, Math.Max(1, 1_000_000_000L / Stopwatch.Frequency));
+                return ErrnoSuccess;
+            }
+            return ErrnoInval;
+        }
+
+        public int ClockTimeGet(int id, long precision, int time)
+        {
+            switch (id)
+            {
+            case 0:
+                this.mem.StoreInt64(time, (this.clock.UtcNow - new DateTime(1970, 1, 1, 0, 0, 0, DateTimeKind.Utc)).Ticks * 100);
+                return ErrnoSuccess;
+            case 1:
+                this.mem.StoreInt64(time, this.Monotonic());
+                return ErrnoSuccess;
+            }
+            return ErrnoInval;
+        }
+
+        public int FdClose(int fd)
+        {
+            if (this.dirs.Remove(fd))
+            {
+                return ErrnoSuccess;
+            }
+            if (!this.files.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            this.files[fd].Dispose();
+            this.files.Remove(fd);
+            this.paths.Remove(fd);
+            return ErrnoSuccess;
+        }
+
+        public int FdFdstatGet(int fd, int stat)
+        {
+            byte filetype;
+            if (this.dirs.ContainsKey(fd))
+            {
+                filetype = FiletypeDirectory;
+            }
+            else if (fd <= 2 && this.files.ContainsKey(fd))
+            {
+                filetype = FiletypeCharacterDevice;
+            }
+            else if (this.files.ContainsKey(fd))
+            {
+                filetype = FiletypeRegularFile;
+            }
+            else
+            {
+                return ErrnoBadf;
+            }
+            this.mem.StoreInt8(stat, (sbyte)filetype);
+            this.mem.StoreInt16(stat + 2, 0);
+            this.mem.StoreInt64(stat + 8, -1);
+            this.mem.StoreInt64(stat + 16, -1);
+            return ErrnoSuccess;
+        }
+
+        public int FdFdstatSetFlags(int fd, int flags)
+        {
+            if (!this.files.ContainsKey(fd) && !this.dirs.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            return ErrnoSuccess;
+        }
+
+        public int FdFilestatGet(int fd, int buf)
+        {
+            if (this.dirs.ContainsKey(fd))
+            {
+                return this.StoreFilestat(buf, this.dirs[fd]);
+            }
+            if (!this.files.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            if (this.paths.ContainsKey(fd))
+            {
+                return this.StoreFilestat(buf, this.paths[fd]);
+            }
+            this.StoreFilestat(buf, FiletypeCharacterDevice, 0, DateTime.UtcNow, DateTime.UtcNow, DateTime.UtcNow);
+            return ErrnoSuccess;
+        }
+
+        public int FdPrestatGet(int fd, int buf)
+        {
+            if (fd != 3 || !this.dirs.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            this.mem.StoreInt8(buf, 0);
+            this.mem.StoreInt32(buf + 4, Encoding.UTF8.GetByteCount(PreopenName));
+            return ErrnoSuccess;
+        }
+
+        public int FdPrestatDirName(int fd, int path, int pathLen)
+        {
+            if (fd != 3 || !this.dirs.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            var bytes = Encoding.UTF8.GetBytes(PreopenName);
+            if (bytes.Length > pathLen)
+            {
+                return ErrnoInval;
+            }
+            this.mem.StoreBytes(path, bytes);
+            return ErrnoSuccess;
+        }
+
+        public int FdRead(int fd, int iovs, int iovsLen, int nread)
+        {
+            // WASI programs run synchronously. Check the cancellation at I/O.
+            this.cancellationToken.ThrowIfCancellationRequested();
+            if (this.dirs.ContainsKey(fd))
+            {
+                return ErrnoIsdir;
+            }
+            if (!this.files.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            var stream = this.files[fd];
+            int total = 0;
+            try
+            {
+                for (int i = 0; i < iovsLen; i++)
+                {
+                    var buf = this.mem.LoadInt32(iovs + 8 * i);
+                    var len = this.mem.LoadInt32(iovs + 8 * i + 4);
+                    var slice = this.mem.LoadSliceDirectly(buf, len);
+                    var n = fd <= 2 ? stream.Read(slice.Array, slice.Offset, slice.Count) : this.fs.Read(stream, slice.Array, slice.Offset, slice.Count, null);
+                    total += n;
+                    if (n < len)
+                    {
+                        break;
+                    }
+                }
+            }
+            catch (IOException)
+            {
+                return ErrnoIo;
+            }
+            this.mem.StoreInt32(nread, total);
+            return ErrnoSuccess;
+        }
+
+        public int FdSeek(int fd, long offset, int whence, int newOffset)
+        {
+            if (!this.files.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            var stream = this.files[fd];
+            if (!stream.CanSeek)
+            {
+                return ErrnoSpipe;
+            }
+            SeekOrigin origin;
+            switch (whence)
+            {
+            case 0:
+                origin = SeekOrigin.Begin;
+                break;
+            case 1:
+                origin = SeekOrigin.Current;
+                break;
+            case 2:
+                origin = SeekOrigin.End;
+                break;
+            default:
+                return ErrnoInval;
+            }
+            try
+            {
+                this.mem.StoreInt64(newOffset, stream.Seek(offset, origin));
+            }
+            catch (IOException)
+            {
+                return ErrnoInval;
+            }
+            return ErrnoSuccess;
+        }
+
+        public int FdSync(int fd)
+        {
+            if (!this.files.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            this.files[fd].Flush();
+            return ErrnoSuccess;
+        }
+
+        public int FdWrite(int fd, int iovs, int iovsLen, int nwritten)
+        {
+            this.cancellationToken.ThrowIfCancellationRequested();
+            if (this.dirs.ContainsKey(fd))
+            {
+                return ErrnoIsdir;
+            }
+            if (!this.files.ContainsKey(fd))
+            {
+                return ErrnoBadf;
+            }
+            var stream = this.files[fd];
+            int total = 0;
+            try
+            {
+                for (int i = 0; i < iovsLen; i++)
+                {
+                    var buf = this.mem.LoadInt32(iovs + 8 * i);
+                    var len = this.mem.LoadInt32(iovs + 8 * i + 4);
+                    var slice = this.mem.LoadSliceDirectly(buf, len);
+                    if (fd <= 2)
+                    {
+                        stream.Write(slice.Array, slice.Offset, slice.Count);
+                    }
+                    else
+                    {
+                        this.fs.Write(stream, slice.Array, slice.Offset, slice.Count, null);
+                    }
+                    if (fd == 2 && this.stderrObserver != null)
+                    {
+                        this.stderrObserver(slice);
+                    }
+                    total += len;
+                }
+                stream.Flush();
+            }
+            catch (IOException)
+            {
+                return ErrnoIo;
+            }
+            this.mem.StoreInt32(nwritten, total);
+            return ErrnoSuccess;
+        }
+
+        public int PathFilestatGet(int fd, int flags, int path, int pathLen, int buf)
+        {
+            string fullPath;
+            var errno = this.ResolvePath(fd, path, pathLen, out fullPath);
+            if (errno != ErrnoSuccess)
+            {
+                return errno;
+            }
+            return this.StoreFilestat(buf, fullPath);
+        }
+
+        public int PathOpen(int fd, int dirflags, int path, int pathLen, int oflags, long rightsBase, long rightsInheriting, int fdflags, int newFd)
+        {
+            const int OflagsCreat = 1;
+            const int OflagsDirectory = 2;
+            const int OflagsExcl = 4;
+            const int OflagsTrunc = 8;
+            const int FdflagsAppend = 1;
+
+            string fullPath;
+            var errno = this.ResolvePath(fd, path, pathLen, out fullPath);
+            if (errno != ErrnoSuccess)
+            {
+                return errno;
+            }
+
+            var info = this.fs.Stat(fullPath);
+            if (info != null && info.IsDirectory)
+            {
+                this.dirs[this.nextFd] = fullPath;
+                this.mem.StoreInt32(newFd, this.nextFd);
+                this.nextFd++;
+                return ErrnoSuccess;
+            }
+            if ((oflags & OflagsDirectory) != 0)
+            {
+                return info != null ? ErrnoNotdir : ErrnoNoent;
+            }
+
+            FileMode mode = FileMode.Open;
+            if ((oflags & OflagsCreat) != 0)
+            {
+                if ((oflags & OflagsExcl) != 0)
+                {
+                    if (info != null)
+                    {
+                        return ErrnoExist;
+                    }
+                    mode = FileMode.CreateNew;
+                }
+                else if ((oflags & OflagsTrunc) != 0)
+                {
+                    mode = FileMode.Create;
+                }
+                else
+                {
+                    mode = FileMode.OpenOrCreate;
+                }
+            }
+            else if ((oflags & OflagsTrunc) != 0)
+            {
+                mode = FileMode.Truncate;
+            }
+
+            FileAccess access = FileAccess.Read;
+            if ((rightsBase & RightsFdWrite) != 0)
+            {
+                access = (rightsBase & RightsFdRead) != 0 ? FileAccess.ReadWrite : FileAccess.Write;
+            }
+
+            try
+            {
+                var stream = this.fs.Open(fullPath, mode, access);
+                if ((fdflags & FdflagsAppend) != 0)
+                {
+                    stream.Seek(0, SeekOrigin.End);
+                }
+                this.files[this.nextFd] = stream;
+                this.paths[this.nextFd] = fullPath;
+            }
+            catch (FileNotFoundException)
+            {
+                return ErrnoNoent;
+            }
+            catch (DirectoryNotFoundException)
+            {
+                return ErrnoNoent;
+            }
+            catch (UnauthorizedAccessException)
+            {
+                return ErrnoAcces;
+            }
+            catch (NotSupportedException)
+            {
+                return ErrnoNosys;
+            }
+            catch (IOException)
+            {
+                return ErrnoIo;
+            }
+            this.mem.StoreInt32(newFd, this.nextFd);
+            this.nextFd++;
+            return ErrnoSuccess;
+        }
+
+        public int PollOneoff(int input, int output, int nsubscriptions, int nevents)
+        {
+            const int EventtypeClock = 0;
+            const int SubclockflagsAbstime = 1;
+
+            if (nsubscriptions == 0)
+            {
+                return ErrnoInval;
+            }
+
+            // Find the earliest clock event. Subscriptions for file descriptors are regarded as ready immediately.
+            long timeout = long.MaxValue;
+            bool hasFd = false;
+            for (int i = 0; i < nsubscriptions; i++)
+            {
+                var sub = input + 48 * i;
+                if (this.mem.LoadUint8(sub + 8) != EventtypeClock)
+                {
+                    hasFd = true;
+                    continue;
+                }
+                var t = this.mem.LoadInt64(sub + 24);
+                if ((this.mem.LoadUint16(sub + 40) & SubclockflagsAbstime) != 0)
+                {
+                    t -= this.mem.LoadInt32(sub + 16) == 0 ? (this.clock.UtcNow - new DateTime(1970, 1, 1, 0, 0, 0, DateTimeKind.Utc)).Ticks * 100 : this.Monotonic();
+                }
+                timeout = Math.Min(timeout, Math.Max(0, t));
+            }
+            if (!hasFd && timeout != long.MaxValue && timeout > 0)
+            {
+                using (var elapsed = new System.Threading.ManualResetEventSlim())
+                using (this.clock.Schedule(TimeSpan.FromTicks(timeout / 100), () => elapsed.Set()))
+                {
+                    elapsed.Wait(this.cancellationToken);
+                }
+            }
+
+            int n = 0;
+            for (int i = 0; i < nsubscriptions; i++)
+            {
+                var sub = input + 48 * i;
+                var type = this.mem.LoadUint8(sub + 8);
+                if (type == EventtypeClock && hasFd)
+                {
+                    continue;
+                }
+                var ev = output + 32 * n;
+                this.mem.StoreInt64(ev, this.mem.LoadInt64(sub));
+                this.mem.StoreInt16(ev + 8, ErrnoSuccess);
+                this.mem.StoreInt8(ev + 10, (sbyte)type);
+                this.mem.StoreInt64(ev + 16, 0);
+                this.mem.StoreInt16(ev + 24, 0);
+                n++;
+            }
+            this.mem.StoreInt32(nevents, n);
+            return ErrnoSuccess;
+        }
+
+        public void ProcExit(int code)
+        {
+            foreach (var stream in this.files.Values)
+            {
+                stream.Flush();
+            }
+            throw new WasiExitException(code);
+        }
+
+        public int RandomGet(int buf, int bufLen)
+        {
+            var slice = this.mem.LoadSliceDirectly(buf, bufLen);
+            this.rng.GetBytes(slice.Array, slice.Offset, slice.Count);
+            return ErrnoSuccess;
+        }
+
+        // CloseFiles closes all the files opened by the program.
+        public void CloseFiles()
+        {
+            foreach (var fd in this.files.Keys.Where(fd => fd > 2).ToList())
+            {
+                this.files[fd].Dispose();
+                this.files.Remove(fd);
+            }
+            this.paths.Clear();
+        }
+
+        private int StoreStrings(string[] strs, int ptrs, int buf)
+        {
+            foreach (var str in strs)
+            {
+                this.mem.StoreInt32(ptrs, buf);
+                ptrs += 4;
+                buf += this.mem.StoreString(buf, str + '\0');
+            }
+            return ErrnoSuccess;
+        }
+
+        private int StoreStringSizes(string[] strs, int count, int bufSize)
+        {
+            this.mem.StoreInt32(count, strs.Length);
+            this.mem.StoreInt32(bufSize, strs.Sum(str => Encoding.UTF8.GetByteCount(str) + 1));
+            return ErrnoSuccess;
+        }
+
+        private int ResolvePath(int fd, int path, int pathLen, out string fullPath)
+        {
+            fullPath = null;
+            if (!this.dirs.ContainsKey(fd))
+            {
+                return this.files.ContainsKey(fd) ? ErrnoNotdir : ErrnoBadf;
+            }
+            var relPath = this.mem.LoadStringDirectly(path, pathLen);
+            if (Path.IsPathRooted(relPath))
+            {
+                return ErrnoAcces;
+            }
+            fullPath = Path.GetFullPath(Path.Combine(this.dirs[fd], relPath));
+            if (fullPath != this.root && !fullPath.StartsWith(this.root + Path.DirectorySeparatorChar))
+            {
+                // Escaping from the preopened directory is not allowed.
+                return ErrnoAcces;
+            }
+            return ErrnoSuccess;
+        }
+
+        private int StoreFilestat(int buf, string path)
+        {
+            var info = this.fs.Stat(path);
+            if (info == null)
+            {
+                return ErrnoNoent;
+            }
+            if (info.IsDirectory)
+            {
+                this.StoreFilestat(buf, FiletypeDirectory, 0, info.ModTimeUtc, info.ModTimeUtc, info.ModTimeUtc);
+                return ErrnoSuccess;
+            }
+            this.StoreFilestat(buf, FiletypeRegularFile, info.Size, info.ModTimeUtc, info.ModTimeUtc, info.ModTimeUtc);
+            return ErrnoSuccess;
+        }
+
+        private void StoreFilestat(int buf, byte filetype, long size, DateTime atime, DateTime mtime, DateTime ctime)
+        {
+            var epoch = new DateTime(1970, 1, 1, 0, 0, 0, DateTimeKind.Utc);
+            this.mem.StoreInt64(buf, 0);
+            this.mem.StoreInt64(buf + 8, 0);
+            this.mem.StoreInt8(buf + 16, (sbyte)filetype);
+            this.mem.StoreInt64(buf + 24, 1);
+            this.mem.StoreInt64(buf + 32, size);
+            this.mem.StoreInt64(buf + 40, (atime - epoch).Ticks * 100);
+            this.mem.StoreInt64(buf + 48, (mtime - epoch).Ticks * 100);
+            this.mem.StoreInt64(buf + 56, (ctime - epoch).Ticks * 100);
+        }
+
+        private long Monotonic()
+        {
+            return this.clock.MonotonicNanoseconds - this.startNanoseconds;
+        }
+
+        private const string PreopenName = "/";
+
+        private Mem mem;
+        private IGoFileSystem fs;
+        private IGoClock clock;
+        private Action<ArraySegment<byte>> stderrObserver;
+        private RandomNumberGenerator rng = RandomNumberGenerator.Create();
+        private System.Threading.CancellationToken cancellationToken;
+        private string root;
+        private string[] args;
+        private string[] env;
+        private Dictionary<int, Stream> files = new Dictionary<int, Stream>();
+        private Dictionary<int, string> paths = new Dictionary<int, string>();
+        private Dictionary<int, string> dirs = new Dictionary<int, string>();
+        private int nextFd;
+        private long startNanoseconds;
+    }`
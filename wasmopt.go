@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runWasmOpt runs Binaryen's wasm-opt with the options on the wasm binary, by -wasm-opt, and returns the optimized
+// binary.
+func runWasmOpt(wasmBytes []byte, options string) ([]byte, error) {
+	path, err := exec.LookPath("wasm-opt")
+	if err != nil {
+		return nil, fmt.Errorf("-wasm-opt requires wasm-opt of Binaryen (https://github.com/WebAssembly/binaryen) in PATH: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "go2dotnet-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.wasm")
+	out := filepath.Join(dir, "out.wasm")
+	if err := ioutil.WriteFile(in, wasmBytes, 0644); err != nil {
+		return nil, err
+	}
+	args := append(strings.Fields(options), in, "-o", out)
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("wasm-opt failed: %v", err)
+	}
+	return ioutil.ReadFile(out)
+}